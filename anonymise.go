@@ -1,25 +1,577 @@
 package main
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"io"
-	"os"
 	"strings"
 )
 
 // anonArgs is the Anonymise function signature
 type anonArgs struct {
-	dumpFilePath string    // a postgresql dump file via either os.Stdin or a file
+	dumpFilePath string    // a postgresql dump file, or "-" or "" for stdin
+	input        io.Reader // standard input, os.Stdin if nil
 	settingsToml string    // a toml settings string
 	output       io.Writer // output to either os.Stdout or a file
-	changedOnly  bool      // only show changed tables inthe output
+	outputDir    string    // output directory for directory format dumps
+	// outputCompression is the compression method of the output, if any
+	outputCompression string
+	changedOnly       bool // only show changed tables inthe output
+	// rolePasswords is the treatment of the role passwords of pg_dumpall
+	// dumps, to scrub or replace them with rolePassword, if any
+	rolePasswords string
+	rolePassword  string
+	toUTF8        bool // rewrite plain dumps in other encodings as UTF-8
+	// csv, if set, describes the input as a CSV or TSV export of a table
+	csv *csvOptions
+	cdc bool // read a stream of JSON change events
+	// database, if set, is the connection string of a database to which
+	// the output is written, in a single transaction if required
+	database          string
+	singleTransaction bool
+	// exportDir, if set, is the directory to which the anonymised data
+	// of each table with filters is exported in exportFormat, with
+	// parquet exports in row groups of exportRowGroupSize rows
+	exportDir          string
+	exportFormat       string
+	exportRowGroupSize int
+	// splitDir, if set, is the directory to which the output of a plain
+	// dump is written split into pre-data, data and post-data files
+	splitDir string
 }
 
-// Anonymise anonymises a postgresql dump file
+// dumpFilter holds the state of a scan through the lines of a
+// postgresql dump, filtering the rows of the COPY blocks of interesting
+// tables. A dumpFilter can be run in two modes: one for reference mode,
+// and another for standard, non-reference mode. In reference mode no
+// lines are output, and the reference tables are instead captured in
+// refTables so that the references can be resolved in a second,
+// standard mode, scan.
+type dumpFilter struct {
+	tableFilters  tableFilters
+	refTables     RefTableRegister
+	referenceMode bool
+	changedOnly   bool
+
+	// the dump table currently being scanned, if any
+	dt  *DumpTable
+	rdt *ReferenceDumpTable
+
+	// refTableInDumpMode is a boolean representing if the normal output
+	// should switch to the stored reference table rather than the file
+	// scan
+	refTableInDumpMode bool
+
+	// the in-dump line number of the current table, counted from 1
+	lineNo int
+
+	// ddl holds the table definitions read from CREATE TABLE statements,
+	// which provide the column names of INSERT statements without a
+	// column list
+	ddl *ddlParser
+
+	// standardStrings records the standard_conforming_strings setting,
+	// which determines if backslashes are escapes in quoted literals
+	standardStrings bool
+
+	// insert accumulates an INSERT statement spanning several lines
+	insert *insertBuffer
+
+	// filters are the filters of the current dump table
+	filters []RowFilterer
+
+	// database is the current database of a pg_dumpall dump, if any
+	database string
+
+	// rolePasswords and rolePassword are the treatment of role
+	// passwords, as for anonArgs
+	rolePasswords string
+	rolePassword  string
+
+	// encoding is the client encoding of the dump, if not UTF-8, and
+	// toUTF8 records if the dump is to be rewritten in UTF-8
+	encoding *clientEncoding
+	toUTF8   bool
+
+	// inLargeObject records if the contents of a large object are being
+	// read, and largeObject is its treatment, if any
+	inLargeObject bool
+	largeObject   *largeObjectFilter
+
+	// largeObjectScan records if the current table is read in reference
+	// mode only for the large objects it refers to
+	largeObjectScan bool
+
+	// version is the pg_dump version of the dump, if known, in the form
+	// of server_version_num, and restrictKey the key of the current
+	// \restrict meta-command, if any
+	version     int
+	restrictKey string
+
+	// export, if set, exports the filtered rows of each table to
+	// exportFile
+	export     *tableExport
+	exportFile *exportFile
+}
+
+// newDumpFilter makes a new dumpFilter
+func newDumpFilter(tf tableFilters, refTables RefTableRegister, referenceMode, changedOnly bool) *dumpFilter {
+	return &dumpFilter{
+		tableFilters:    tf,
+		refTables:       refTables,
+		referenceMode:   referenceMode,
+		changedOnly:     changedOnly,
+		dt:              new(DumpTable),
+		rdt:             new(ReferenceDumpTable),
+		ddl:             newDDLParser(),
+		standardStrings: true,
+	}
+}
+
+// clone returns a new dumpFilter with the same filters, reference
+// tables, table definitions and mode as d, allowing the data of several
+// tables to be filtered concurrently
+func (d *dumpFilter) clone() *dumpFilter {
+	c := newDumpFilter(d.tableFilters, d.refTables, d.referenceMode, d.changedOnly)
+	c.ddl = d.ddl
+	c.standardStrings = d.standardStrings
+	c.database = d.database
+	c.rolePasswords, c.rolePassword = d.rolePasswords, d.rolePassword
+	c.encoding, c.toUTF8 = d.encoding, d.toUTF8
+	c.version = d.version
+	c.export = d.export
+	return c
+}
+
+// readDefinition reads a line outside of table data for the table
+// definitions and settings needed to parse INSERT statements and the
+// client encoding of the dump
+func (d *dumpFilter) readDefinition(t string) error {
+	if strings.HasPrefix(t, "SET ") {
+		if m := stdStringsRegex.FindStringSubmatch(t); m != nil {
+			d.standardStrings = m[1] == "on"
+		}
+		if m := clientEncodingRegex.FindStringSubmatch(t); m != nil {
+			return d.setClientEncoding(m[1])
+		}
+		return nil
+	}
+	d.ddl.parseLine(t)
+	return nil
+}
+
+// readDefinitions reads the definitions of the entries of an archive
+// table of contents, as for the lines of a plain dump
+func (d *dumpFilter) readDefinitions(toc []*tocEntry) error {
+	for _, te := range toc {
+		if !te.defn.Valid {
+			continue
+		}
+		for _, line := range strings.Split(te.defn.String, "\n") {
+			if err := d.readDefinition(line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// startEntry initialises the dumpFilter for the data of an archive toc
+// entry from its copy statement, returning the copy statement to output
+// and true if it should be output. Table data dumped as INSERT
+// statements has no copy statement, and is initialised from the table
+// name of the entry.
+func (d *dumpFilter) startEntry(te *tocEntry) (string, bool, error) {
+	if te.copyStmt.String != "" || !te.isTableData() {
+		return d.filterLine(strings.TrimSpace(te.copyStmt.String))
+	}
+	name := qualifiedName(te.namespace.String, te.tag.String)
+	return "", false, d.startInsertTable(name, nil)
+}
+
+// finish finishes the data of a scan, ending any table without a
+// terminating line, such as a table of INSERT statements at the end of
+// a dump
+func (d *dumpFilter) finish() error {
+	if d.insert != nil {
+		return fmt.Errorf("incomplete insert statement for table %s", d.dt.TableName)
+	}
+	if d.dt.Inited() {
+		d.endTable()
+	}
+	return nil
+}
+
+// refTablesComplete reports if, in reference mode, all reference tables
+// have been seen so that a scan can return early
+func (d *dumpFilter) refTablesComplete() bool {
+	// the reference tables of a pg_dumpall dump may be in any of its
+	// databases
+	if d.database != "" {
+		return false
+	}
+	// the tables referring to large objects may be anywhere in a dump
+	if d.tableFilters.largeObjects.byReference() {
+		return false
+	}
+	return d.referenceMode && len(d.refTables) == len(d.tableFilters.refTableNames)
+}
+
+// filterLine filters a line of a dump file, returning the line to output
+// and true, or false if the line should not be output
+func (d *dumpFilter) filterLine(t string) (string, bool, error) {
+
+	if !d.dt.Inited() {
+		if strings.HasPrefix(t, connectPrefix) {
+			if err := d.connect(t); err != nil {
+				return "", false, err
+			}
+		}
+		if err := d.readHeaderLine(t); err != nil {
+			return "", false, err
+		}
+		t, changed := d.filterRolePassword(t)
+		t, keep, loChanged := d.filterLargeObjectLine(t)
+		if !keep {
+			return "", false, nil
+		}
+		changed = changed || loChanged
+		if err := d.readDefinition(t); err != nil {
+			return "", false, err
+		}
+		if d.toUTF8 && clientEncodingRegex.MatchString(t) {
+			t, changed = "SET client_encoding = '"+utf8Encoding+"';", true
+		}
+		if err := d.startTable(t); err != nil {
+			return "", false, err
+		}
+		if d.dt.insert && d.dt.Inited() {
+			return d.filterInsertLine(t)
+		}
+		if d.referenceMode || (d.changedOnly && !d.dt.Inited() && !changed) {
+			return "", false, nil
+		}
+		return t, true, nil
+	}
+
+	if d.dt.insert {
+		return d.filterInsertLine(t)
+	}
+
+	// the dump table is initialised; filter the lines unless the end of
+	// table marker is found
+	columns, ok := d.dt.LineSplitter(t)
+	if !ok {
+		d.endTable()
+		if d.referenceMode || d.changedOnly {
+			return "", false, nil
+		}
+		return t, true, nil
+	}
+	if err := d.checkRowFields(columns); err != nil {
+		return "", false, err
+	}
+	columns, ok, err := d.filterRow(columns)
+	return encodeCopyLine(columns), ok, err
+}
+
+// filterInsertLine filters a line of the INSERT statements of a table,
+// returning the filtered statement once its last line has been read.
+// The table ends at the first line which does not belong to an INSERT
+// statement for the table, which is then filtered as usual.
+func (d *dumpFilter) filterInsertLine(t string) (string, bool, error) {
+
+	switch {
+	case d.insert != nil:
+		d.insert.add(t)
+	case isInsert(t):
+		name, _, _, err := parseInsertHeader(t)
+		if err != nil {
+			return "", false, err
+		}
+		if databaseTableName(d.database, name) != d.dt.TableName {
+			d.endTable()
+			return d.filterLine(t)
+		}
+		d.insert = newInsertBuffer(d.standardStrings)
+		d.insert.add(t)
+	default:
+		d.endTable()
+		return d.filterLine(t)
+	}
+
+	if !d.insert.complete() {
+		return "", false, nil
+	}
+	stmt := d.insert.String()
+	d.insert = nil
+
+	s, err := parseInsert(stmt, d.standardStrings)
+	if err != nil {
+		return "", false, fmt.Errorf("insert statement error on table %s: %w", d.dt.TableName, err)
+	}
+	rows := make([][]string, len(s.rows))
+	for i, r := range s.rows {
+		columns := r.columns()
+		if len(columns) != len(d.dt.columnNames) {
+			return "", false, fmt.Errorf("insert statement on table %s has %d values for %d columns", d.dt.TableName, len(columns), len(d.dt.columnNames))
+		}
+		columns, ok, err := d.filterRow(columns)
+		if err != nil {
+			return "", false, err
+		}
+		if ok {
+			rows[i] = columns
+		}
+	}
+	if d.referenceMode {
+		return "", false, nil
+	}
+	line, ok := s.render(rows)
+	return line, ok, nil
+}
+
+// startTable attempts to initialise a dump table from a line, which
+// does not init if the line is not the header of an interesting COPY
+// block
+func (d *dumpFilter) startTable(t string) error {
+
+	if isInsert(t) {
+		name, columns, _, err := parseInsertHeader(t)
+		if err != nil {
+			return fmt.Errorf("Error parsing line %s : %w", t, err)
+		}
+		return d.startInsertTable(name, columns)
+	}
+	if err := d.checkCopyStatement(t); err != nil {
+		return err
+	}
+	return d.initTable(t, func(refContext bool) (*DumpTable, error) {
+		return newDumpTable(t, d.database, refContext, d.tableFilters)
+	})
+}
+
+// startInsertTable attempts to initialise a dump table for the INSERT
+// statements of a table, with the listed columns or otherwise those of
+// the table definition
+func (d *dumpFilter) startInsertTable(name string, columns []string) error {
+	td, _ := d.ddl.table(name)
+	return d.initTable(name, func(refContext bool) (*DumpTable, error) {
+		return newInsertDumpTable(databaseTableName(d.database, name), columns, td, refContext, d.tableFilters)
+	})
+}
+
+// initTable initialises a dump table, described by t for errors, using
+// newTable
+func (d *dumpFilter) initTable(t string, newTable func(refContext bool) (*DumpTable, error)) error {
+
+	// init dump table, which does not init if it returns a sentinel
+	// error except for ErrIsRefDumpTable
+	dt, err := newTable(d.referenceMode)
+	if d.referenceMode {
+		d.rdt = newReferenceDumpTable(dt)
+	}
+	d.dt = dt
+	switch err {
+	case ErrNoDumpTable:
+	case ErrNotInterestingTable:
+	case ErrIsNormalDumpTable: // normal in ref context
+	case nil:
+	default:
+		return fmt.Errorf("Error parsing line %s : %w", t, err)
+	}
+
+	// if not in referenceMode and the table is in refTables, set
+	// refTableInDumpMode to true
+	if !d.referenceMode {
+		if _, ok := d.refTables[d.dt.TableName]; ok {
+			d.refTableInDumpMode = true
+		}
+	}
+
+	if !d.dt.Inited() {
+		return nil
+	}
+
+	// re-initialise in-dump line numbers now the dumptable is
+	// initialised, and initialise any reference filters
+	d.filters = d.tableFilters.getTableFilters(d.dt.TableName)
+	if len(d.filters) == 0 {
+		return fmt.Errorf("could not extract filters for table %s", d.dt.TableName)
+	}
+	d.lineNo = 0
+	d.largeObjectScan = d.referenceMode && !d.tableFilters.isReferenceTable(d.dt.TableName)
+	if !d.referenceMode {
+		refTables := d.refTables.inDatabase(d.database)
+		for _, f := range d.filters {
+			f.setRefDumpTable(refTables)
+		}
+	}
+	return d.startExport()
+}
+
+// endTable finishes the current dump table, registering it in the
+// refTables map in reference mode if it is a reference table
+func (d *dumpFilter) endTable() {
+	if d.referenceMode && !d.largeObjectScan {
+		d.refTables[d.dt.TableName] = d.rdt
+		d.rdt = new(ReferenceDumpTable)
+	}
+	d.endExport()
+	d.dt = new(DumpTable)
+	d.refTableInDumpMode = false
+	d.largeObjectScan = false
+}
+
+// filterRow runs the filters for the current table over the columns of
+// a row, returning the columns to output, or false if the row has been
+// deleted or the dumpFilter is in reference mode.
+//
+// In reference mode the original and filtered rows are captured in the
+// reference dump table. If the table is a reference table in standard
+// mode, the already filtered row is output. Tables read in reference
+// mode only for the large objects they refer to are not captured, and
+// only their large object filters are run.
+func (d *dumpFilter) filterRow(columns []string) ([]string, bool, error) {
+
+	var err error
+
+	// count lines from 1
+	d.lineNo++
+	columns = d.decodeRow(columns)
+
+	var row Row
+	switch {
+	case d.refTableInDumpMode:
+		row = d.refTables[d.dt.TableName].latestRows[d.lineNo-1]
+		if row.lineNo == 0 {
+			return nil, false, nil
+		}
+		if err := d.exportRow(row.Columns); err != nil {
+			return nil, false, err
+		}
+		columns, err = d.encodeRow(row.Columns)
+		return columns, err == nil, err
+
+	case d.largeObjectScan:
+		row = NewRow(d.dt, columns, d.lineNo)
+		for _, f := range d.filters {
+			if _, ok := f.(*largeObjectFilter); !ok {
+				continue
+			}
+			if row, err = f.Filter(row); err != nil {
+				return nil, false, fmt.Errorf("filter error on table %s: %w", d.dt.TableName, err)
+			}
+		}
+		return nil, false, nil
+
+	case d.referenceMode:
+		row = NewRow(d.dt, columns, d.lineNo)
+		copyCols := make([]string, len(row.Columns))
+		copy(copyCols, row.Columns)
+		origRow := NewRow(row.DumpTabler, copyCols, row.lineNo)
+		d.rdt.originalRows = append(d.rdt.originalRows, origRow)
+
+	default:
+		row = NewRow(d.dt, columns, d.lineNo)
+	}
+
+	// process each row with the filters of the table
+	for _, f := range d.filters {
+		row, err = f.Filter(row)
+		if err != nil {
+			return nil, false, fmt.Errorf("filter error on table %s: %w", d.dt.TableName, err)
+		}
+	}
+
+	if d.referenceMode {
+		d.rdt.latestRows = append(d.rdt.latestRows, row)
+		return nil, false, nil
+	}
+
+	// convert columns back to a line unless the Row has been deleted
+	if row.lineNo == 0 {
+		return nil, false, nil
+	}
+	if err := d.exportRow(row.Columns); err != nil {
+		return nil, false, err
+	}
+	columns, err = d.encodeRow(row.Columns)
+	return columns, err == nil, err
+}
+
+// scanPlain filters a plain text postgresql dump file line by line,
+// writing the output to w
+func scanPlain(df *dumpFilter, r io.Reader, w io.Writer) error {
+
+	lr := newLineReader(r)
+	defer lr.Close()
+	for {
+		err := df.filterNext(lr, w)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// return early if all reference tables have been seen
+		if df.refTablesComplete() {
+			return nil
+		}
+	}
+	// the table data of a dump of a known version is terminated
+	if df.version != 0 && df.dt.Inited() && !df.dt.insert {
+		return fmt.Errorf("dump ends within the data of table %s", df.dt.TableName)
+	}
+	return df.finish()
+}
+
+// checkOutput checks that the output options of args can be used
+// together
+func (args anonArgs) checkOutput() error {
+	if args.database == "" && args.splitDir == "" {
+		return nil
+	}
+	switch {
+	case args.database != "" && args.splitDir != "":
+		return errors.New("split output cannot be written to a database")
+	case args.outputCompression != "" && args.outputCompression != streamNone:
+		return errOutputCompression
+	}
+	return nil
+}
+
+// checkPlainOnly returns the error of the options of args available
+// only for plain dumps, if any, for dumps of other formats and
+// standalone exports
+func (args anonArgs) checkPlainOnly() error {
+	switch {
+	case args.toUTF8:
+		return errUTF8PlainOnly
+	case args.database != "":
+		return errDatabasePlainOnly
+	case args.splitDir != "":
+		return errSplitPlainOnly
+	}
+	return nil
+}
+
+// Anonymise anonymises a postgresql dump file, either a plain text dump,
+// a custom format (pg_dump -Fc) archive, a directory format (pg_dump
+// -Fd) dump or a tar format (pg_dump -Ft) archive. Dump files compressed
+// with gzip, zstd or lz4 are decompressed transparently on each scan.
+// Standard input is read once, with the part needed for a second scan
+// spooled to a temporary file. The output of a plain dump may be
+// written directly to a database.
 func Anonymise(args anonArgs) error {
 
+	if err := checkRolePasswords(args.rolePasswords, args.rolePassword); err != nil {
+		return err
+	}
+	if err := args.checkOutput(); err != nil {
+		return err
+	}
+
 	// load settings
 	settings, err := LoadToml(args.settingsToml)
 	if err != nil {
@@ -35,217 +587,154 @@ func Anonymise(args anonArgs) error {
 	// refTables hold the processed reference table data
 	refTables := RefTableRegister{}
 
-	// refTableInDumpMode is a boolean representing if the normal output
-	// should switch to the stored reference table rather than the file
-	// scan
-	var refTableInDumpMode bool
-
-	// scanDumpFile is a func that can be run in two modes: one for
-	// reference mode, and another for standard, non-reference mode.
-	// In reference mode two scans of the dumpfile are required: one for
-	// collecting the reference tables in memory, then again to read the
-	// tables again so that the references can be resolved.
-	scanDumpFile := func(referenceMode bool, dumpFile io.Reader) error {
-
-		// init a dump table and related filters
-		dt := new(DumpTable)
-		rdt := new(ReferenceDumpTable)
-
-		var scanner *bufio.Scanner
-		scanner = bufio.NewScanner(dumpFile)
-
-		var lineNo = 0
-		for scanner.Scan() {
-
-			t := scanner.Text()
-
-			if !dt.Inited() {
-
-				// init dump table, which does not init if it returns a
-				// sentinel error except for ErrIsRefDumpTable
-				if referenceMode {
-					rdt, err = NewReferenceDumpTable(t, tableFilters)
-					dt = rdt.DumpTable
-				}
-				if !referenceMode {
-					dt, err = NewDumpTable(t, referenceMode, tableFilters)
-				}
-				switch err {
-				case ErrNoDumpTable:
-				case ErrNotInterestingTable:
-				case ErrIsNormalDumpTable: // normal in ref context
-				case nil:
-				default:
-					return fmt.Errorf("Error parsing line %s : %w", t, err)
-				}
-
-				// if not in referenceMode and the table is in
-				// refTables, set refTableInDumpMode to true
-				if !referenceMode {
-					_, ok := refTables[dt.TableName]
-					if ok {
-						refTableInDumpMode = true
-					}
-				}
-
-				// re-initialise in-dump line numbers if the dumptable is
-				// now initalised, add reference tables to the saving
-				// map, and initialise any reference filters
-				if dt.Inited() {
-
-					// extract filters
-					filters := tableFilters.getTableFilters(dt.TableName)
-					if len(filters) == 0 {
-						return fmt.Errorf("could not extract filters for table %s", dt.TableName)
-					}
-
-					lineNo = 0
-
-					// load reference table into reference filter
-					if !referenceMode {
-						for _, f := range filters {
-							f.setRefDumpTable(refTables)
-						}
-					}
-				}
-
-			} else {
-
-				// the dump table is initialised; filter the lines unless
-				// the end of table marker is found
-
-				// however, in referenceMode, no output to args.output
-				// is made, and lines are instead captured in
-				// dt.orginalRows and dt.latestRows
-
-				// also consider if the table is in reftables, if so,
-				// output the already filtered latestRows
-
-				// count lines from 1
-				lineNo++
-
-				columns, ok := dt.LineSplitter(scanner.Text())
-				if !ok {
-					// register reference table in map
-					if referenceMode {
-						refTables[dt.TableName] = rdt
-						// return early if all reference tables have
-						// been seen
-						if len(refTables) == len(tableFilters.refTableNames) {
-							return nil
-						}
-					}
-
-					dt = new(DumpTable)
-					if referenceMode {
-						rdt = new(ReferenceDumpTable)
-					}
-
-					// unset
-					refTableInDumpMode = false
-
-					if args.changedOnly {
-						continue
-					}
-					if !referenceMode {
-						_, err := io.WriteString(args.output, t+"\n")
-						if err != nil {
-							return fmt.Errorf("write error: %w", err)
-						}
-					}
-					continue
-				}
-
-				var row Row
-
-				switch {
-				case refTableInDumpMode:
-					row = refTables[dt.TableName].latestRows[lineNo-1]
-					t = strings.Join(row.Columns, "\t")
-
-				case referenceMode:
-					row = NewRow(dt, columns, lineNo)
-					copyCols := make([]string, len(row.Columns))
-					copy(copyCols, row.Columns)
-					origRow := NewRow(row.DumpTabler, copyCols, row.lineNo)
-					rdt.originalRows = append(rdt.originalRows, origRow)
-
-				default:
-					row = NewRow(dt, columns, lineNo)
-				}
-
-				if !refTableInDumpMode {
-
-					// extract filters
-					filters := tableFilters.getTableFilters(dt.TableName)
-					if len(filters) == 0 {
-						return fmt.Errorf("could not extract filters for table %s", dt.TableName)
-					}
-
-					// process each row
-					for _, f := range filters {
-						row, err = f.Filter(row)
-						if err != nil {
-							return fmt.Errorf("filter error on table %s: %w", dt.TableName, err)
-						}
-					}
-
-					if referenceMode {
-						rdt.latestRows = append(rdt.latestRows, row)
-					}
-
-					// convert columns back to t unless the Row has been deleted
-					if row.lineNo == 0 {
-						t = "row deleted"
-					} else {
-						t = strings.Join(row.Columns, "\t")
-					}
-				}
+	// input provides the input for each scan, spooling standard input
+	// for a second scan if required
+	twoPass := (len(tableFilters.refTableNames) > 0 || tableFilters.largeObjects.byReference()) && !args.cdc
+	input, err := newDumpInput(args.dumpFilePath, args.input, twoPass)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	// export the filtered rows of tables if required
+	var export *tableExport
+	if args.exportDir != "" {
+		if args.cdc {
+			return errExportCDC
+		}
+		export, err = newTableExport(args.exportDir, args.exportFormat, args.exportRowGroupSize)
+		if err != nil {
+			return err
+		}
+		defer export.Close()
+	}
+
+	// scanDumpFile scans a dump file using the scanner appropriate to
+	// its format. In reference mode two scans of the dumpfile are
+	// required: one for collecting the reference tables in memory, then
+	// again to read the tables again so that the references can be
+	// resolved.
+	scanDumpFile := func(referenceMode bool, w io.Writer) error {
+
+		df := newDumpFilter(tableFilters, refTables, referenceMode, args.changedOnly)
+		df.rolePasswords, df.rolePassword = args.rolePasswords, args.rolePassword
+		df.toUTF8 = args.toUTF8
+		if !referenceMode {
+			df.export = export
+		}
+
+		if input.isDir() {
+			if err := args.checkPlainOnly(); err != nil {
+				return err
 			}
+			return scanDirectory(df, args.dumpFilePath, args.outputDir, w)
+		}
 
-			if t == "row deleted" {
-				continue
+		of, err := input.open()
+		if err != nil {
+			return err
+		}
+		defer of.Close()
+
+		// decompress compressed dumps
+		dumpFile, closer, err := decompressStream(of)
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+
+		if args.csv != nil || args.cdc {
+			if err := args.checkPlainOnly(); err != nil {
+				return err
 			}
+		}
+		if args.csv != nil {
+			return scanCSV(df, *args.csv, dumpFile, w)
+		}
+		if args.cdc {
+			return scanCDC(df, dumpFile, w)
+		}
 
-			if !referenceMode {
-				// output
-				if args.changedOnly && !dt.Inited() {
-					continue
-				}
-				_, err := io.WriteString(args.output, t+"\n")
-				if err != nil {
-					return fmt.Errorf("write error: %w", err)
-				}
+		magic, _ := dumpFile.Peek(len(archiveMagic))
+		isCustom := string(magic) == archiveMagic
+		isTar := !isCustom && isTarArchive(dumpFile)
+		if isCustom || isTar {
+			if err := args.checkPlainOnly(); err != nil {
+				return err
 			}
 		}
-		return nil
+		if isCustom {
+			return scanCustom(df, dumpFile, w)
+		}
+		if isTar {
+			return scanTar(df, dumpFile, w)
+		}
+		return scanPlain(df, dumpFile, w)
 	}
 
-	fileOpener := func(path string) (io.Reader, error) {
-		of, err := os.Open(path)
-		if errors.Is(err, os.ErrNotExist) {
-			return of, fmt.Errorf("could not open dumpfile at %s for reading: %w", path, err)
+	// compress the output if required; uncompressed output is written
+	// directly so that seekable outputs remain seekable
+	output := args.output
+	var compressor io.WriteCloser
+	if args.outputCompression != "" && args.outputCompression != streamNone {
+		compressor, err = newStreamCompressor(args.outputCompression, args.output)
+		if err != nil {
+			return fmt.Errorf("output compression error: %w", err)
 		}
-		return of, err
+		output = compressor
 	}
 
-	// run reference table scan
-	if len(tableFilters.refTableNames) > 0 {
-		fo, err := fileOpener(args.dumpFilePath)
+	// write the output to a database if required
+	var db *dbWriter
+	if args.database != "" {
+		db, err = newDBWriter(args.database, args.singleTransaction)
 		if err != nil {
 			return err
 		}
-		err = scanDumpFile(true, fo)
+		defer db.abort()
+		output = db
+	}
+
+	// split the output into files if required
+	var split *splitWriter
+	if args.splitDir != "" {
+		split, err = newSplitWriter(args.splitDir)
+		if err != nil {
+			return err
+		}
+		defer split.abort()
+		output = split
+	}
+
+	// run reference table scan
+	if twoPass {
+		err = scanDumpFile(true, io.Discard)
 		if err != nil {
 			return err
 		}
 	}
 
 	// run standard scan
-	fo, err := fileOpener(args.dumpFilePath)
-	if err != nil {
+	if err := scanDumpFile(false, output); err != nil {
 		return err
 	}
-	err = scanDumpFile(false, fo)
-	return err
+	if compressor != nil {
+		if err := compressor.Close(); err != nil {
+			return fmt.Errorf("output compression error: %w", err)
+		}
+	}
+	if export != nil {
+		if err := export.Close(); err != nil {
+			return err
+		}
+	}
+	if split != nil {
+		if err := split.Close(); err != nil {
+			return err
+		}
+	}
+	if db != nil {
+		return db.Close()
+	}
+	return nil
 }
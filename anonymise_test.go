@@ -72,7 +72,7 @@ func TestAnonymiseOK(t *testing.T) {
 
 	tomlString, err := os.ReadFile("testdata/settings.toml")
 	if err != nil {
-		t.Errorf("could not read settings file: %w", err)
+		t.Errorf("could not read settings file: %s", err)
 	}
 
 	buffer := bytes.NewBuffer(nil)
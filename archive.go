@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// archiveMagic is the set of bytes at the start of each pg_dump
+// custom, directory (in toc.dat) or tar (in toc.dat) archive
+const archiveMagic = "PGDMP"
+
+// archive formats recorded in the archive header
+const (
+	archCustom    = 1
+	archTar       = 3
+	archDirectory = 5
+)
+
+// data offset states recorded in custom format toc entries
+const (
+	offsetPosNotSet = 1
+	offsetPosSet    = 2
+	offsetNoData    = 3
+)
+
+// custom format data block types
+const (
+	blkData  = 1
+	blkBlobs = 3
+)
+
+// archiveVersion makes a comparable archive version number from the
+// major, minor and revision numbers of an archive header
+func archiveVersion(vmaj, vmin, vrev int) int {
+	return (vmaj*256+vmin)*256 + vrev
+}
+
+// supported archive versions; 1.12 was introduced in postgresql 9.6 and
+// 1.16 in postgresql 17
+var (
+	archiveVersion1_12 = archiveVersion(1, 12, 0)
+	archiveVersion1_14 = archiveVersion(1, 14, 0) // adds table access method
+	archiveVersion1_15 = archiveVersion(1, 15, 0) // adds compression algorithm
+	archiveVersion1_16 = archiveVersion(1, 16, 0) // adds relkind
+)
+
+// archive compression algorithms as recorded in the header of 1.15 and
+// later archives
+const (
+	compressionNone = 0
+	compressionGzip = 1
+	compressionLZ4  = 2
+	compressionZstd = 3
+)
+
+// archiveHeader is the header of a pg_dump archive
+type archiveHeader struct {
+	vmaj, vmin, vrev byte
+	intSize          byte
+	offSize          byte
+	format           byte
+	compression      int // zlib compression level for pre 1.15 archives
+	compressionAlgo  byte
+	createTime       [7]int // tm_sec, tm_min, tm_hour, tm_mday, tm_mon, tm_year, tm_isdst
+	dbName           sql.NullString
+	remoteVersion    sql.NullString
+	dumpVersion      sql.NullString
+}
+
+// version returns the comparable archive version of the header
+func (h *archiveHeader) version() int {
+	return archiveVersion(int(h.vmaj), int(h.vmin), int(h.vrev))
+}
+
+// algorithm returns the compression algorithm used for the archive
+// data, translating the zlib compression level of older archives
+func (h *archiveHeader) algorithm() byte {
+	if h.version() >= archiveVersion1_15 {
+		return h.compressionAlgo
+	}
+	if h.compression == 0 {
+		return compressionNone
+	}
+	return compressionGzip
+}
+
+// tocEntry is an entry in the table of contents of a pg_dump archive
+type tocEntry struct {
+	dumpID     int
+	hadDumper  int
+	tableOID   sql.NullString
+	oid        sql.NullString
+	tag        sql.NullString
+	desc       sql.NullString
+	section    int
+	defn       sql.NullString
+	dropStmt   sql.NullString
+	copyStmt   sql.NullString
+	namespace  sql.NullString
+	tablespace sql.NullString
+	tableam    sql.NullString
+	relkind    int
+	owner      sql.NullString
+	withOids   sql.NullString
+	deps       []sql.NullString
+
+	// custom format data offset
+	dataState byte
+	dataPos   int64
+
+	// directory and tar format data file name
+	filename sql.NullString
+}
+
+// isTableData reports if the toc entry describes table data
+func (te *tocEntry) isTableData() bool {
+	return te.desc.String == "TABLE DATA"
+}
+
+// archiveReader reads the primitive types of a pg_dump archive. Errors
+// are sticky: after the first error all reads return zero values and
+// the error is available from err
+type archiveReader struct {
+	r       *bufio.Reader
+	intSize int
+	offSize int
+	err     error
+}
+
+// newArchiveReader returns an archiveReader for r
+func newArchiveReader(r io.Reader) *archiveReader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &archiveReader{r: br}
+}
+
+// readByte reads a single byte
+func (ar *archiveReader) readByte() byte {
+	if ar.err != nil {
+		return 0
+	}
+	b, err := ar.r.ReadByte()
+	if err != nil {
+		ar.err = err
+	}
+	return b
+}
+
+// readInt reads an integer stored as a sign byte followed by intSize
+// little endian bytes
+func (ar *archiveReader) readInt() int {
+	sign := ar.readByte()
+	var i, shift int
+	for b := 0; b < ar.intSize; b++ {
+		i += int(ar.readByte()) << shift
+		shift += 8
+	}
+	if ar.err != nil {
+		return 0
+	}
+	if sign != 0 {
+		i = -i
+	}
+	return i
+}
+
+// readStr reads a length prefixed string, where a length of -1
+// represents NULL
+func (ar *archiveReader) readStr() sql.NullString {
+	l := ar.readInt()
+	if ar.err != nil || l < 0 {
+		return sql.NullString{}
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(ar.r, b); err != nil {
+		ar.err = err
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(b), Valid: true}
+}
+
+// readOffset reads a data offset state byte and offSize little endian
+// bytes
+func (ar *archiveReader) readOffset() (byte, int64) {
+	state := ar.readByte()
+	var o int64
+	for b := 0; b < ar.offSize; b++ {
+		o |= int64(ar.readByte()) << (8 * b)
+	}
+	return state, o
+}
+
+// readHeader reads an archive header, setting the integer and offset
+// sizes of the reader for subsequent reads
+func (ar *archiveReader) readHeader() (archiveHeader, error) {
+
+	var h archiveHeader
+
+	magic := make([]byte, len(archiveMagic))
+	if _, err := io.ReadFull(ar.r, magic); err != nil || string(magic) != archiveMagic {
+		return h, errors.New("archive header: not a pg_dump archive")
+	}
+
+	h.vmaj = ar.readByte()
+	h.vmin = ar.readByte()
+	if h.vmaj > 1 || (h.vmaj == 1 && h.vmin > 0) {
+		h.vrev = ar.readByte()
+	}
+	if ar.err != nil {
+		return h, fmt.Errorf("archive header: %w", ar.err)
+	}
+	if h.version() < archiveVersion1_12 || h.version() > archiveVersion1_16 {
+		return h, fmt.Errorf("archive header: unsupported archive version %d.%d.%d", h.vmaj, h.vmin, h.vrev)
+	}
+
+	h.intSize = ar.readByte()
+	h.offSize = ar.readByte()
+	ar.intSize = int(h.intSize)
+	ar.offSize = int(h.offSize)
+	h.format = ar.readByte()
+
+	if h.version() >= archiveVersion1_15 {
+		h.compressionAlgo = ar.readByte()
+	} else {
+		h.compression = ar.readInt()
+	}
+	for i := range h.createTime {
+		h.createTime[i] = ar.readInt()
+	}
+	h.dbName = ar.readStr()
+	h.remoteVersion = ar.readStr()
+	h.dumpVersion = ar.readStr()
+
+	if ar.err != nil {
+		return h, fmt.Errorf("archive header: %w", ar.err)
+	}
+	return h, nil
+}
+
+// readTOC reads the table of contents following an archive header
+func (ar *archiveReader) readTOC(h archiveHeader) ([]*tocEntry, error) {
+
+	count := ar.readInt()
+	if ar.err != nil {
+		return nil, fmt.Errorf("archive toc: %w", ar.err)
+	}
+
+	toc := make([]*tocEntry, 0, count)
+	for i := 0; i < count; i++ {
+		te := &tocEntry{}
+		te.dumpID = ar.readInt()
+		te.hadDumper = ar.readInt()
+		te.tableOID = ar.readStr()
+		te.oid = ar.readStr()
+		te.tag = ar.readStr()
+		te.desc = ar.readStr()
+		te.section = ar.readInt()
+		te.defn = ar.readStr()
+		te.dropStmt = ar.readStr()
+		te.copyStmt = ar.readStr()
+		te.namespace = ar.readStr()
+		te.tablespace = ar.readStr()
+		if h.version() >= archiveVersion1_14 {
+			te.tableam = ar.readStr()
+		}
+		if h.version() >= archiveVersion1_16 {
+			te.relkind = ar.readInt()
+		}
+		te.owner = ar.readStr()
+		te.withOids = ar.readStr()
+		for {
+			dep := ar.readStr()
+			if !dep.Valid {
+				break
+			}
+			te.deps = append(te.deps, dep)
+		}
+
+		switch h.format {
+		case archCustom:
+			te.dataState, te.dataPos = ar.readOffset()
+		case archDirectory, archTar:
+			te.filename = ar.readStr()
+		default:
+			return nil, fmt.Errorf("archive toc: unsupported archive format %d", h.format)
+		}
+
+		if ar.err != nil {
+			return nil, fmt.Errorf("archive toc entry %d: %w", i, ar.err)
+		}
+		if te.dumpID <= 0 {
+			return nil, fmt.Errorf("archive toc entry %d: invalid dump id %d", i, te.dumpID)
+		}
+		toc = append(toc, te)
+	}
+	return toc, nil
+}
+
+// archiveWriter writes the primitive types of a pg_dump archive,
+// recording the number of bytes written. Like archiveReader, errors are
+// sticky
+type archiveWriter struct {
+	w       *bufio.Writer
+	intSize int
+	offSize int
+	pos     int64
+	err     error
+}
+
+// newArchiveWriter returns an archiveWriter for w
+func newArchiveWriter(w io.Writer) *archiveWriter {
+	return &archiveWriter{w: bufio.NewWriter(w)}
+}
+
+// Write writes bytes to the archive
+func (aw *archiveWriter) Write(p []byte) (int, error) {
+	if aw.err != nil {
+		return 0, aw.err
+	}
+	n, err := aw.w.Write(p)
+	aw.pos += int64(n)
+	aw.err = err
+	return n, err
+}
+
+// writeByte writes a single byte
+func (aw *archiveWriter) writeByte(b byte) {
+	aw.Write([]byte{b})
+}
+
+// writeInt writes an integer as a sign byte followed by intSize little
+// endian bytes
+func (aw *archiveWriter) writeInt(i int) {
+	if i < 0 {
+		aw.writeByte(1)
+		i = -i
+	} else {
+		aw.writeByte(0)
+	}
+	for b := 0; b < aw.intSize; b++ {
+		aw.writeByte(byte(i & 0xff))
+		i >>= 8
+	}
+}
+
+// writeStr writes a length prefixed string, or a length of -1 for NULL
+func (aw *archiveWriter) writeStr(s sql.NullString) {
+	if !s.Valid {
+		aw.writeInt(-1)
+		return
+	}
+	aw.writeInt(len(s.String))
+	io.WriteString(aw, s.String)
+}
+
+// writeOffset writes a data offset state byte and offSize little
+// endian bytes
+func (aw *archiveWriter) writeOffset(state byte, o int64) {
+	aw.writeByte(state)
+	for b := 0; b < aw.offSize; b++ {
+		aw.writeByte(byte(o & 0xff))
+		o >>= 8
+	}
+}
+
+// writeHeader writes an archive header, setting the integer and offset
+// sizes of the writer for subsequent writes
+func (aw *archiveWriter) writeHeader(h archiveHeader) error {
+
+	aw.intSize = int(h.intSize)
+	aw.offSize = int(h.offSize)
+
+	io.WriteString(aw, archiveMagic)
+	aw.writeByte(h.vmaj)
+	aw.writeByte(h.vmin)
+	if h.vmaj > 1 || (h.vmaj == 1 && h.vmin > 0) {
+		aw.writeByte(h.vrev)
+	}
+	aw.writeByte(h.intSize)
+	aw.writeByte(h.offSize)
+	aw.writeByte(h.format)
+	if h.version() >= archiveVersion1_15 {
+		aw.writeByte(h.compressionAlgo)
+	} else {
+		aw.writeInt(h.compression)
+	}
+	for _, t := range h.createTime {
+		aw.writeInt(t)
+	}
+	aw.writeStr(h.dbName)
+	aw.writeStr(h.remoteVersion)
+	aw.writeStr(h.dumpVersion)
+
+	if aw.err != nil {
+		return fmt.Errorf("archive header write: %w", aw.err)
+	}
+	return nil
+}
+
+// writeTOC writes the table of contents of an archive
+func (aw *archiveWriter) writeTOC(h archiveHeader, toc []*tocEntry) error {
+
+	aw.writeInt(len(toc))
+	for _, te := range toc {
+		aw.writeInt(te.dumpID)
+		aw.writeInt(te.hadDumper)
+		aw.writeStr(te.tableOID)
+		aw.writeStr(te.oid)
+		aw.writeStr(te.tag)
+		aw.writeStr(te.desc)
+		aw.writeInt(te.section)
+		aw.writeStr(te.defn)
+		aw.writeStr(te.dropStmt)
+		aw.writeStr(te.copyStmt)
+		aw.writeStr(te.namespace)
+		aw.writeStr(te.tablespace)
+		if h.version() >= archiveVersion1_14 {
+			aw.writeStr(te.tableam)
+		}
+		if h.version() >= archiveVersion1_16 {
+			aw.writeInt(te.relkind)
+		}
+		aw.writeStr(te.owner)
+		aw.writeStr(te.withOids)
+		for _, dep := range te.deps {
+			aw.writeStr(dep)
+		}
+		aw.writeStr(sql.NullString{})
+
+		switch h.format {
+		case archCustom:
+			aw.writeOffset(te.dataState, te.dataPos)
+		case archDirectory, archTar:
+			aw.writeStr(te.filename)
+		default:
+			return fmt.Errorf("archive toc write: unsupported archive format %d", h.format)
+		}
+	}
+
+	if aw.err != nil {
+		return fmt.Errorf("archive toc write: %w", aw.err)
+	}
+	return nil
+}
+
+// flush flushes any buffered data to the underlying writer
+func (aw *archiveWriter) flush() error {
+	if aw.err != nil {
+		return aw.err
+	}
+	aw.err = aw.w.Flush()
+	return aw.err
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"testing"
+)
+
+func TestArchiveInts(t *testing.T) {
+
+	for _, size := range []int{4, 8} {
+		buf := bytes.NewBuffer(nil)
+		aw := newArchiveWriter(buf)
+		aw.intSize = size
+
+		ints := []int{0, 1, -1, 255, 256, 65535, -2147483647, 2147483647}
+		for _, i := range ints {
+			aw.writeInt(i)
+		}
+		if err := aw.flush(); err != nil {
+			t.Fatalf("flush error %s", err)
+		}
+		if got, want := buf.Len(), len(ints)*(size+1); got != want {
+			t.Errorf("int size %d: written %d bytes, expected %d", size, got, want)
+		}
+
+		ar := newArchiveReader(buf)
+		ar.intSize = size
+		for _, i := range ints {
+			if got := ar.readInt(); got != i {
+				t.Errorf("int size %d: read %d expected %d", size, got, i)
+			}
+		}
+		if ar.err != nil {
+			t.Errorf("unexpected read error %s", ar.err)
+		}
+	}
+}
+
+func TestArchiveStrings(t *testing.T) {
+
+	strs := []sql.NullString{
+		{String: "", Valid: false},
+		{String: "", Valid: true},
+		{String: "COPY public.users (id, name) FROM stdin;\n", Valid: true},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	aw := newArchiveWriter(buf)
+	aw.intSize = 4
+	for _, s := range strs {
+		aw.writeStr(s)
+	}
+	aw.flush()
+
+	ar := newArchiveReader(buf)
+	ar.intSize = 4
+	for _, s := range strs {
+		if got := ar.readStr(); got != s {
+			t.Errorf("read %+v expected %+v", got, s)
+		}
+	}
+
+	// reading beyond the end of the buffer is a sticky error
+	ar.readStr()
+	if ar.err == nil {
+		t.Error("expected read error at end of buffer")
+	}
+	if got := ar.readInt(); got != 0 {
+		t.Errorf("expected zero value after error, got %d", got)
+	}
+}
+
+func TestArchiveHeaderTOC(t *testing.T) {
+
+	for _, vmin := range []byte{12, 14, 15, 16} {
+
+		h := testArchiveHeader(vmin, compressionGzip)
+		toc := []*tocEntry{
+			{
+				dumpID:    1,
+				tableOID:  sql.NullString{String: "2615", Valid: true},
+				oid:       sql.NullString{String: "16385", Valid: true},
+				tag:       sql.NullString{String: "example_schema", Valid: true},
+				desc:      sql.NullString{String: "SCHEMA", Valid: true},
+				section:   2,
+				defn:      sql.NullString{String: "CREATE SCHEMA example_schema;\n", Valid: true},
+				dropStmt:  sql.NullString{String: "DROP SCHEMA example_schema;\n", Valid: true},
+				copyStmt:  sql.NullString{String: "", Valid: true},
+				owner:     sql.NullString{String: "dbuser", Valid: true},
+				withOids:  sql.NullString{String: "false", Valid: true},
+				dataState: offsetNoData,
+			},
+			{
+				dumpID:    2,
+				hadDumper: 1,
+				tag:       sql.NullString{String: "users", Valid: true},
+				desc:      sql.NullString{String: "TABLE DATA", Valid: true},
+				section:   3,
+				copyStmt:  sql.NullString{String: "COPY public.users (id) FROM stdin;\n", Valid: true},
+				namespace: sql.NullString{String: "public", Valid: true},
+				relkind:   'r',
+				deps:      []sql.NullString{{String: "1", Valid: true}},
+				dataState: offsetPosSet,
+				dataPos:   1234,
+			},
+		}
+		if vmin >= 14 {
+			toc[1].tableam = sql.NullString{String: "heap", Valid: true}
+		}
+		if vmin < 16 {
+			toc[1].relkind = 0
+		}
+
+		buf := bytes.NewBuffer(nil)
+		aw := newArchiveWriter(buf)
+		if err := aw.writeHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if err := aw.writeTOC(h, toc); err != nil {
+			t.Fatal(err)
+		}
+		aw.flush()
+
+		ar := newArchiveReader(buf)
+		rh, err := ar.readHeader()
+		if err != nil {
+			t.Fatalf("version 1.%d header read error: %s", vmin, err)
+		}
+		if rh != h {
+			t.Errorf("version 1.%d header\ngot  %+v\nwant %+v", vmin, rh, h)
+		}
+		rtoc, err := ar.readTOC(rh)
+		if err != nil {
+			t.Fatalf("version 1.%d toc read error: %s", vmin, err)
+		}
+		if len(rtoc) != len(toc) {
+			t.Fatalf("version 1.%d toc length %d, expected %d", vmin, len(rtoc), len(toc))
+		}
+		for i, te := range rtoc {
+			if got, want := te.copyStmt, toc[i].copyStmt; got != want {
+				t.Errorf("version 1.%d entry %d copyStmt %v expected %v", vmin, i, got, want)
+			}
+			if got, want := te.tableam, toc[i].tableam; got != want {
+				t.Errorf("version 1.%d entry %d tableam %v expected %v", vmin, i, got, want)
+			}
+			if got, want := te.relkind, toc[i].relkind; got != want {
+				t.Errorf("version 1.%d entry %d relkind %v expected %v", vmin, i, got, want)
+			}
+			if got, want := len(te.deps), len(toc[i].deps); got != want {
+				t.Errorf("version 1.%d entry %d deps %d expected %d", vmin, i, got, want)
+			}
+			if te.dataState != toc[i].dataState || te.dataPos != toc[i].dataPos {
+				t.Errorf("version 1.%d entry %d offset %d/%d expected %d/%d",
+					vmin, i, te.dataState, te.dataPos, toc[i].dataState, toc[i].dataPos)
+			}
+		}
+	}
+}
+
+func TestArchiveHeaderFail(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"empty", []byte{}},
+		{"plain dump", []byte("--\n-- PostgreSQL database dump\n--\n")},
+		{"old version", []byte{'P', 'G', 'D', 'M', 'P', 1, 10, 0, 4, 8, archCustom}},
+		{"truncated", []byte{'P', 'G', 'D', 'M', 'P', 1, 14, 0, 4, 8, archCustom}},
+	}
+	for _, tc := range tests {
+		ar := newArchiveReader(bytes.NewReader(tc.input))
+		if _, err := ar.readHeader(); err == nil {
+			t.Errorf("%s: expected header read error", tc.name)
+		}
+	}
+}
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Change data capture streams of newline-delimited JSON change events
+// may be anonymised with the filters of the tables of the events. Three
+// event formats are read:
+//
+//   - wal2json format version 2, with one insert, update or delete
+//     event per line, such as {"action":"I","schema":"public",
+//     "table":"users","columns":[{"name":"id","type":"integer",
+//     "value":1}, ...]}, in which the old key of updates and deletes is
+//     given by "identity"
+//   - wal2json format version 1, with one transaction per line, such as
+//     {"change":[{"kind":"insert","schema":"public","table":"users",
+//     "columnnames":[...],"columntypes":[...],"columnvalues":[...]}]},
+//     in which the old key is given by "oldkeys"
+//   - Debezium, with or without the schema envelope, such as
+//     {"payload":{"before":null,"after":{"id":1, ...},"source":{...},
+//     "op":"c"}}, in which the old row is given by "before"
+//
+// The new and old tuples of each event are filtered as rows of the
+// event's table. Values are presented to filters in the text form of a
+// dump, with booleans as t or f and JSON null as NULL, and filtered
+// values are written in the JSON type of the original value where they
+// can be. The columns of an old tuple which are unchanged by an update
+// are given the filtered values of the new tuple, so that keys are
+// replaced consistently. Events of a table with a delete filter are
+// dropped, and other events, such as transaction boundaries, are output
+// unchanged.
+
+// change event actions
+const (
+	cdcInsert = "insert"
+	cdcUpdate = "update"
+	cdcDelete = "delete"
+)
+
+// jsonMember is a member of a jsonObject
+type jsonMember struct {
+	key   string
+	value json.RawMessage
+}
+
+// jsonObject is a JSON object which keeps the order and the original
+// text of its members, so that only the members which change are
+// reformatted
+type jsonObject []jsonMember
+
+// UnmarshalJSON reads a JSON object
+func (o *jsonObject) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return errors.New("json object expected")
+	}
+	*o = jsonObject{}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := t.(string)
+		if !ok {
+			return errors.New("json object key expected")
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		*o = append(*o, jsonMember{key, value})
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// MarshalJSON writes a JSON object
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(m.key)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(m.value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// get returns the value of a member, or nil if there is none
+func (o jsonObject) get(key string) json.RawMessage {
+	for _, m := range o {
+		if m.key == key {
+			return m.value
+		}
+	}
+	return nil
+}
+
+// getString returns the value of a string member, or "" if it is not a
+// string
+func (o jsonObject) getString(key string) string {
+	var s string
+	if v := o.get(key); v != nil {
+		_ = json.Unmarshal(v, &s)
+	}
+	return s
+}
+
+// set sets the value of a member, appending it if there is none
+func (o *jsonObject) set(key string, value interface{}) error {
+	raw, ok := value.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(value); err != nil {
+			return err
+		}
+	}
+	for i, m := range *o {
+		if m.key == key {
+			(*o)[i].value = raw
+			return nil
+		}
+	}
+	*o = append(*o, jsonMember{key, raw})
+	return nil
+}
+
+// jsonText returns the text form of a JSON value, as presented to the
+// filters
+func jsonText(v json.RawMessage) string {
+	v = bytes.TrimSpace(v)
+	switch {
+	case len(v) == 0 || string(v) == "null":
+		return nullValue
+	case string(v) == "true":
+		return "t"
+	case string(v) == "false":
+		return "f"
+	case v[0] == '"':
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			return s
+		}
+	}
+	return string(v)
+}
+
+// jsonValue returns the JSON value of a filtered text value, in the
+// JSON type of the original value if the text is valid for that type,
+// otherwise as a string
+func jsonValue(text string, original json.RawMessage) json.RawMessage {
+	if text == nullValue {
+		return json.RawMessage("null")
+	}
+	original = bytes.TrimSpace(original)
+	if len(original) > 0 {
+		switch c := original[0]; {
+		case c == 't' || c == 'f':
+			if b, err := strconv.ParseBool(text); err == nil {
+				return json.RawMessage(strconv.FormatBool(b))
+			}
+		case c == '-' || (c >= '0' && c <= '9'):
+			if _, err := strconv.ParseFloat(text, 64); err == nil && json.Valid([]byte(text)) {
+				return json.RawMessage(text)
+			}
+		case c == '{' || c == '[':
+			if len(text) > 0 && text[0] == c && json.Valid([]byte(text)) {
+				return json.RawMessage(text)
+			}
+		}
+	}
+	s, _ := json.Marshal(text)
+	return s
+}
+
+// cdcTuple is the new or old tuple of a change event
+type cdcTuple struct {
+	names   []string
+	values  []json.RawMessage
+	changed bool
+}
+
+// texts returns the text values of the tuple's columns by name
+func (t *cdcTuple) texts() map[string]string {
+	texts := map[string]string{}
+	if t == nil {
+		return texts
+	}
+	for i, n := range t.names {
+		texts[n] = jsonText(t.values[i])
+	}
+	return texts
+}
+
+// filterTuple runs the filters of a table over a tuple, as row lineNo
+// of the table, returning the filtered text values of its columns, or
+// false if the row is deleted. Columns used by the filters which are
+// not in the tuple, such as the non-key columns of an old tuple, are
+// given the values of fill, or NULL
+func filterTuple(table string, filters []RowFilterer, t *cdcTuple, fill map[string]string, lineNo int) ([]string, bool, error) {
+
+	names := append([]string{}, t.names...)
+	values := make([]string, len(t.names))
+	for i, v := range t.values {
+		values[i] = jsonText(v)
+	}
+	present := map[string]bool{}
+	for _, n := range names {
+		present[n] = true
+	}
+	for _, f := range filters {
+		for _, c := range f.usedColumns() {
+			if present[c] {
+				continue
+			}
+			present[c] = true
+			v, ok := fill[c]
+			if !ok {
+				v = nullValue
+			}
+			names, values = append(names, c), append(values, v)
+		}
+	}
+
+	dt := &DumpTable{TableName: table, columnNames: names, initialised: true}
+	row := NewRow(dt, values, lineNo)
+	var err error
+	for _, f := range filters {
+		row, err = f.Filter(row)
+		if err != nil {
+			return nil, false, fmt.Errorf("filter error on table %s: %w", table, err)
+		}
+	}
+	if row.lineNo == 0 {
+		return nil, false, nil
+	}
+	return row.Columns[:len(t.names)], true, nil
+}
+
+// setTexts sets the values of the columns of a tuple whose filtered
+// text differs from the original text
+func (t *cdcTuple) setTexts(original map[string]string, filtered []string) {
+	for i, n := range t.names {
+		if filtered[i] == original[n] {
+			continue
+		}
+		t.values[i] = jsonValue(filtered[i], t.values[i])
+		t.changed = true
+	}
+}
+
+// filterChange filters the new and old tuples, either of which may be
+// nil, of a change event for a table, which is event number eventNo of
+// the stream. The tuples are updated in place. It returns false if the
+// event should be dropped
+func (d *dumpFilter) filterChange(table string, newT, oldT *cdcTuple, eventNo int) (bool, error) {
+
+	filters := d.tableFilters.getTableFilters(table)
+	if len(filters) == 0 {
+		return true, nil
+	}
+	for _, f := range filters {
+		if f.getRefDumpTable() != "" {
+			return false, fmt.Errorf("table %s: reference filters cannot be applied to change events", table)
+		}
+	}
+
+	newTexts, oldTexts := newT.texts(), oldT.texts()
+
+	var newFiltered []string
+	if newT != nil {
+		var ok bool
+		var err error
+		newFiltered, ok, err = filterTuple(table, filters, newT, oldTexts, eventNo)
+		if err != nil || !ok {
+			return false, err
+		}
+		newT.setTexts(newTexts, newFiltered)
+	}
+
+	if oldT != nil {
+		oldFiltered, ok, err := filterTuple(table, filters, oldT, newTexts, eventNo)
+		if err != nil || !ok {
+			return false, err
+		}
+		// columns unchanged by an update take the new tuple's values
+		if newT != nil {
+			for i, n := range oldT.names {
+				for j, m := range newT.names {
+					if m == n && newTexts[m] == oldTexts[n] {
+						oldFiltered[i] = newFiltered[j]
+					}
+				}
+			}
+		}
+		oldT.setTexts(oldTexts, oldFiltered)
+	}
+	return true, nil
+}
+
+// cdcNamedTuple reads a tuple from an array of objects with name and
+// value members, as for wal2json format version 2
+func cdcNamedTuple(raw json.RawMessage) (*cdcTuple, []jsonObject, error) {
+	if raw == nil || string(raw) == "null" {
+		return nil, nil, nil
+	}
+	var columns []jsonObject
+	if err := json.Unmarshal(raw, &columns); err != nil {
+		return nil, nil, err
+	}
+	t := &cdcTuple{}
+	for _, c := range columns {
+		t.names = append(t.names, c.getString("name"))
+		t.values = append(t.values, c.get("value"))
+	}
+	return t, columns, nil
+}
+
+// cdcArrayTuple reads a tuple from arrays of names and values, as for
+// wal2json format version 1
+func cdcArrayTuple(names, values json.RawMessage) (*cdcTuple, error) {
+	if names == nil {
+		return nil, nil
+	}
+	t := &cdcTuple{}
+	if err := json.Unmarshal(names, &t.names); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(values, &t.values); err != nil {
+		return nil, err
+	}
+	if len(t.names) != len(t.values) {
+		return nil, fmt.Errorf("%d column names for %d values", len(t.names), len(t.values))
+	}
+	return t, nil
+}
+
+// cdcObjectTuple reads a tuple from an object of column values, as for
+// Debezium
+func cdcObjectTuple(raw json.RawMessage) (*cdcTuple, error) {
+	if raw == nil || string(raw) == "null" {
+		return nil, nil
+	}
+	var o jsonObject
+	if err := json.Unmarshal(raw, &o); err != nil {
+		return nil, err
+	}
+	t := &cdcTuple{}
+	for _, m := range o {
+		t.names = append(t.names, m.key)
+		t.values = append(t.values, m.value)
+	}
+	return t, nil
+}
+
+// filterWal2jsonV2 filters a wal2json format version 2 event,
+// returning false if it should be dropped, and true if it has changed
+func (d *dumpFilter) filterWal2jsonV2(event *jsonObject, eventNo int) (bool, bool, error) {
+
+	var action string
+	switch event.getString("action") {
+	case "I":
+		action = cdcInsert
+	case "U":
+		action = cdcUpdate
+	case "D":
+		action = cdcDelete
+	default:
+		return true, false, nil
+	}
+	table := qualifiedName(event.getString("schema"), event.getString("table"))
+
+	newT, newColumns, err := cdcNamedTuple(event.get("columns"))
+	if err != nil {
+		return false, false, fmt.Errorf("%s event columns: %w", action, err)
+	}
+	oldT, oldColumns, err := cdcNamedTuple(event.get("identity"))
+	if err != nil {
+		return false, false, fmt.Errorf("%s event identity: %w", action, err)
+	}
+	if ok, err := d.filterChange(table, newT, oldT, eventNo); err != nil || !ok {
+		return ok, false, err
+	}
+
+	changed := false
+
+	for _, c := range []struct {
+		key     string
+		t       *cdcTuple
+		columns []jsonObject
+	}{{"columns", newT, newColumns}, {"identity", oldT, oldColumns}} {
+		if c.t == nil || !c.t.changed {
+			continue
+		}
+		for i := range c.columns {
+			if err := c.columns[i].set("value", c.t.values[i]); err != nil {
+				return false, false, err
+			}
+		}
+		if err := event.set(c.key, c.columns); err != nil {
+			return false, false, err
+		}
+		changed = true
+	}
+	return true, changed, nil
+}
+
+// filterWal2jsonV1 filters a wal2json format version 1 transaction,
+// dropping the changes of deleted rows, and returning true if it has
+// changed
+func (d *dumpFilter) filterWal2jsonV1(event *jsonObject, eventNo int) (bool, bool, error) {
+
+	var changes []jsonObject
+	if err := json.Unmarshal(event.get("change"), &changes); err != nil {
+		return false, false, fmt.Errorf("change array: %w", err)
+	}
+	kept := []jsonObject{}
+	changed := false
+	for _, c := range changes {
+		kind := c.getString("kind")
+		if kind != cdcInsert && kind != cdcUpdate && kind != cdcDelete {
+			kept = append(kept, c)
+			continue
+		}
+		table := qualifiedName(c.getString("schema"), c.getString("table"))
+
+		newT, err := cdcArrayTuple(c.get("columnnames"), c.get("columnvalues"))
+		if err != nil {
+			return false, false, fmt.Errorf("%s change columns: %w", kind, err)
+		}
+		var oldKeys jsonObject
+		var oldT *cdcTuple
+		if raw := c.get("oldkeys"); raw != nil {
+			if err := json.Unmarshal(raw, &oldKeys); err != nil {
+				return false, false, fmt.Errorf("%s change oldkeys: %w", kind, err)
+			}
+			if oldT, err = cdcArrayTuple(oldKeys.get("keynames"), oldKeys.get("keyvalues")); err != nil {
+				return false, false, fmt.Errorf("%s change oldkeys: %w", kind, err)
+			}
+		}
+
+		ok, err := d.filterChange(table, newT, oldT, eventNo)
+		if err != nil {
+			return false, false, err
+		}
+		if !ok {
+			changed = true
+			continue
+		}
+		if newT != nil && newT.changed {
+			if err := c.set("columnvalues", newT.values); err != nil {
+				return false, false, err
+			}
+			changed = true
+		}
+		if oldT != nil && oldT.changed {
+			if err := oldKeys.set("keyvalues", oldT.values); err != nil {
+				return false, false, err
+			}
+			if err := c.set("oldkeys", oldKeys); err != nil {
+				return false, false, err
+			}
+			changed = true
+		}
+		kept = append(kept, c)
+	}
+	if !changed {
+		return true, false, nil
+	}
+	return true, true, event.set("change", kept)
+}
+
+// filterDebezium filters a Debezium event payload, returning false if
+// it should be dropped, and true if it has changed
+func (d *dumpFilter) filterDebezium(payload *jsonObject, eventNo int) (bool, bool, error) {
+
+	switch payload.getString("op") {
+	case "c", "r", "u", "d":
+	default:
+		return true, false, nil
+	}
+	var source jsonObject
+	if err := json.Unmarshal(payload.get("source"), &source); err != nil {
+		return false, false, fmt.Errorf("event source: %w", err)
+	}
+	table := databaseTableName(source.getString("db"),
+		qualifiedName(source.getString("schema"), source.getString("table")))
+
+	newT, err := cdcObjectTuple(payload.get("after"))
+	if err != nil {
+		return false, false, fmt.Errorf("event after: %w", err)
+	}
+	oldT, err := cdcObjectTuple(payload.get("before"))
+	if err != nil {
+		return false, false, fmt.Errorf("event before: %w", err)
+	}
+	if ok, err := d.filterChange(table, newT, oldT, eventNo); err != nil || !ok {
+		return ok, false, err
+	}
+
+	changed := false
+
+	for _, c := range []struct {
+		key string
+		t   *cdcTuple
+	}{{"after", newT}, {"before", oldT}} {
+		if c.t == nil || !c.t.changed {
+			continue
+		}
+		o := jsonObject{}
+		for i, n := range c.t.names {
+			o = append(o, jsonMember{n, c.t.values[i]})
+		}
+		if err := payload.set(c.key, o); err != nil {
+			return false, false, err
+		}
+		changed = true
+	}
+	return true, changed, nil
+}
+
+// filterEvent filters a change event line, returning the line to
+// output and true, or false if the event should be dropped, and true if
+// the event has changed. Unchanged events are returned verbatim
+func (d *dumpFilter) filterEvent(line []byte, eventNo int) ([]byte, bool, bool, error) {
+
+	trimmed := bytes.TrimSpace(line)
+	if len(trimmed) == 0 || string(trimmed) == "null" {
+		return line, true, false, nil
+	}
+	var event jsonObject
+	if err := json.Unmarshal(trimmed, &event); err != nil {
+		return nil, false, false, err
+	}
+
+	var ok, changed bool
+	var err error
+	switch {
+	case event.get("action") != nil:
+		ok, changed, err = d.filterWal2jsonV2(&event, eventNo)
+	case event.get("change") != nil:
+		ok, changed, err = d.filterWal2jsonV1(&event, eventNo)
+	case event.get("op") != nil:
+		ok, changed, err = d.filterDebezium(&event, eventNo)
+	case event.get("payload") != nil:
+		var payload jsonObject
+		if err := json.Unmarshal(event.get("payload"), &payload); err != nil {
+			return nil, false, false, fmt.Errorf("event payload: %w", err)
+		}
+		ok, changed, err = d.filterDebezium(&payload, eventNo)
+		if err == nil && changed {
+			err = event.set("payload", payload)
+		}
+	default:
+		return nil, false, false, errors.New("unrecognised change event format")
+	}
+	if err != nil || !ok {
+		return nil, false, true, err
+	}
+	if !changed {
+		return line, true, false, nil
+	}
+	out, err := event.MarshalJSON()
+	return out, err == nil, true, err
+}
+
+// scanCDC filters a stream of newline-delimited JSON change events,
+// writing the output to w. In test mode only changed events are output
+func scanCDC(df *dumpFilter, r *bufio.Reader, w io.Writer) error {
+
+	for eventNo := 1; ; eventNo++ {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(line) == 0 && err == io.EOF {
+			return nil
+		}
+		line = bytes.TrimRight(line, "\r\n")
+
+		out, ok, changed, ferr := df.filterEvent(line, eventNo)
+		if ferr != nil {
+			return fmt.Errorf("change event %d: %w", eventNo, ferr)
+		}
+		if ok && (changed || !df.changedOnly) {
+			if _, werr := w.Write(append(out, '\n')); werr != nil {
+				return fmt.Errorf("write error: %w", werr)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
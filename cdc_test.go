@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONValues(t *testing.T) {
+
+	texts := map[string]string{
+		`null`:         nullValue,
+		`true`:         "t",
+		`false`:        "f",
+		`"a\"b"`:       `a"b`,
+		`-1.5e3`:       "-1.5e3",
+		`{"a": [1]}`:   `{"a": [1]}`,
+		`"2024-01-01"`: "2024-01-01",
+	}
+	for raw, want := range texts {
+		if got := jsonText(json.RawMessage(raw)); got != want {
+			t.Errorf("%s: expected text %q got %q", raw, want, got)
+		}
+	}
+
+	values := []struct {
+		text, original, want string
+	}{
+		{nullValue, `"x"`, `null`},
+		{"f", `true`, `false`},
+		{"yes", `true`, `"yes"`},
+		{"42", `7`, `42`},
+		{"0x2a", `7`, `"0x2a"`},
+		{"NaN", `7`, `"NaN"`},
+		{`{"b":2}`, `{"a":1}`, `{"b":2}`},
+		{`[1`, `[1]`, `"[1"`},
+		{"42", `"7"`, `"42"`},
+		{"x", `null`, `"x"`},
+	}
+	for _, tc := range values {
+		if got := string(jsonValue(tc.text, json.RawMessage(tc.original))); got != tc.want {
+			t.Errorf("%q for %s: expected %s got %s", tc.text, tc.original, tc.want, got)
+		}
+	}
+}
+
+func TestJSONObject(t *testing.T) {
+
+	input := `{"z": 1, "a" : {"k":[1, 2]}, "m":"s"}`
+	var o jsonObject
+	if err := json.Unmarshal([]byte(input), &o); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.set("a", json.RawMessage(`null`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.set("n", []string{"x"}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := o.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"z":1,"a":null,"m":"s","n":["x"]}`; string(out) != want {
+		t.Errorf("expected %s got %s", want, out)
+	}
+	if err := json.Unmarshal([]byte(`[1]`), &o); err == nil {
+		t.Error("expected an error reading an array as an object")
+	}
+}
+
+const cdcSettings = `
+[["public.users"]]
+filter = "string replace"
+columns = ["name", "email", "active"]
+replacements = ["zachary", "z@example.com", "f"]
+notif = {"id" = "3"}
+
+[["public.secrets"]]
+filter = "delete"
+
+[["public.tokens"]]
+filter = "uuid"
+columns = ["token"]
+`
+
+func TestAnonymiseCDC(t *testing.T) {
+
+	tests := []struct {
+		name, input, want string
+	}{
+		{
+			name:  "wal2json v2 transaction boundary",
+			input: `{"action":"B","xid":100}`,
+			want:  `{"action":"B","xid":100}`,
+		},
+		{
+			name:  "wal2json v2 insert",
+			input: `{"action":"I","schema":"public","table":"users","columns":[{"name":"id","type":"integer","value":1},{"name":"name","type":"text","value":"ann"},{"name":"email","type":"text","value":"ann@corp.com"},{"name":"active","type":"boolean","value":true}]}`,
+			want:  `{"action":"I","schema":"public","table":"users","columns":[{"name":"id","type":"integer","value":1},{"name":"name","type":"text","value":"zachary"},{"name":"email","type":"text","value":"z@example.com"},{"name":"active","type":"boolean","value":false}]}`,
+		},
+		{
+			name:  "wal2json v2 insert not matching the conditions",
+			input: `{"action":"I", "schema":"public", "table":"users", "columns":[{"name":"id","type":"integer","value":3},{"name":"name","type":"text","value":"cy"}]}`,
+			want:  `{"action":"I", "schema":"public", "table":"users", "columns":[{"name":"id","type":"integer","value":3},{"name":"name","type":"text","value":"cy"}]}`,
+		},
+		{
+			name:  "wal2json v2 update",
+			input: `{"action":"U","schema":"public","table":"users","columns":[{"name":"id","type":"integer","value":1},{"name":"name","type":"text","value":"bea"},{"name":"email","type":"text","value":"ann@corp.com"},{"name":"active","type":"boolean","value":false}],"identity":[{"name":"email","type":"text","value":"ann@corp.com"}]}`,
+			want:  `{"action":"U","schema":"public","table":"users","columns":[{"name":"id","type":"integer","value":1},{"name":"name","type":"text","value":"zachary"},{"name":"email","type":"text","value":"z@example.com"},{"name":"active","type":"boolean","value":false}],"identity":[{"name":"email","type":"text","value":"z@example.com"}]}`,
+		},
+		{
+			name:  "wal2json v2 delete by a key which is not filtered",
+			input: `{"action":"D","schema":"public","table":"users","identity":[{"name":"id","type":"integer","value":2}]}`,
+			want:  `{"action":"D","schema":"public","table":"users","identity":[{"name":"id","type":"integer","value":2}]}`,
+		},
+		{
+			name:  "wal2json v2 insert into a deleted table",
+			input: `{"action":"I","schema":"public","table":"secrets","columns":[{"name":"id","type":"integer","value":1}]}`,
+		},
+		{
+			name:  "wal2json v2 insert into a table without filters",
+			input: `{"action":"I","schema":"public","table":"orders","columns":[{"name":"id","type":"integer","value":1}]}`,
+			want:  `{"action":"I","schema":"public","table":"orders","columns":[{"name":"id","type":"integer","value":1}]}`,
+		},
+		{
+			name:  "wal2json v1 transaction",
+			input: `{"xid":101,"change":[{"kind":"insert","schema":"public","table":"users","columnnames":["id","name","email","active"],"columntypes":["integer","text","text","boolean"],"columnvalues":[4,"cy","cy@corp.com",false]},{"kind":"delete","schema":"public","table":"secrets","oldkeys":{"keynames":["id"],"keytypes":["integer"],"keyvalues":[1]}},{"kind":"update","schema":"public","table":"users","columnnames":["id","email"],"columntypes":["integer","text"],"columnvalues":[4,"new@corp.com"],"oldkeys":{"keynames":["email"],"keytypes":["text"],"keyvalues":["cy@corp.com"]}}]}`,
+			want:  `{"xid":101,"change":[{"kind":"insert","schema":"public","table":"users","columnnames":["id","name","email","active"],"columntypes":["integer","text","text","boolean"],"columnvalues":[4,"zachary","z@example.com",false]},{"kind":"update","schema":"public","table":"users","columnnames":["id","email"],"columntypes":["integer","text"],"columnvalues":[4,"z@example.com"],"oldkeys":{"keynames":["email"],"keytypes":["text"],"keyvalues":["z@example.com"]}}]}`,
+		},
+		{
+			name:  "debezium update with envelope",
+			input: `{"schema":{"type":"struct"},"payload":{"before":{"id":5,"name":"dee","active":true},"after":{"id":5,"name":"dee","active":true},"source":{"db":"sales","schema":"public","table":"users"},"op":"u","ts_ms":1}}`,
+			want:  `{"schema":{"type":"struct"},"payload":{"before":{"id":5,"name":"zachary","active":false},"after":{"id":5,"name":"zachary","active":false},"source":{"db":"sales","schema":"public","table":"users"},"op":"u","ts_ms":1}}`,
+		},
+		{
+			name:  "debezium delete not matching the conditions",
+			input: `{"before":{"id":3,"name":"cy"},"after":null,"source":{"schema":"public","table":"users"},"op":"d"}`,
+			want:  `{"before":{"id":3,"name":"cy"},"after":null,"source":{"schema":"public","table":"users"},"op":"d"}`,
+		},
+		{
+			name:  "debezium snapshot read of a deleted table",
+			input: `{"before":null,"after":{"id":1},"source":{"schema":"public","table":"secrets"},"op":"r"}`,
+		},
+		{
+			name:  "debezium tombstone",
+			input: `null`,
+			want:  `null`,
+		},
+	}
+
+	inputs, wants := []string{}, []string{}
+	for _, tc := range tests {
+		inputs = append(inputs, tc.input)
+		if tc.want != "" {
+			wants = append(wants, tc.want)
+		}
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	args := anonArgs{
+		dumpFilePath: stdinPath,
+		input:        strings.NewReader(strings.Join(inputs, "\n") + "\n"),
+		settingsToml: cdcSettings,
+		output:       buffer,
+		cdc:          true,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+	got := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	if len(got) != len(wants) {
+		t.Fatalf("expected %d events got %d:\n%s", len(wants), len(got), buffer)
+	}
+	for i := range wants {
+		if got[i] != wants[i] {
+			t.Errorf("event %d: expected\n%s\ngot\n%s", i+1, wants[i], got[i])
+		}
+	}
+}
+
+func TestAnonymiseCDCConsistentKeys(t *testing.T) {
+
+	// an update of a row keyed by a column replaced with random values
+	// replaces the old key with the new key's replacement
+	input := `{"action":"U","schema":"public","table":"tokens","columns":[{"name":"token","type":"text","value":"abc"},{"name":"n","type":"text","value":"2"}],"identity":[{"name":"token","type":"text","value":"abc"}]}` + "\n" +
+		`{"action":"U","schema":"public","table":"tokens","columns":[{"name":"token","type":"text","value":"def"}],"identity":[{"name":"token","type":"text","value":"abc"}]}`
+
+	buffer := bytes.NewBuffer(nil)
+	args := anonArgs{
+		dumpFilePath: stdinPath,
+		input:        strings.NewReader(input),
+		settingsToml: cdcSettings,
+		output:       buffer,
+		changedOnly:  true,
+		cdc:          true,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+
+	type column struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	type event struct {
+		Columns  []column `json:"columns"`
+		Identity []column `json:"identity"`
+	}
+	var events []event
+	for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		var e event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if v := events[0].Columns[0].Value; v == "abc" || v != events[0].Identity[0].Value {
+		t.Errorf("unchanged key replaced inconsistently: %s %s", v, events[0].Identity[0].Value)
+	}
+	if v := events[1].Columns[0].Value; v == "def" || v == events[1].Identity[0].Value || events[1].Identity[0].Value == "abc" {
+		t.Errorf("changed key not replaced: %s %s", v, events[1].Identity[0].Value)
+	}
+}
+
+func TestAnonymiseCDCFail(t *testing.T) {
+
+	settings := cdcSettings + `
+[["public.orders"]]
+filter = "reference replace"
+columns = ["customer"]
+replacements = ["name"]
+optargs = {"fklookup" = ["user_id", "public.users.id"]}
+`
+	for _, input := range []string{
+		`{"action":"I","schema":"public","table":"orders","columns":[{"name":"customer","type":"text","value":"x"}]}`,
+		`{"unknown":"format"}`,
+		`{"action":"I","schema":"public","table":"users","columns":[{"name":"id"`,
+		`{"change":[{"kind":"insert","schema":"public","table":"users","columnnames":["id"],"columnvalues":[1,2]}]}`,
+		`[1, 2]`,
+	} {
+		args := anonArgs{
+			dumpFilePath: stdinPath,
+			input:        strings.NewReader(input + "\n"),
+			settingsToml: settings,
+			output:       bytes.NewBuffer(nil),
+			cdc:          true,
+		}
+		if err := Anonymise(args); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// stream compression methods, as may be used for whole dump files or
+// for the data files of directory format dumps
+const (
+	streamNone = "none"
+	streamGzip = "gzip"
+	streamZstd = "zstd"
+	streamLZ4  = "lz4"
+)
+
+// errOutputCompression is returned when compressing output written to
+// a database or split into files
+var errOutputCompression = errors.New("output written to a database or split into files cannot be compressed")
+
+// compressionMagic are the magic bytes at the start of each compressed
+// stream
+var compressionMagic = map[string][]byte{
+	streamGzip: {0x1f, 0x8b},
+	streamZstd: {0x28, 0xb5, 0x2f, 0xfd},
+	streamLZ4:  {0x04, 0x22, 0x4d, 0x18},
+}
+
+// compressionExtensions are the file name extensions of each stream
+// compression method
+var compressionExtensions = map[string]string{
+	".gz":  streamGzip,
+	".zst": streamZstd,
+	".lz4": streamLZ4,
+}
+
+// detectCompression returns the compression method of a stream from
+// its magic bytes, or streamNone if the stream is not compressed
+func detectCompression(r *bufio.Reader) string {
+	for method, magic := range compressionMagic {
+		if b, _ := r.Peek(len(magic)); bytes.Equal(b, magic) {
+			return method
+		}
+	}
+	return streamNone
+}
+
+// compressionFromExtension returns the compression method suggested by
+// a file name extension, or streamNone
+func compressionFromExtension(path string) string {
+	if method, ok := compressionExtensions[filepath.Ext(path)]; ok {
+		return method
+	}
+	return streamNone
+}
+
+// zstdReadCloser adapts a zstd decoder, which does not return an error
+// on Close, to io.ReadCloser
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+// Close releases the decoder's resources
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
+
+// newStreamDecompressor returns a reader decompressing r with the
+// compression method
+func newStreamDecompressor(method string, r io.Reader) (io.ReadCloser, error) {
+	switch method {
+	case streamNone:
+		return io.NopCloser(r), nil
+	case streamGzip:
+		return gzip.NewReader(r)
+	case streamZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReadCloser{zr}, nil
+	case streamLZ4:
+		return io.NopCloser(lz4.NewReader(r)), nil
+	}
+	return nil, fmt.Errorf("compression method %s not supported", method)
+}
+
+// newStreamCompressor returns a writer compressing to w with the
+// compression method
+func newStreamCompressor(method string, w io.Writer) (io.WriteCloser, error) {
+	switch method {
+	case streamNone:
+		return nopWriteCloser{w}, nil
+	case streamGzip:
+		return gzip.NewWriter(w), nil
+	case streamZstd:
+		return zstd.NewWriter(w)
+	case streamLZ4:
+		return lz4.NewWriter(w), nil
+	}
+	return nil, fmt.Errorf("compression method %s not supported", method)
+}
+
+// decompressStream returns a buffered reader of the decompressed
+// contents of r, detecting the compression method from magic bytes.
+// The returned closer releases the resources of the decompressor
+func decompressStream(r io.Reader) (*bufio.Reader, io.Closer, error) {
+	br := bufio.NewReader(r)
+	method := detectCompression(br)
+	if method == streamNone {
+		return br, io.NopCloser(br), nil
+	}
+	dr, err := newStreamDecompressor(method, br)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s decompression error: %w", method, err)
+	}
+	return bufio.NewReader(dr), dr, nil
+}
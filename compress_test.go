@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// compressBytes compresses b with the compression method
+func compressBytes(t *testing.T, method string, b []byte) []byte {
+	t.Helper()
+
+	buf := bytes.NewBuffer(nil)
+	w, err := newStreamCompressor(method, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestStreamCompression(t *testing.T) {
+
+	contents := []byte("COPY public.users (id, name) FROM stdin;\n1\tariadne\n\\.\n")
+	for _, method := range []string{streamNone, streamGzip, streamZstd, streamLZ4} {
+		compressed := compressBytes(t, method, contents)
+		if got := detectCompression(bufio.NewReader(bytes.NewReader(compressed))); got != method {
+			t.Errorf("%s: detected compression %s", method, got)
+		}
+		r, closer, err := decompressStream(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatalf("%s: decompression error %s", method, err)
+		}
+		b, err := io.ReadAll(r)
+		closer.Close()
+		if err != nil {
+			t.Fatalf("%s: read error %s", method, err)
+		}
+		if !bytes.Equal(b, contents) {
+			t.Errorf("%s: round trip failed, got %q", method, b)
+		}
+	}
+	if _, err := newStreamCompressor("bzip2", io.Discard); err == nil {
+		t.Error("expected unsupported compression error")
+	}
+}
+
+func TestCompressionFromExtension(t *testing.T) {
+	tests := map[string]string{
+		"dump.sql":     streamNone,
+		"dump.sql.gz":  streamGzip,
+		"dump.sql.zst": streamZstd,
+		"dump.sql.lz4": streamLZ4,
+		"":             streamNone,
+	}
+	for path, want := range tests {
+		if got := compressionFromExtension(path); got != want {
+			t.Errorf("%s: expected %s got %s", path, want, got)
+		}
+	}
+}
+
+// TestAnonymiseCompressed tests that compressed plain dumps and custom
+// format archives are anonymised, including the reference table scan,
+// and that the output may be compressed
+func TestAnonymiseCompressed(t *testing.T) {
+
+	tomlString, err := os.ReadFile("testdata/settings.toml")
+	if err != nil {
+		t.Fatalf("could not read settings file: %s", err)
+	}
+	plain, err := os.ReadFile("testdata/pg_dump.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{streamGzip, streamZstd, streamLZ4} {
+
+		dumpFile := filepath.Join(t.TempDir(), "dump.sql")
+		if err := os.WriteFile(dumpFile, compressBytes(t, method, plain), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		buffer := bytes.NewBuffer(nil)
+		args := anonArgs{
+			dumpFilePath:      dumpFile,
+			settingsToml:      string(tomlString),
+			output:            buffer,
+			outputCompression: method,
+		}
+		if err := Anonymise(args); err != nil {
+			t.Fatalf("%s: Anonymise should not fail: %s", method, err)
+		}
+
+		r, closer, err := decompressStream(buffer)
+		if err != nil {
+			t.Fatalf("%s: output decompression error %s", method, err)
+		}
+		b, err := io.ReadAll(r)
+		closer.Close()
+		if err != nil {
+			t.Fatalf("%s: output read error %s", method, err)
+		}
+		output := string(b)
+		if !strings.Contains(output, "3\t5\tvanessa\n") {
+			t.Errorf("%s: fkexample not anonymised by reference", method)
+		}
+		if strings.Contains(output, "ariadne") {
+			t.Errorf("%s: users not anonymised", method)
+		}
+
+		// custom format archives may also be compressed as a whole
+		archiveFile := filepath.Join(t.TempDir(), "dump.custom.gz")
+		archive := makeCustomArchive(t, testArchiveHeader(16, compressionNone))
+		if err := os.WriteFile(archiveFile, compressBytes(t, method, archive), 0644); err != nil {
+			t.Fatal(err)
+		}
+		buffer.Reset()
+		args = anonArgs{
+			dumpFilePath: archiveFile,
+			settingsToml: string(tomlString),
+			output:       buffer,
+		}
+		if err := Anonymise(args); err != nil {
+			t.Fatalf("%s: Anonymise of archive should not fail: %s", method, err)
+		}
+		_, tables := readCustomArchive(t, buffer.Bytes())
+		if got := tables["public.fkexample"]; !strings.Contains(got, "3\t5\tvanessa\n") {
+			t.Errorf("%s: archive fkexample not anonymised, got %q", method, got)
+		}
+	}
+}
+
+func TestAnonymiseCompressedFail(t *testing.T) {
+
+	// compression is refused for output written to a database or split
+	// into files, rather than silently dropped
+	tests := []anonArgs{
+		{database: "host=localhost dbname=test", outputCompression: streamGzip},
+		{splitDir: t.TempDir(), outputCompression: streamZstd},
+	}
+	for i, args := range tests {
+		args.dumpFilePath = "testdata/pg_dump.sql"
+		args.settingsToml = "[[\"public.users\"]]\nfilter = \"delete\"\n"
+		if err := Anonymise(args); err != errOutputCompression {
+			t.Errorf("test %d: expected a compression error, got %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// copyNull is the representation of NULL in COPY text format data
+const copyNull = `\N`
+
+// nullValue is the decoded value of a NULL column. As postgresql text
+// values cannot contain a zero byte it cannot be confused with the
+// value of a column which is not NULL. In settings NULL is written as
+// in COPY data, as \N
+const nullValue = "\x00"
+
+// copyEscaper escapes the characters which must be escaped in COPY
+// text format data
+var copyEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"\b", `\b`,
+	"\f", `\f`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+	"\v", `\v`,
+)
+
+// copyEscape escapes a value for COPY text format data
+func copyEscape(s string) string {
+	return copyEscaper.Replace(s)
+}
+
+// copyUnescape decodes the backslash escapes of a COPY text format
+// value, which apart from the single character escapes may be octal
+// (\o, \oo or \ooo) or hexadecimal (\xh or \xhh) byte values. Other
+// escaped characters are taken literally
+func copyUnescape(s string) string {
+
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i == len(s)-1 {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = s[i]; c {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			j := i + 1
+			for j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7' {
+				j++
+			}
+			n, _ := strconv.ParseUint(s[i:j], 8, 8)
+			b.WriteByte(byte(n))
+			i = j - 1
+		case 'x':
+			j := i + 1
+			for j < len(s) && j < i+3 && isHexDigit(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				b.WriteByte(c)
+				continue
+			}
+			n, _ := strconv.ParseUint(s[i+1:j], 16, 8)
+			b.WriteByte(byte(n))
+			i = j - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// isHexDigit reports if c is a hexadecimal digit
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// decodeCopyLine splits a line of COPY text format data into its
+// decoded column values, in which NULL is nullValue
+func decodeCopyLine(line string) []string {
+	columns := strings.Split(line, "\t")
+	for i, c := range columns {
+		columns[i] = decodeCopyValue(c)
+	}
+	return columns
+}
+
+// decodeCopyValue decodes a single COPY text format column value
+func decodeCopyValue(s string) string {
+	if s == copyNull {
+		return nullValue
+	}
+	return copyUnescape(s)
+}
+
+// encodeCopyLine makes a line of COPY text format data from decoded
+// column values
+func encodeCopyLine(columns []string) string {
+	var b strings.Builder
+	for i, c := range columns {
+		if i > 0 {
+			b.WriteByte('\t')
+		}
+		if c == nullValue {
+			b.WriteString(copyNull)
+			continue
+		}
+		b.WriteString(copyEscape(c))
+	}
+	return b.String()
+}
+
+// settingsValue returns the value of a column given in settings, in
+// which \N is NULL. As other values are taken literally, the text \N is
+// written with an extra leading backslash, as \\N, and a value of more
+// backslashes followed by N loses one of them, so that \\\N is \\N.
+func settingsValue(s string) string {
+	if s == copyNull {
+		return nullValue
+	}
+	if len(s) > len(copyNull) && strings.TrimLeft(s, `\`) == "N" {
+		return s[1:]
+	}
+	return s
+}
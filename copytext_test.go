@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCopyEscape(t *testing.T) {
+	tests := []struct {
+		value, escaped string
+	}{
+		{"plain", "plain"},
+		{"a\tb\nc\rd", `a\tb\nc\rd`},
+		{`back\slash`, `back\\slash`},
+		{"\b\f\v", `\b\f\v`},
+	}
+	for _, tc := range tests {
+		if got := copyEscape(tc.value); got != tc.escaped {
+			t.Errorf("escape %q: expected %q got %q", tc.value, tc.escaped, got)
+		}
+		if got := copyUnescape(tc.escaped); got != tc.value {
+			t.Errorf("unescape %q: expected %q got %q", tc.escaped, tc.value, got)
+		}
+	}
+
+	// octal, hexadecimal and other escapes
+	for escaped, value := range map[string]string{
+		`\101\x42\x4`: "AB\x04",
+		`\q\`:         `q\`,
+		`\xz`:         "xz",
+	} {
+		if got := copyUnescape(escaped); got != value {
+			t.Errorf("unescape %q: expected %q got %q", escaped, value, got)
+		}
+	}
+}
+
+func TestCopyLine(t *testing.T) {
+	line := `1	\N	tab\there	back\\slash	\\N	`
+	want := []string{"1", nullValue, "tab\there", `back\slash`, `\N`, ""}
+	columns := decodeCopyLine(line)
+	if !equalColumns(columns, want) {
+		t.Errorf("decode: expected %q got %q", want, columns)
+	}
+	if got := encodeCopyLine(columns); got != line {
+		t.Errorf("encode: expected %q got %q", line, got)
+	}
+}
+
+func TestSettingsValue(t *testing.T) {
+	for value, want := range map[string]string{
+		`\N`:     nullValue,
+		`\\N`:    `\N`,
+		`\\\N`:   `\\N`,
+		`N`:      `N`,
+		`\\`:     `\\`,
+		`a\N`:    `a\N`,
+		`\\Nope`: `\\Nope`,
+	} {
+		if got := settingsValue(value); got != want {
+			t.Errorf("%q: expected %q got %q", value, want, got)
+		}
+	}
+}
+
+func TestAnonymiseCopyEscapes(t *testing.T) {
+
+	dump := strings.Join([]string{
+		`COPY public.notes (id, author, body, path) FROM stdin;`,
+		"1\t\\N\tline one\\nline two\tC:\\\\temp",
+		"2\tanon\ta\\tb\t\\N",
+		"3\t\\\\N\tplain\tx",
+		"4\t\\N\tother\tp",
+		`\.`,
+		``,
+	}, "\n")
+
+	settings := `
+[["public.notes"]]
+filter = "string replace"
+columns = ["author"]
+replacements = ['D:\data\t']
+if = {"body" = "line one\nline two"}
+
+[["public.notes"]]
+filter = "string replace"
+columns = ["path"]
+replacements = ['\N']
+if = {"author" = '\N'}
+
+[["public.notes"]]
+filter = "string replace"
+columns = ["body"]
+replacements = ["tab	separated"]
+notif = {"path" = '\N'}
+
+[["public.notes"]]
+filter = "string replace"
+columns = ["path"]
+replacements = ['\\N']
+if = {"author" = '\\N'}
+`
+	dumpFile := filepath.Join(t.TempDir(), "dump.sql")
+	if err := os.WriteFile(dumpFile, []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	}
+	buffer := bytes.NewBuffer(nil)
+	args := anonArgs{dumpFilePath: dumpFile, settingsToml: settings, output: buffer}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+
+	// the first row matches on a decoded newline and has a backslash
+	// replacement; the author of the third row is the string \N, not
+	// NULL, matched and replaced with the string \N by \\N in settings,
+	// and the path of the fourth row is replaced with NULL
+	for _, want := range []string{
+		"1\tD:\\\\data\\\\t\ttab\\tseparated\tC:\\\\temp\n",
+		"2\tanon\ta\\tb\t\\N\n",
+		"3\t\\\\N\ttab\\tseparated\t\\\\N\n",
+		"4\t\\N\tother\t\\N\n",
+	} {
+		if !strings.Contains(buffer.String(), want) {
+			t.Errorf("output does not contain %q\n%s", want, buffer.String())
+		}
+	}
+}
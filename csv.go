@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Standalone exports made with COPY ... TO 'file.csv' WITH CSV HEADER
+// may be anonymised with the filters of a settings table. The header
+// row provides the column names of the table, and each row is parsed
+// following RFC 4180, as postgresql writes it: fields are separated by
+// the delimiter, and may be enclosed in double quotes, in which a double
+// quote is doubled and delimiters and line endings are literal. An
+// unquoted empty field is NULL, while a quoted empty field is an empty
+// string.
+//
+// Unchanged rows are written verbatim. The fields of changed rows are
+// quoted as they were in the input, and otherwise only if required.
+
+// csvQuote is the quote character of CSV fields
+const csvQuote = '"'
+
+// csvOptions are the options of a CSV or TSV export
+type csvOptions struct {
+	table     string // the settings table of the export
+	delimiter byte
+}
+
+// csvField is a field of a CSV record, recording if it was quoted
+type csvField struct {
+	value  string
+	quoted bool
+}
+
+// csvRecord is a record of a CSV export
+type csvRecord struct {
+	fields []csvField
+	raw    string // the record as read, including its line ending
+	ending string // the line ending of the record
+}
+
+// newCSVOptions returns the options of a CSV or TSV export at path, or
+// nil if the input is not an export. Exports are recognised by a .csv
+// or .tsv extension, before any compression extension, unless force is
+// set. The table defaults to the file name without its extensions, such
+// as public.users for public.users.csv, in the public schema if the
+// name is not schema qualified, and the delimiter to a comma for CSV
+// and a tab for TSV exports
+func newCSVOptions(path, table, delimiter string, force bool) (*csvOptions, error) {
+
+	name := filepath.Base(path)
+	if compressionFromExtension(name) != streamNone {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if !force && ext != ".csv" && ext != ".tsv" {
+		if table != "" || delimiter != "" {
+			return nil, errors.New("the table and delimiter options are only for CSV or TSV exports")
+		}
+		return nil, nil
+	}
+
+	opts := &csvOptions{delimiter: ','}
+	switch {
+	case delimiter == `\t`:
+		opts.delimiter = '\t'
+	case len(delimiter) == 1:
+		opts.delimiter = delimiter[0]
+	case delimiter != "":
+		return nil, fmt.Errorf("csv delimiter %q is not a single character", delimiter)
+	case ext == ".tsv":
+		opts.delimiter = '\t'
+	}
+	if opts.delimiter == csvQuote || opts.delimiter == '\r' || opts.delimiter == '\n' {
+		return nil, fmt.Errorf("csv delimiter %q is not permitted", opts.delimiter)
+	}
+
+	if table == "" {
+		if isStdin(path) {
+			return nil, errors.New("a table is required for a CSV or TSV export read from stdin")
+		}
+		table = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	parts, err := parseQualifiedName(table, 1, 2)
+	if err != nil {
+		return nil, fmt.Errorf("csv table name error: %w", err)
+	}
+	if len(parts) == 1 {
+		parts = append([]string{"public"}, parts...)
+	}
+	opts.table = tableName(parts)
+	return opts, nil
+}
+
+// readRecord reads the next record of an export, which may span several
+// lines if a quoted field includes a line ending. At the end of the
+// input io.EOF is returned
+func (o csvOptions) readRecord(r *bufio.Reader) (*csvRecord, error) {
+	var raw strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		raw.WriteString(line)
+		if raw.Len() == 0 && err == io.EOF {
+			return nil, io.EOF
+		}
+		rec, complete, perr := o.splitRecord(raw.String())
+		if perr != nil {
+			return nil, perr
+		}
+		if complete {
+			return rec, nil
+		}
+		if err == io.EOF {
+			return nil, errors.New("csv record has an unterminated quoted field")
+		}
+	}
+}
+
+// splitRecord splits the text of a record into its fields, returning
+// false if the record ends within a quoted field
+func (o csvOptions) splitRecord(s string) (*csvRecord, bool, error) {
+
+	rec := &csvRecord{raw: s}
+	i := 0
+	for {
+		if i < len(s) && s[i] == csvQuote {
+			var b strings.Builder
+			for i++; ; i++ {
+				if i == len(s) {
+					return nil, false, nil
+				}
+				if s[i] != csvQuote {
+					b.WriteByte(s[i])
+					continue
+				}
+				if i+1 < len(s) && s[i+1] == csvQuote {
+					b.WriteByte(csvQuote)
+					i++
+					continue
+				}
+				i++
+				break
+			}
+			rec.fields = append(rec.fields, csvField{value: b.String(), quoted: true})
+		} else {
+			j := i
+			for j < len(s) && s[j] != o.delimiter && s[j] != '\r' && s[j] != '\n' {
+				if s[j] == csvQuote {
+					return nil, false, fmt.Errorf("csv record has a quote within an unquoted field: %s", s)
+				}
+				j++
+			}
+			rec.fields = append(rec.fields, csvField{value: s[i:j]})
+			i = j
+		}
+
+		switch {
+		case i == len(s):
+			return rec, true, nil
+		case s[i] == o.delimiter:
+			i++
+		case s[i:] == "\n" || s[i:] == "\r\n":
+			rec.ending = s[i:]
+			return rec, true, nil
+		default:
+			return nil, false, fmt.Errorf("csv record has unexpected text after a quoted field: %s", s)
+		}
+	}
+}
+
+// values returns the values of the fields of a record, with unquoted
+// empty fields as NULL
+func (rec *csvRecord) values() []string {
+	values := make([]string, len(rec.fields))
+	for i, f := range rec.fields {
+		values[i] = f.value
+		if f.value == "" && !f.quoted {
+			values[i] = nullValue
+		}
+	}
+	return values
+}
+
+// formatRecord formats the values of a record in CSV format, quoting
+// the fields which were quoted in rec and those which require quoting
+func (o csvOptions) formatRecord(rec *csvRecord, values []string) string {
+	var b strings.Builder
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(o.delimiter)
+		}
+		if v == nullValue {
+			continue
+		}
+		writeCSVField(&b, v, o.delimiter, i < len(rec.fields) && rec.fields[i].quoted)
+	}
+	b.WriteString(rec.ending)
+	return b.String()
+}
+
+// writeCSVField writes a value as a CSV field, quoted if quote is set or
+// the value requires quoting. Empty strings are quoted, to distinguish
+// them from NULL
+func writeCSVField(b *strings.Builder, v string, delimiter byte, quote bool) {
+	if !quote && v != "" && v != `\.` && !strings.ContainsAny(v, string([]byte{delimiter, csvQuote, '\r', '\n'})) {
+		b.WriteString(v)
+		return
+	}
+	b.WriteByte(csvQuote)
+	b.WriteString(strings.ReplaceAll(v, `"`, `""`))
+	b.WriteByte(csvQuote)
+}
+
+// startCSVTable initialises the dumpFilter for the rows of an export of
+// a table with the columns of its header row
+func (d *dumpFilter) startCSVTable(name string, columns []string) error {
+	return d.initTable(name, func(refContext bool) (*DumpTable, error) {
+		return newInsertDumpTable(name, columns, nil, refContext, d.tableFilters)
+	})
+}
+
+// scanCSV filters a CSV or TSV export of a table, writing the output to
+// w. In test mode only the header and changed rows are output
+func scanCSV(df *dumpFilter, opts csvOptions, r *bufio.Reader, w io.Writer) error {
+
+	header, err := opts.readRecord(r)
+	if err == io.EOF {
+		return fmt.Errorf("csv export of table %s has no header row", opts.table)
+	}
+	if err != nil {
+		return fmt.Errorf("csv header error: %w", err)
+	}
+	columns := header.values()
+	for i, c := range columns {
+		if c == nullValue || c == "" {
+			return fmt.Errorf("csv header of table %s has an empty column name at column %d", opts.table, i+1)
+		}
+	}
+
+	if err := df.startCSVTable(opts.table, columns); err != nil {
+		return err
+	}
+	if !df.dt.Inited() {
+		if df.referenceMode {
+			return nil
+		}
+		return fmt.Errorf("no filters are set for csv table %s", opts.table)
+	}
+	if !df.referenceMode {
+		if _, err := io.WriteString(w, header.raw); err != nil {
+			return fmt.Errorf("write error: %w", err)
+		}
+	}
+
+	for rowNo := 1; ; rowNo++ {
+		rec, err := opts.readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("csv table %s row %d: %w", opts.table, rowNo, err)
+		}
+		values := rec.values()
+		if len(values) != len(columns) {
+			return fmt.Errorf("csv table %s row %d has %d fields, expected %d", opts.table, rowNo, len(values), len(columns))
+		}
+		df.dt.lines++
+
+		filtered, ok, err := df.filterRow(append([]string{}, values...))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		changed := false
+		for i := range values {
+			changed = changed || filtered[i] != values[i]
+		}
+		out := rec.raw
+		switch {
+		case changed:
+			out = opts.formatRecord(rec, filtered)
+		case df.changedOnly:
+			continue
+		}
+		if _, err := io.WriteString(w, out); err != nil {
+			return fmt.Errorf("write error: %w", err)
+		}
+	}
+	df.endTable()
+	return nil
+}
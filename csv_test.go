@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewCSVOptions(t *testing.T) {
+
+	tests := []struct {
+		path, table, delimiter string
+		force                  bool
+		want                   *csvOptions
+		err                    bool
+	}{
+		{path: "dump.sql", want: nil},
+		{path: "public.users.csv", want: &csvOptions{"public.users", ','}},
+		{path: "/tmp/exports/users.CSV", want: &csvOptions{"public.users", ','}},
+		{path: "sales.orders.tsv.gz", want: &csvOptions{"sales.orders", '\t'}},
+		{path: "extract.txt", table: `crm."Contacts"`, delimiter: "|", force: true, want: &csvOptions{`crm."Contacts"`, '|'}},
+		{path: "-", table: "public.users", delimiter: `\t`, force: true, want: &csvOptions{"public.users", '\t'}},
+		{path: "dump.sql", table: "public.users", err: true},
+		{path: "-", force: true, err: true},
+		{path: "users.csv", delimiter: "||", err: true},
+		{path: "users.csv", delimiter: `"`, err: true},
+		{path: "a.b.c.csv", err: true},
+	}
+	for _, tc := range tests {
+		got, err := newCSVOptions(tc.path, tc.table, tc.delimiter, tc.force)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.path)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected %v got %v (%v)", tc.path, tc.want, got, err)
+		}
+	}
+}
+
+func TestCSVRecords(t *testing.T) {
+
+	input := "id,name,note\r\n" +
+		"1,\"Smith, J\",\"said \"\"hi\"\"\"\r\n" +
+		"2,,\"\"\r\n" +
+		"3,\"two\nlines\",x"
+	opts := csvOptions{table: "public.t", delimiter: ','}
+	r := bufio.NewReader(strings.NewReader(input))
+
+	want := [][]csvField{
+		{{"id", false}, {"name", false}, {"note", false}},
+		{{"1", false}, {"Smith, J", true}, {`said "hi"`, true}},
+		{{"2", false}, {"", false}, {"", true}},
+		{{"3", false}, {"two\nlines", true}, {"x", false}},
+	}
+	raw := ""
+	for i, w := range want {
+		rec, err := opts.readRecord(r)
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(rec.fields, w) {
+			t.Errorf("record %d: expected %v got %v", i, w, rec.fields)
+		}
+		// unchanged values are formatted as they were read
+		if got := opts.formatRecord(rec, rec.values()); got != rec.raw {
+			t.Errorf("record %d: formatted as %q, read as %q", i, got, rec.raw)
+		}
+		raw += rec.raw
+	}
+	if raw != input {
+		t.Errorf("raw records %q do not make up the input", raw)
+	}
+	if _, err := opts.readRecord(r); err != io.EOF {
+		t.Errorf("expected the end of the input, got %v", err)
+	}
+
+	rec, _ := opts.readRecord(bufio.NewReader(strings.NewReader("2,,\"\"\n")))
+	if got := rec.values(); !reflect.DeepEqual(got, []string{"2", nullValue, ""}) {
+		t.Errorf("unexpected values %q", got)
+	}
+	got := opts.formatRecord(rec, []string{"a,b", "", nullValue})
+	if got != "\"a,b\",\"\",\n" {
+		t.Errorf("unexpected formatted record %q", got)
+	}
+
+	for _, bad := range []string{"1,\"open\n", "1,ab\"c\n", "1,\"a\"b\n"} {
+		if _, err := opts.readRecord(bufio.NewReader(strings.NewReader(bad))); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestAnonymiseCSV(t *testing.T) {
+
+	settings := `
+[["public.users"]]
+filter = "string replace"
+columns = ["name", "note"]
+replacements = ["Doe, \"Jo\"", "redacted"]
+notif = {"id" = "3"}
+
+[["public.orders"]]
+filter = "reference replace"
+columns = ["customer"]
+replacements = ["name"]
+optargs = {"fklookup" = ["user_id", "public.users.id"]}
+`
+	tests := []struct {
+		name, file, input, want string
+		changedOnly             bool
+	}{
+		{
+			name: "csv",
+			file: "public.users.csv",
+			input: "id,name,note\n" +
+				"1,Smith,\"multi\nline\"\n" +
+				"2,\"Jones\",\n" +
+				"3,\"Brown, A\",\"kept\"\"\"\n",
+			want: "id,name,note\n" +
+				"1,\"Doe, \"\"Jo\"\"\",\"redacted\"\n" +
+				"2,\"Doe, \"\"Jo\"\"\",redacted\n" +
+				"3,\"Brown, A\",\"kept\"\"\"\n",
+		},
+		{
+			name: "tsv",
+			file: "users.tsv",
+			input: "id\tname\tnote\r\n" +
+				"1\tSmith, J\t\"a\tb\"\r\n" +
+				"3\tBrown\t\r\n",
+			want: "id\tname\tnote\r\n" +
+				"1\t\"Doe, \"\"Jo\"\"\"\t\"redacted\"\r\n" +
+				"3\tBrown\t\r\n",
+		},
+		{
+			name:        "test mode",
+			file:        "public.users.csv",
+			input:       "id,name,note\n1,Smith,a\n3,Brown,b\n",
+			want:        "id,name,note\n1,\"Doe, \"\"Jo\"\"\",redacted\n",
+			changedOnly: true,
+		},
+	}
+
+	for _, tc := range tests {
+		path := filepath.Join(t.TempDir(), tc.file)
+		if err := os.WriteFile(path, []byte(tc.input), 0644); err != nil {
+			t.Fatal(err)
+		}
+		opts, err := newCSVOptions(path, "", "", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buffer := bytes.NewBuffer(nil)
+		args := anonArgs{
+			dumpFilePath: path,
+			settingsToml: settings,
+			output:       buffer,
+			changedOnly:  tc.changedOnly,
+			csv:          opts,
+		}
+		if err := Anonymise(args); err != nil {
+			t.Fatalf("%s: Anonymise should not fail: %s", tc.name, err)
+		}
+		if got := buffer.String(); got != tc.want {
+			t.Errorf("%s: expected\n%q\ngot\n%q", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestAnonymiseCSVFail(t *testing.T) {
+
+	settings := `
+[["public.users"]]
+filter = "string replace"
+columns = ["name"]
+replacements = ["zachary"]
+`
+	tests := []struct {
+		name, table, input string
+	}{
+		{"no header", "public.users", ""},
+		{"empty column name", "public.users", "id,,name\n"},
+		{"missing column", "public.users", "id,email\n1,a@example.com\n"},
+		{"short row", "public.users", "id,name\n1\n"},
+		{"no filters", "public.orders", "id,name\n1,a\n"},
+	}
+	for _, tc := range tests {
+		args := anonArgs{
+			dumpFilePath: stdinPath,
+			input:        strings.NewReader(tc.input),
+			settingsToml: settings,
+			output:       bytes.NewBuffer(nil),
+			csv:          &csvOptions{table: tc.table, delimiter: ','},
+		}
+		if err := Anonymise(args); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
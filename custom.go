@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// chunkSize is the maximum size of the data chunks written to custom
+// format data blocks
+const chunkSize = 32 * 1024
+
+// chunkReader reads the length prefixed chunks of a custom format data
+// block as a single stream, returning io.EOF at the zero length chunk
+// terminating the block
+type chunkReader struct {
+	ar        *archiveReader
+	remaining int
+	done      bool
+}
+
+// Read reads data from the current chunk, moving to the next chunk if
+// the current one is exhausted
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		n := c.ar.readInt()
+		if c.ar.err != nil {
+			return 0, c.ar.err
+		}
+		if n < 0 {
+			return 0, fmt.Errorf("invalid chunk length %d", n)
+		}
+		if n == 0 {
+			c.done = true
+			return 0, io.EOF
+		}
+		c.remaining = n
+	}
+	if len(p) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.ar.r.Read(p)
+	c.remaining -= n
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// chunkWriter writes each write as a length prefixed chunk of a custom
+// format data block
+type chunkWriter struct {
+	aw *archiveWriter
+}
+
+// Write writes p as a single chunk
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c.aw.writeInt(len(p))
+	return c.aw.Write(p)
+}
+
+// nopWriteCloser adds a no-op Close method to an io.Writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close does nothing
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// newDecompressor returns a reader decompressing archive data stored
+// with the compression algorithm algo. Gzip compressed archive data is
+// stored as a zlib stream
+func newDecompressor(algo byte, r io.Reader) (io.ReadCloser, error) {
+	switch algo {
+	case compressionNone:
+		return io.NopCloser(r), nil
+	case compressionGzip:
+		return zlib.NewReader(r)
+	case compressionLZ4:
+		return newStreamDecompressor(streamLZ4, r)
+	case compressionZstd:
+		return newStreamDecompressor(streamZstd, r)
+	}
+	return nil, fmt.Errorf("archive compression algorithm %d not supported", algo)
+}
+
+// newCompressor returns a writer compressing archive data with the
+// compression algorithm, and where available the level, recorded in
+// the archive header
+func newCompressor(h archiveHeader, w io.Writer) (io.WriteCloser, error) {
+	switch h.algorithm() {
+	case compressionNone:
+		return nopWriteCloser{w}, nil
+	case compressionGzip:
+		level := zlib.DefaultCompression
+		if h.version() < archiveVersion1_15 && h.compression <= zlib.BestCompression {
+			level = h.compression
+		}
+		return zlib.NewWriterLevel(w, level)
+	case compressionLZ4:
+		return newStreamCompressor(streamLZ4, w)
+	case compressionZstd:
+		return newStreamCompressor(streamZstd, w)
+	}
+	return nil, fmt.Errorf("archive compression algorithm %d not supported", h.algorithm())
+}
+
+// copyChunks copies the chunks of a data block verbatim, including the
+// terminating zero length chunk
+func copyChunks(ar *archiveReader, aw *archiveWriter) error {
+	for {
+		n := ar.readInt()
+		if ar.err != nil {
+			return ar.err
+		}
+		aw.writeInt(n)
+		if n == 0 {
+			return aw.err
+		}
+		if _, err := io.CopyN(aw, ar.r, int64(n)); err != nil {
+			return err
+		}
+	}
+}
+
+// copyBlobs copies a large object block verbatim. Each large object
+// is recorded as an oid followed by data chunks, and the block is
+// terminated by a zero oid
+func copyBlobs(ar *archiveReader, aw *archiveWriter) error {
+	for {
+		oid := ar.readInt()
+		if ar.err != nil {
+			return ar.err
+		}
+		aw.writeInt(oid)
+		if oid == 0 {
+			return aw.err
+		}
+		if err := copyChunks(ar, aw); err != nil {
+			return err
+		}
+	}
+}
+
+// filterData filters the lines of the data of a single table, such as
+// an archive data block, with a dumpFilter initialised for the table,
+// writing the output lines to out
+func filterData(df *dumpFilter, in io.Reader, out io.Writer) error {
+
+	lr := newLineReader(in)
+	defer lr.Close()
+	for {
+		err := df.filterNext(lr, out)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// finish tables without a terminating line
+	return df.finish()
+}
+
+// filterCustomData filters the data block of a toc entry if the table
+// described by its copy statement is of interest to the dumpFilter,
+// otherwise the block is copied verbatim. In test mode the filtered
+// lines are written in plain text to text rather than to the archive
+func filterCustomData(df *dumpFilter, h archiveHeader, te *tocEntry, ar *archiveReader, aw *archiveWriter, text io.Writer) error {
+
+	// initialise the dump table from the toc entry
+	line, ok, err := df.startEntry(te)
+	if err != nil {
+		return err
+	}
+	if !df.dt.Inited() {
+		return copyChunks(ar, aw)
+	}
+
+	var out io.WriteCloser
+	var chunks *bufio.Writer
+	if df.changedOnly {
+		if ok {
+			if _, err := io.WriteString(text, line+"\n"); err != nil {
+				return fmt.Errorf("write error: %w", err)
+			}
+		}
+		out = nopWriteCloser{text}
+	} else {
+		chunks = bufio.NewWriterSize(&chunkWriter{aw}, chunkSize)
+		out, err = newCompressor(h, chunks)
+		if err != nil {
+			return err
+		}
+	}
+
+	cr := &chunkReader{ar: ar}
+	in, err := newDecompressor(h.algorithm(), cr)
+	switch {
+	case errors.Is(err, io.EOF):
+		// an empty data block
+		in = io.NopCloser(strings.NewReader(""))
+	case err != nil:
+		return fmt.Errorf("data decompression error: %w", err)
+	}
+
+	if err := filterData(df, in, out); err != nil {
+		return err
+	}
+
+	// read to the end of the block
+	if _, err := io.Copy(io.Discard, cr); err != nil {
+		return err
+	}
+	if err := in.Close(); err != nil {
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if chunks != nil {
+		if err := chunks.Flush(); err != nil {
+			return err
+		}
+		aw.writeInt(0)
+	}
+	return aw.err
+}
+
+// scanCustom filters the table data of a pg_dump custom format
+// archive read from r, writing a new custom format archive to w. Data
+// blocks for tables without filters, large objects without filters and
+// the table of contents are copied verbatim, apart from the entries of
+// dropped large objects. If w is seekable the table of contents
+// is rewritten with the data block offsets, as pg_dump does, to allow
+// parallel restores.
+func scanCustom(df *dumpFilter, r io.Reader, w io.Writer) error {
+
+	ar := newArchiveReader(r)
+	h, err := ar.readHeader()
+	if err != nil {
+		return err
+	}
+	if h.format != archCustom {
+		return fmt.Errorf("archive format %d is not a custom format archive", h.format)
+	}
+	toc, err := ar.readTOC(h)
+	if err != nil {
+		return err
+	}
+	if h.dumpVersion.String != "" {
+		if err := df.setDumpVersion(h.dumpVersion.String); err != nil {
+			return err
+		}
+	}
+	if err := df.readDefinitions(toc); err != nil {
+		return err
+	}
+	entries := map[int]*tocEntry{}
+	for _, te := range toc {
+		entries[te.dumpID] = te
+	}
+	toc = df.filterLargeObjectTOC(toc)
+
+	// an archive is not written in reference or test mode
+	archiveOut := w
+	if df.referenceMode || df.changedOnly {
+		archiveOut = io.Discard
+	}
+	aw := newArchiveWriter(archiveOut)
+	if err := aw.writeHeader(h); err != nil {
+		return err
+	}
+
+	// the data offsets are unknown until the data blocks are written
+	tocPos := aw.pos
+	for _, te := range toc {
+		if te.dataState != offsetNoData {
+			te.dataState, te.dataPos = offsetPosNotSet, 0
+		}
+	}
+	if err := aw.writeTOC(h, toc); err != nil {
+		return err
+	}
+
+	for {
+		blkType := ar.readByte()
+		if ar.err == io.EOF {
+			break
+		}
+		dumpID := ar.readInt()
+		if ar.err != nil {
+			return fmt.Errorf("custom archive block read error: %w", ar.err)
+		}
+		te, ok := entries[dumpID]
+		if !ok {
+			return fmt.Errorf("custom archive block for unknown dump id %d", dumpID)
+		}
+
+		te.dataState, te.dataPos = offsetPosSet, aw.pos
+		aw.writeByte(blkType)
+		aw.writeInt(dumpID)
+
+		switch blkType {
+		case blkData:
+			err = filterCustomData(df, h, te, ar, aw, w)
+		case blkBlobs:
+			err = filterBlobs(df, h, ar, aw)
+		default:
+			err = fmt.Errorf("unknown block type %d", blkType)
+		}
+		if err != nil {
+			return fmt.Errorf("custom archive %s %s: %w", te.desc.String, te.tag.String, err)
+		}
+		if df.refTablesComplete() {
+			return nil
+		}
+	}
+
+	if err := aw.flush(); err != nil {
+		return fmt.Errorf("write error: %w", err)
+	}
+	if archiveOut != w {
+		return nil
+	}
+
+	// rewrite the table of contents with the data offsets if possible
+	ws, ok := w.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+	if _, err := ws.Seek(tocPos, io.SeekStart); err != nil {
+		return nil // not seekable, such as a pipe
+	}
+	tw := newArchiveWriter(ws)
+	tw.intSize, tw.offSize = aw.intSize, aw.offSize
+	if err := tw.writeTOC(h, toc); err != nil {
+		return err
+	}
+	if err := tw.flush(); err != nil {
+		return fmt.Errorf("write error: %w", err)
+	}
+	_, err = ws.Seek(0, io.SeekEnd)
+	return err
+}
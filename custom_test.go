@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testArchiveHeader makes a custom format archive header for the
+// archive minor version and compression algorithm
+func testArchiveHeader(vmin, algo byte) archiveHeader {
+	h := archiveHeader{
+		vmaj:          1,
+		vmin:          vmin,
+		intSize:       4,
+		offSize:       8,
+		format:        archCustom,
+		createTime:    [7]int{0, 30, 12, 1, 5, 122, 1},
+		dbName:        sql.NullString{String: "test", Valid: true},
+		remoteVersion: sql.NullString{String: "12.10", Valid: true},
+		dumpVersion:   sql.NullString{String: "12.10", Valid: true},
+	}
+	if vmin >= 15 {
+		h.compressionAlgo = algo
+	} else if algo == compressionGzip {
+		h.compression = -1
+	}
+	return h
+}
+
+// testCopyBlocks extracts the COPY header lines and the data lines,
+// including the terminating line, of the COPY blocks in a plain dump
+func testCopyBlocks(t *testing.T, path string) ([]string, []string) {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("could not open %s: %s", path, err)
+	}
+	defer f.Close()
+
+	headers, data := []string{}, []string{}
+	inBlock := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case !inBlock && strings.HasPrefix(line, "COPY "):
+			headers = append(headers, line)
+			data = append(data, "")
+			inBlock = true
+		case inBlock:
+			data[len(data)-1] += line + "\n"
+			if line == `\.` {
+				data[len(data)-1] += "\n\n"
+				inBlock = false
+			}
+		}
+	}
+	return headers, data
+}
+
+// testTOC makes a table of contents from the COPY blocks of
+// testdata/pg_dump.sql, together with a schema entry without data and a
+// large object entry, returning the toc and the table data
+func testTOC(t *testing.T) ([]*tocEntry, []string) {
+	t.Helper()
+
+	headers, data := testCopyBlocks(t, "testdata/pg_dump.sql")
+
+	toc := []*tocEntry{
+		{
+			dumpID:    1,
+			tag:       sql.NullString{String: "example_schema", Valid: true},
+			desc:      sql.NullString{String: "SCHEMA", Valid: true},
+			section:   2,
+			defn:      sql.NullString{String: "CREATE SCHEMA example_schema;\n", Valid: true},
+			withOids:  sql.NullString{String: "false", Valid: true},
+			dataState: offsetNoData,
+			filename:  sql.NullString{String: "", Valid: true},
+		},
+	}
+	for i, hdr := range headers {
+		name := strings.Fields(hdr)[1]
+		parts := strings.Split(name, ".")
+		toc = append(toc, &tocEntry{
+			dumpID:    i + 2,
+			hadDumper: 1,
+			tag:       sql.NullString{String: parts[1], Valid: true},
+			desc:      sql.NullString{String: "TABLE DATA", Valid: true},
+			section:   3,
+			copyStmt:  sql.NullString{String: hdr + "\n", Valid: true},
+			namespace: sql.NullString{String: parts[0], Valid: true},
+			withOids:  sql.NullString{String: "false", Valid: true},
+			deps:      []sql.NullString{{String: "1", Valid: true}},
+			dataState: offsetPosNotSet,
+			filename:  sql.NullString{String: fmt.Sprintf("%d.dat", i+2), Valid: true},
+		})
+	}
+	toc = append(toc, &tocEntry{
+		dumpID:    len(toc) + 1,
+		hadDumper: 1,
+		tag:       sql.NullString{String: "BLOBS", Valid: true},
+		desc:      sql.NullString{String: "BLOBS", Valid: true},
+		section:   3,
+		withOids:  sql.NullString{String: "false", Valid: true},
+		dataState: offsetPosNotSet,
+		filename:  sql.NullString{String: "blobs.toc", Valid: true},
+	})
+	return toc, data
+}
+
+// makeCustomArchive makes a custom format archive from the testTOC
+// entries and data
+func makeCustomArchive(t *testing.T, h archiveHeader) []byte {
+	t.Helper()
+
+	toc, data := testTOC(t)
+	return makeCustomArchiveFrom(t, h, toc, data)
+}
+
+// makeCustomArchiveFrom makes a custom format archive from toc entries
+// and the data of the table data entries, which follow the first entry
+func makeCustomArchiveFrom(t *testing.T, h archiveHeader, toc []*tocEntry, data []string) []byte {
+	t.Helper()
+
+	blobID := toc[len(toc)-1].dumpID
+
+	buf := bytes.NewBuffer(nil)
+	aw := newArchiveWriter(buf)
+	aw.writeHeader(h)
+	aw.writeTOC(h, toc)
+
+	writeData := func(d string) {
+		chunks := bufio.NewWriterSize(&chunkWriter{aw}, 64)
+		cw, err := newCompressor(h, chunks)
+		if err != nil {
+			t.Fatalf("compressor error %s", err)
+		}
+		io.WriteString(cw, d)
+		cw.Close()
+		chunks.Flush()
+		aw.writeInt(0)
+	}
+
+	for i, d := range data {
+		aw.writeByte(blkData)
+		aw.writeInt(i + 2)
+		writeData(d)
+	}
+	aw.writeByte(blkBlobs)
+	aw.writeInt(blobID)
+	aw.writeInt(16400)
+	writeData("a scanned document")
+	aw.writeInt(0)
+
+	if err := aw.flush(); err != nil {
+		t.Fatalf("could not make archive: %s", err)
+	}
+	return buf.Bytes()
+}
+
+// readCustomArchive reads a custom format archive, returning the
+// table of contents and the decompressed data of each table keyed by
+// schema.table name
+func readCustomArchive(t *testing.T, archive []byte) ([]*tocEntry, map[string]string) {
+	t.Helper()
+
+	ar := newArchiveReader(bytes.NewReader(archive))
+	h, err := ar.readHeader()
+	if err != nil {
+		t.Fatalf("header read error %s", err)
+	}
+	toc, err := ar.readTOC(h)
+	if err != nil {
+		t.Fatalf("toc read error %s", err)
+	}
+	entries := map[int]*tocEntry{}
+	for _, te := range toc {
+		entries[te.dumpID] = te
+	}
+
+	tables := map[string]string{}
+	for {
+		blkType := ar.readByte()
+		if ar.err == io.EOF {
+			break
+		}
+		te := entries[ar.readInt()]
+		if te == nil {
+			t.Fatal("block with unknown dump id")
+		}
+		if blkType == blkBlobs {
+			if err := copyBlobs(ar, newArchiveWriter(io.Discard)); err != nil {
+				t.Fatalf("blob read error %s", err)
+			}
+			continue
+		}
+		cr := &chunkReader{ar: ar}
+		dr, err := newDecompressor(h.algorithm(), cr)
+		if err != nil {
+			t.Fatalf("decompressor error %s", err)
+		}
+		b, err := io.ReadAll(dr)
+		if err != nil {
+			t.Fatalf("data read error %s", err)
+		}
+		tables[te.namespace.String+"."+te.tag.String] = string(b)
+		io.Copy(io.Discard, cr)
+	}
+	return toc, tables
+}
+
+func TestChunkReaderWriter(t *testing.T) {
+
+	buf := bytes.NewBuffer(nil)
+	aw := newArchiveWriter(buf)
+	aw.intSize = 4
+	cw := bufio.NewWriterSize(&chunkWriter{aw}, 16)
+	data := strings.Repeat("abcdefghij", 10)
+	io.WriteString(cw, data)
+	cw.Flush()
+	aw.writeInt(0)
+	io.WriteString(aw, "trailing")
+	aw.flush()
+
+	ar := newArchiveReader(buf)
+	ar.intSize = 4
+	got, err := io.ReadAll(&chunkReader{ar: ar})
+	if err != nil {
+		t.Fatalf("chunk read error %s", err)
+	}
+	if string(got) != data {
+		t.Errorf("got %s expected %s", got, data)
+	}
+	rest, _ := io.ReadAll(ar.r)
+	if string(rest) != "trailing" {
+		t.Errorf("chunk reader read beyond the block, remainder %q", rest)
+	}
+}
+
+func TestAnonymiseCustom(t *testing.T) {
+
+	tomlString, err := os.ReadFile("testdata/settings.toml")
+	if err != nil {
+		t.Fatalf("could not read settings file: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		vmin byte
+		algo byte
+	}{
+		{"1.12 uncompressed", 12, compressionNone},
+		{"1.14 zlib", 14, compressionGzip},
+		{"1.16 gzip", 16, compressionGzip},
+		{"1.16 lz4", 16, compressionLZ4},
+		{"1.16 zstd", 16, compressionZstd},
+	}
+
+	for _, tc := range tests {
+
+		dir := t.TempDir()
+		dumpFile := filepath.Join(dir, "dump.custom")
+		outFile := filepath.Join(dir, "out.custom")
+		archive := makeCustomArchive(t, testArchiveHeader(tc.vmin, tc.algo))
+		if err := os.WriteFile(dumpFile, archive, 0644); err != nil {
+			t.Fatal(err)
+		}
+		output, err := os.Create(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		args := anonArgs{
+			dumpFilePath: dumpFile,
+			settingsToml: string(tomlString),
+			output:       output,
+		}
+		if err := Anonymise(args); err != nil {
+			t.Fatalf("%s: Anonymise should not fail: %s", tc.name, err)
+		}
+		output.Close()
+
+		result, err := os.ReadFile(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		toc, tables := readCustomArchive(t, result)
+
+		if got := tables["example_schema.events"]; got != "\\.\n\n\n" {
+			t.Errorf("%s: events table should be empty, got %q", tc.name, got)
+		}
+		if got := tables["public.fkexample"]; !strings.Contains(got, "3\t5\tvanessa\n") {
+			t.Errorf("%s: fkexample not anonymised, got %q", tc.name, got)
+		}
+		users := tables["public.users"]
+		if c := strings.Count(users, "zachary"); c != 2 {
+			t.Errorf("%s: expected 2 zacharies in users, got %d", tc.name, c)
+		}
+		if strings.Contains(users, "ariadne") {
+			t.Errorf("%s: users not anonymised", tc.name)
+		}
+
+		// the offsets of the data blocks should have been recorded in
+		// the seekable output file
+		for _, te := range toc {
+			if te.dataState == offsetNoData {
+				continue
+			}
+			if te.dataState != offsetPosSet {
+				t.Errorf("%s: entry %d offset not set", tc.name, te.dumpID)
+				continue
+			}
+			ar := newArchiveReader(bytes.NewReader(result[te.dataPos:]))
+			ar.intSize = 4
+			blk, id := ar.readByte(), ar.readInt()
+			if (blk != blkData && blk != blkBlobs) || id != te.dumpID {
+				t.Errorf("%s: entry %d offset %d points to block %d id %d",
+					tc.name, te.dumpID, te.dataPos, blk, id)
+			}
+		}
+	}
+}
+
+func TestAnonymiseCustomTestMode(t *testing.T) {
+
+	tomlString, err := os.ReadFile("testdata/settings.toml")
+	if err != nil {
+		t.Fatalf("could not read settings file: %s", err)
+	}
+
+	dumpFile := filepath.Join(t.TempDir(), "dump.custom")
+	archive := makeCustomArchive(t, testArchiveHeader(14, compressionGzip))
+	if err := os.WriteFile(dumpFile, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	args := anonArgs{
+		dumpFilePath: dumpFile,
+		settingsToml: string(tomlString),
+		output:       buffer,
+		changedOnly:  true,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+
+	var count int
+	if count = strings.Count(buffer.String(), "COPY "); count != 3 {
+		t.Errorf("count of COPY lines should be 3, got %d", count)
+	}
+	if count = strings.Count(buffer.String(), "zachary"); count != 3 {
+		t.Errorf("count of zachary string not 3, got %d", count)
+	}
+	if count = strings.Count(buffer.String(), "\n"); count != 12 {
+		t.Errorf("expected 12 lines of output, got %d", count)
+	}
+	t.Log(buffer.String())
+}
+
+func TestAnonymiseCustomUnsupportedCompression(t *testing.T) {
+
+	tomlString, err := os.ReadFile("testdata/settings.toml")
+	if err != nil {
+		t.Fatalf("could not read settings file: %s", err)
+	}
+
+	// the archive is made without compression but declares an unknown
+	// compression algorithm
+	dumpFile := filepath.Join(t.TempDir(), "dump.custom")
+	archive := makeCustomArchive(t, testArchiveHeader(16, compressionNone))
+	archive[len(archiveMagic)+6] = 9
+	if err := os.WriteFile(dumpFile, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	args := anonArgs{
+		dumpFilePath: dumpFile,
+		settingsToml: string(tomlString),
+		output:       io.Discard,
+	}
+	if err := Anonymise(args); err == nil {
+		t.Error("expected unsupported compression error")
+	} else {
+		t.Log(err)
+	}
+}
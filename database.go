@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// The output of a plain dump may be written directly to a postgresql
+// database given by a connection string, in either the key=value or URL
+// form, rather than being piped into psql. The output is split into
+// statements, which are executed in turn, and the data of each COPY
+// statement, which is streamed to the server with the COPY FROM STDIN
+// protocol. An error reports the output line of the statement, or the
+// table and row of the COPY data, which caused it.
+//
+// The \connect lines of pg_dumpall dumps connect to the named database
+// and the \restrict and \unrestrict lines are skipped, while other psql
+// meta-commands cannot be executed. COPY data is loaded in a
+// transaction, as required by the protocol. In a single transaction the
+// whole output is loaded in one transaction, without the BEGIN and
+// COMMIT statements of the dump.
+
+// errDatabasePlainOnly is returned when writing an archive to a database
+var errDatabasePlainOnly = errors.New("only plain format dumps can be written to a database")
+
+// copyLineRegex matches the line of COPY data in the context of a
+// server error, such as "COPY users, line 2, column id: ..."
+var copyLineRegex = regexp.MustCompile(`^COPY [^,]+, line (\d+)`)
+
+// dollarQuoteRegex matches a dollar quote tag, such as $$ or $body$
+var dollarQuoteRegex = regexp.MustCompile(`^\$(?:[A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// dbSession is a connection to a database to which output is written
+type dbSession interface {
+	exec(query string) error
+	copyIn(statement string) (dbCopy, error)
+	close() error
+}
+
+// dbCopy is the data of a COPY FROM STDIN statement being loaded
+type dbCopy interface {
+	write(line string) error
+	finish() error
+}
+
+// connectDatabase connects to the database of a connection string in the
+// key=value form
+var connectDatabase = connectPostgres
+
+// pqSession is a dbSession using a connection of the lib/pq driver
+type pqSession struct {
+	conn driver.Conn
+}
+
+// connectPostgres connects to a postgresql database
+func connectPostgres(connString string) (dbSession, error) {
+	connector, err := pq.NewConnector(connString)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := connector.Connect(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &pqSession{conn: conn}, nil
+}
+
+// exec executes one or more statements with the simple query protocol
+func (s *pqSession) exec(query string) error {
+	_, err := s.conn.(driver.ExecerContext).ExecContext(context.Background(), query, nil)
+	return err
+}
+
+// copyIn starts a COPY FROM STDIN statement
+func (s *pqSession) copyIn(statement string) (dbCopy, error) {
+	stmt, err := s.conn.Prepare(statement)
+	if err != nil {
+		return nil, err
+	}
+	return &pqCopy{stmt: stmt}, nil
+}
+
+// close closes the connection, rolling back any open transaction
+func (s *pqSession) close() error {
+	return s.conn.Close()
+}
+
+// pqCopy streams the lines of COPY data to the server
+type pqCopy struct {
+	stmt driver.Stmt
+}
+
+// copyDataStmt is the lib/pq statement of a COPY FROM STDIN statement,
+// which takes COPY data in text format
+type copyDataStmt interface {
+	CopyData(ctx context.Context, line string) (driver.Result, error)
+}
+
+// write writes a line of COPY data. Lines are buffered, so that an error
+// may be that of an earlier line
+func (c *pqCopy) write(line string) error {
+	_, err := c.stmt.(copyDataStmt).CopyData(context.Background(), line)
+	return err
+}
+
+// finish ends the COPY data, returning any error of the buffered lines
+func (c *pqCopy) finish() error {
+	_, err := c.stmt.Exec(nil)
+	if cerr := c.stmt.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// withDatabase returns a connection string in the key=value form for
+// the named database, converting a connection URL
+func withDatabase(connString, database string) (string, error) {
+	if strings.HasPrefix(connString, "postgres://") || strings.HasPrefix(connString, "postgresql://") {
+		var err error
+		if connString, err = pq.ParseURL(connString); err != nil {
+			return "", fmt.Errorf("invalid database connection URL: %w", err)
+		}
+	}
+	if database == "" {
+		return connString, nil
+	}
+	quoted := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(database)
+	return strings.TrimSpace(connString + " dbname='" + quoted + "'"), nil
+}
+
+// sqlBuffer accumulates the lines of a statement until its terminating
+// semicolon, which is outside of quoted literals and identifiers, dollar
+// quoted strings and comments
+type sqlBuffer struct {
+	lines        []string
+	quote        byte   // the quote character of a quoted literal or identifier
+	escapes      bool   // backslash escapes apply in the current literal
+	dollarTag    string // the tag of the current dollar quoted string
+	commentDepth int    // the nesting depth of the current block comment
+	prevE        bool
+	complete     bool
+}
+
+// add adds a line to the statement
+func (b *sqlBuffer) add(t string) {
+	b.lines = append(b.lines, t)
+	for i := 0; i < len(t) && !b.complete; i++ {
+		c := t[i]
+		switch {
+		case b.commentDepth > 0:
+			if strings.HasPrefix(t[i:], "/*") {
+				b.commentDepth++
+				i++
+			} else if strings.HasPrefix(t[i:], "*/") {
+				b.commentDepth--
+				i++
+			}
+		case b.dollarTag != "":
+			if strings.HasPrefix(t[i:], b.dollarTag) {
+				i += len(b.dollarTag) - 1
+				b.dollarTag = ""
+			}
+		case b.quote != 0 && b.escapes && c == '\\':
+			i++
+		case b.quote != 0 && c == b.quote:
+			b.quote = 0
+		case b.quote != 0:
+		case c == '\'' || c == '"':
+			b.quote = c
+			b.escapes = c == '\'' && b.prevE
+		case c == '-' && strings.HasPrefix(t[i:], "--"):
+			i = len(t)
+		case c == '/' && strings.HasPrefix(t[i:], "/*"):
+			b.commentDepth = 1
+			i++
+		case c == '$' && (i == 0 || !isIdentifierByte(t[i-1])):
+			if tag := dollarQuoteRegex.FindString(t[i:]); tag != "" {
+				b.dollarTag = tag
+				i += len(tag) - 1
+			}
+		case c == ';':
+			b.complete = true
+		}
+		b.prevE = b.quote == 0 && b.commentDepth == 0 && (c == 'E' || c == 'e') && (i == 0 || !isIdentifierByte(t[i-1]))
+	}
+}
+
+// isIdentifierByte reports if a byte may be part of an unquoted
+// identifier
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// empty reports if no lines have been added
+func (b *sqlBuffer) empty() bool {
+	return len(b.lines) == 0
+}
+
+// String returns the statement
+func (b *sqlBuffer) String() string {
+	return strings.Join(b.lines, "\n")
+}
+
+// reset empties the buffer for the next statement
+func (b *sqlBuffer) reset() {
+	*b = sqlBuffer{}
+}
+
+// dbWriter is an io.Writer of the output of a plain dump which executes
+// its statements and loads its COPY data in a database
+type dbWriter struct {
+	connString        string
+	singleTransaction bool
+	session           dbSession
+	err               error  // the error of an earlier write
+	partial           []byte // a line without its newline
+	lineNo            int    // the output line number
+	stmt              sqlBuffer
+	stmtLineNo        int
+	copy              dbCopy
+	copyTable         string
+	copyLineNo        int
+}
+
+// newDBWriter connects to the database of a connection string, starting
+// a transaction if the output is to be written in a single transaction
+func newDBWriter(connString string, singleTransaction bool) (*dbWriter, error) {
+	connString, err := withDatabase(connString, "")
+	if err != nil {
+		return nil, err
+	}
+	w := &dbWriter{connString: connString, singleTransaction: singleTransaction}
+	if err := w.connect(connString); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// connect connects the writer to a database, closing any earlier
+// connection
+func (w *dbWriter) connect(connString string) error {
+	if w.session != nil {
+		if err := w.session.close(); err != nil {
+			return fmt.Errorf("database close error: %w", err)
+		}
+		w.session = nil
+	}
+	session, err := connectDatabase(connString)
+	if err != nil {
+		return fmt.Errorf("database connection error: %w", err)
+	}
+	w.session = session
+	if w.singleTransaction {
+		return w.exec("BEGIN")
+	}
+	return nil
+}
+
+// exec executes a statement of the writer, rather than of the output
+func (w *dbWriter) exec(query string) error {
+	if err := w.session.exec(query); err != nil {
+		return fmt.Errorf("database error executing %s: %w", query, err)
+	}
+	return nil
+}
+
+// Write writes output to the database line by line
+func (w *dbWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.partial = append(w.partial, p...)
+			break
+		}
+		line := string(p[:i])
+		if len(w.partial) > 0 {
+			line = string(w.partial) + line
+			w.partial = w.partial[:0]
+		}
+		p = p[i+1:]
+		if w.err = w.writeLine(line); w.err != nil {
+			return 0, w.err
+		}
+	}
+	return n, nil
+}
+
+// writeLine writes a line of output
+func (w *dbWriter) writeLine(t string) error {
+
+	w.lineNo++
+	if w.copy != nil {
+		if t == `\.` {
+			return w.finishCopy()
+		}
+		if err := w.copy.write(t); err != nil {
+			return w.copyError(err)
+		}
+		return nil
+	}
+
+	if !w.stmt.empty() {
+		return w.addLine(t)
+	}
+
+	// lines between statements
+	switch {
+	case strings.TrimSpace(t) == "" || strings.HasPrefix(t, "--"):
+		return nil
+	case isCopyData(t):
+		return w.startCopy(t)
+	case strings.HasPrefix(t, connectPrefix):
+		return w.connectLine(t)
+	case restrictRegex.MatchString(t):
+		return nil
+	case strings.HasPrefix(t, `\`):
+		return fmt.Errorf("output line %d: psql meta-command %s cannot be executed in a database", w.lineNo, t)
+	case w.singleTransaction && (t == "BEGIN;" || t == "COMMIT;"):
+		return nil
+	}
+	w.stmtLineNo = w.lineNo
+	return w.addLine(t)
+}
+
+// addLine adds a line to the current statement, executing it if it is
+// complete
+func (w *dbWriter) addLine(t string) error {
+	w.stmt.add(t)
+	if !w.stmt.complete {
+		return nil
+	}
+	defer w.stmt.reset()
+	if err := w.session.exec(w.stmt.String()); err != nil {
+		return fmt.Errorf("database error at output line %d, %s: %w", w.stmtLineNo, w.stmt.lines[0], err)
+	}
+	return nil
+}
+
+// connectLine connects to the database of a \connect line
+func (w *dbWriter) connectLine(t string) error {
+	if w.singleTransaction {
+		return fmt.Errorf("output line %d: %s cannot be followed in a single transaction", w.lineNo, t)
+	}
+	database, err := parseConnect(t)
+	if err != nil {
+		return err
+	}
+	connString, err := withDatabase(w.connString, database)
+	if err != nil {
+		return err
+	}
+	return w.connect(connString)
+}
+
+// startCopy starts loading the data of a COPY statement, in a
+// transaction of its own unless the output is written in a single
+// transaction
+func (w *dbWriter) startCopy(t string) error {
+	w.copyTable = t
+	if name, _, _, err := parseCopyStatement(t); err == nil {
+		w.copyTable = name
+	}
+	w.copyLineNo = w.lineNo
+	if !w.singleTransaction {
+		if err := w.exec("BEGIN"); err != nil {
+			return err
+		}
+	}
+	c, err := w.session.copyIn(t)
+	if err != nil {
+		return fmt.Errorf("database error at output line %d, table %s: %w", w.lineNo, w.copyTable, err)
+	}
+	w.copy = c
+	return nil
+}
+
+// finishCopy finishes loading the data of a COPY statement
+func (w *dbWriter) finishCopy() error {
+	c := w.copy
+	w.copy = nil
+	if err := c.finish(); err != nil {
+		return w.copyError(err)
+	}
+	if !w.singleTransaction {
+		return w.exec("COMMIT")
+	}
+	return nil
+}
+
+// copyError reports an error loading COPY data with the table and, if
+// given by the server, the row and its output line
+func (w *dbWriter) copyError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		if m := copyLineRegex.FindStringSubmatch(pqErr.Where); m != nil {
+			row, _ := strconv.Atoi(m[1])
+			return fmt.Errorf("database error loading table %s row %d at output line %d: %w", w.copyTable, row, w.copyLineNo+row, err)
+		}
+	}
+	return fmt.Errorf("database error loading table %s: %w", w.copyTable, err)
+}
+
+// Close writes any remaining output, commits a single transaction and
+// closes the connection. Output ending within a statement or COPY data
+// is an error
+func (w *dbWriter) Close() error {
+	err := w.err
+	if err == nil && len(w.partial) > 0 {
+		err = w.writeLine(string(w.partial))
+	}
+	switch {
+	case err != nil:
+	case w.copy != nil:
+		err = fmt.Errorf("output ends within the data of table %s", w.copyTable)
+	case !w.stmt.empty():
+		err = fmt.Errorf("output ends within the statement at output line %d, %s", w.stmtLineNo, w.stmt.lines[0])
+	case w.singleTransaction:
+		err = w.exec("COMMIT")
+	}
+	if cerr := w.session.close(); err == nil && cerr != nil {
+		err = fmt.Errorf("database close error: %w", cerr)
+	}
+	w.session = nil
+	return err
+}
+
+// abort closes the connection of a writer which has not been closed,
+// rolling back any open transaction
+func (w *dbWriter) abort() {
+	if w.session != nil {
+		w.session.close()
+		w.session = nil
+	}
+}
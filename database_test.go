@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestSQLBuffer(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		lines    []string
+		complete []bool
+	}{
+		{"single line", []string{"SET x = 1;"}, []bool{true}},
+		{"multiple lines", []string{"CREATE TABLE t (", "    a integer", ");"}, []bool{false, false, true}},
+		{"quoted semicolon", []string{"COMMENT ON TABLE t IS 'a;", "b';"}, []bool{false, true}},
+		{"doubled quote", []string{"SELECT 'it''s;';"}, []bool{true}},
+		{"escaped quote", []string{`SELECT E'it\'s;';`}, []bool{true}},
+		{"standard string backslash", []string{`SELECT 'a\';`}, []bool{true}},
+		{"quoted identifier", []string{`CREATE TABLE "a;b" (x int);`}, []bool{true}},
+		{"comment", []string{"SELECT 1 -- not;", "+ 1;"}, []bool{false, true}},
+		{"block comment", []string{"SELECT 1 /* not; */ + 1;"}, []bool{true}},
+		{"multiline block comment", []string{"/* a;", "'b; */ SELECT 1;"}, []bool{false, true}},
+		{
+			"nested block comments",
+			[]string{"SELECT /* a /* b; */", "c;", "*/ 1;"},
+			[]bool{false, false, true},
+		},
+		{"block comment in literal", []string{"SELECT '/*';"}, []bool{true}},
+		{
+			"dollar quoted function",
+			[]string{"CREATE FUNCTION f() RETURNS void AS $_$", "BEGIN", "    COMMIT;", "END", "$_$;"},
+			[]bool{false, false, false, false, true},
+		},
+		{"nested dollar tags", []string{"SELECT $a$ $$; $a$;"}, []bool{true}},
+		{"positional parameter", []string{"PREPARE p AS SELECT $1;"}, []bool{true}},
+	}
+	for _, tc := range tests {
+		var b sqlBuffer
+		for i, line := range tc.lines {
+			b.add(line)
+			if b.complete != tc.complete[i] {
+				t.Errorf("%s: line %d expected complete %t", tc.name, i+1, tc.complete[i])
+			}
+		}
+		if got := b.String(); got != strings.Join(tc.lines, "\n") {
+			t.Errorf("%s: got statement %q", tc.name, got)
+		}
+	}
+}
+
+func TestWithDatabase(t *testing.T) {
+
+	tests := []struct {
+		connString, database, want string
+	}{
+		{"host=localhost dbname=a", "", "host=localhost dbname=a"},
+		{"host=localhost dbname=a", "b", "host=localhost dbname=a dbname='b'"},
+		{"host=localhost", `it's\`, `host=localhost dbname='it\'s\\'`},
+		{"postgres://u@localhost:5433/a?sslmode=disable", "b", "dbname='a' host='localhost' port='5433' sslmode='disable' user='u' dbname='b'"},
+	}
+	for _, tc := range tests {
+		got, err := withDatabase(tc.connString, tc.database)
+		if err != nil || got != tc.want {
+			t.Errorf("%s %s: expected %s got %s %v", tc.connString, tc.database, tc.want, got, err)
+		}
+	}
+}
+
+// fakeSession records the statements and COPY data written to a
+// database, failing a statement or COPY line containing fail
+type fakeSession struct {
+	log  *[]string
+	fail string
+}
+
+func (s *fakeSession) exec(query string) error {
+	*s.log = append(*s.log, query)
+	if s.fail != "" && strings.Contains(query, s.fail) {
+		return &pq.Error{Message: "syntax error"}
+	}
+	return nil
+}
+
+func (s *fakeSession) copyIn(statement string) (dbCopy, error) {
+	*s.log = append(*s.log, statement)
+	return &fakeCopy{session: s}, nil
+}
+
+func (s *fakeSession) close() error {
+	*s.log = append(*s.log, "close")
+	return nil
+}
+
+type fakeCopy struct {
+	session *fakeSession
+	lines   int
+	err     error
+}
+
+func (c *fakeCopy) write(line string) error {
+	c.lines++
+	*c.session.log = append(*c.session.log, "data: "+line)
+	if c.session.fail != "" && strings.Contains(line, c.session.fail) && c.err == nil {
+		c.err = &pq.Error{Message: "invalid input syntax", Where: fmt.Sprintf("COPY users, line %d, column id: %q", c.lines, line)}
+	}
+	return nil
+}
+
+func (c *fakeCopy) finish() error {
+	*c.session.log = append(*c.session.log, "end of data")
+	return c.err
+}
+
+// useFakeSessions replaces the database connection of a test with fake
+// sessions, returning the log of the sessions
+func useFakeSessions(t *testing.T, fail string) *[]string {
+	t.Helper()
+	log := []string{}
+	connectDatabase = func(connString string) (dbSession, error) {
+		log = append(log, "connect "+connString)
+		return &fakeSession{log: &log, fail: fail}, nil
+	}
+	t.Cleanup(func() { connectDatabase = connectPostgres })
+	return &log
+}
+
+const databaseSettings = `
+[["public.users"]]
+filter = "string replace"
+columns = ["name"]
+replacements = ["zachary"]
+`
+
+var databaseDump = []string{
+	`\restrict abc`,
+	"SET statement_timeout = 0;",
+	"",
+	"--",
+	"-- Name: users; Type: TABLE; Schema: public; Owner: -",
+	"--",
+	"",
+	"CREATE TABLE public.users (",
+	"    id integer,",
+	"    name text",
+	");",
+	"",
+	"COPY public.users (id, name) FROM stdin;",
+	"1\tann",
+	"x\tbea",
+	`\.`,
+	"",
+	"BEGIN;",
+	"SELECT pg_catalog.lo_create('16400');",
+	"COMMIT;",
+	`\unrestrict abc`,
+}
+
+func TestAnonymiseDatabase(t *testing.T) {
+
+	tests := []struct {
+		name              string
+		singleTransaction bool
+		want              []string
+	}{
+		{
+			name: "transaction for each table",
+			want: []string{
+				"connect host=localhost dbname=test",
+				"SET statement_timeout = 0;",
+				"CREATE TABLE public.users (\n    id integer,\n    name text\n);",
+				"BEGIN",
+				"COPY public.users (id, name) FROM stdin;",
+				"data: 1\tzachary",
+				"data: x\tzachary",
+				"end of data",
+				"COMMIT",
+				"BEGIN;",
+				"SELECT pg_catalog.lo_create('16400');",
+				"COMMIT;",
+				"close",
+			},
+		},
+		{
+			name:              "single transaction",
+			singleTransaction: true,
+			want: []string{
+				"connect host=localhost dbname=test",
+				"BEGIN",
+				"SET statement_timeout = 0;",
+				"CREATE TABLE public.users (\n    id integer,\n    name text\n);",
+				"COPY public.users (id, name) FROM stdin;",
+				"data: 1\tzachary",
+				"data: x\tzachary",
+				"end of data",
+				"SELECT pg_catalog.lo_create('16400');",
+				"COMMIT",
+				"close",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		log := useFakeSessions(t, "")
+		args := anonArgs{
+			dumpFilePath:      versionDump(t, "17.6", databaseDump...),
+			settingsToml:      databaseSettings,
+			database:          "host=localhost dbname=test",
+			singleTransaction: tc.singleTransaction,
+		}
+		if err := Anonymise(args); err != nil {
+			t.Fatalf("%s: Anonymise should not fail: %s", tc.name, err)
+		}
+		if !reflect.DeepEqual(*log, tc.want) {
+			t.Errorf("%s: expected\n%q\ngot\n%q", tc.name, tc.want, *log)
+		}
+	}
+}
+
+func TestAnonymiseDatabaseConnect(t *testing.T) {
+
+	log := useFakeSessions(t, "")
+	args := anonArgs{
+		dumpFilePath: versionDump(t, "17.6", "CREATE DATABASE sales;", `\connect sales`, "SET x = 1;"),
+		settingsToml: databaseSettings,
+		database:     "postgres://localhost/postgres",
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+	want := []string{
+		"connect dbname='postgres' host='localhost'",
+		"CREATE DATABASE sales;",
+		"close",
+		"connect dbname='postgres' host='localhost' dbname='sales'",
+		"SET x = 1;",
+		"close",
+	}
+	if !reflect.DeepEqual(*log, want) {
+		t.Errorf("expected\n%q\ngot\n%q", want, *log)
+	}
+}
+
+func TestAnonymiseDatabaseFail(t *testing.T) {
+
+	tests := []struct {
+		name              string
+		lines             []string
+		fail              string
+		singleTransaction bool
+		err               string
+	}{
+		{
+			name:  "copy row",
+			lines: databaseDump,
+			fail:  "x\t",
+			err:   "loading table public.users row 2 at output line 22",
+		},
+		{
+			name:  "statement",
+			lines: databaseDump,
+			fail:  "CREATE TABLE",
+			err:   "at output line 15, CREATE TABLE public.users (: pq: syntax error",
+		},
+		{
+			name:  "meta-command",
+			lines: []string{`\set ON_ERROR_STOP on`},
+			err:   `psql meta-command \set ON_ERROR_STOP on cannot be executed`,
+		},
+		{
+			name:              "connect in a single transaction",
+			lines:             []string{`\connect sales`},
+			singleTransaction: true,
+			err:               "cannot be followed in a single transaction",
+		},
+		{
+			name:  "unterminated statement",
+			lines: []string{"SELECT 'a;"},
+			err:   "output ends within the statement at output line 8",
+		},
+	}
+
+	for _, tc := range tests {
+		log := useFakeSessions(t, tc.fail)
+		args := anonArgs{
+			dumpFilePath:      versionDump(t, "17.6", tc.lines...),
+			settingsToml:      databaseSettings,
+			database:          "host=localhost",
+			singleTransaction: tc.singleTransaction,
+		}
+		err := Anonymise(args)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.name, tc.err, err)
+		}
+		if (*log)[len(*log)-1] != "close" {
+			t.Errorf("%s: expected the connection to be closed", tc.name)
+		}
+	}
+
+	args := anonArgs{
+		dumpFilePath: stdinPath,
+		input:        strings.NewReader("id,name\n1,ann\n"),
+		settingsToml: databaseSettings,
+		csv:          &csvOptions{table: "public.users", delimiter: ','},
+		database:     "host=localhost",
+		output:       bytes.NewBuffer(nil),
+	}
+	useFakeSessions(t, "")
+	if err := Anonymise(args); err != errDatabasePlainOnly {
+		t.Errorf("expected a plain format error for a CSV export, got %v", err)
+	}
+}
+
+// TestAnonymiseDatabasePostgres writes a dump to the throwaway database
+// given by the GOPG_ANONYMISE_TEST_DATABASE connection string, such as
+// "host=localhost user=postgres dbname=anontest sslmode=disable"
+func TestAnonymiseDatabasePostgres(t *testing.T) {
+
+	connString := os.Getenv("GOPG_ANONYMISE_TEST_DATABASE")
+	if connString == "" {
+		t.Skip("GOPG_ANONYMISE_TEST_DATABASE is not set")
+	}
+	db, err := sql.Open("postgres", connString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("DROP TABLE IF EXISTS public.users"); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := append([]string{}, databaseDump[:14]...)
+	lines = append(lines, "2\tbea", `\.`, `\unrestrict abc`)
+	args := anonArgs{
+		dumpFilePath:      versionDump(t, "17.6", lines...),
+		settingsToml:      databaseSettings,
+		database:          connString,
+		singleTransaction: true,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM public.users WHERE name = 'zachary'").Scan(&n); err != nil || n != 2 {
+		t.Errorf("expected 2 anonymised rows, got %d %v", n, err)
+	}
+
+	// a failing row is reported and the single transaction rolled back
+	if _, err := db.Exec("DROP TABLE public.users"); err != nil {
+		t.Fatal(err)
+	}
+	args.dumpFilePath = versionDump(t, "17.6", databaseDump...)
+	if err := Anonymise(args); err == nil || !strings.Contains(err.Error(), "table public.users row 2") {
+		t.Errorf("expected an error for row 2, got %v", err)
+	}
+	if err := db.QueryRow("SELECT count(*) FROM pg_catalog.pg_tables WHERE tablename = 'users'").Scan(&n); err != nil || n != 0 {
+		t.Errorf("expected the table not to be created, got %d %v", n, err)
+	}
+}
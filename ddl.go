@@ -0,0 +1,124 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// createTablePrefixes are the prefixes of the first line of a CREATE
+// TABLE statement as written by pg_dump
+var createTablePrefixes = []string{"CREATE TABLE ", "CREATE UNLOGGED TABLE ", "CREATE FOREIGN TABLE "}
+
+// columnTypeEnd matches the end of the type of a column definition
+var columnTypeEnd = regexp.MustCompile(` (?:NOT NULL|NULL|DEFAULT|COLLATE|GENERATED|CONSTRAINT|CHECK|UNIQUE|PRIMARY KEY|REFERENCES|OPTIONS)\b`)
+
+// tableDefinition is the definition of a table's columns read from a
+// CREATE TABLE statement
+type tableDefinition struct {
+	TableName   string
+	columnNames []string
+	columnTypes []string
+}
+
+// ddlParser reads table definitions from the CREATE TABLE statements of
+// a dump, line by line
+type ddlParser struct {
+	tables  map[string]*tableDefinition
+	current *tableDefinition
+}
+
+// newDDLParser makes a new ddlParser
+func newDDLParser() *ddlParser {
+	return &ddlParser{tables: map[string]*tableDefinition{}}
+}
+
+// parseLine reads a line of a dump, recording the table definition of
+// any CREATE TABLE statement
+func (p *ddlParser) parseLine(t string) {
+
+	if p.current == nil {
+		if !strings.HasPrefix(t, "CREATE ") {
+			return
+		}
+		if name, ok := parseCreateTable(t); ok {
+			p.current = &tableDefinition{TableName: name}
+		}
+		return
+	}
+
+	// the statement ends with a line starting with a closing
+	// parenthesis, which may be followed by further clauses such as
+	// INHERITS or PARTITION BY
+	if strings.HasPrefix(t, ")") {
+		p.tables[p.current.TableName] = p.current
+		p.current = nil
+		return
+	}
+
+	t = strings.TrimSuffix(strings.TrimSpace(t), ",")
+	if t == "" || strings.HasPrefix(t, "CONSTRAINT ") {
+		return
+	}
+	name, typ := splitColumnDefinition(t)
+	p.current.columnNames = append(p.current.columnNames, name)
+	p.current.columnTypes = append(p.current.columnTypes, typ)
+}
+
+// parseCreateTable returns the table name of the first line of a
+// CREATE TABLE statement with a column list, and true, or false if the
+// line is not such a line
+func parseCreateTable(t string) (string, bool) {
+	for _, prefix := range createTablePrefixes {
+		if !strings.HasPrefix(t, prefix) {
+			continue
+		}
+		parts, p, err := scanQualifiedName(t, len(prefix))
+		if err != nil || len(parts) > 2 || t[p:] != " (" {
+			return "", false
+		}
+		return tableName(parts), true
+	}
+	return "", false
+}
+
+// table returns the definition of a table, if it has been read
+func (p *ddlParser) table(name string) (*tableDefinition, bool) {
+	td, ok := p.tables[name]
+	return td, ok
+}
+
+// splitColumnDefinition splits a column definition into the unquoted
+// column name and the column type, without any constraints or defaults
+func splitColumnDefinition(t string) (string, string) {
+
+	var name, rest string
+	if strings.HasPrefix(t, `"`) {
+		// a quoted identifier, in which quotes are doubled
+		i := 1
+		for i < len(t) {
+			if t[i] == '"' {
+				if i+1 < len(t) && t[i+1] == '"' {
+					i += 2
+					continue
+				}
+				break
+			}
+			i++
+		}
+		if i >= len(t) {
+			return t, ""
+		}
+		name, rest = strings.ReplaceAll(t[1:i], `""`, `"`), t[i+1:]
+	} else {
+		parts := strings.SplitN(t, " ", 2)
+		name = parts[0]
+		if len(parts) == 2 {
+			rest = " " + parts[1]
+		}
+	}
+
+	if loc := columnTypeEnd.FindStringIndex(rest); loc != nil {
+		rest = rest[:loc[0]]
+	}
+	return name, strings.TrimSpace(rest)
+}
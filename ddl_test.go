@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"testing"
+)
+
+func TestDDLParser(t *testing.T) {
+
+	f, err := os.Open("testdata/pg_dump.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	p := newDDLParser()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		p.parseLine(scanner.Text())
+	}
+	if len(p.tables) != 3 {
+		t.Errorf("expected 3 tables, got %d", len(p.tables))
+	}
+	td, ok := p.table("public.users")
+	if !ok {
+		t.Fatal("users table not found")
+	}
+	names := []string{"id", "firstname", "lastname", "password", "uuid", "notes"}
+	types := []string{"integer", "text", "text", "text", "uuid", "text"}
+	if !equalColumns(td.columnNames, names) || !equalColumns(td.columnTypes, types) {
+		t.Errorf("unexpected users definition %v %v", td.columnNames, td.columnTypes)
+	}
+}
+
+func TestSplitColumnDefinition(t *testing.T) {
+	tests := []struct {
+		def, name, typ string
+	}{
+		{"id integer NOT NULL", "id", "integer"},
+		{"flags text[]", "flags", "text[]"},
+		{"name character varying(20) DEFAULT 'x'::character varying", "name", "character varying(20)"},
+		{"created timestamp with time zone DEFAULT now() NOT NULL", "created", "timestamp with time zone"},
+		{`"Mixed ""Case""" text COLLATE pg_catalog."C"`, `Mixed "Case"`, "text"},
+		{"total numeric GENERATED ALWAYS AS ((a + b)) STORED", "total", "numeric"},
+	}
+	for _, tc := range tests {
+		name, typ := splitColumnDefinition(tc.def)
+		if name != tc.name || typ != tc.typ {
+			t.Errorf("%s: expected %q %q got %q %q", tc.def, tc.name, tc.typ, name, typ)
+		}
+	}
+}
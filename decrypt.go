@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+// The decrypt command reverses the format preserving encryption of the
+// ff1 encrypt and ff3-1 encrypt filters, decrypting values given as
+// arguments, or otherwise the lines of standard input, with the key,
+// algorithm and alphabet used to encrypt them. Values too short to be
+// encrypted are treated as given by the short option, matching that of
+// the filter: kept values are written unchanged, while hashed values,
+// the default, and values refused by the filter are reported as errors,
+// as they cannot be decrypted.
+
+// decryptCommand is the name of the decrypt command
+const decryptCommand = "decrypt"
+
+var decryptUsage = `decrypt: decrypt values encrypted by the ff1 or ff3-1 encrypt filters.
+
+Decrypt values given as arguments, or otherwise each line of stdin,
+writing a line for each to stdout. The key, algorithm and alphabet must
+be those used to encrypt the values, and the short value treatment that
+of the filter: values too short to be encrypted are written unchanged
+if the filter kept them, and are otherwise errors.
+
+gopg-anonymise decrypt (--key-env name | --key-file path) [-a algorithm]
+               [--alphabet alphabet] [--short treatment]`
+
+// DecryptOptions set the decrypt command flag options
+type DecryptOptions struct {
+	KeyEnv    string `long:"key-env" description:"environment variable of the hexadecimal AES key"`
+	KeyFile   string `long:"key-file" description:"file of the hexadecimal AES key"`
+	Algorithm string `short:"a" long:"algorithm" choice:"ff1" choice:"ff3-1" default:"ff1" description:"encryption algorithm"`
+	Alphabet  string `long:"alphabet" choice:"digits" choice:"lower" choice:"upper" choice:"alphanumeric" default:"digits" description:"alphabet of the encrypted characters"`
+	Short     string `long:"short" choice:"hash" choice:"keep" choice:"fail" default:"hash" description:"short value treatment of the encrypt filter"`
+	Args      struct {
+		Values []string `description:"encrypted values (otherwise stdin)"`
+	} `positional-args:"yes"`
+}
+
+// decryptArgs are the arguments of runDecrypt
+type decryptArgs struct {
+	key       []byte
+	algorithm string
+	alphabet  string
+	short     string // the short value treatment of the filter
+	values    []string
+}
+
+// parseDecryptFlags parses the options of the decrypt command, which
+// follow the command name
+func parseDecryptFlags() (args decryptArgs, err error) {
+
+	var options DecryptOptions
+	var parser = flags.NewParser(&options, flags.Default)
+	parser.Usage = decryptUsage
+
+	if _, err := parser.ParseArgs(os.Args[2:]); err != nil {
+		os.Exit(1)
+	}
+
+	switch {
+	case options.KeyEnv != "" && options.KeyFile != "":
+		return args, errors.New("only one of --key-env and --key-file may be given")
+	case options.KeyEnv != "":
+		args.key, err = readKey("env", options.KeyEnv)
+	case options.KeyFile != "":
+		args.key, err = readKey("file", options.KeyFile)
+	default:
+		return args, errors.New("a key must be given with --key-env or --key-file")
+	}
+	if err != nil {
+		return args, err
+	}
+	args.algorithm = options.Algorithm
+	args.alphabet = options.Alphabet
+	args.short = options.Short
+	args.values = options.Args.Values
+	return args, nil
+}
+
+// runDecrypt decrypts the values of the arguments, or otherwise the
+// lines of the input, writing each to the output
+func runDecrypt(args decryptArgs, input io.Reader, output io.Writer) error {
+
+	key, err := fpeKey(args.key)
+	if err != nil {
+		return err
+	}
+	c, err := newFPECipher(args.algorithm, key, nil)
+	if err != nil {
+		return err
+	}
+	alphabet, ok := fpeAlphabets[args.alphabet]
+	if !ok {
+		return fmt.Errorf("unknown alphabet %q", args.alphabet)
+	}
+
+	w := bufio.NewWriter(output)
+	decrypt := func(v string) error {
+		if n := fpeCount(v, alphabet); n > 0 && n < fpeMinLength(len(alphabet)) {
+			switch args.short {
+			case fpeShortKeep:
+				_, err := w.WriteString(v + "\n")
+				return err
+			case fpeShortFail:
+				w.Flush()
+				return fmt.Errorf("could not decrypt %q: the value is too short to have been encrypted", v)
+			default:
+				w.Flush()
+				return fmt.Errorf("could not decrypt %q: the value is too short to have been encrypted, and is a keyed hash which cannot be decrypted", v)
+			}
+		}
+		d, err := fpeTransform(v, alphabet, c.decrypt)
+		if err != nil {
+			w.Flush()
+			return fmt.Errorf("could not decrypt %q: %w", v, err)
+		}
+		_, err = w.WriteString(d + "\n")
+		return err
+	}
+
+	if len(args.values) > 0 {
+		for _, v := range args.values {
+			if err := decrypt(v); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	}
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		if err := decrypt(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read input: %w", err)
+	}
+	return w.Flush()
+}
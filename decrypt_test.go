@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunDecrypt(t *testing.T) {
+
+	for _, algorithm := range []string{fpeFF1, fpeFF31} {
+		columns := []string{"account", "code"}
+		filter, err := NewEncryptFilter(algorithm+" encrypt", columns, []string{"digits", "alphanumeric"}, []byte(fpeTestKey), "", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := filter.Filter(hashRow(columns, "40-1234-5678", "Zx81-Spectrum"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// values as arguments
+		var output bytes.Buffer
+		args := decryptArgs{key: []byte(fpeTestKey), algorithm: algorithm, alphabet: "digits", values: []string{r.Columns[0], r.Columns[0]}}
+		if err := runDecrypt(args, nil, &output); err != nil {
+			t.Fatal(err)
+		}
+		if got, expected := output.String(), "40-1234-5678\n40-1234-5678\n"; got != expected {
+			t.Errorf("%s: decrypted %q, expected %q", algorithm, got, expected)
+		}
+
+		// values as lines of the input
+		output.Reset()
+		args = decryptArgs{key: []byte(fpeTestKey + "\n"), algorithm: algorithm, alphabet: "alphanumeric"}
+		if err := runDecrypt(args, strings.NewReader(r.Columns[1]+"\n"), &output); err != nil {
+			t.Fatal(err)
+		}
+		if got, expected := output.String(), "Zx81-Spectrum\n"; got != expected {
+			t.Errorf("%s: decrypted %q, expected %q", algorithm, got, expected)
+		}
+	}
+}
+
+func TestRunDecryptShort(t *testing.T) {
+
+	// short values kept by the filter are written unchanged, in the
+	// same batch as the values encrypted
+	values := []string{"40-1234-5678", "12-34", "", "ext 9", "0044 20 7946 0018", "123456"}
+	for _, algorithm := range []string{fpeFF1, fpeFF31} {
+		columns := []string{"phone"}
+		filter, err := NewEncryptFilter(algorithm+" encrypt", columns, nil, []byte(fpeTestKey), "keep", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var encrypted []string
+		for _, v := range values {
+			r, err := filter.Filter(hashRow(columns, v))
+			if err != nil {
+				t.Fatal(err)
+			}
+			encrypted = append(encrypted, r.Columns[0])
+		}
+		if encrypted[0] == values[0] || encrypted[1] != values[1] {
+			t.Errorf("%s: unexpected encrypted values %q", algorithm, encrypted)
+		}
+
+		var output bytes.Buffer
+		args := decryptArgs{key: []byte(fpeTestKey), algorithm: algorithm, alphabet: "digits", short: fpeShortKeep}
+		if err := runDecrypt(args, strings.NewReader(strings.Join(encrypted, "\n")+"\n"), &output); err != nil {
+			t.Fatalf("%s: runDecrypt should not fail: %s", algorithm, err)
+		}
+		if got, expected := output.String(), strings.Join(values, "\n")+"\n"; got != expected {
+			t.Errorf("%s: decrypted %q, expected %q", algorithm, got, expected)
+		}
+	}
+}
+
+func TestRunDecryptFail(t *testing.T) {
+
+	tests := []struct {
+		args     decryptArgs
+		contains string
+	}{
+		{decryptArgs{key: []byte("secret"), algorithm: fpeFF1, alphabet: "digits"}, "hexadecimal"},
+		{decryptArgs{key: []byte(fpeTestKey), algorithm: "ff2", alphabet: "digits"}, "unknown algorithm"},
+		{decryptArgs{key: []byte(fpeTestKey), algorithm: fpeFF1, alphabet: "hex"}, "unknown alphabet"},
+		{decryptArgs{key: []byte(fpeTestKey), algorithm: fpeFF31, alphabet: "digits", short: fpeShortKeep, values: []string{"123", strings.Repeat("1", 57)}}, "could not decrypt"},
+		{decryptArgs{key: []byte(fpeTestKey), algorithm: fpeFF1, alphabet: "digits", values: []string{"12-34"}}, "keyed hash which cannot be decrypted"},
+		{decryptArgs{key: []byte(fpeTestKey), algorithm: fpeFF1, alphabet: "digits", short: fpeShortHash, values: []string{"12-34"}}, "keyed hash which cannot be decrypted"},
+		{decryptArgs{key: []byte(fpeTestKey), algorithm: fpeFF1, alphabet: "digits", short: fpeShortFail, values: []string{"12-34"}}, "too short to have been encrypted"},
+	}
+	for i, tt := range tests {
+		var output bytes.Buffer
+		err := runDecrypt(tt.args, strings.NewReader(""), &output)
+		if err == nil || !strings.Contains(err.Error(), tt.contains) {
+			t.Errorf("test %d: expected an error containing %q, got %v", i, tt.contains, err)
+		}
+	}
+
+	// the values before the error are written
+	var output bytes.Buffer
+	_ = runDecrypt(tests[3].args, nil, &output)
+	if output.String() != "123\n" {
+		t.Errorf("unexpected output %q", output.String())
+	}
+}
+
+func TestDecryptFlagParsing(t *testing.T) {
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte(fpeTestKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Args = []string{"prog", "decrypt", "--key-file", keyFile, "-a", "ff3-1", "--alphabet", "upper", "--short", "keep", "ABCDEF", "GHIJKL"}
+	args, err := parseDecryptFlags()
+	if err != nil {
+		t.Fatalf("failed flag parsing %s", err)
+	}
+	if string(args.key) != fpeTestKey || args.algorithm != fpeFF31 || args.alphabet != "upper" || args.short != fpeShortKeep || len(args.values) != 2 {
+		t.Errorf("unexpected decrypt options %+v", args)
+	}
+
+	t.Setenv("ANON_KEY", fpeTestKey)
+	for _, a := range [][]string{
+		{"prog", "decrypt"},
+		{"prog", "decrypt", "--key-env", "ANON_KEY", "--key-file", keyFile},
+		{"prog", "decrypt", "--key-env", "UNSET_ANON_KEY"},
+	} {
+		os.Args = a
+		if _, err := parseDecryptFlags(); err == nil {
+			t.Errorf("%v: expected an error", a[2:])
+		}
+	}
+}
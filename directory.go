@@ -0,0 +1,397 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// directoryTOC is the name of the table of contents file of a pg_dump
+// directory format dump
+const directoryTOC = "toc.dat"
+
+// dataFileSuffixes are the possible compression suffixes of the data
+// files of a directory format dump
+var dataFileSuffixes = []string{"", ".gz", ".lz4", ".zst"}
+
+// directoryDataFile returns the path of the data file named in a toc
+// entry, which may carry a compression suffix
+func directoryDataFile(dir, filename string) (string, error) {
+	for _, suffix := range dataFileSuffixes {
+		p := filepath.Join(dir, filename+suffix)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("data file %s not found in %s", filename, dir)
+}
+
+// newFileDecompressor returns a reader decompressing a data file
+// according to the compression suffix of its name
+func newFileDecompressor(name string, r io.Reader) (io.ReadCloser, error) {
+	if filepath.Ext(name) == ".dat" {
+		return io.NopCloser(r), nil
+	}
+	method, ok := compressionExtensions[filepath.Ext(name)]
+	if !ok {
+		return nil, fmt.Errorf("compression of data file %s not supported", name)
+	}
+	return newStreamDecompressor(method, r)
+}
+
+// newFileCompressor returns a writer compressing a data file according
+// to the compression suffix of its name, using the gzip compression
+// level of the archive header where available
+func newFileCompressor(name string, h archiveHeader, w io.Writer) (io.WriteCloser, error) {
+	if filepath.Ext(name) == ".dat" {
+		return nopWriteCloser{w}, nil
+	}
+	method, ok := compressionExtensions[filepath.Ext(name)]
+	if !ok {
+		return nil, fmt.Errorf("compression of data file %s not supported", name)
+	}
+	if method == streamGzip && h.version() < archiveVersion1_15 &&
+		h.compression > 0 && h.compression <= gzip.BestCompression {
+		return gzip.NewWriterLevel(w, h.compression)
+	}
+	return newStreamCompressor(method, w)
+}
+
+// readDirectoryTOC reads the header and table of contents from the
+// toc.dat file of a directory format dump
+func readDirectoryTOC(dir string) (archiveHeader, []*tocEntry, error) {
+
+	f, err := os.Open(filepath.Join(dir, directoryTOC))
+	if err != nil {
+		return archiveHeader{}, nil, fmt.Errorf("directory dump toc error: %w", err)
+	}
+	defer f.Close()
+
+	ar := newArchiveReader(f)
+	h, err := ar.readHeader()
+	if err != nil {
+		return h, nil, err
+	}
+	if h.format != archDirectory {
+		return h, nil, fmt.Errorf("archive format %d is not a directory format archive", h.format)
+	}
+	toc, err := ar.readTOC(h)
+	return h, toc, err
+}
+
+// writeDirectoryTOC writes the toc.dat file of a directory format dump
+func writeDirectoryTOC(dir string, h archiveHeader, toc []*tocEntry) error {
+
+	f, err := os.Create(filepath.Join(dir, directoryTOC))
+	if err != nil {
+		return fmt.Errorf("directory dump toc error: %w", err)
+	}
+	aw := newArchiveWriter(f)
+	if err := aw.writeHeader(h); err != nil {
+		f.Close()
+		return err
+	}
+	if err := aw.writeTOC(h, toc); err != nil {
+		f.Close()
+		return err
+	}
+	if err := aw.flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("directory dump toc write error: %w", err)
+	}
+	return f.Close()
+}
+
+// makeOutputDir makes an output directory for a directory format dump,
+// which, like pg_dump, must not already contain files
+func makeOutputDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("could not make output directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("could not read output directory: %w", err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("output directory %s is not empty", dir)
+	}
+	return nil
+}
+
+// copyFile copies a file verbatim
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// directoryJob is the filtering of the data file of a single table in a
+// directory format dump
+type directoryJob struct {
+	df       *dumpFilter
+	te       *tocEntry
+	path     string // path of the input data file
+	header   string // copy statement line to output in test mode
+	headerOK bool
+}
+
+// run filters the job's data file, writing the output to a data file
+// of the same name in outDir, or in test mode to text
+func (j *directoryJob) run(h archiveHeader, outDir string, text io.Writer) error {
+
+	inFile, err := os.Open(j.path)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+	in, err := newFileDecompressor(j.path, inFile)
+	if err != nil {
+		return err
+	}
+
+	var outFile *os.File
+	var out io.WriteCloser
+	switch {
+	case j.df.referenceMode:
+		out = nopWriteCloser{io.Discard}
+	case j.df.changedOnly:
+		if j.headerOK {
+			if _, err := io.WriteString(text, j.header+"\n"); err != nil {
+				return fmt.Errorf("write error: %w", err)
+			}
+		}
+		out = nopWriteCloser{text}
+	default:
+		outFile, err = os.Create(filepath.Join(outDir, filepath.Base(j.path)))
+		if err != nil {
+			return err
+		}
+		defer outFile.Close()
+		out, err = newFileCompressor(j.path, h, outFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := filterData(j.df, in, out); err != nil {
+		return err
+	}
+	if err := in.Close(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if outFile != nil {
+		return outFile.Close()
+	}
+	return nil
+}
+
+// runParallel runs jobs using a goroutine per cpu, returning the first
+// error encountered, if any
+func runParallel(jobs []*directoryJob, run func(*directoryJob) error) error {
+
+	var wg sync.WaitGroup
+	jobChan := make(chan *directoryJob)
+	errs := make(chan error, len(jobs))
+
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobChan {
+				if err := run(j); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for _, j := range jobs {
+		jobChan <- j
+	}
+	close(jobChan)
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// scanDirectory filters the table data files of a pg_dump directory
+// format dump in dir, writing a new directory format dump to outDir.
+// The data files of tables without filters, large objects without
+// filters and other files are copied verbatim, and the table of
+// contents is rewritten.
+//
+// Each data file is filtered independently; those of tables that are
+// not reference tables are filtered in parallel. In reference and test
+// modes the data files are filtered in table of contents order and no
+// dump is written.
+func scanDirectory(df *dumpFilter, dir, outDir string, w io.Writer) error {
+
+	h, toc, err := readDirectoryTOC(dir)
+	if err != nil {
+		return err
+	}
+	if h.dumpVersion.String != "" {
+		if err := df.setDumpVersion(h.dumpVersion.String); err != nil {
+			return err
+		}
+	}
+	if err := df.readDefinitions(toc); err != nil {
+		return err
+	}
+	toc = df.filterLargeObjectTOC(toc)
+
+	writeDump := !df.referenceMode && !df.changedOnly
+	if writeDump {
+		if err := makeOutputDir(outDir); err != nil {
+			return err
+		}
+		if err := writeDirectoryTOC(outDir, h, toc); err != nil {
+			return err
+		}
+	}
+
+	// determine the data files to filter, which are those of tables of
+	// interest to the dumpFilter
+	filtered := map[string]bool{directoryTOC: true}
+	var serial, parallel []*directoryJob
+	for _, te := range toc {
+		if !te.isTableData() || te.filename.String == "" {
+			continue
+		}
+		tdf := df.clone()
+		header, ok, err := tdf.startEntry(te)
+		if err != nil {
+			return err
+		}
+		if !tdf.dt.Inited() {
+			continue
+		}
+		path, err := directoryDataFile(dir, te.filename.String)
+		if err != nil {
+			return err
+		}
+		filtered[filepath.Base(path)] = true
+
+		job := &directoryJob{df: tdf, te: te, path: path, header: header, headerOK: ok}
+		if _, isRef := df.refTables[tdf.dt.TableName]; isRef || !writeDump {
+			serial = append(serial, job)
+		} else {
+			parallel = append(parallel, job)
+		}
+	}
+
+	run := func(j *directoryJob) error {
+		if err := j.run(h, outDir, w); err != nil {
+			return fmt.Errorf("directory dump %s %s: %w", j.te.desc.String, j.te.tag.String, err)
+		}
+		return nil
+	}
+	for _, j := range serial {
+		if err := run(j); err != nil {
+			return err
+		}
+	}
+	if err := runParallel(parallel, run); err != nil {
+		return err
+	}
+	if !writeDump {
+		return nil
+	}
+
+	// filter the large objects listed in blobs toc files
+	if df.tableFilters.largeObjects.active() {
+		for _, te := range toc {
+			if te.desc.String != "BLOBS" || te.filename.String == "" {
+				continue
+			}
+			if err := filterDirectoryBlobs(df, h, dir, outDir, te.filename.String, filtered); err != nil {
+				return fmt.Errorf("directory dump %s %s: %w", te.desc.String, te.tag.String, err)
+			}
+		}
+	}
+
+	// copy the remaining files verbatim
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || filtered[e.Name()] {
+			continue
+		}
+		if err := copyFile(filepath.Join(dir, e.Name()), filepath.Join(outDir, e.Name())); err != nil {
+			return fmt.Errorf("directory dump copy error: %w", err)
+		}
+	}
+	return nil
+}
+
+// filterDirectoryBlobs filters the blobs toc file named name of a
+// directory format dump in dir, writing it to outDir together with the
+// rewritten files of replaced and truncated large objects. The files
+// written, and those of dropped large objects, are recorded in filtered
+// so that they are not copied
+func filterDirectoryBlobs(df *dumpFilter, h archiveHeader, dir, outDir, name string, filtered map[string]bool) error {
+
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	b, treated, err := df.filterBlobsTOC(b)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(outDir, name), b, 0600); err != nil {
+		return err
+	}
+	filtered[name] = true
+
+	for filename, f := range treated {
+		path, err := directoryDataFile(dir, filename)
+		if err != nil {
+			return err
+		}
+		filtered[filepath.Base(path)] = true
+		if f.treatment == loDrop {
+			continue
+		}
+		outFile, err := os.Create(filepath.Join(outDir, filepath.Base(path)))
+		if err != nil {
+			return err
+		}
+		out, err := newFileCompressor(path, h, outFile)
+		if err != nil {
+			outFile.Close()
+			return err
+		}
+		if _, err := out.Write(f.placeholder); err != nil {
+			outFile.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			outFile.Close()
+			return err
+		}
+		if err := outFile.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// makeDirectoryDump makes a directory format dump in dir from the
+// testTOC entries and data, compressing the data files with the
+// compression algorithm recorded in the header
+func makeDirectoryDump(t *testing.T, dir string, h archiveHeader) {
+	t.Helper()
+
+	h.format = archDirectory
+	toc, data := testTOC(t)
+	if err := writeDirectoryTOC(dir, h, toc); err != nil {
+		t.Fatal(err)
+	}
+
+	suffix := map[byte]string{
+		compressionGzip: ".gz",
+		compressionLZ4:  ".lz4",
+		compressionZstd: ".zst",
+	}[h.algorithm()]
+	writeFile := func(name, contents string) {
+		f, err := os.Create(filepath.Join(dir, name+suffix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		w, err := newFileCompressor(name+suffix, h, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, contents)
+		w.Close()
+	}
+	for i, d := range data {
+		writeFile(toc[i+1].filename.String, d)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "blobs.toc"), []byte("16400 blob_16400.dat\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeFile("blob_16400.dat", "a scanned document")
+}
+
+// readDirectoryData reads the decompressed contents of a directory
+// format dump data file
+func readDirectoryData(t *testing.T, dir, filename string) string {
+	t.Helper()
+
+	path, err := directoryDataFile(dir, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := newFileDecompressor(path, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("could not read %s: %s", path, err)
+	}
+	return string(b)
+}
+
+func TestAnonymiseDirectory(t *testing.T) {
+
+	tomlString, err := os.ReadFile("testdata/settings.toml")
+	if err != nil {
+		t.Fatalf("could not read settings file: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		vmin byte
+		algo byte
+	}{
+		{"1.14 uncompressed", 14, compressionNone},
+		{"1.14 gzip", 14, compressionGzip},
+		{"1.16 gzip", 16, compressionGzip},
+		{"1.16 lz4", 16, compressionLZ4},
+		{"1.16 zstd", 16, compressionZstd},
+	}
+
+	for _, tc := range tests {
+
+		dumpDir := filepath.Join(t.TempDir(), "dump")
+		outDir := filepath.Join(t.TempDir(), "out")
+		if err := os.Mkdir(dumpDir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		makeDirectoryDump(t, dumpDir, testArchiveHeader(tc.vmin, tc.algo))
+
+		args := anonArgs{
+			dumpFilePath: dumpDir,
+			settingsToml: string(tomlString),
+			outputDir:    outDir,
+		}
+		if err := Anonymise(args); err != nil {
+			t.Fatalf("%s: Anonymise should not fail: %s", tc.name, err)
+		}
+
+		h, toc, err := readDirectoryTOC(outDir)
+		if err != nil {
+			t.Fatalf("%s: could not read output toc: %s", tc.name, err)
+		}
+		if h.algorithm() != tc.algo || len(toc) != 5 {
+			t.Errorf("%s: unexpected output toc %+v %d entries", tc.name, h, len(toc))
+		}
+
+		files := map[string]string{}
+		for _, te := range toc {
+			if te.isTableData() {
+				files[te.namespace.String+"."+te.tag.String] = te.filename.String
+			}
+		}
+		if got := readDirectoryData(t, outDir, files["example_schema.events"]); got != "\\.\n\n\n" {
+			t.Errorf("%s: events table should be empty, got %q", tc.name, got)
+		}
+		if got := readDirectoryData(t, outDir, files["public.fkexample"]); !strings.Contains(got, "3\t5\tvanessa\n") {
+			t.Errorf("%s: fkexample not anonymised, got %q", tc.name, got)
+		}
+		users := readDirectoryData(t, outDir, files["public.users"])
+		if c := strings.Count(users, "zachary"); c != 2 {
+			t.Errorf("%s: expected 2 zacharies in users, got %d", tc.name, c)
+		}
+
+		// large objects are copied verbatim
+		if got := readDirectoryData(t, outDir, "blob_16400.dat"); got != "a scanned document" {
+			t.Errorf("%s: large object not copied, got %q", tc.name, got)
+		}
+		if _, err := os.Stat(filepath.Join(outDir, "blobs.toc")); err != nil {
+			t.Errorf("%s: blobs.toc not copied: %s", tc.name, err)
+		}
+	}
+}
+
+func TestAnonymiseDirectoryTestMode(t *testing.T) {
+
+	tomlString, err := os.ReadFile("testdata/settings.toml")
+	if err != nil {
+		t.Fatalf("could not read settings file: %s", err)
+	}
+
+	dumpDir := t.TempDir()
+	makeDirectoryDump(t, dumpDir, testArchiveHeader(14, compressionGzip))
+
+	buffer := new(strings.Builder)
+	args := anonArgs{
+		dumpFilePath: dumpDir,
+		settingsToml: string(tomlString),
+		output:       buffer,
+		changedOnly:  true,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+	if count := strings.Count(buffer.String(), "COPY "); count != 3 {
+		t.Errorf("count of COPY lines should be 3, got %d", count)
+	}
+	if count := strings.Count(buffer.String(), "zachary"); count != 3 {
+		t.Errorf("count of zachary string not 3, got %d", count)
+	}
+}
+
+func TestAnonymiseDirectoryOutputNotEmpty(t *testing.T) {
+
+	tomlString, err := os.ReadFile("testdata/settings.toml")
+	if err != nil {
+		t.Fatalf("could not read settings file: %s", err)
+	}
+
+	dumpDir := t.TempDir()
+	makeDirectoryDump(t, dumpDir, testArchiveHeader(14, compressionGzip))
+
+	args := anonArgs{
+		dumpFilePath: dumpDir,
+		settingsToml: string(tomlString),
+		outputDir:    dumpDir,
+	}
+	if err := Anonymise(args); err == nil {
+		t.Error("writing to a non-empty output directory should fail")
+	} else {
+		t.Log(err)
+	}
+}
+
+func TestFileCompressors(t *testing.T) {
+
+	h := testArchiveHeader(14, compressionGzip)
+	for _, name := range []string{"1.dat", "1.dat.gz", "1.dat.lz4", "1.dat.zst"} {
+		buf := new(strings.Builder)
+		w, err := newFileCompressor(name, h, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, "1\ttest\n")
+		w.Close()
+		r, err := newFileDecompressor(name, strings.NewReader(buf.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(r)
+		if string(b) != "1\ttest\n" {
+			t.Errorf("%s: round trip failed, got %q", name, b)
+		}
+	}
+	if _, err := newFileDecompressor("1.dat.bz2", strings.NewReader("")); err == nil {
+		t.Error("expected unsupported compression error")
+	}
+}
@@ -16,6 +16,154 @@ typically be processed in under a minute. Note that the use of the
 reference (or "foreign key") filter requires two scans of the dump file
 and to hold the referenced table/s in memory.
 
+Lines of any length are supported, such as table rows holding large
+`jsonb` documents or `bytea` images. Long lines which are not part of a
+table with filters are copied to the output without being held in
+memory, and of the long rows of tables with filters only the columns
+used by the filters are held in memory, the others being spooled to a
+temporary file. The rows of reference tables are held in memory whole.
+
+Plain text dumps, custom format archives made with `pg_dump -Fc`,
+directory format dumps made with `pg_dump -Fd` and tar format archives
+made with `pg_dump -Ft` are supported. For custom format archives the `COPY` data of each table
+with filters is decompressed, filtered and recompressed with the
+archive's compression, while the data of other tables, large objects
+without filters and the archive table of contents are copied verbatim. When the output
+is a file the data offsets in the table of contents are recorded, so
+that the output can be restored in parallel using `pg_restore -j`.
+
+Directory format dumps are written to a new directory dump given by the
+`-o` output option. The data file of each table with filters is filtered
+independently, in parallel for tables which are not reference tables,
+and other files are copied verbatim.
+
+Tar format archives are streamed member by member without extraction.
+The data members of tables with filters are filtered in memory, as tar
+headers record the size of each member, and all other members are
+copied verbatim. In test mode the changed lines of archive and
+directory dumps are shown as plain text.
+
+Dump files and archives compressed with gzip, zstd or lz4, for example
+with `pg_dump | zstd > dump.sql.zst`, are detected and decompressed
+transparently, including for the reference table scan. The output is
+compressed according to the extension of the `-o` output file (`.gz`,
+`.zst` or `.lz4`), or with the method given by the `-z` option. The
+data files of directory format dumps and the data of custom format
+archives may also be compressed with any of these methods.
+
+If no input file, or `-`, is given the dump is read from standard input,
+so that the programme can be used in a pipeline such as `pg_dump mydb |
+gopg-anonymise -s settings.toml | psql testdb`. Standard input is only
+read once: when reference filters are used the start of the dump up to
+the end of the last reference table is spooled to a temporary file
+during the reference table scan and replayed for the second scan.
+
+Dumps made with `pg_dump --inserts` or `--column-inserts`, including
+multi-row statements made with `--rows-per-insert`, are also supported.
+The values of each row are presented to the filters as for `COPY` data
+and statements are re-serialised in the same style, with unchanged rows
+written verbatim. The column names of statements without a column list
+are taken from the table's `CREATE TABLE` statement.
+
+Dumps made by pg_dump 9.6 or later are supported, and the version is
+read from the `Dumped by pg_dump version` header line of plain dumps or
+the header of archives. Tables dumped `WITH OIDS` before version 12 are
+filtered with the oid of each row as the column `oid`, and the
+`\restrict` and `\unrestrict` lines of later point releases are checked
+to have the same key. Versions 9.6 to 17 are known, while dumps made by
+later versions are read as those of an unknown version. In a dump of a
+known version, a `COPY` statement not of the form written by pg_dump, or
+table data without a terminating `\.` line, is an error rather than
+being passed through unfiltered, as is, in any dump, an unrecognised
+`COPY` statement for a table with filters or a row of such a table with
+the wrong number of fields.
+
+The output of `pg_dumpall`, in which the dump of each database follows
+a `\connect` line, is also supported. The tables of each database may be
+given in settings qualified by the database name, such as
+`[["sales.public.users"]]`, so that the same table in different
+databases may be filtered differently, while tables given without a
+database name are filtered in every database. Reference filters refer to
+tables in the same database. The passwords of the roles in the globals
+section are scrubbed, as with `-r scrub`, unless replaced with `-r
+replace --role-password=<password or hash>`, so that password hashes
+are never written.
+
+Dumps in single byte client encodings, such as `LATIN1` or `WIN1252`,
+are decoded so that filters see UTF-8 values, and the output is written
+in the dump's own encoding. A dump whose settings have replacement
+values that the encoding cannot represent is rejected when its `SET
+client_encoding` line is read. With `-u` a plain dump is instead
+rewritten as UTF-8, and its `SET client_encoding` line is changed to
+match.
+
+Standalone CSV or TSV exports of a table, such as those made with `COPY
+public.users TO 'public.users.csv' WITH CSV HEADER`, are anonymised
+with the filters of a settings table, using the column names of the
+header row. Files with a `.csv` or `.tsv` extension are read as exports
+of the table named by the file name, such as `public.users`, unless
+another is given with `--table`; `--csv` reads other files, or standard
+input, as exports. Fields are separated by commas, or tabs for `.tsv`
+files, unless set with `--delimiter`, and may be quoted as in RFC 4180.
+An unquoted empty field is NULL. Unchanged rows are output verbatim and
+the changed fields of other rows keep their quoting.
+
+With `--cdc` the input is read as a stream of newline-delimited JSON
+change events from logical replication, in wal2json format version 1 or
+2 or Debezium format, with or without its schema envelope. The new and
+old tuples of each insert, update or delete event are filtered as rows
+of the event's table, such as `public.users`, or `mydb.public.users` for
+Debezium events which name their database. Old tuples which only have
+the key columns of a table are filtered with the other columns taken
+from the new tuple, or as NULL, and the columns unchanged by an update
+are given the same replacements in both tuples. Events for tables with a
+delete filter are dropped, reference filters cannot be used, and other
+events are output unchanged.
+
+With `-d` the output of a plain dump is written directly to the
+postgresql database of a connection string, such as `-d "host=localhost
+dbname=scratch"` or `-d postgres://user@localhost/scratch`, rather than
+being piped into `psql`. Each statement is executed in turn and the data
+of each `COPY` statement is streamed to the server with the `COPY FROM
+STDIN` protocol, in a transaction for each table. An error reports the
+output line of the failing statement, or the table and row of the
+failing data. With `-1` the whole output is written in a single
+transaction, which is rolled back on any error. The `\connect` lines of
+`pg_dumpall` dumps connect to the named database, except in a single
+transaction, and other psql meta-commands are errors.
+
+With `--export-dir` the anonymised data of each table with filters is
+also exported to a file of its own in the given directory, named by the
+table, such as `public.users.csv`, or `sales.public.users.csv` for a
+table of a `pg_dumpall` dump, so that it may be loaded into other tools
+without restoring a database. The export format is set by
+`--export-format`. CSV exports have a header row of the table's column
+names, NULL is an unquoted empty field and an empty string is quoted, as
+written by `COPY ... WITH CSV HEADER`. JSON Lines (`jsonl`) exports have
+an object for each row, with the columns in table order as string
+values or `null`. Exports are written in UTF-8 whatever the encoding of
+the dump, and tables without filters are not exported.
+
+Parquet exports are typed by the `CREATE TABLE` statements of the dump:
+integer, floating point, boolean, date and timestamp columns are written
+as the matching Parquet types, `json` and `jsonb` columns as JSON
+strings and one-dimensional arrays as lists of their element type, while
+other types such as `numeric`, `uuid` or enums are written as strings.
+Rows are written in row groups of 50,000 rows, or as set by
+`--row-group-size`, with each column compressed with zstd.
+
+With `--split` the output of a plain format dump is written to a
+directory split into `pre-data.sql`, holding the entries before the
+data such as the `CREATE TABLE` statements, a file in the `data`
+directory for the data of each table, and `post-data.sql`, holding the
+entries after the data such as sequence values, indexes and
+constraints. Each data file and the post-data file starts with the `SET`
+statements of the dump, so that the data of tables may be restored in
+parallel, or a single table reloaded. The generated `restore.sh` script
+restores the files in order with `psql`, passing on its arguments and
+running `JOBS` data files at a time, for example `JOBS=4 ./restore.sh
+--dbname=scratch`.
+
 Running the programme
 
 	Usage:
@@ -23,31 +171,242 @@ Running the programme
 
 	Anonymise a postgresql dump file using a toml settings file setting out
 	the deletion, or columnar uuid, string, file or reference replacement
-	filters to use.
+	filters to use. The pipeline command, described by "gopg-anonymise
+	pipeline -h", runs pg_dump and restores its anonymised output, and the
+	decrypt command, described by "gopg-anonymise decrypt -h", decrypts
+	values encrypted by the ff1 or ff3-1 encrypt filters.
+
+	gopg-anonymise -s <settings.toml> [-o output or stdout] [-z compression]
+	               [-r role passwords] [-u] [-t test]
+	               [--csv] [--table schema.table] [--delimiter char] [--cdc]
+	               [-d connection string [-1]]
+	               [--export-dir directory [--export-format format]
+	               [--row-group-size rows]] [--split directory] [Input]
+
+	Application Options:
+	  -s, --settings=                         settings toml file
+	  -o, --output=                           output file or directory (otherwise
+	                                          stdout)
+	  -z, --compress=[gzip|zstd|lz4|none]     output compression (default from
+	                                          output file extension)
+	  -r, --role-passwords=[scrub|replace]    scrub or replace the role passwords
+	                                          of pg_dumpall dumps (default scrub)
+	      --role-password=                    replacement role password or password
+	                                          hash
+	  -u, --utf8                              rewrite plain dumps in other
+	                                          encodings as UTF-8
+	  -t, --testmode                          show only changed lines for testing
+	      --csv                               read a CSV or TSV export with a
+	                                          header row (default for .csv and .tsv
+	                                          files)
+	      --table=                            settings table of a CSV or TSV export
+	                                          (default from the file name)
+	      --delimiter=                        CSV field delimiter (default comma,
+	                                          or tab for .tsv files)
+	      --cdc                               read newline-delimited wal2json or
+	                                          Debezium JSON change events
+	  -d, --database=                         write the output to the postgresql
+	                                          database of a connection string or URL
+	  -1, --single-transaction                write the output to the database in a
+	                                          single transaction
+	      --export-dir=                       also export the anonymised data of
+	                                          each table with filters to a file in
+	                                          this directory
+	      --export-format=[csv|jsonl|parquet] format of the table exports (default:
+	                                          csv)
+	      --row-group-size=                   rows in each row group of parquet
+	                                          exports (default: 50000)
+	      --split=                            split the output into pre-data,
+	                                          per-table data and post-data files
+	                                          with a restore script in this
+	                                          directory
+
+	Help Options:
+	  -h, --help                              Show this help message
+
+	Arguments:
+	  Input:                                  input postgresql dump file
+	                                          (otherwise stdin)
+
+Running a pipeline
+
+The pipeline command replaces a shell pipeline of `pg_dump`,
+gopg-anonymise and `psql`, such as in a cron job. It runs `pg_dump` on
+the source database, anonymises its output as it is read and restores
+the result into the target database with `psql`, or with `pg_restore`
+for `-F custom` dumps, stopping at the first error. The standard error of
+`pg_dump` and the restore programme is passed through, and the command
+fails with the last line of the first to fail. If `pg_dump` or the
+anonymisation fails, or the command is interrupted, the restore
+programme is killed before it reads the end of the dump, so that with
+`-1` nothing is committed.
+
+Passwords in the source and target connection strings or URLs are
+removed from the command lines of `pg_dump`, `psql` and `pg_restore`,
+which can be seen by other users, and given to them in the `PGPASSWORD`
+environment variable instead.
+
+	Usage:
+	  gopg-anonymise pipeline: anonymise a database into another database.
+
+	Run pg_dump on the source database, anonymise the dump using a toml
+	settings file and restore it into the target database with psql, or
+	pg_restore for custom format dumps. The source and target are
+	postgresql connection strings or URLs.
+
+	gopg-anonymise pipeline -s <settings.toml> --source <connection string>
+	               --target <connection string> [-F format] [-1]
+	               [--dump-option option] [--restore-option option]
+
+	Application Options:
+	  -s, --settings=             settings toml file
+	      --source=               source database connection string or URL for
+	                              pg_dump
+	      --target=               target database connection string or URL
+	  -F, --format=[plain|custom] dump format, restored with psql (plain) or
+	                              pg_restore (custom) (default: plain)
+	  -1, --single-transaction    restore the dump in a single transaction
+	      --dump-option=          additional pg_dump option, such as
+	                              --schema=public (may be repeated)
+	      --restore-option=       additional psql or pg_restore option (may be
+	                              repeated)
+
+	Help Options:
+	  -h, --help                  Show this help message
+
+Decrypting values
+
+The decrypt command reverses the **ff1 encrypt** and **ff3-1 encrypt**
+filters, such as to find the account of an anonymised account number
+under controlled conditions. Values are given as arguments or as lines
+of standard input, with the key, algorithm and alphabet used to encrypt
+them, for example `gopg-anonymise decrypt --key-env ANON_KEY
+40-9931-0276`. Values too short to be encrypted are treated as given
+by `--short`, which must match the `short` option of the filter: they
+are written unchanged with `--short keep`, as the filter kept them, and
+are otherwise errors, as a hashed value cannot be decrypted.
+
+	Usage:
+	  gopg-anonymise decrypt: decrypt values encrypted by the ff1 or ff3-1 encrypt filters.
+
+	Decrypt values given as arguments, or otherwise each line of stdin,
+	writing a line for each to stdout. The key, algorithm and alphabet must
+	be those used to encrypt the values, and the short value treatment that
+	of the filter: values too short to be encrypted are written unchanged
+	if the filter kept them, and are otherwise errors.
 
-	gopg-anonymise -s <settings.toml> [-o output or stdout] [-t test] [Input]
+	gopg-anonymise decrypt (--key-env name | --key-file path) [-a algorithm]
+	               [--alphabet alphabet] [--short treatment] [Values...]
 
 	Application Options:
-	  -s, --settings= settings toml file
-	  -o, --output=   output file (otherwise stdout)
-	  -t, --testmode  show only changed lines for testing
+	      --key-env=                                   environment variable of the
+	                                                   hexadecimal AES key
+	      --key-file=                                  file of the hexadecimal AES
+	                                                   key
+	  -a, --algorithm=[ff1|ff3-1]                      encryption algorithm
+	                                                   (default: ff1)
+	      --alphabet=[digits|lower|upper|alphanumeric] alphabet of the encrypted
+	                                                   characters (default: digits)
+	      --short=[hash|keep|fail]                     short value treatment of the
+	                                                   encrypt filter (default:
+	                                                   hash)
 
 	Help Options:
-	  -h, --help      Show this help message
+	  -h, --help                                       Show this help message
 
 	Arguments:
-	  Input:          input file or stdin
+	  Values:                                          encrypted values (otherwise
+	                                                   stdin)
 
 An example settings file
 
 A toml file is used to describe tables that should be anonymised. For
 each table to be anonymised one or more filters may be provided.
 
-Presently, apart from the row **delete** filter, four column replacement
-filters are provided:
+Presently, apart from the row **delete** filter, ten column replacement
+filters and three large object filters are provided:
 
 - **uuid** replaces one or more columns with a new uuid
 
+- **hash** replaces the data in one or more columns with a keyed hash
+  (HMAC-SHA256) of the original value, so that equal values are
+  replaced with equal values in every table and on every run with the
+  same key, keeping joins. The replacements give the format of each
+  column: `hex`, the default, `base32`, `uuid`, `integer`, in the range
+  given by the `range` option or from 1 to 2147483647, or
+  `alphanumeric`, replacing each digit and letter with one of the same
+  kind. The secret key is read from an environment variable or a file,
+  such as `optargs = {"key" = ["env", "ANON_KEY"], "range" = ["1",
+  "99999"]}`.
+
+- **ff1 encrypt** and **ff3-1 encrypt** replace the data in one or more
+  columns with its format preserving encryption by the FF1 or FF3-1
+  modes of NIST SP 800-38G, which keeps the length of a value and the
+  positions of the characters outside the alphabet of its column, so
+  that digits stay digits and an account number such as `40-1234-5678`
+  is replaced with another of the same form. The replacements give the
+  alphabet of each column: `digits`, the default, `lower`, `upper` or
+  `alphanumeric`. A value must have at least 6 digits, 5 letters or 4
+  alphanumeric characters of its alphabet to be encrypted, as the modes
+  require a million possible values. Shorter values, such as a phone
+  extension, are treated as given by the `short` option: replaced with a
+  keyed hash of the same form which cannot be decrypted, with `["hash",
+  ""]`, the default, so that no original value is written unless asked
+  for, kept, with `["keep", ""]`, or refused with an error, with
+  `["fail", ""]`. The key, of 32, 48 or 64 hexadecimal
+  digits for AES-128, AES-192 or AES-256, is read as for the **hash**
+  filter, and the original values may be recovered with the same key by
+  the decrypt command.
+
+- **fake** replaces the data in one or more columns with realistic
+  fake data made from the embedded datasets of a locale: `en_GB`, the
+  default, `en_US`, `de_DE` or `fr_FR`. The replacements give the
+  generator of each column: `first_name`, `last_name`, `email`,
+  `phone`, `street_address`, `postcode`, `city`, `company` or
+  `username`. Email addresses use the `example.com`, `example.org` and
+  `example.net` domains and phone numbers the ranges reserved for drama
+  in each country. The locale and an optional seed are given by the
+  `locale` option, such as `optargs = {"locale" = ["de_DE", "42"]}`.
+  With a seed, equal values are replaced with equal values on every run,
+  otherwise the values are random.
+
+- **fake person** replaces the data in several columns with the parts
+  of a single fake person for each row, so that the title, names,
+  gender, email address and username of a row agree, with the email
+  address and username made from the person's name. The replacements
+  give the part for each column: `title`, `first_name`, `last_name`,
+  `full_name`, `gender`, `email`, `username`, `phone`,
+  `street_address`, `postcode` or `city`. The locale and seed are given
+  as for the **fake** filter, and the `from` option may name columns
+  holding the original gender and country, such as `optargs = {"from" =
+  ["sex", "country"]}`, either of which may be empty, so that a row with
+  a gender of `F` is given a female name and title, and a row with a
+  country of `Germany` a person of the `de_DE` locale. A column given
+  the `gender` part is written in the style of its original value, so
+  that `F` is replaced with `F` or `M` and `female` with `female` or
+  `male`, while values not recognised, such as `X`, are kept. As `F`
+  is written both with `M` and with the French `H`, and `M` both with
+  `F` and with the German `W`, the style of a column is that of the
+  other values seen in it, or else of the language of the row's locale.
+
+- **email** replaces the email addresses in one or more columns with
+  addresses of the same structure, replacing each digit and letter of
+  the local part with one of the same kind chosen by a keyed hash of the
+  address, so that `ann.lee+news` keeps its dot and plus sign. The
+  `domain` option keeps the domain, `["keep", ""]`, replaces it with a
+  reserved test domain, `["replace", "example.com"]`, the default, or
+  keeps only its top level domain after a label, `["tld", "example"]`,
+  replacing `corp.co.uk` with `example.uk`. A secret key must be given
+  as for the **hash** filter, so that addresses are replaced with the
+  same addresses on every run, while the replacements cannot be rebuilt
+  from a list of candidate addresses without the key. The
+  replacements of each column are unique in the table, ignoring case,
+  so that the data can be restored into a table with a unique index:
+  an address whose replacement was given to an earlier address is
+  hashed again, and if need be given a number. As the addresses of rows
+  left unchanged could equal replacements, the filter cannot be given
+  `if` or `notif` conditions.
+
 - **string replace** replaces the data in one or more columns with
   replacement values.
 
@@ -65,13 +424,48 @@ filters are provided:
   its `username` value from the new anonymised value in a `users` table
   even if the foreign key value has been updated.
 
+- **large object replace**, **large object truncate** and **large
+  object drop** replace the contents of large objects with the contents
+  of the source file, empty them or remove them from the dump.
+
 Each filter can be qualified by `If` and `NotIf` filters which determine
 if the filter should be run based on the contents of one or more columns
 in the row. Conditionals match if any of their criteria are true.
 
+Large object filters given for `pg_catalog.pg_largeobject` apply to all
+large objects, or to those chosen by conditions on their oid, such as
+`if = {"loid" = "16400"}`. Given for another table, they apply to the
+large objects referred to by the oids in the listed columns of the
+table's rows, which are collected in a first scan of the dump, as large
+objects are created before the table data is read. The referring
+columns of dropped large objects are set to NULL, and a large object
+chosen by several filters is dropped before it is truncated, and
+truncated before it is replaced. The `lo_create`, `ALTER`, `COMMENT`,
+`GRANT` and `REVOKE` statements of dropped large objects are removed
+from plain dumps and archive tables of contents, and large object data
+is rewritten in every dump format.
+
+Filters and conditionals work on the true contents of each column. The
+backslash escapes of `COPY` data, such as `\t` for a tab, are decoded
+before filtering and replacement values are escaped when written, so
+that replacements may contain backslashes, tabs and newlines. NULL is
+distinct from any string and is written as `\N` in conditionals and
+string replacements, where the two character text `\N` is written with
+an extra backslash as `\\N`, that is `'\\N'` or `"\\\\N"` in TOML, and
+any longer run of backslashes followed by `N` likewise loses one
+backslash. The lines of the source files of file replace filters are in
+`COPY` format, so `\N` is NULL, `\t` a tab and `\\` a backslash.
+
 The order of filters is important. Beware of changing a row ahead of
 using a conditional.
 
+Table and column names in the settings file may be quoted as in
+postgresql, so that mixed case names or names containing spaces, dots or
+commas can be used, for example `[['public."UserAccounts"']]` and
+`columns = ['"e-mail, primary"']`. Unlike in postgresql, unquoted names
+are not folded to lower case, so `public.UserAccounts` refers to the
+same table.
+
 	[["example_schema.events"]]
 	filter = "delete"
 
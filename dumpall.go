@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The output of pg_dumpall starts with the role and tablespace globals,
+// followed by the dump of each database, which is introduced by a
+// \connect line, such as
+//
+//     \connect mydb
+//     \connect "MyDb"
+//     \connect -reuse-previous=on "dbname='my db'"
+//
+// The tables of each database are named with the database name, such as
+// mydb.public.users, so that settings may be given for a table in a
+// particular database.
+
+// role password treatments
+const (
+	scrubRolePasswords   = "scrub"
+	replaceRolePasswords = "replace"
+)
+
+// connectPrefix is the prefix of the psql meta-command connecting to a
+// database
+const connectPrefix = `\connect `
+
+// rolePasswordRegex matches the PASSWORD clause of a CREATE ROLE or
+// ALTER ROLE statement
+var rolePasswordRegex = regexp.MustCompile(` PASSWORD '(?:[^']|'')*'`)
+
+// parseConnect returns the database name of a \connect line
+func parseConnect(line string) (string, error) {
+
+	arg := strings.TrimSuffix(strings.TrimPrefix(line, connectPrefix), ";")
+	reuse := strings.HasPrefix(arg, "-reuse-previous=on ")
+	arg = strings.TrimPrefix(arg, "-reuse-previous=on ")
+
+	// the argument may be double quoted, in which quotes are doubled
+	if strings.HasPrefix(arg, `"`) {
+		name, p, err := scanIdentifier(arg, 0)
+		if err != nil {
+			return "", fmt.Errorf("connect line %s: %w", line, err)
+		}
+		if p != len(arg) {
+			return "", fmt.Errorf("connect line %s: unexpected %s", line, arg[p:])
+		}
+		arg = name
+	} else if arg == "" || strings.ContainsAny(arg, ` "'`) {
+		return "", fmt.Errorf("connect line %s: invalid database name", line)
+	}
+	if !reuse {
+		return arg, nil
+	}
+
+	// a connection string with a dbname value, which may be single
+	// quoted with backslash escapes
+	if !strings.HasPrefix(arg, "dbname=") {
+		return "", fmt.Errorf("connect line %s: dbname expected", line)
+	}
+	value := strings.TrimPrefix(arg, "dbname=")
+	if !strings.HasPrefix(value, "'") {
+		if value == "" || strings.ContainsAny(value, ` \'`) {
+			return "", fmt.Errorf("connect line %s: invalid dbname", line)
+		}
+		return value, nil
+	}
+	var b strings.Builder
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+			if i == len(value) {
+				return "", fmt.Errorf("connect line %s: unterminated dbname", line)
+			}
+			b.WriteByte(value[i])
+		case '\'':
+			if i != len(value)-1 {
+				return "", fmt.Errorf("connect line %s: unexpected %s", line, value[i+1:])
+			}
+			return b.String(), nil
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return "", fmt.Errorf("connect line %s: unterminated dbname", line)
+}
+
+// isRoleLine reports if a line is a role statement which may set a
+// password
+func isRoleLine(line string) bool {
+	return strings.HasPrefix(line, "CREATE ROLE ") || strings.HasPrefix(line, "ALTER ROLE ")
+}
+
+// filterRolePassword scrubs or replaces the password of a role
+// statement according to the dumpFilter's role password treatment,
+// scrubbing it if no treatment is given so that password hashes are
+// never written, returning the line and true if it has changed
+func (d *dumpFilter) filterRolePassword(line string) (string, bool) {
+
+	var replacement string
+	if d.rolePasswords == replaceRolePasswords {
+		replacement = " PASSWORD '" + strings.ReplaceAll(d.rolePassword, "'", "''") + "'"
+	}
+	if !isRoleLine(line) || !rolePasswordRegex.MatchString(line) {
+		return line, false
+	}
+	return rolePasswordRegex.ReplaceAllLiteralString(line, replacement), true
+}
+
+// connect switches the dumpFilter to the database of a \connect line
+// of a pg_dumpall dump. As each database has its own tables, the table
+// definitions read so far are discarded
+func (d *dumpFilter) connect(line string) error {
+	database, err := parseConnect(line)
+	if err != nil {
+		return err
+	}
+	d.database = database
+	d.ddl = newDDLParser()
+	return nil
+}
+
+// checkRolePasswords checks the role password treatment options, of
+// which an empty treatment scrubs role passwords
+func checkRolePasswords(treatment, password string) error {
+	switch treatment {
+	case "":
+		if password != "" {
+			return errors.New("a role password requires the replace role passwords option")
+		}
+	case scrubRolePasswords:
+		if password != "" {
+			return errors.New("a role password cannot be given when scrubbing role passwords")
+		}
+	case replaceRolePasswords:
+		if password == "" {
+			return errors.New("replacing role passwords requires a role password")
+		}
+	default:
+		return fmt.Errorf("unknown role password treatment %s", treatment)
+	}
+	return nil
+}
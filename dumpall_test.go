@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseConnect(t *testing.T) {
+
+	tests := map[string]string{
+		`\connect sales`:                                      "sales",
+		`\connect "Support Desk"`:                             "Support Desk",
+		`\connect "say ""hi"""`:                               `say "hi"`,
+		`\connect -reuse-previous=on "dbname='Support Desk'"`: "Support Desk",
+		`\connect -reuse-previous=on "dbname='it\'s'"`:        "it's",
+		`\connect -reuse-previous=on "dbname='a""b\\c'"`:      `a"b\c`,
+		`\connect -reuse-previous=on "dbname=plain"`:          "plain",
+		`\connect `:                                          "",
+		`\connect two words`:                                 "",
+		`\connect "unterminated`:                             "",
+		`\connect -reuse-previous=on "host=x"`:               "",
+		`\connect -reuse-previous=on "dbname='unterminated"`: "",
+	}
+	for line, want := range tests {
+		got, err := parseConnect(line)
+		if want == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", line, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("%s: expected %s got %s (%v)", line, want, got, err)
+		}
+	}
+}
+
+func TestCheckRolePasswords(t *testing.T) {
+	tests := []struct {
+		treatment, password string
+		ok                  bool
+	}{
+		{"", "", true},
+		{"", "secret", false},
+		{"scrub", "", true},
+		{"scrub", "secret", false},
+		{"replace", "secret", true},
+		{"replace", "", false},
+		{"remove", "", false},
+	}
+	for _, tc := range tests {
+		if err := checkRolePasswords(tc.treatment, tc.password); (err == nil) != tc.ok {
+			t.Errorf("%s %s: unexpected result %v", tc.treatment, tc.password, err)
+		}
+	}
+}
+
+const dumpallSettings = `
+[["sales.public.users"]]
+filter = "string replace"
+columns = ["name", "email"]
+replacements = ["zachary", "z@example.com"]
+
+[['"Support Desk".public.users']]
+filter = "string replace"
+columns = ["name", "email"]
+replacements = ["yael", "y@example.com"]
+
+[["public.orders"]]
+filter = "reference replace"
+columns = ["customer"]
+replacements = ["name"]
+optargs = {"fklookup" = ["user_id", "public.users.id"]}
+
+[['"Support Desk".public.tickets']]
+filter = "reference replace"
+columns = ["reporter"]
+replacements = ["name"]
+optargs = {"fklookup" = ["user_id", "public.users.id"]}
+`
+
+func TestAnonymiseDumpall(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		rolePasswords string
+		rolePassword  string
+		roles         []string
+	}{
+		{
+			name: "default",
+			roles: []string{
+				"LOGIN NOREPLICATION NOBYPASSRLS;\n",
+				"REPLICATION BYPASSRLS VALID UNTIL 'infinity';\n",
+			},
+		},
+		{
+			name:          "scrub",
+			rolePasswords: "scrub",
+			roles: []string{
+				"LOGIN NOREPLICATION NOBYPASSRLS;\n",
+				"REPLICATION BYPASSRLS VALID UNTIL 'infinity';\n",
+			},
+		},
+		{
+			name:          "replace",
+			rolePasswords: "replace",
+			rolePassword:  "it's",
+			roles: []string{
+				"NOBYPASSRLS PASSWORD 'it''s';\n",
+				"BYPASSRLS PASSWORD 'it''s' VALID UNTIL 'infinity';\n",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		buffer := bytes.NewBuffer(nil)
+		args := anonArgs{
+			dumpFilePath:  "testdata/pg_dumpall.sql",
+			settingsToml:  dumpallSettings,
+			output:        buffer,
+			rolePasswords: tc.rolePasswords,
+			rolePassword:  tc.rolePassword,
+		}
+		if err := Anonymise(args); err != nil {
+			t.Fatalf("%s: Anonymise should not fail: %s", tc.name, err)
+		}
+		output := buffer.String()
+
+		// the same table in each database is filtered differently, and
+		// references are resolved in the table's own database
+		for _, want := range []string{
+			"COPY public.users (id, name, email) FROM stdin;\n1\tzachary\tz@example.com\n2\tzachary\tz@example.com\n",
+			"1\t2\tzachary\n2\t1\tzachary\n",
+			"COPY public.users (id, name, email) FROM stdin;\n1\tyael\ty@example.com\n2\tyael\ty@example.com\n",
+			"1\t1\tyael\n2\t2\tyael\n",
+		} {
+			if !strings.Contains(output, want) {
+				t.Errorf("%s: output does not contain %q", tc.name, want)
+			}
+		}
+		for _, want := range tc.roles {
+			if !strings.Contains(output, want) {
+				t.Errorf("%s: output does not contain %q", tc.name, want)
+			}
+		}
+		for _, hash := range []string{"SCRAM-SHA-256$", "md5a3556571e93b0d20722ba62be61e8c2d"} {
+			if strings.Contains(output, hash) {
+				t.Errorf("%s: output contains password hash %q", tc.name, hash)
+			}
+		}
+		if !strings.Contains(output, "NOLOGIN NOREPLICATION NOBYPASSRLS;\n") {
+			t.Errorf("%s: role without a password changed", tc.name)
+		}
+	}
+}
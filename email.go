@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The email filter replaces email addresses with addresses of the same
+// structure, replacing each digit and letter of the local part with
+// one of the same kind chosen by a keyed hash of the address, as for
+// the alphanumeric format of the hash filter, so that ann.lee+news@
+// keeps its dot and plus sign. The domain is kept, replaced with a
+// reserved test domain, example.com by default, or replaced with a
+// label, example by default, followed by the original top level
+// domain, as given by the "domain" option, such as optargs = {"domain"
+// = ["tld", "example"]}.
+//
+// The key, which must be given, is read from the "key" option as for
+// the hash filter, so that an address is replaced with the same address
+// on every run while the replacements cannot be rebuilt from a list of
+// candidate addresses without the key. Addresses differing only in
+// case are replaced with addresses differing in the same way.
+//
+// The replacement addresses of each column are unique in the table,
+// ignoring case, so that the anonymised data can be restored into a
+// table with a unique index. An address whose replacement is that of
+// an earlier address of the table is hashed again, and after
+// emailSuffixAttempts tries has a number added to its local part, so
+// the replacement of such an address depends on the order of the rows.
+// The addresses of each column are kept in memory. As an address kept
+// by a row not filtered could equal the replacement of another, the
+// filter cannot be given If or NotIf conditions. NULLs are not
+// replaced.
+
+// email domain treatments
+const (
+	emailDomainReplace = "replace"
+	emailDomainKeep    = "keep"
+	emailDomainTLD     = "tld"
+)
+
+// defaults of the domain treatments
+const (
+	emailDefaultDomain = "example.com"
+	emailDefaultLabel  = "example"
+)
+
+// emailSuffixAttempts is the number of tries to find a unique
+// replacement before adding a number to the local part
+const emailSuffixAttempts = 10
+
+// EmailFilter replaces columns of email addresses with unique
+// addresses of the same structure
+type EmailFilter struct {
+	filterName
+	Columns []string
+	domain  string // the treatment of the domain
+	replace string // the replacement domain or label
+	hash    *HashFilter
+	seen    []map[string]string // the original address of each replacement in each column
+}
+
+// NewEmailFilter makes a new EmailFilter with a domain treatment and
+// its replacement domain or label, if any, using a secret key
+func NewEmailFilter(columns []string, domain, replace string, key []byte) (*EmailFilter, error) {
+
+	f := EmailFilter{
+		filterName: "email",
+		Columns:    columns,
+		domain:     domain,
+		replace:    replace,
+	}
+
+	if len(columns) == 0 {
+		return &f, errors.New("email: at least one column must be specified")
+	}
+	switch domain {
+	case "", emailDomainReplace:
+		f.domain = emailDomainReplace
+		if replace == "" {
+			f.replace = emailDefaultDomain
+		}
+	case emailDomainTLD:
+		if replace == "" {
+			f.replace = emailDefaultLabel
+		}
+	case emailDomainKeep:
+		if replace != "" {
+			return &f, errors.New("email: a replacement cannot be given for kept domains")
+		}
+	default:
+		return &f, fmt.Errorf("email: unknown domain treatment %q", domain)
+	}
+	if strings.ContainsAny(f.replace, "@ ") {
+		return &f, fmt.Errorf("email: invalid replacement domain %q", f.replace)
+	}
+
+	if len(key) == 0 {
+		return &f, errors.New("email: a key must be provided")
+	}
+	var err error
+	if f.hash, err = NewHashFilter(columns, nil, key, hashIntegerMin, hashIntegerMax, nil, nil); err != nil {
+		return &f, fmt.Errorf("email: %w", err)
+	}
+	for range columns {
+		f.seen = append(f.seen, map[string]string{})
+	}
+	return &f, nil
+}
+
+// Filter replaces the email addresses of a row
+func (f *EmailFilter) Filter(r Row) (Row, error) {
+
+	// if there is no line number the previous filter may have stopped
+	// processing
+	if r.lineNo == 0 {
+		return r, nil
+	}
+
+	changed := 0
+	for i, rc := range r.ColumnNames() {
+		for j, cn := range f.Columns {
+			if rc == cn {
+				changed++
+				if r.Columns[i] != nullValue {
+					r.Columns[i] = f.replaceAddress(r.Columns[i], f.seen[j])
+				}
+				break
+			}
+		}
+	}
+
+	if changed != len(f.Columns) {
+		return r, errors.New("email: could not find all columns in EmailFilter")
+	}
+
+	return r, nil
+}
+
+// replaceAddress returns the replacement of an address which is unique
+// among the replacements of a column
+func (f *EmailFilter) replaceAddress(v string, seen map[string]string) string {
+
+	local, domain := v, ""
+	at := strings.LastIndexByte(v, '@')
+	if at >= 0 {
+		local, domain = v[:at], f.replaceDomain(v[at+1:])
+	}
+
+	original := strings.ToLower(v)
+	for attempt := 0; ; attempt++ {
+		input := original
+		if attempt > 0 {
+			input += "\x00" + strconv.Itoa(attempt)
+		}
+		address := f.hash.alphanumericFrom(local, input)
+		if attempt >= emailSuffixAttempts {
+			address += strconv.Itoa(attempt)
+		}
+		if at >= 0 {
+			address += "@" + domain
+		}
+		key := strings.ToLower(address)
+		if prev, ok := seen[key]; !ok || prev == original {
+			seen[key] = original
+			return address
+		}
+	}
+}
+
+// replaceDomain returns the replacement of a domain
+func (f *EmailFilter) replaceDomain(domain string) string {
+	switch f.domain {
+	case emailDomainKeep:
+		return domain
+	case emailDomainTLD:
+		if i := strings.LastIndexByte(domain, '.'); i >= 0 {
+			return f.replace + "." + strings.ToLower(domain[i+1:])
+		}
+	}
+	return f.replace
+}
+
+// usedColumns returns the replaced columns
+func (f *EmailFilter) usedColumns() []string {
+	return f.Columns
+}
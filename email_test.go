@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestEmailFilter(t *testing.T) {
+
+	tests := []struct {
+		domain, replace string
+		address         string
+		pattern         string
+	}{
+		{"", "", "ann.lee+news@corp.co.uk", `^[a-z]{3}\.[a-z]{3}\+[a-z]{4}@example\.com$`},
+		{"replace", "test.invalid", "Bob99@gmail.com", `^[A-Z][a-z]{2}[0-9]{2}@test\.invalid$`},
+		{"keep", "", "ann.lee@Corp.co.uk", `^[a-z]{3}\.[a-z]{3}@Corp\.co\.uk$`},
+		{"tld", "", "ann.lee@corp.co.UK", `^[a-z]{3}\.[a-z]{3}@example\.uk$`},
+		{"tld", "anon", "x_1@gmail.com", `^[a-z]_[0-9]@anon\.com$`},
+		{"", "", "not an address", `^[a-z]{3} [a-z]{2} [a-z]{7}$`},
+	}
+	for _, tt := range tests {
+		filter, err := NewEmailFilter([]string{"email"}, tt.domain, tt.replace, []byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := filter.Filter(hashRow([]string{"email"}, tt.address))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !regexp.MustCompile(tt.pattern).MatchString(r.Columns[0]) {
+			t.Errorf("%s %s: %q does not match %s", tt.domain, tt.address, r.Columns[0], tt.pattern)
+		}
+	}
+}
+
+func TestEmailFilterDeterministic(t *testing.T) {
+
+	replace := func(key string, addresses ...string) []string {
+		filter, err := NewEmailFilter([]string{"email"}, "", "", []byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var replaced []string
+		for _, a := range addresses {
+			r, err := filter.Filter(hashRow([]string{"email"}, a))
+			if err != nil {
+				t.Fatal(err)
+			}
+			replaced = append(replaced, r.Columns[0])
+		}
+		return replaced
+	}
+
+	a := replace("secret", "ann@example.com", "Ann@Example.com", "ann@example.com", nullValue)
+	if b := replace("secret", "ann@example.com"); a[0] != b[0] {
+		t.Errorf("the same key gave %q and %q", a[0], b[0])
+	}
+	if a[0] != a[2] || !strings.EqualFold(a[0], a[1]) || a[0] == a[1] {
+		t.Errorf("unexpected replacements of equal addresses %q", a[:3])
+	}
+	if a[3] != nullValue {
+		t.Errorf("NULL replaced with %q", a[3])
+	}
+	if b := replace("other", "ann@example.com"); a[0] == b[0] {
+		t.Errorf("a different key gave %q", b[0])
+	}
+}
+
+func TestEmailFilterUnique(t *testing.T) {
+
+	// single letter addresses have only 26 replacements in a domain
+	filter, err := NewEmailFilter([]string{"email", "backup"}, "", "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		address := fmt.Sprintf("%c@host%d.com", 'a'+i%26, i)
+		if i%2 == 0 {
+			address = strings.ToUpper(address)
+		}
+		r, err := filter.Filter(hashRow([]string{"email", "backup"}, address, address))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := strings.ToLower(r.Columns[0])
+		if seen[key] {
+			t.Errorf("%s: replacement %s is not unique", address, r.Columns[0])
+		}
+		seen[key] = true
+		if !regexp.MustCompile(`^[a-zA-Z][0-9]*@example\.com$`).MatchString(r.Columns[0]) {
+			t.Errorf("%s: unexpected replacement %s", address, r.Columns[0])
+		}
+		if r.Columns[1] != r.Columns[0] {
+			t.Errorf("%s: columns replaced with %s and %s", address, r.Columns[0], r.Columns[1])
+		}
+	}
+}
+
+func TestEmailFilterFail(t *testing.T) {
+
+	tests := []struct {
+		columns         []string
+		domain, replace string
+		key             string
+	}{
+		{nil, "", "", "secret"},
+		{[]string{"email"}, "drop", "", "secret"},
+		{[]string{"email"}, "keep", "example.com", "secret"},
+		{[]string{"email"}, "replace", "user@example.com", "secret"},
+		{[]string{"email"}, "", "", ""},
+	}
+	for i, tt := range tests {
+		if _, err := NewEmailFilter(tt.columns, tt.domain, tt.replace, []byte(tt.key)); err == nil {
+			t.Errorf("test %d: expected an error", i)
+		}
+	}
+
+	filter, err := NewEmailFilter([]string{"email"}, "", "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := filter.Filter(hashRow([]string{"mail"}, "ann@example.com")); err == nil {
+		t.Error("expected a missing column error")
+	}
+}
+
+func TestLoadEmailFilter(t *testing.T) {
+
+	t.Setenv("ANON_KEY", "secret")
+	settings, err := LoadToml(`
+[["public.users"]]
+filter = "email"
+columns = ["email"]
+optargs = {"domain" = ["tld", "anon"], "key" = ["env", "ANON_KEY"]}
+
+[["public.contacts"]]
+filter = "email"
+columns = ["email"]
+optargs = {"key" = ["env", "ANON_KEY"]}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf, err := loadFilters(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := tf.getTableFilters("public.users")[0].(*EmailFilter)
+	if users.domain != emailDomainTLD || users.replace != "anon" || string(users.hash.key) != "secret" {
+		t.Errorf("unexpected filter %+v", users)
+	}
+	contacts := tf.getTableFilters("public.contacts")[0].(*EmailFilter)
+	if contacts.domain != emailDomainReplace || contacts.replace != emailDefaultDomain {
+		t.Errorf("unexpected filter %+v", contacts)
+	}
+
+	// a key must be given
+	settings, err = LoadToml(`
+[["public.users"]]
+filter = "email"
+columns = ["email"]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadFilters(settings); err == nil || !strings.Contains(err.Error(), "a key must be provided") {
+		t.Errorf("expected a missing key error, got %v", err)
+	}
+}
+
+// TestLoadEmailFilterConditions checks that conditions are refused, as
+// the addresses of rows not filtered could equal the replacements
+func TestLoadEmailFilterConditions(t *testing.T) {
+
+	for _, condition := range []string{"if", "notif"} {
+		settings, err := LoadToml(`
+[["public.users"]]
+filter = "email"
+columns = ["email"]
+` + condition + ` = {"id" = "1"}
+`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadFilters(settings); err == nil || !strings.Contains(err.Error(), "conditions") {
+			t.Errorf("%s: expected a conditions error, got %v", condition, err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Dumps are written in the client encoding set by the SET
+// client_encoding line of the dump preamble, or the ENCODING entry of
+// an archive. Filters work on UTF-8 values, so that the values of dumps
+// in other encodings are decoded before filtering and encoded again
+// afterwards, unless the dump is rewritten in UTF-8.
+//
+// Only the single byte encodings are transcoded. As their first 128
+// characters are those of ASCII, the tabs, newlines and backslash
+// escapes of dump lines are unaffected by the encoding.
+
+// utf8Encoding is the postgresql name of the UTF-8 encoding
+const utf8Encoding = "UTF8"
+
+// sqlASCIIEncoding is the postgresql encoding of undeclared bytes
+const sqlASCIIEncoding = "SQL_ASCII"
+
+// errUTF8PlainOnly is returned when rewriting an archive in UTF-8
+var errUTF8PlainOnly = errors.New("only plain format dumps can be rewritten in UTF-8")
+
+// clientEncodingRegex matches the SET client_encoding line of a dump
+var clientEncodingRegex = regexp.MustCompile(`^SET client_encoding = '([^']+)';$`)
+
+// charmaps are the single byte client encodings, by postgresql name
+var charmaps = map[string]*charmap.Charmap{
+	"LATIN1":     charmap.ISO8859_1,
+	"LATIN2":     charmap.ISO8859_2,
+	"LATIN3":     charmap.ISO8859_3,
+	"LATIN4":     charmap.ISO8859_4,
+	"LATIN5":     charmap.ISO8859_9,
+	"LATIN6":     charmap.ISO8859_10,
+	"LATIN7":     charmap.ISO8859_13,
+	"LATIN8":     charmap.ISO8859_14,
+	"LATIN9":     charmap.ISO8859_15,
+	"LATIN10":    charmap.ISO8859_16,
+	"ISO_8859_5": charmap.ISO8859_5,
+	"ISO_8859_6": charmap.ISO8859_6,
+	"ISO_8859_7": charmap.ISO8859_7,
+	"ISO_8859_8": charmap.ISO8859_8,
+	"KOI8R":      charmap.KOI8R,
+	"KOI8U":      charmap.KOI8U,
+	"WIN866":     charmap.CodePage866,
+	"WIN874":     charmap.Windows874,
+	"WIN1250":    charmap.Windows1250,
+	"WIN1251":    charmap.Windows1251,
+	"WIN1252":    charmap.Windows1252,
+	"WIN1253":    charmap.Windows1253,
+	"WIN1254":    charmap.Windows1254,
+	"WIN1255":    charmap.Windows1255,
+	"WIN1256":    charmap.Windows1256,
+	"WIN1257":    charmap.Windows1257,
+	"WIN1258":    charmap.Windows1258,
+}
+
+// clientEncoding is a client encoding other than UTF-8
+type clientEncoding struct {
+	name    string
+	charmap *charmap.Charmap
+}
+
+// lookupClientEncoding returns the clientEncoding of a postgresql
+// encoding name, or nil for UTF-8 and SQL_ASCII, which are not
+// transcoded
+func lookupClientEncoding(name string) (*clientEncoding, error) {
+	name = strings.ToUpper(name)
+	if name == utf8Encoding || name == sqlASCIIEncoding {
+		return nil, nil
+	}
+	cm, ok := charmaps[name]
+	if !ok {
+		return nil, fmt.Errorf("client encoding %s is not supported", name)
+	}
+	return &clientEncoding{name: name, charmap: cm}, nil
+}
+
+// decode decodes a value in the client encoding to UTF-8
+func (e *clientEncoding) decode(s string) string {
+	// single byte decoders do not fail
+	d, _ := e.charmap.NewDecoder().String(s)
+	return d
+}
+
+// decodeBytes decodes part of a line in the client encoding to UTF-8
+func (e *clientEncoding) decodeBytes(b []byte) []byte {
+	d, _ := e.charmap.NewDecoder().Bytes(b)
+	return d
+}
+
+// encode encodes a UTF-8 value in the client encoding, failing if the
+// value cannot be represented
+func (e *clientEncoding) encode(s string) (string, error) {
+	encoded, err := e.charmap.NewEncoder().String(s)
+	if err != nil {
+		return "", fmt.Errorf("value %q cannot be represented in %s", s, e.name)
+	}
+	return encoded, nil
+}
+
+// represents reports whether a rune can be represented in the client
+// encoding
+func (e *clientEncoding) represents(r rune) bool {
+	_, ok := e.charmap.EncodeRune(r)
+	return ok
+}
+
+// setClientEncoding sets the client encoding of the dump from the name
+// given by its SET client_encoding line, checking that the replacement
+// values of the filters can be represented in the encoding and giving
+// the encoding to the filters generating values from datasets
+func (d *dumpFilter) setClientEncoding(name string) error {
+	enc, err := lookupClientEncoding(name)
+	if err != nil {
+		return err
+	}
+	if d.toUTF8 && strings.ToUpper(name) == sqlASCIIEncoding {
+		return fmt.Errorf("a dump in %s encoding cannot be rewritten in UTF-8", sqlASCIIEncoding)
+	}
+	d.encoding = enc
+	for _, filters := range d.tableFilters.tableFilters {
+		for _, f := range filters {
+			if t, ok := f.(transliterator); ok {
+				t.setEncoding(d.rowEncoding())
+			}
+		}
+	}
+	if enc == nil || d.toUTF8 {
+		return nil
+	}
+	for table, filters := range d.tableFilters.tableFilters {
+		for _, f := range filters {
+			r, ok := f.(replacer)
+			if !ok {
+				continue
+			}
+			for _, v := range r.replacementValues() {
+				if _, err := enc.encode(v); err != nil {
+					return fmt.Errorf("%s filter for table %s: replacement %w", f.FilterName(), table, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// rowEncoding returns the client encoding in which the values of rows
+// are decoded and encoded, which is nil for UTF-8 dumps and dumps
+// rewritten in UTF-8
+func (d *dumpFilter) rowEncoding() *clientEncoding {
+	if d.toUTF8 {
+		return nil
+	}
+	return d.encoding
+}
+
+// decodeRow decodes the values of a row in the client encoding to UTF-8
+func (d *dumpFilter) decodeRow(columns []string) []string {
+	enc := d.rowEncoding()
+	if enc == nil {
+		return columns
+	}
+	decoded := make([]string, len(columns))
+	for i, c := range columns {
+		decoded[i] = enc.decode(c)
+	}
+	return decoded
+}
+
+// encodeRow encodes the UTF-8 values of a filtered row in the client
+// encoding
+func (d *dumpFilter) encodeRow(columns []string) ([]string, error) {
+	enc := d.rowEncoding()
+	if enc == nil {
+		return columns, nil
+	}
+	encoded := make([]string, len(columns))
+	for i, c := range columns {
+		v, err := enc.encode(c)
+		if err != nil {
+			column := fmt.Sprintf("%d", i+1)
+			if names := d.dt.ColumnNames(); i < len(names) {
+				column = names[i]
+			}
+			return nil, fmt.Errorf("table %s row %d column %s: %w", d.dt.TableName, d.lineNo, column, err)
+		}
+		encoded[i] = v
+	}
+	return encoded, nil
+}
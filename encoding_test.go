@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLookupClientEncoding(t *testing.T) {
+
+	for _, name := range []string{"UTF8", "utf8", "SQL_ASCII"} {
+		if enc, err := lookupClientEncoding(name); enc != nil || err != nil {
+			t.Errorf("%s: expected no transcoding, got %v %v", name, enc, err)
+		}
+	}
+	for _, name := range []string{"LATIN1", "latin9", "WIN1252", "KOI8R"} {
+		if enc, err := lookupClientEncoding(name); enc == nil || err != nil {
+			t.Errorf("%s: expected an encoding, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"EUC_JP", "SJIS", "MULE_INTERNAL"} {
+		if _, err := lookupClientEncoding(name); err == nil {
+			t.Errorf("%s: expected an unsupported encoding error", name)
+		}
+	}
+
+	enc, _ := lookupClientEncoding("LATIN1")
+	if got := enc.decode("Ren\xe9"); got != "René" {
+		t.Errorf("unexpected decoded value %q", got)
+	}
+	if got, err := enc.encode("Zoë"); err != nil || got != "Zo\xeb" {
+		t.Errorf("unexpected encoded value %q %v", got, err)
+	}
+	if _, err := enc.encode("€"); err == nil {
+		t.Error("expected an error encoding € in LATIN1")
+	}
+}
+
+// encodingDump makes a plain dump in the given client encoding, the
+// rows of which are given in that encoding
+func encodingDump(t *testing.T, encoding string, rows ...string) string {
+	t.Helper()
+	dump := strings.Join(append([]string{
+		`SET client_encoding = '` + encoding + `';`,
+		`SET standard_conforming_strings = on;`,
+		``,
+		`COPY public.users (id, name, city) FROM stdin;`,
+	}, append(rows, `\.`, ``)...), "\n")
+	dumpFile := filepath.Join(t.TempDir(), "dump.sql")
+	if err := os.WriteFile(dumpFile, []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dumpFile
+}
+
+func TestAnonymiseEncodings(t *testing.T) {
+
+	// conditions and replacements are given in UTF-8
+	settings := `
+[["public.users"]]
+filter = "string replace"
+columns = ["name", "city"]
+replacements = ["%s", "Orléans"]
+if = {"city" = "%s"}
+`
+	tests := []struct {
+		name        string
+		encoding    string
+		replacement string
+		city        string
+		rows        []string
+		toUTF8      bool
+		setLine     string
+		want        []string
+	}{
+		{
+			name:        "latin1",
+			encoding:    "LATIN1",
+			replacement: "Zoë",
+			city:        "Zürich",
+			rows:        []string{"1\tRen\xe9\tZ\xfcrich", "2\tJos\xe9\tK\xf6ln"},
+			setLine:     "SET client_encoding = 'LATIN1';",
+			want:        []string{"1\tZo\xeb\tOrl\xe9ans", "2\tJos\xe9\tK\xf6ln"},
+		},
+		{
+			name:        "win1252",
+			encoding:    "WIN1252",
+			replacement: "Œuvre",
+			city:        "€city",
+			rows:        []string{"1\tRen\xe9\t\x80city", "2\t\x93quoted\x94\t\\N"},
+			setLine:     "SET client_encoding = 'WIN1252';",
+			want:        []string{"1\t\x8cuvre\tOrl\xe9ans", "2\t\x93quoted\x94\t\\N"},
+		},
+		{
+			name:        "latin1 to utf8",
+			encoding:    "LATIN1",
+			replacement: "Zoë",
+			city:        "Zürich",
+			rows:        []string{"1\tRen\xe9\tZ\xfcrich", "2\tJos\xe9\tK\xf6ln"},
+			toUTF8:      true,
+			setLine:     "SET client_encoding = 'UTF8';",
+			want:        []string{"1\tZoë\tOrléans", "2\tJosé\tKöln"},
+		},
+		{
+			name:        "win1252 to utf8 with unrepresentable replacement",
+			encoding:    "WIN1252",
+			replacement: "Ζωή",
+			city:        "€city",
+			rows:        []string{"1\tRen\xe9\t\x80city"},
+			toUTF8:      true,
+			setLine:     "SET client_encoding = 'UTF8';",
+			want:        []string{"1\tΖωή\tOrléans"},
+		},
+	}
+
+	for _, tc := range tests {
+		buffer := bytes.NewBuffer(nil)
+		args := anonArgs{
+			dumpFilePath: encodingDump(t, tc.encoding, tc.rows...),
+			settingsToml: strings.Replace(strings.Replace(settings, "%s", tc.replacement, 1), "%s", tc.city, 1),
+			output:       buffer,
+			toUTF8:       tc.toUTF8,
+		}
+		if err := Anonymise(args); err != nil {
+			t.Fatalf("%s: Anonymise should not fail: %s", tc.name, err)
+		}
+		output := buffer.String()
+		if !strings.HasPrefix(output, tc.setLine+"\n") {
+			t.Errorf("%s: unexpected encoding line in %q", tc.name, output)
+		}
+		if want := strings.Join(tc.want, "\n") + "\n\\.\n"; !strings.Contains(output, want) {
+			t.Errorf("%s: output %q does not contain %q", tc.name, output, want)
+		}
+	}
+}
+
+func TestAnonymiseEncodingsFake(t *testing.T) {
+
+	// generated names, streets and cities are transliterated where the
+	// encoding cannot represent their letters
+	settings := `
+[["public.users"]]
+filter = "fake person"
+columns = ["name", "city"]
+replacements = ["full_name", "city"]
+optargs = {"locale" = ["de_DE", "42"]}
+`
+	var rows []string
+	for i := 0; i < 200; i++ {
+		rows = append(rows, fmt.Sprintf("%d\tname %d\tcity %d", i, i, i))
+	}
+	for _, encoding := range []string{"KOI8R", "WIN1251"} {
+		buffer := bytes.NewBuffer(nil)
+		args := anonArgs{
+			dumpFilePath: encodingDump(t, encoding, rows...),
+			settingsToml: settings,
+			output:       buffer,
+		}
+		if err := Anonymise(args); err != nil {
+			t.Fatalf("%s: Anonymise should not fail: %s", encoding, err)
+		}
+		if strings.Contains(buffer.String(), "name 1\t") {
+			t.Errorf("%s: rows not anonymised", encoding)
+		}
+	}
+}
+
+func TestAnonymiseEncodingsFail(t *testing.T) {
+
+	settings := `
+[["public.users"]]
+filter = "string replace"
+columns = ["name"]
+replacements = ["%s"]
+`
+	tests := []struct {
+		name        string
+		encoding    string
+		replacement string
+		toUTF8      bool
+		err         string
+	}{
+		{"unrepresentable replacement", "LATIN1", "€", false, "cannot be represented in LATIN1"},
+		{"unrepresentable cjk replacement", "WIN1252", "名前", false, "cannot be represented in WIN1252"},
+		{"unsupported encoding", "EUC_JP", "name", false, "not supported"},
+		{"sql_ascii to utf8", "SQL_ASCII", "name", true, "cannot be rewritten"},
+	}
+
+	for _, tc := range tests {
+		args := anonArgs{
+			dumpFilePath: encodingDump(t, tc.encoding, "1\tRen\xe9\tParis"),
+			settingsToml: strings.Replace(settings, "%s", tc.replacement, 1),
+			output:       bytes.NewBuffer(nil),
+			toUTF8:       tc.toUTF8,
+		}
+		err := Anonymise(args)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.name, tc.err, err)
+		}
+	}
+
+	// the replacement domain of the email filter is checked
+	t.Setenv("ANON_KEY", "secret")
+	args := anonArgs{
+		dumpFilePath: encodingDump(t, "LATIN1", "1\tann@example.com\tParis"),
+		settingsToml: `
+[["public.users"]]
+filter = "email"
+columns = ["name"]
+optargs = {"domain" = ["replace", "€.example"], "key" = ["env", "ANON_KEY"]}
+`,
+		output: bytes.NewBuffer(nil),
+	}
+	if err := Anonymise(args); err == nil || !strings.Contains(err.Error(), "cannot be represented in LATIN1") {
+		t.Errorf("unrepresentable email domain: expected an encoding error, got %v", err)
+	}
+}
+
+func TestAnonymiseUTF8ArchiveFail(t *testing.T) {
+
+	tomlString, err := os.ReadFile("testdata/settings.toml")
+	if err != nil {
+		t.Fatalf("could not read settings file: %s", err)
+	}
+	dumpFile := filepath.Join(t.TempDir(), "dump.custom")
+	archive := makeCustomArchive(t, testArchiveHeader(14, compressionNone))
+	if err := os.WriteFile(dumpFile, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := anonArgs{
+		dumpFilePath: dumpFile,
+		settingsToml: string(tomlString),
+		output:       bytes.NewBuffer(nil),
+		toUTF8:       true,
+	}
+	if err := Anonymise(args); !errors.Is(err, errUTF8PlainOnly) {
+		t.Errorf("expected a plain format only error, got %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Alongside the anonymised dump, the anonymised data of each table with
+// filters may be exported to a file of its own in a directory, named by
+// the table, such as public.users.csv or sales.public.users.jsonl for a
+// table of a pg_dumpall dump. The first row of a CSV export is a header
+// of the column names, and a NULL is an unquoted empty field while an
+// empty string is quoted, as for postgresql's COPY ... CSV HEADER. Each
+// line of a JSON Lines export is an object of the columns of a row, in
+// the order of the table's columns, with string values or null. Values
+// are written in UTF-8, whatever the encoding of the dump. Parquet
+// exports, described in parquet.go, are typed by the table definitions
+// of the dump.
+
+// export formats
+const (
+	exportCSV     = "csv"
+	exportJSONL   = "jsonl"
+	exportParquet = "parquet"
+)
+
+// errExportCDC is returned when exporting change events
+var errExportCDC = errors.New("change events cannot be exported by table")
+
+// tableExport writes the rows of tables to the files of an export
+// directory. The tables of a directory format dump may be exported
+// concurrently
+type tableExport struct {
+	dir          string
+	format       string
+	rowGroupSize int // rows of each row group of parquet exports
+	mu           sync.Mutex
+	tables       map[string]*exportFile // the files of the tables, by name
+	err          error                  // the first error closing a file
+}
+
+// exportFile is the export file of a table
+type exportFile struct {
+	file    *os.File
+	w       *bufio.Writer
+	format  string
+	columns []string
+	parquet *parquetWriter // the writer of a parquet export
+	closed  bool
+}
+
+// newTableExport makes an export to a directory, which is created if
+// necessary. The row group size of parquet exports defaults to
+// parquetRowGroupSize
+func newTableExport(dir, format string, rowGroupSize int) (*tableExport, error) {
+	if format != exportCSV && format != exportJSONL && format != exportParquet {
+		return nil, fmt.Errorf("invalid export format %q", format)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not make export directory: %w", err)
+	}
+	return &tableExport{dir: dir, format: format, rowGroupSize: rowGroupSize, tables: map[string]*exportFile{}}, nil
+}
+
+// exportFileName returns the name of the export file of a table
+func exportFileName(table, format string) string {
+	return strings.NewReplacer("/", "_", `\`, "_").Replace(table) + "." + format
+}
+
+// start starts the export file of a table with the header of a CSV
+// export, or the schema of a parquet export from the table definition,
+// if any. The data of a table may only be exported once
+func (e *tableExport) start(table string, columns []string, td *tableDefinition) (*exportFile, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.tables[table]; ok {
+		return nil, fmt.Errorf("the data of table %s has already been exported", table)
+	}
+	file, err := os.Create(filepath.Join(e.dir, exportFileName(table, e.format)))
+	if err != nil {
+		return nil, fmt.Errorf("export error: %w", err)
+	}
+	f := &exportFile{file: file, w: bufio.NewWriter(file), format: e.format, columns: columns}
+	e.tables[table] = f
+	switch e.format {
+	case exportCSV:
+		return f, f.writeCSV(columns)
+	case exportParquet:
+		f.parquet, err = newParquetWriter(f.w, table, columns, td, e.rowGroupSize)
+		if err != nil {
+			return f, fmt.Errorf("export error: %w", err)
+		}
+	}
+	return f, nil
+}
+
+// write writes the UTF-8 values of a row
+func (f *exportFile) write(values []string) error {
+	switch f.format {
+	case exportCSV:
+		return f.writeCSV(values)
+	case exportParquet:
+		if err := f.parquet.writeRow(values); err != nil {
+			return fmt.Errorf("export error: %w", err)
+		}
+		return nil
+	}
+	return f.writeJSON(values)
+}
+
+// writeCSV writes a CSV record
+func (f *exportFile) writeCSV(values []string) error {
+	var b strings.Builder
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if v != nullValue {
+			writeCSVField(&b, v, ',', false)
+		}
+	}
+	b.WriteByte('\n')
+	if _, err := f.w.WriteString(b.String()); err != nil {
+		return fmt.Errorf("export error: %w", err)
+	}
+	return nil
+}
+
+// writeJSON writes a row as a JSON object
+func (f *exportFile) writeJSON(values []string) error {
+	if len(values) != len(f.columns) {
+		return fmt.Errorf("export error: %d values for %d columns", len(values), len(f.columns))
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(f.columns[i])
+		b.Write(name)
+		b.WriteByte(':')
+		if v == nullValue {
+			b.WriteString("null")
+			continue
+		}
+		value, _ := json.Marshal(v)
+		b.Write(value)
+	}
+	b.WriteString("}\n")
+	if _, err := f.w.WriteString(b.String()); err != nil {
+		return fmt.Errorf("export error: %w", err)
+	}
+	return nil
+}
+
+// close flushes and closes an export file, after writing the end of a
+// parquet export, returning the first error
+func (f *exportFile) close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	var err error
+	if f.parquet != nil {
+		err = f.parquet.close()
+	}
+	if ferr := f.w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// end closes the export file of a table, recording any error for Close
+func (e *tableExport) end(f *exportFile) {
+	err := f.close()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil && e.err == nil {
+		e.err = fmt.Errorf("export error: %w", err)
+	}
+}
+
+// Close closes any export files which have not been ended, as after an
+// error, returning the first error closing a file
+func (e *tableExport) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, f := range e.tables {
+		if err := f.close(); err != nil && e.err == nil {
+			e.err = fmt.Errorf("export error: %w", err)
+		}
+	}
+	return e.err
+}
+
+// startExport starts the export of the current table, if required
+func (d *dumpFilter) startExport() error {
+	if d.export == nil || d.referenceMode {
+		return nil
+	}
+	// the table definitions of a pg_dumpall dump are those of the
+	// current database
+	_, name := splitDatabaseTableName(d.dt.TableName)
+	td, _ := d.ddl.table(name)
+	f, err := d.export.start(d.dt.TableName, d.dt.ColumnNames(), td)
+	if err != nil {
+		return err
+	}
+	d.exportFile = f
+	return nil
+}
+
+// exportRow exports the UTF-8 values of a filtered row of the current
+// table, if required
+func (d *dumpFilter) exportRow(values []string) error {
+	if d.exportFile == nil {
+		return nil
+	}
+	return d.exportFile.write(values)
+}
+
+// endExport ends the export of the current table, if any
+func (d *dumpFilter) endExport() {
+	if d.exportFile != nil {
+		d.export.end(d.exportFile)
+		d.exportFile = nil
+	}
+}
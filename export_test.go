@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportFile(t *testing.T) {
+
+	columns := []string{"id", "name", "notes"}
+	rows := [][]string{
+		{"1", "ann", nullValue},
+		{"2", "", `say "hi", then go`},
+		{"3", "bé", "two\nlines"},
+		{"4", `\.`, "a\\b"},
+	}
+	tests := map[string]string{
+		exportCSV: "id,name,notes\n" +
+			"1,ann,\n" +
+			"2,\"\",\"say \"\"hi\"\", then go\"\n" +
+			"3,bé,\"two\nlines\"\n" +
+			"4,\"\\.\",a\\b\n",
+		exportJSONL: `{"id":"1","name":"ann","notes":null}` + "\n" +
+			`{"id":"2","name":"","notes":"say \"hi\", then go"}` + "\n" +
+			`{"id":"3","name":"bé","notes":"two\nlines"}` + "\n" +
+			`{"id":"4","name":"\\.","notes":"a\\b"}` + "\n",
+	}
+
+	for format, want := range tests {
+		dir := t.TempDir()
+		e, err := newTableExport(dir, format, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := e.start("public.users", columns, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range rows {
+			if err := f.write(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		e.end(f)
+		if _, err := e.start("public.users", columns, nil); err == nil {
+			t.Errorf("%s: expected an error exporting a table twice", format)
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join(dir, "public.users."+format))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: expected\n%s\ngot\n%s", format, want, got)
+		}
+	}
+
+	if _, err := newTableExport(t.TempDir(), "xml", 0); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+const exportSettings = `
+[["public.users"]]
+filter = "string replace"
+columns = ["password"]
+replacements = ["secret"]
+
+[["example_schema.events"]]
+filter = "delete"
+`
+
+// readExport reads the export files of a directory, by name
+func readExport(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = string(b)
+	}
+	return files
+}
+
+func TestAnonymiseExport(t *testing.T) {
+
+	dir := t.TempDir()
+	buffer := bytes.NewBuffer(nil)
+	args := anonArgs{
+		dumpFilePath: "testdata/pg_dump.sql",
+		settingsToml: exportSettings,
+		output:       buffer,
+		exportDir:    dir,
+		exportFormat: exportCSV,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+	if !strings.Contains(buffer.String(), "1\tariadne\taugustus\tsecret\t") {
+		t.Error("expected the anonymised dump to be written")
+	}
+
+	files := readExport(t, dir)
+	if len(files) != 2 {
+		t.Fatalf("expected the two tables with filters to be exported, got %d files", len(files))
+	}
+	if got := files["example_schema.events.csv"]; got != "id,flags,data\n" {
+		t.Errorf("expected only the header of a deleted table, got %q", got)
+	}
+	users := strings.Split(files["public.users.csv"], "\n")
+	if users[0] != "id,firstname,lastname,password,uuid,notes" {
+		t.Errorf("unexpected header %s", users[0])
+	}
+	if want := "1,ariadne,augustus,secret,13c80bc0-1033-409a-80c7-6e9812129ae3,"; users[1] != want {
+		t.Errorf("expected row\n%s\ngot\n%s", want, users[1])
+	}
+
+	// the exports of a custom format archive of the same data match
+	archive := filepath.Join(t.TempDir(), "dump.custom")
+	if err := os.WriteFile(archive, makeCustomArchive(t, testArchiveHeader(14, compressionGzip)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	customDir := t.TempDir()
+	args.dumpFilePath, args.output, args.exportDir = archive, bytes.NewBuffer(nil), customDir
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail for a custom archive: %s", err)
+	}
+	customFiles := readExport(t, customDir)
+	for name, want := range files {
+		if customFiles[name] != want {
+			t.Errorf("%s: expected custom archive export\n%s\ngot\n%s", name, want, customFiles[name])
+		}
+	}
+}
+
+func TestAnonymiseExportJSONLEncoding(t *testing.T) {
+
+	dumpFile := versionDump(t, "16.2",
+		"SET client_encoding = 'LATIN1';",
+		`COPY public.users (id, name, notes) FROM stdin;`,
+		"1\tren\xe9e\t\\N",
+		"2\tzo\xeb\ta\\tb",
+		`\.`,
+	)
+	dir := t.TempDir()
+	args := anonArgs{
+		dumpFilePath: dumpFile,
+		settingsToml: "[[\"public.users\"]]\nfilter = \"string replace\"\ncolumns = [\"name\"]\nreplacements = [\"zo\u00eb\"]\nnotif = {\"id\" = \"2\"}\n",
+		output:       bytes.NewBuffer(nil),
+		exportDir:    dir,
+		exportFormat: exportJSONL,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+	want := `{"id":"1","name":"zoë","notes":null}` + "\n" + `{"id":"2","name":"zoë","notes":"a\tb"}` + "\n"
+	if got := readExport(t, dir)["public.users.jsonl"]; got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+}
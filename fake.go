@@ -0,0 +1,425 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The fake filter replaces the values of columns with realistic fake
+// data made by a generator for each column, given by the replacements
+// of the filter, from the names, streets and cities of the embedded
+// datasets of a locale, en_GB by default. The phone numbers are those of
+// the ranges reserved for drama by the telephone regulators of each
+// country and the email addresses are those of the example domains
+// reserved by RFC 2606, so that no fake value reaches a real person.
+//
+// The locale and an optional seed are given by the "locale" option,
+// such as optargs = {"locale" = ["de_DE", "42"]}. With a seed, each
+// value is generated from the seed and the original value, so that
+// equal values are replaced with equal values in every table and on
+// every run with the same seed, otherwise the values are random. NULLs
+// are not replaced. In dumps of other client encodings than UTF-8, the
+// letters of generated values that the encoding cannot represent are
+// transliterated, so that "Lübeck" is written as "Luebeck" in KOI8R.
+
+// fakeData are the datasets of each locale, with a line for each name
+//
+//go:embed fakedata
+var fakeData embed.FS
+
+// the files of the datasets of a locale
+const (
+	fakeFemaleNames = "female_names.txt"
+	fakeMaleNames   = "male_names.txt"
+	fakeLastNames   = "last_names.txt"
+	fakeStreets     = "streets.txt"
+	fakeCities      = "cities.txt"
+)
+
+// fakeDefaultLocale is the locale of filters without a locale option
+const fakeDefaultLocale = "en_GB"
+
+// fakeEmailDomains are the domains reserved for examples by RFC 2606
+var fakeEmailDomains = []string{"example.com", "example.org", "example.net"}
+
+// fakeRand is a small splitmix64 random number generator, which is
+// cheap to seed for each value
+type fakeRand struct {
+	state uint64
+}
+
+// newFakeRand makes a random number generator from a random seed
+func newFakeRand() *fakeRand {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return &fakeRand{binary.BigEndian.Uint64(b[:])}
+}
+
+// seedFakeRand makes a random number generator seeded by a seed and
+// the parts of a value
+func seedFakeRand(seed string, parts ...string) *fakeRand {
+	h := sha256.New()
+	h.Write([]byte(seed))
+	for _, p := range parts {
+		h.Write([]byte{0})
+		h.Write([]byte(p))
+	}
+	return &fakeRand{binary.BigEndian.Uint64(h.Sum(nil))}
+}
+
+// next returns the next random number
+func (r *fakeRand) next() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	z := r.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// intn returns a random number from 0 to n-1
+func (r *fakeRand) intn(n int) int {
+	return int(r.next() % uint64(n))
+}
+
+// between returns a random number from min to max
+func (r *fakeRand) between(min, max int) int {
+	return min + r.intn(max-min+1)
+}
+
+// pick returns a random item of a slice
+func (r *fakeRand) pick(s []string) string {
+	return s[r.intn(len(s))]
+}
+
+// digits returns a string of n random digits
+func (r *fakeRand) digits(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('0' + r.intn(10))
+	}
+	return string(b)
+}
+
+// fakeLocale is the data and formats of the fake values of a locale
+type fakeLocale struct {
+	name        string
+	femaleNames []string
+	maleNames   []string
+	lastNames   []string
+	streets     []string
+	cities      []string
+	// formats of the locale
+	phone           func(r *fakeRand) string
+	address         func(street string, r *fakeRand) string
+	postcode        func(r *fakeRand) string
+	companySuffixes []string
+}
+
+// fakeLocales are the formats of each locale, of which the datasets are
+// loaded by loadFakeLocale
+var fakeLocales = map[string]fakeLocale{
+	"en_GB": {
+		// the Ofcom mobile range for drama
+		phone: func(r *fakeRand) string { return "07700 900" + r.digits(3) },
+		address: func(street string, r *fakeRand) string {
+			return strconv.Itoa(r.between(1, 200)) + " " + street
+		},
+		postcode: func(r *fakeRand) string {
+			areas := []string{"B", "BS", "CB", "CF", "E", "EH", "G", "L", "LS", "M", "N", "NE", "NG", "NW", "OX", "S", "SE", "SW", "W", "YO"}
+			const letters = "ABDEFGHJLNPQRSTUWXYZ"
+			return fmt.Sprintf("%s%d %d%c%c", r.pick(areas), r.between(1, 20), r.intn(10),
+				letters[r.intn(len(letters))], letters[r.intn(len(letters))])
+		},
+		companySuffixes: []string{"Ltd", "plc", "LLP", "& Sons", "Group"},
+	},
+	"en_US": {
+		// the 555-0100 to 555-0199 range for fiction
+		phone: func(r *fakeRand) string {
+			areas := []string{"202", "206", "212", "305", "312", "404", "415", "503", "512", "617", "702", "713"}
+			return "(" + r.pick(areas) + ") 555-01" + r.digits(2)
+		},
+		address: func(street string, r *fakeRand) string {
+			return strconv.Itoa(r.between(100, 9999)) + " " + street
+		},
+		postcode:        func(r *fakeRand) string { return fmt.Sprintf("%05d", r.between(1001, 99950)) },
+		companySuffixes: []string{"Inc.", "LLC", "Corp.", "& Co.", "Group"},
+	},
+	"de_DE": {
+		// the Bundesnetzagentur Berlin range for drama
+		phone: func(r *fakeRand) string { return "030 23125 " + r.digits(3) },
+		address: func(street string, r *fakeRand) string {
+			return street + " " + strconv.Itoa(r.between(1, 120))
+		},
+		postcode:        func(r *fakeRand) string { return fmt.Sprintf("%05d", r.between(1067, 99998)) },
+		companySuffixes: []string{"GmbH", "AG", "KG", "GmbH & Co. KG", "e.K."},
+	},
+	"fr_FR": {
+		// the ARCEP ranges for fiction
+		phone: func(r *fakeRand) string {
+			return r.pick([]string{"01 99 00", "06 39 98"}) + " " + r.digits(2) + " " + r.digits(2)
+		},
+		address: func(street string, r *fakeRand) string {
+			return strconv.Itoa(r.between(1, 150)) + " " + street
+		},
+		postcode:        func(r *fakeRand) string { return fmt.Sprintf("%02d%03d", r.between(1, 95), r.intn(1000)) },
+		companySuffixes: []string{"SA", "SARL", "SAS", "et Fils", "Groupe"},
+	},
+}
+
+// loadFakeLocale returns a locale with its datasets
+func loadFakeLocale(name string) (*fakeLocale, error) {
+	l, ok := fakeLocales[name]
+	if !ok {
+		locales := make([]string, 0, len(fakeLocales))
+		for n := range fakeLocales {
+			locales = append(locales, n)
+		}
+		sort.Strings(locales)
+		return nil, fmt.Errorf("unknown locale %q, expected one of %s", name, strings.Join(locales, ", "))
+	}
+	l.name = name
+	for _, d := range []struct {
+		file  string
+		names *[]string
+	}{
+		{fakeFemaleNames, &l.femaleNames},
+		{fakeMaleNames, &l.maleNames},
+		{fakeLastNames, &l.lastNames},
+		{fakeStreets, &l.streets},
+		{fakeCities, &l.cities},
+	} {
+		b, err := fakeData.ReadFile(path.Join("fakedata", name, d.file))
+		if err != nil {
+			return nil, fmt.Errorf("could not read dataset: %w", err)
+		}
+		*d.names = strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	}
+	return &l, nil
+}
+
+// firstName returns a female or male first name
+func (l *fakeLocale) firstName(r *fakeRand) string {
+	if r.intn(2) == 0 {
+		return r.pick(l.femaleNames)
+	}
+	return r.pick(l.maleNames)
+}
+
+// email returns an email address made from a name
+func (l *fakeLocale) email(first, last string, r *fakeRand) string {
+	local := fakeASCII(first) + r.pick([]string{".", "_", ""}) + fakeASCII(last)
+	if r.intn(3) == 0 {
+		local += strconv.Itoa(r.between(1, 99))
+	}
+	return local + "@" + r.pick(fakeEmailDomains)
+}
+
+// username returns a username made from a name
+func (l *fakeLocale) username(first, last string, r *fakeRand) string {
+	first, last = fakeASCII(first), fakeASCII(last)
+	switch r.intn(3) {
+	case 0:
+		return first[:1] + last
+	case 1:
+		return first + "." + last
+	}
+	return last + first[:1] + strconv.Itoa(r.between(1, 99))
+}
+
+// company returns a company name
+func (l *fakeLocale) company(r *fakeRand) string {
+	if r.intn(3) == 0 {
+		return r.pick(l.lastNames) + " & " + r.pick(l.lastNames)
+	}
+	return r.pick(l.lastNames) + " " + r.pick(l.companySuffixes)
+}
+
+// fakeGenerators make a fake value in a locale
+var fakeGenerators = map[string]func(l *fakeLocale, r *fakeRand) string{
+	"first_name": func(l *fakeLocale, r *fakeRand) string { return l.firstName(r) },
+	"last_name":  func(l *fakeLocale, r *fakeRand) string { return r.pick(l.lastNames) },
+	"email": func(l *fakeLocale, r *fakeRand) string {
+		return l.email(l.firstName(r), r.pick(l.lastNames), r)
+	},
+	"phone":          func(l *fakeLocale, r *fakeRand) string { return l.phone(r) },
+	"street_address": func(l *fakeLocale, r *fakeRand) string { return l.address(r.pick(l.streets), r) },
+	"postcode":       func(l *fakeLocale, r *fakeRand) string { return l.postcode(r) },
+	"city":           func(l *fakeLocale, r *fakeRand) string { return r.pick(l.cities) },
+	"company":        func(l *fakeLocale, r *fakeRand) string { return l.company(r) },
+	"username": func(l *fakeLocale, r *fakeRand) string {
+		return l.username(l.firstName(r), r.pick(l.lastNames), r)
+	},
+}
+
+// fakeTransliterations are the ASCII letters of the accented letters of
+// the datasets
+var fakeTransliterations = map[rune]string{
+	'ä': "ae", 'ö': "oe", 'ü': "ue", 'ß': "ss",
+	'à': "a", 'â': "a", 'ç': "c", 'é': "e", 'è': "e", 'ê': "e", 'ë': "e",
+	'î': "i", 'ï': "i", 'ô': "o", 'ù': "u", 'û': "u", 'ÿ': "y",
+}
+
+// fakeASCII returns a name in lower case ASCII letters for email
+// addresses and usernames, transliterating accented letters and
+// removing other characters
+func fakeASCII(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z':
+			b.WriteRune(r)
+		case fakeTransliterations[r] != "":
+			b.WriteString(fakeTransliterations[r])
+		}
+	}
+	return b.String()
+}
+
+// fakeTransliterate returns a generated value with the letters that
+// cannot be represented in an encoding transliterated, keeping the case
+// of their first letter, and other characters it cannot represent
+// removed. Values are returned unchanged for a nil encoding.
+func fakeTransliterate(v string, enc *clientEncoding) string {
+	if enc == nil {
+		return v
+	}
+	var b strings.Builder
+	for _, r := range v {
+		lower := unicode.ToLower(r)
+		switch {
+		case enc.represents(r):
+			b.WriteRune(r)
+		case fakeTransliterations[lower] == "":
+		case lower != r:
+			t := fakeTransliterations[lower]
+			b.WriteString(strings.ToUpper(t[:1]) + t[1:])
+		default:
+			b.WriteString(fakeTransliterations[r])
+		}
+	}
+	return b.String()
+}
+
+// fakeLocaleOption returns the locale and seed of the "locale" option
+// of a filter, if given, or the default locale without a seed
+func fakeLocaleOption(f Filter) (string, string) {
+	opt, ok := f.OptArgs["locale"]
+	if !ok {
+		return fakeDefaultLocale, ""
+	}
+	if opt[0] == "" {
+		opt[0] = fakeDefaultLocale
+	}
+	return opt[0], opt[1]
+}
+
+// FakeFilter replaces columns with fake values
+type FakeFilter struct {
+	filterName
+	Columns    []string
+	generators []string // the generator of each column
+	locale     *fakeLocale
+	seed       string
+	rand       *fakeRand       // the generator of random values without a seed
+	encoding   *clientEncoding // the encoding of the values, nil for UTF-8
+	whereTrue  map[string]string
+	whereFalse map[string]string
+}
+
+// NewFakeFilter makes a new FakeFilter with a generator for each column
+// in a locale, generating values from the seed and the original values
+// if a seed is given
+func NewFakeFilter(columns, generators []string, locale, seed string, whereTrue, whereFalse map[string]string) (*FakeFilter, error) {
+
+	f := FakeFilter{
+		filterName: "fake",
+		Columns:    columns,
+		generators: generators,
+		seed:       seed,
+		whereTrue:  whereTrue,
+		whereFalse: whereFalse,
+	}
+
+	if len(columns) == 0 {
+		return &f, errors.New("fake: at least one column must be specified")
+	}
+	if len(generators) != len(columns) {
+		return &f, errors.New("fake: column length != generator length")
+	}
+	for _, g := range generators {
+		if _, ok := fakeGenerators[g]; !ok {
+			return &f, fmt.Errorf("fake: unknown generator %q", g)
+		}
+	}
+	var err error
+	if f.locale, err = loadFakeLocale(locale); err != nil {
+		return &f, fmt.Errorf("fake: %w", err)
+	}
+	if seed == "" {
+		f.rand = newFakeRand()
+	}
+	return &f, nil
+}
+
+// Filter replaces the columns of a row with fake values
+func (f *FakeFilter) Filter(r Row) (Row, error) {
+
+	// if there is no line number the previous filter may have stopped
+	// processing
+	if r.lineNo == 0 {
+		return r, nil
+	}
+
+	// if no match for whereTrue conditions, return
+	if len(f.whereTrue) > 0 && r.match(f.FilterName(), f.whereTrue) != true {
+		return r, nil
+	}
+	// if match for whereFalse conditions, return
+	if len(f.whereFalse) > 0 && r.match(f.FilterName(), f.whereFalse) == true {
+		return r, nil
+	}
+
+	changed := 0
+	for i, rc := range r.ColumnNames() {
+		for j, cn := range f.Columns {
+			if rc == cn {
+				changed++
+				if r.Columns[i] != nullValue {
+					random := f.rand
+					if f.seed != "" {
+						random = seedFakeRand(f.seed, f.generators[j], r.Columns[i])
+					}
+					r.Columns[i] = fakeTransliterate(fakeGenerators[f.generators[j]](f.locale, random), f.encoding)
+				}
+				break
+			}
+		}
+	}
+
+	if changed != len(f.Columns) {
+		return r, errors.New("fake: could not find all columns in FakeFilter")
+	}
+
+	return r, nil
+}
+
+// setEncoding sets the encoding of the values, nil for UTF-8
+func (f *FakeFilter) setEncoding(enc *clientEncoding) {
+	f.encoding = enc
+}
+
+// usedColumns returns the replaced and condition columns
+func (f *FakeFilter) usedColumns() []string {
+	return whereColumns(f.Columns, f.whereTrue, f.whereFalse)
+}
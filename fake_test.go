@@ -0,0 +1,209 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFakeLocales(t *testing.T) {
+
+	for name := range fakeLocales {
+		l, err := loadFakeLocale(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, names := range [][]string{l.femaleNames, l.maleNames, l.lastNames, l.streets, l.cities} {
+			if len(names) < 40 {
+				t.Errorf("%s dataset %d: only %d names", name, i, len(names))
+			}
+			seen := map[string]bool{}
+			for _, n := range names {
+				if strings.TrimSpace(n) != n || n == "" || seen[n] {
+					t.Errorf("%s dataset %d: invalid or repeated name %q", name, i, n)
+				}
+				seen[n] = true
+			}
+		}
+		for _, n := range append(append([]string{}, l.femaleNames...), l.maleNames...) {
+			if fakeASCII(n) == "" {
+				t.Errorf("%s: name %q has no ASCII letters", name, n)
+			}
+		}
+	}
+	if _, err := loadFakeLocale("en_AU"); err == nil {
+		t.Error("expected an unknown locale error")
+	}
+}
+
+func TestFakeASCII(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Müller":        "mueller",
+		"Weiß":          "weiss",
+		"Éléonore":      "eleonore",
+		"François":      "francois",
+		"O'Brien-Smith": "obriensmith",
+	} {
+		if got := fakeASCII(name); got != expected {
+			t.Errorf("%s: got %s, expected %s", name, got, expected)
+		}
+	}
+}
+
+// fakePatterns match the values of each generator in each locale
+var fakePatterns = map[string]map[string]string{
+	"en_GB": {
+		"phone":          `^07700 900[0-9]{3}$`,
+		"street_address": `^[0-9]{1,3} [A-Z]`,
+		"postcode":       `^[A-Z]{1,2}[0-9]{1,2} [0-9][A-Z]{2}$`,
+	},
+	"en_US": {
+		"phone":          `^\([0-9]{3}\) 555-01[0-9]{2}$`,
+		"street_address": `^[0-9]{3,4} [A-Z]`,
+		"postcode":       `^[0-9]{5}$`,
+	},
+	"de_DE": {
+		"phone":          `^030 23125 [0-9]{3}$`,
+		"street_address": `^[A-ZÄÖÜ].* [0-9]{1,3}$`,
+		"postcode":       `^[0-9]{5}$`,
+	},
+	"fr_FR": {
+		"phone":          `^0[16] [0-9]{2} [0-9]{2} [0-9]{2} [0-9]{2}$`,
+		"street_address": `^[0-9]{1,3} [a-zG]`,
+		"postcode":       `^[0-9]{5}$`,
+	},
+}
+
+func TestFakeFilter(t *testing.T) {
+
+	generators := []string{"first_name", "last_name", "email", "phone", "street_address", "postcode", "city", "company", "username"}
+	common := map[string]string{
+		"first_name": `^\pL[\pL'-]+$`,
+		"last_name":  `^\pL[\pL' -]+$`,
+		"email":      `^[a-z]+[._]?[a-z]+[0-9]{0,2}@example\.(com|org|net)$`,
+		"city":       `^\pL`,
+		"company":    `^\pL`,
+		"username":   `^[a-z]+(\.[a-z]+|[0-9]{1,2})?$`,
+	}
+	values := []string{"Ann", "Smith", "ann@example.com", "020 7946 0018", "1 The Street", "N1 9GU", "London", "Acme", "ann"}
+
+	for locale, patterns := range fakePatterns {
+		filter, err := NewFakeFilter(generators, generators, locale, "", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n < 50; n++ {
+			r, err := filter.Filter(hashRow(generators, append([]string{}, values...)...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, g := range generators {
+				p, ok := patterns[g]
+				if !ok {
+					p = common[g]
+				}
+				if !regexp.MustCompile(p).MatchString(r.Columns[i]) {
+					t.Errorf("%s %s: %q does not match %s", locale, g, r.Columns[i], p)
+				}
+			}
+		}
+	}
+}
+
+func TestFakeFilterSeed(t *testing.T) {
+
+	columns := []string{"name", "email"}
+	generators := []string{"first_name", "email"}
+	fake := func(seed, name, email string) []string {
+		filter, err := NewFakeFilter(columns, generators, "fr_FR", seed, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := filter.Filter(hashRow(columns, name, email))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.Columns
+	}
+
+	// equal values with the same seed are replaced with equal values
+	a := fake("42", "Ann", "ann@example.com")
+	if b := fake("42", "Ann", "ann@example.com"); strings.Join(a, ",") != strings.Join(b, ",") {
+		t.Errorf("seeded values differ: %q and %q", a, b)
+	}
+	different := 0
+	for _, v := range []string{"Bob", "Cat", "Dan", "Eve"} {
+		if fake("42", v, v)[0] != a[0] {
+			different++
+		}
+		if fake("43", "Ann", "ann@example.com")[1] == a[1] {
+			t.Error("a different seed made the same email address")
+		}
+	}
+	if different == 0 {
+		t.Error("different values were all replaced with the same name")
+	}
+
+	// NULLs are not replaced
+	if got := fake("42", nullValue, "x"); got[0] != nullValue {
+		t.Errorf("NULL replaced with %q", got[0])
+	}
+}
+
+func TestFakeFilterFail(t *testing.T) {
+
+	tests := []struct {
+		columns    []string
+		generators []string
+		locale     string
+	}{
+		{nil, nil, "en_GB"},
+		{[]string{"a", "b"}, []string{"city"}, "en_GB"},
+		{[]string{"a"}, []string{"country"}, "en_GB"},
+		{[]string{"a"}, []string{"city"}, "xx_XX"},
+	}
+	for i, tt := range tests {
+		if _, err := NewFakeFilter(tt.columns, tt.generators, tt.locale, "", nil, nil); err == nil {
+			t.Errorf("test %d: expected an error", i)
+		}
+	}
+
+	filter, err := NewFakeFilter([]string{"city"}, []string{"city"}, "en_GB", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := filter.Filter(hashRow([]string{"town"}, "York")); err == nil {
+		t.Error("expected a missing column error")
+	}
+}
+
+func TestLoadFakeFilter(t *testing.T) {
+
+	settings, err := LoadToml(`
+[["public.customers"]]
+filter = "fake"
+columns = ["firstname", "town"]
+replacements = ["first_name", "city"]
+optargs = {"locale" = ["de_DE", "42"]}
+
+[["public.suppliers"]]
+filter = "fake"
+columns = ["name"]
+replacements = ["company"]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf, err := loadFilters(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	customers := tf.getTableFilters("public.customers")[0].(*FakeFilter)
+	if customers.locale.name != "de_DE" || customers.seed != "42" {
+		t.Errorf("unexpected locale %s and seed %s", customers.locale.name, customers.seed)
+	}
+	suppliers := tf.getTableFilters("public.suppliers")[0].(*FakeFilter)
+	if suppliers.locale.name != fakeDefaultLocale || suppliers.seed != "" {
+		t.Errorf("unexpected locale %s and seed %s", suppliers.locale.name, suppliers.seed)
+	}
+}
@@ -24,6 +24,25 @@ type RowFilterer interface {
 	setRefDumpTable(rt RefTableRegister)
 	// getExternalTables retrieves the name of any foreign dump table
 	getRefDumpTable() string
+	// usedColumns returns the names of the columns the filter reads or
+	// replaces, so that other columns of long rows need not be held in
+	// memory
+	usedColumns() []string
+}
+
+// replacer is implemented by filters with fixed replacement values,
+// which are checked against the encoding of a dump
+type replacer interface {
+	// replacementValues returns the replacement values of the filter
+	replacementValues() []string
+}
+
+// transliterator is implemented by filters generating values from
+// datasets, which are given the encoding of the rows of a dump so that
+// the letters it cannot represent are transliterated
+type transliterator interface {
+	// setEncoding sets the encoding of the values, nil for UTF-8
+	setEncoding(enc *clientEncoding)
 }
 
 // filterName is the base filter type name, embedded in each filter
@@ -45,6 +64,18 @@ func (f *filterName) getRefDumpTable() string {
 	return ""
 }
 
+// whereColumns returns columns together with the column names of the
+// whereTrue and whereFalse conditions of a filter
+func whereColumns(columns []string, whereTrue, whereFalse map[string]string) []string {
+	used := append([]string{}, columns...)
+	for _, where := range []map[string]string{whereTrue, whereFalse} {
+		for c := range where {
+			used = append(used, c)
+		}
+	}
+	return used
+}
+
 // DeleteFilter removes all lines
 type DeleteFilter struct {
 	filterName
@@ -61,6 +92,11 @@ func (f DeleteFilter) Filter(r Row) (Row, error) {
 	return rr, nil
 }
 
+// usedColumns returns no columns, as the filter does not read any
+func (f DeleteFilter) usedColumns() []string {
+	return nil
+}
+
 // replaceByColumnFilter replaces a column named "Column" with the
 // provided replacement string
 type replaceByColumnFilter struct {
@@ -116,6 +152,11 @@ func (f *replaceByColumnFilter) Filter(r Row) (Row, error) {
 	return r, nil
 }
 
+// usedColumns returns the replaced and condition columns
+func (f *replaceByColumnFilter) usedColumns() []string {
+	return whereColumns([]string{f.Column}, f.whereTrue, f.whereFalse)
+}
+
 // fileByColumnFilter reads the contents of file into the struct and
 // uses this to replace the contents of the designated column
 type fileByColumnFilter struct {
@@ -128,7 +169,9 @@ type fileByColumnFilter struct {
 }
 
 // newFileByColumnFilter makes a new fileByColumnFilter, which should
-// only be called by a multi-columnar FileFilter
+// only be called by a multi-columnar FileFilter. The lines of the
+// source are in COPY text format, so that \N is NULL and backslash
+// escapes such as \t are decoded
 func newFileByColumnFilter(column string, fh io.Reader, whereTrue, whereFalse map[string]string) (*fileByColumnFilter, error) {
 
 	f := &fileByColumnFilter{
@@ -148,7 +191,7 @@ func newFileByColumnFilter(column string, fh io.Reader, whereTrue, whereFalse ma
 		if strings.Contains(t, "\t") {
 			return f, fmt.Errorf("file replacer: source for %s contains a tab", f.Column)
 		}
-		f.Replacements = append(f.Replacements, t)
+		f.Replacements = append(f.Replacements, decodeCopyValue(t))
 	}
 	// return an error if the scanner failed
 	if err := scanner.Err(); err != nil {
@@ -195,6 +238,11 @@ func (f *fileByColumnFilter) Filter(r Row) (Row, error) {
 	return r, nil
 }
 
+// usedColumns returns the replaced and condition columns
+func (f *fileByColumnFilter) usedColumns() []string {
+	return whereColumns([]string{f.Column}, f.whereTrue, f.whereFalse)
+}
+
 // UUIDFilter replaces the column with the output of a UUID
 // generation function
 type UUIDFilter struct {
@@ -259,6 +307,11 @@ func (f *UUIDFilter) Filter(r Row) (Row, error) {
 	return r, nil
 }
 
+// usedColumns returns the replaced and condition columns
+func (f *UUIDFilter) usedColumns() []string {
+	return whereColumns(f.Columns, f.whereTrue, f.whereFalse)
+}
+
 // ReplaceFilter allows multiple columns to be replaced by fixed
 // strings, described by a slice of replacements. ReplaceFilter uses
 // replaceByColumnFilter to do its work
@@ -312,6 +365,20 @@ func (f ReplaceFilter) Filter(r Row) (Row, error) {
 	return r, nil
 }
 
+// replacementValues returns the replacement strings
+func (f ReplaceFilter) replacementValues() []string {
+	return f.Replacements
+}
+
+// usedColumns returns the columns used by the component filters
+func (f ReplaceFilter) usedColumns() []string {
+	used := []string{}
+	for _, cf := range f.filters {
+		used = append(used, cf.usedColumns()...)
+	}
+	return used
+}
+
 // FileFilter replaces a number of columns in a table with replacements
 // from a tab delmited file (typically a postgres dump file). The actual
 // work of this filter is performed by a set of fileByColumnFilter
@@ -380,6 +447,24 @@ func (f FileFilter) Filter(r Row) (Row, error) {
 	return r, nil
 }
 
+// replacementValues returns the replacements read from the source
+func (f FileFilter) replacementValues() []string {
+	values := []string{}
+	for _, cf := range f.filters {
+		values = append(values, cf.(*fileByColumnFilter).Replacements...)
+	}
+	return values
+}
+
+// usedColumns returns the columns used by the component filters
+func (f FileFilter) usedColumns() []string {
+	used := []string{}
+	for _, cf := range f.filters {
+		used = append(used, cf.usedColumns()...)
+	}
+	return used
+}
+
 // ReferenceFilter replaces a number of columns in a table with
 // replacements from another table, as a very simple foreign key lookup using
 // the local key localKey and foreignKey (qualified in schema.table.column
@@ -441,15 +526,17 @@ func NewReferenceFilter(columns, replacements []string, whereTrue, whereFalse ma
 	if len(columns) != len(replacements) {
 		return f, fmt.Errorf("column length %d != replacement length %d", len(columns), len(replacements))
 	}
-	// check that the local and foreignKey keys follows the expected format
-	if len(strings.Split(localKey, ".")) != 1 {
+	// check that the local and foreignKey keys follows the expected
+	// format, in which identifiers may be quoted
+	var err error
+	if f.localKey, err = parseIdentifier(localKey); err != nil {
 		return f, fmt.Errorf("reference filter local key must not have schema qualification, got %s", localKey)
 	}
-	if len(strings.Split(foreignKey, ".")) != 3 {
+	parts, err := parseQualifiedName(foreignKey, 3, 3)
+	if err != nil {
 		return f, fmt.Errorf("reference filter foreign key requires schema.table.column format, got %s", foreignKey)
 	}
-	parts := strings.Split(foreignKey, ".")
-	f.foreignTable = parts[0] + "." + parts[1]
+	f.foreignTable = qualifiedName(parts[0], parts[1])
 	f.foreignKey = parts[2] // reassign
 	return f, nil
 }
@@ -507,14 +594,19 @@ func (f *ReferenceFilter) Filter(r Row) (Row, error) {
 
 	keyValue, err := r.colVal(f.localKey)
 	if err != nil {
-		fmt.Errorf("reference filter cannot resolve key value: %w", err)
+		return r, fmt.Errorf("reference filter cannot resolve key value: %w", err)
+	}
+
+	// a NULL key references no row
+	if keyValue == nullValue {
+		return r, nil
 	}
 
 	for i, colName := range f.Columns {
 
 		targetColNo, err := r.colNo(colName)
 		if err != nil {
-			fmt.Errorf("reference filter: cannot resolve column name %s: %w", colName, err)
+			return r, fmt.Errorf("reference filter: cannot resolve column name %s: %w", colName, err)
 		}
 		remoteColName := f.Replacements[i]
 
@@ -529,3 +621,8 @@ func (f *ReferenceFilter) Filter(r Row) (Row, error) {
 
 	return r, nil
 }
+
+// usedColumns returns the local key, replaced and condition columns
+func (f *ReferenceFilter) usedColumns() []string {
+	return whereColumns(append([]string{f.localKey}, f.Columns...), f.whereTrue, f.whereFalse)
+}
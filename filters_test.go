@@ -192,7 +192,7 @@ func TestStringReplaceFilterWhereTrue(t *testing.T) {
 		}
 		rowPassword, err := ro.colVal("password")
 		if err != nil {
-			t.Errorf("Unexpected colVal error: %w\n", err)
+			t.Errorf("Unexpected colVal error: %s\n", err)
 		}
 
 		if rowPassword != expected[i].password {
@@ -239,7 +239,7 @@ func TestStringReplaceFilterWhereFalse(t *testing.T) {
 		}
 		rowPassword, err := ro.colVal("password")
 		if err != nil {
-			t.Errorf("Unexpected colVal error: %w\n", err)
+			t.Errorf("Unexpected colVal error: %s\n", err)
 		}
 
 		if rowPassword != expected[i].password {
@@ -263,7 +263,7 @@ func TestStringReplaceFilterWhereTrueNULL(t *testing.T) {
 	rows = []Row{
 		Row{
 			DumpTabler: dt,
-			Columns:    []string{"Adam Applebaum", "20", `\N`, "f86f06f8-bc48-11ec-9d40-07b727bf6764"},
+			Columns:    []string{"Adam Applebaum", "20", nullValue, "f86f06f8-bc48-11ec-9d40-07b727bf6764"},
 			lineNo:     1,
 		},
 		Row{
@@ -273,7 +273,7 @@ func TestStringReplaceFilterWhereTrueNULL(t *testing.T) {
 		},
 		Row{
 			DumpTabler: dt,
-			Columns:    []string{"Zachary Zebb", "55", "\\N", "09cf3bd4-bc49-11ec-83d6-ab2e063c8ce1"},
+			Columns:    []string{"Zachary Zebb", "55", nullValue, "09cf3bd4-bc49-11ec-83d6-ab2e063c8ce1"},
 			lineNo:     3,
 		},
 	}
@@ -281,8 +281,8 @@ func TestStringReplaceFilterWhereTrueNULL(t *testing.T) {
 	filter, err := newReplaceByColumnFilter(
 		"password",
 		"APassword",
-		map[string]string{"password": "\\N"}, // whereTrue
-		map[string]string{},                  // whereFalse
+		map[string]string{"password": nullValue}, // whereTrue
+		map[string]string{},                      // whereFalse
 	)
 	if err != nil {
 		t.Error("TestStringReplaceFilter failed init")
@@ -310,7 +310,7 @@ func TestStringReplaceFilterWhereTrueNULL(t *testing.T) {
 		}
 		rowPassword, err := ro.colVal("password")
 		if err != nil {
-			t.Errorf("Unexpected colVal error: %w\n", err)
+			t.Errorf("Unexpected colVal error: %s\n", err)
 		}
 
 		if rowPassword != expected[i].password {
@@ -761,7 +761,7 @@ func TestNewReferenceFilter(t *testing.T) {
 		"public.users.age",  // remote key column
 	)
 	if err != nil {
-		t.Errorf("could not register reference filter %w", err)
+		t.Errorf("could not register reference filter %s", err)
 	}
 	// register the referenced dump tables
 	filter.setRefDumpTable(rtr)
@@ -803,7 +803,7 @@ func TestNewReferenceFilterWithAdd(t *testing.T) {
 		"public.users.age",  // remote key column
 	)
 	if err != nil {
-		t.Errorf("could not register reference filter %w", err)
+		t.Errorf("could not register reference filter %s", err)
 	}
 
 	// grab, zero and reset rdt rows
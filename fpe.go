@@ -0,0 +1,500 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// The ff1 encrypt and ff3-1 encrypt filters replace the values of
+// columns with their format preserving encryption by the FF1 or FF3-1
+// modes of NIST SP 800-38G, using AES with a secret key, so that the
+// original values can be recovered with the same key by the decrypt
+// command. The characters of a value in the alphabet of its column,
+// given by the replacements of the filter, are encrypted as one string
+// of numerals keeping their positions, while other characters are kept,
+// so that the value keeps its length and a phone number such as
+// 020-7946-0018 is encrypted to another of the same form with the
+// default alphabet of digits. The key is 32, 48 or 64 hexadecimal
+// digits, for AES-128, AES-192 or AES-256, read from an environment
+// variable or a file as for the hash filter.
+//
+// The modes require at least a million possible values, so a value with
+// fewer than 6 digits, 5 letters or 4 alphanumeric characters of its
+// alphabet cannot be encrypted, and FF3-1 limits values to 56 digits,
+// 40 letters or 32 alphanumeric characters. Short values, such as a
+// phone extension, are treated as given by the "short" option: replaced
+// with a keyed hash of the same form which cannot be decrypted, the
+// default, so that no original value is written unless asked for, kept,
+// or refused with an error, such as optargs = {"short" = ["keep", ""]}. Values without characters of the alphabet, and NULLs,
+// are not replaced.
+
+// format preserving encryption algorithms
+const (
+	fpeFF1  = "ff1"
+	fpeFF31 = "ff3-1"
+)
+
+// fpeAlphabets are the alphabets of the characters encrypted
+var fpeAlphabets = map[string]string{
+	"digits":       "0123456789",
+	"lower":        "abcdefghijklmnopqrstuvwxyz",
+	"upper":        "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"alphanumeric": "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+}
+
+// fpeDefaultAlphabet is the alphabet of columns without a replacement
+const fpeDefaultAlphabet = "digits"
+
+// fpeMinDomain is the minimum number of possible values, radix^length,
+// of a string of numerals
+const fpeMinDomain = 1000000
+
+// treatments of values too short to be encrypted
+const (
+	fpeShortKeep = "keep"
+	fpeShortHash = "hash"
+	fpeShortFail = "fail"
+)
+
+// fpeCipher encrypts and decrypts strings of numerals with FF1 or FF3-1
+type fpeCipher struct {
+	algorithm string
+	block     cipher.Block // AES with the key for FF1, or the reversed key for FF3-1
+	tweak     []byte
+}
+
+// newFPECipher makes a cipher of an algorithm with an AES key and a
+// tweak, which for FF3-1 is 7 bytes or empty for a tweak of zeros
+func newFPECipher(algorithm string, key, tweak []byte) (*fpeCipher, error) {
+	c := &fpeCipher{algorithm: algorithm, tweak: tweak}
+	switch algorithm {
+	case fpeFF1:
+	case fpeFF31:
+		key = reverseBytes(key)
+		if len(tweak) == 0 {
+			c.tweak = make([]byte, 7)
+		}
+		if len(c.tweak) != 7 {
+			return nil, errors.New("the ff3-1 tweak must be 7 bytes")
+		}
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", algorithm)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.New("the key must be 32, 48 or 64 hexadecimal digits, an AES-128, AES-192 or AES-256 key")
+	}
+	c.block = block
+	return c, nil
+}
+
+// fpeKey decodes a hexadecimal key
+func fpeKey(key []byte) ([]byte, error) {
+	k, err := hex.DecodeString(strings.TrimSpace(string(key)))
+	if err != nil || (len(k) != 16 && len(k) != 24 && len(k) != 32) {
+		return nil, errors.New("the key must be 32, 48 or 64 hexadecimal digits, an AES-128, AES-192 or AES-256 key")
+	}
+	return k, nil
+}
+
+// fpeMinLength returns the minimum length of a string of numerals of a
+// radix which can be encrypted
+func fpeMinLength(radix int) int {
+	minLen := 2
+	for d := pow(radix, minLen); d.Cmp(big.NewInt(fpeMinDomain)) < 0; d.Mul(d, big.NewInt(int64(radix))) {
+		minLen++
+	}
+	return minLen
+}
+
+// check checks that a string of numerals of a radix can be encrypted
+func (c *fpeCipher) check(n, radix int) error {
+	if minLen := fpeMinLength(radix); n < minLen {
+		return fmt.Errorf("%d characters are fewer than the minimum of %d", n, minLen)
+	}
+	if c.algorithm == fpeFF31 {
+		// 2 * floor(log_radix(2^96))
+		maxLen := 0
+		limit := new(big.Int).Lsh(big.NewInt(1), 96)
+		for d := big.NewInt(int64(radix)); d.Cmp(limit) <= 0; d.Mul(d, big.NewInt(int64(radix))) {
+			maxLen++
+		}
+		if n > 2*maxLen {
+			return fmt.Errorf("%d characters are more than the ff3-1 maximum of %d", n, 2*maxLen)
+		}
+	}
+	return nil
+}
+
+// encrypt encrypts a string of numerals of a radix
+func (c *fpeCipher) encrypt(x []int, radix int) ([]int, error) {
+	if err := c.check(len(x), radix); err != nil {
+		return nil, err
+	}
+	if c.algorithm == fpeFF31 {
+		tl, tr := ff31Tweak(c.tweak)
+		return c.ff3(x, radix, tl, tr, false), nil
+	}
+	return c.ff1(x, radix, false), nil
+}
+
+// decrypt decrypts a string of numerals of a radix
+func (c *fpeCipher) decrypt(x []int, radix int) ([]int, error) {
+	if err := c.check(len(x), radix); err != nil {
+		return nil, err
+	}
+	if c.algorithm == fpeFF31 {
+		tl, tr := ff31Tweak(c.tweak)
+		return c.ff3(x, radix, tl, tr, true), nil
+	}
+	return c.ff1(x, radix, true), nil
+}
+
+// ff1 runs the ten Feistel rounds of FF1 (SP 800-38G algorithms 7 and 8)
+func (c *fpeCipher) ff1(x []int, radix int, decrypt bool) []int {
+
+	n, t := len(x), len(c.tweak)
+	u := n / 2
+	v := n - u
+	a, b := append([]int{}, x[:u]...), append([]int{}, x[u:]...)
+
+	// the bytes of a numeral string of length v and of the round output
+	bytesB := (new(big.Int).Sub(pow(radix, v), big.NewInt(1)).BitLen() + 7) / 8
+	d := 4*((bytesB+3)/4) + 4
+
+	p := make([]byte, aes.BlockSize)
+	copy(p, []byte{1, 2, 1, byte(radix >> 16), byte(radix >> 8), byte(radix), 10, byte(u)})
+	binary.BigEndian.PutUint32(p[8:], uint32(n))
+	binary.BigEndian.PutUint32(p[12:], uint32(t))
+	pad := ((-(t + bytesB + 1))%16 + 16) % 16
+
+	modU, modV := pow(radix, u), pow(radix, v)
+	for j := 0; j < 10; j++ {
+		i := j
+		if decrypt {
+			i = 9 - j
+		}
+		m, mod := u, modU
+		if i%2 == 1 {
+			m, mod = v, modV
+		}
+		// the round function of the half that is not changed
+		in := b
+		if decrypt {
+			in = a
+		}
+		q := append(append([]byte{}, c.tweak...), make([]byte, pad)...)
+		q = append(q, byte(i))
+		q = append(q, num(in, radix).FillBytes(make([]byte, bytesB))...)
+		y := new(big.Int).SetBytes(c.ff1Expand(append(append([]byte{}, p...), q...), d))
+
+		if decrypt {
+			y.Sub(num(b, radix), y)
+			a, b = str(y.Mod(y, mod), radix, m), a
+		} else {
+			y.Add(num(a, radix), y)
+			a, b = b, str(y.Mod(y, mod), radix, m)
+		}
+	}
+	return append(a, b...)
+}
+
+// ff1Expand returns d bytes from the CBC-MAC of a block aligned input
+// and its encryptions xored with counters
+func (c *fpeCipher) ff1Expand(in []byte, d int) []byte {
+	r := make([]byte, aes.BlockSize)
+	for i := 0; i < len(in); i += aes.BlockSize {
+		for k := range r {
+			r[k] ^= in[i+k]
+		}
+		c.block.Encrypt(r, r)
+	}
+	s := append([]byte{}, r...)
+	for j := 1; len(s) < d; j++ {
+		block := append([]byte{}, r...)
+		for k := 0; k < 8; k++ {
+			block[aes.BlockSize-1-k] ^= byte(uint64(j) >> (8 * k))
+		}
+		c.block.Encrypt(block, block)
+		s = append(s, block...)
+	}
+	return s[:d]
+}
+
+// ff31Tweak splits a 56 bit FF3-1 tweak into its left and right halves
+func ff31Tweak(t []byte) (tl, tr [4]byte) {
+	tl = [4]byte{t[0], t[1], t[2], t[3] & 0xf0}
+	tr = [4]byte{t[4], t[5], t[6], t[3] << 4}
+	return tl, tr
+}
+
+// ff3 runs the eight Feistel rounds of FF3 with the halves of a tweak
+// (SP 800-38G algorithms 9 and 10)
+func (c *fpeCipher) ff3(x []int, radix int, tl, tr [4]byte, decrypt bool) []int {
+
+	n := len(x)
+	u := (n + 1) / 2
+	v := n - u
+	a, b := append([]int{}, x[:u]...), append([]int{}, x[u:]...)
+
+	modU, modV := pow(radix, u), pow(radix, v)
+	for j := 0; j < 8; j++ {
+		i := j
+		if decrypt {
+			i = 7 - j
+		}
+		m, mod, w := u, modU, tr
+		if i%2 == 1 {
+			m, mod, w = v, modV, tl
+		}
+		in := b
+		if decrypt {
+			in = a
+		}
+		p := make([]byte, aes.BlockSize)
+		copy(p, w[:])
+		p[3] ^= byte(i)
+		num(reverseInts(in), radix).FillBytes(p[4:])
+		s := reverseBytes(p)
+		c.block.Encrypt(s, s)
+		y := new(big.Int).SetBytes(reverseBytes(s))
+
+		if decrypt {
+			y.Sub(num(reverseInts(b), radix), y)
+			a, b = reverseInts(str(y.Mod(y, mod), radix, m)), a
+		} else {
+			y.Add(num(reverseInts(a), radix), y)
+			a, b = b, reverseInts(str(y.Mod(y, mod), radix, m))
+		}
+	}
+	return append(a, b...)
+}
+
+// num returns the number of a string of numerals, most significant
+// first
+func num(x []int, radix int) *big.Int {
+	n, r := new(big.Int), big.NewInt(int64(radix))
+	for _, d := range x {
+		n.Mul(n, r).Add(n, big.NewInt(int64(d)))
+	}
+	return n
+}
+
+// str returns the string of m numerals of a number, most significant
+// first
+func str(n *big.Int, radix, m int) []int {
+	x := make([]int, m)
+	n, r, d := new(big.Int).Set(n), big.NewInt(int64(radix)), new(big.Int)
+	for i := m - 1; i >= 0; i-- {
+		n.DivMod(n, r, d)
+		x[i] = int(d.Int64())
+	}
+	return x
+}
+
+// pow returns radix^m
+func pow(radix, m int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(int64(radix)), big.NewInt(int64(m)), nil)
+}
+
+// reverseInts returns a reversed copy of a string of numerals
+func reverseInts(x []int) []int {
+	r := make([]int, len(x))
+	for i, d := range x {
+		r[len(x)-1-i] = d
+	}
+	return r
+}
+
+// reverseBytes returns a reversed copy of a byte slice
+func reverseBytes(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i, c := range b {
+		r[len(b)-1-i] = c
+	}
+	return r
+}
+
+// fpeCount returns the number of characters of a value in an alphabet
+func fpeCount(v, alphabet string) int {
+	n := 0
+	for i := 0; i < len(v); i++ {
+		if strings.IndexByte(alphabet, v[i]) >= 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// fpeTransform encrypts or decrypts the characters of a value in an
+// alphabet, keeping the other characters in place
+func fpeTransform(v, alphabet string, crypt func([]int, int) ([]int, error)) (string, error) {
+	b := []byte(v)
+	var positions, x []int
+	for i, c := range b {
+		if d := strings.IndexByte(alphabet, c); d >= 0 {
+			positions = append(positions, i)
+			x = append(x, d)
+		}
+	}
+	if len(x) == 0 {
+		return v, nil
+	}
+	y, err := crypt(x, len(alphabet))
+	if err != nil {
+		return "", err
+	}
+	for i, p := range positions {
+		b[p] = alphabet[y[i]]
+	}
+	return string(b), nil
+}
+
+// EncryptFilter replaces columns with their format preserving
+// encryption
+type EncryptFilter struct {
+	filterName
+	Columns    []string
+	alphabets  []string // the alphabet of each column
+	cipher     *fpeCipher
+	short      string      // the treatment of values too short to be encrypted
+	hash       *HashFilter // the hash of short values
+	whereTrue  map[string]string
+	whereFalse map[string]string
+}
+
+// NewEncryptFilter makes a new ff1 encrypt or ff3-1 encrypt filter with
+// a hexadecimal key and an alphabet for each column, or digits for every
+// column if no alphabets are given, and a treatment of short values,
+// keeping them if it is empty
+func NewEncryptFilter(name string, columns, alphabets []string, key []byte, short string, whereTrue, whereFalse map[string]string) (*EncryptFilter, error) {
+
+	f := EncryptFilter{
+		filterName: filterName(name),
+		Columns:    columns,
+		short:      short,
+		whereTrue:  whereTrue,
+		whereFalse: whereFalse,
+	}
+
+	if len(columns) == 0 {
+		return &f, fmt.Errorf("%s: at least one column must be specified", name)
+	}
+	if len(alphabets) == 0 {
+		alphabets = make([]string, len(columns))
+		for i := range alphabets {
+			alphabets[i] = fpeDefaultAlphabet
+		}
+	}
+	if len(alphabets) != len(columns) {
+		return &f, fmt.Errorf("%s: column length != alphabet length", name)
+	}
+	for _, a := range alphabets {
+		chars, ok := fpeAlphabets[a]
+		if !ok {
+			return &f, fmt.Errorf("%s: unknown alphabet %q", name, a)
+		}
+		f.alphabets = append(f.alphabets, chars)
+	}
+
+	k, err := fpeKey(key)
+	if err != nil {
+		return &f, fmt.Errorf("%s: %w", name, err)
+	}
+	f.cipher, err = newFPECipher(strings.TrimSuffix(name, " encrypt"), k, nil)
+	if err != nil {
+		return &f, fmt.Errorf("%s: %w", name, err)
+	}
+
+	switch short {
+	case fpeShortKeep, fpeShortFail:
+	case "", fpeShortHash:
+		f.short = fpeShortHash
+		if f.hash, err = NewHashFilter(columns, nil, k, hashIntegerMin, hashIntegerMax, nil, nil); err != nil {
+			return &f, fmt.Errorf("%s: %w", name, err)
+		}
+	default:
+		return &f, fmt.Errorf("%s: unknown short value treatment %q", name, short)
+	}
+	return &f, nil
+}
+
+// Filter replaces the columns of a row with their encryption
+func (f *EncryptFilter) Filter(r Row) (Row, error) {
+
+	// if there is no line number the previous filter may have stopped
+	// processing
+	if r.lineNo == 0 {
+		return r, nil
+	}
+
+	// if no match for whereTrue conditions, return
+	if len(f.whereTrue) > 0 && r.match(f.FilterName(), f.whereTrue) != true {
+		return r, nil
+	}
+	// if match for whereFalse conditions, return
+	if len(f.whereFalse) > 0 && r.match(f.FilterName(), f.whereFalse) == true {
+		return r, nil
+	}
+
+	changed := 0
+	for i, rc := range r.ColumnNames() {
+		for j, cn := range f.Columns {
+			if rc != cn {
+				continue
+			}
+			changed++
+			if r.Columns[i] != nullValue {
+				if n := fpeCount(r.Columns[i], f.alphabets[j]); n > 0 && n < fpeMinLength(len(f.alphabets[j])) && f.short != fpeShortFail {
+					if f.short == fpeShortHash {
+						r.Columns[i] = f.hashShort(r.Columns[i], f.alphabets[j])
+					}
+					break
+				}
+				v, err := fpeTransform(r.Columns[i], f.alphabets[j], f.cipher.encrypt)
+				if err != nil {
+					return r, fmt.Errorf("%s: column %s could not be encrypted: %w", f.FilterName(), cn, err)
+				}
+				r.Columns[i] = v
+			}
+			break
+		}
+	}
+
+	if changed != len(f.Columns) {
+		return r, fmt.Errorf("%s: could not find all columns in EncryptFilter", f.FilterName())
+	}
+
+	return r, nil
+}
+
+// hashShort replaces the characters of a short value in an alphabet
+// with characters of the same kind chosen by its keyed hash, keeping
+// the other characters in place
+func (f *EncryptFilter) hashShort(v, alphabet string) string {
+	b := []byte(v)
+	var positions []int
+	var chars []byte
+	for i, c := range b {
+		if strings.IndexByte(alphabet, c) >= 0 {
+			positions = append(positions, i)
+			chars = append(chars, c)
+		}
+	}
+	hashed := f.hash.alphanumericFrom(string(chars), v)
+	for i, p := range positions {
+		b[p] = hashed[i]
+	}
+	return string(b)
+}
+
+// usedColumns returns the replaced and condition columns
+func (f *EncryptFilter) usedColumns() []string {
+	return whereColumns(f.Columns, f.whereTrue, f.whereFalse)
+}
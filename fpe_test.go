@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// numerals returns the numerals of a string in an alphabet
+func numerals(s, alphabet string) []int {
+	x := make([]int, len(s))
+	for i := range s {
+		x[i] = strings.IndexByte(alphabet, s[i])
+	}
+	return x
+}
+
+// numeralString returns the string of numerals in an alphabet
+func numeralString(x []int, alphabet string) string {
+	b := make([]byte, len(x))
+	for i, d := range x {
+		b[i] = alphabet[d]
+	}
+	return string(b)
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestFF1 checks the FF1 samples of NIST
+func TestFF1(t *testing.T) {
+
+	base36 := "0123456789abcdefghijklmnopqrstuvwxyz"
+	tests := []struct {
+		key, tweak string
+		radix      int
+		plain      string
+		cipher     string
+	}{
+		{"2B7E151628AED2A6ABF7158809CF4F3C", "", 10, "0123456789", "2433477484"},
+		{"2B7E151628AED2A6ABF7158809CF4F3C", "39383736353433323130", 10, "0123456789", "6124200773"},
+		{"2B7E151628AED2A6ABF7158809CF4F3C", "3737373770717273373737", 36, "0123456789abcdefghi", "a9tv40mll9kdu509eum"},
+		{"2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F", "", 10, "0123456789", "2830668132"},
+		{"2B7E151628AED2A6ABF7158809CF4F3CEF4359D8D580AA4F7F036D6F04FC6A94", "", 10, "0123456789", "6657667009"},
+	}
+	for i, tt := range tests {
+		c, err := newFPECipher(fpeFF1, mustHex(t, tt.key), mustHex(t, tt.tweak))
+		if err != nil {
+			t.Fatal(err)
+		}
+		alphabet := base36[:tt.radix]
+		x, err := c.encrypt(numerals(tt.plain, alphabet), tt.radix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := numeralString(x, alphabet); got != tt.cipher {
+			t.Errorf("test %d: encrypted %s, expected %s", i, got, tt.cipher)
+		}
+		x, err = c.decrypt(x, tt.radix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := numeralString(x, alphabet); got != tt.plain {
+			t.Errorf("test %d: decrypted %s, expected %s", i, got, tt.plain)
+		}
+	}
+}
+
+// TestFF3 checks the FF3 samples of NIST, which use the rounds of FF3-1
+// with a 64 bit tweak
+func TestFF3(t *testing.T) {
+
+	digits := fpeAlphabets["digits"]
+	tests := []struct {
+		tweak  string
+		plain  string
+		cipher string
+	}{
+		{"D8E7920AFA330A73", "890121234567890000", "750918814058654607"},
+		{"9A768A92F60E12D8", "890121234567890000", "018989839189395384"},
+		{"D8E7920AFA330A73", "89012123456789000000789000000", "48598367162252569629397416226"},
+	}
+	for i, tt := range tests {
+		c, err := newFPECipher(fpeFF31, mustHex(t, "EF4359D8D580AA4F7F036D6F04FC6A94"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tweak := mustHex(t, tt.tweak)
+		var tl, tr [4]byte
+		copy(tl[:], tweak[:4])
+		copy(tr[:], tweak[4:])
+		x := c.ff3(numerals(tt.plain, digits), 10, tl, tr, false)
+		if got := numeralString(x, digits); got != tt.cipher {
+			t.Errorf("test %d: encrypted %s, expected %s", i, got, tt.cipher)
+		}
+		x = c.ff3(x, 10, tl, tr, true)
+		if got := numeralString(x, digits); got != tt.plain {
+			t.Errorf("test %d: decrypted %s, expected %s", i, got, tt.plain)
+		}
+	}
+}
+
+func TestFF31Tweak(t *testing.T) {
+	tl, tr := ff31Tweak(mustHex(t, "D8E7920AFA330A"))
+	if hex.EncodeToString(tl[:]) != "d8e79200" || hex.EncodeToString(tr[:]) != "fa330aa0" {
+		t.Errorf("unexpected tweak halves %x %x", tl, tr)
+	}
+}
+
+func TestFPELengths(t *testing.T) {
+
+	key := mustHex(t, "2B7E151628AED2A6ABF7158809CF4F3C")
+	tests := []struct {
+		algorithm string
+		radix     int
+		n         int
+		ok        bool
+	}{
+		{fpeFF1, 10, 5, false},
+		{fpeFF1, 10, 6, true},
+		{fpeFF1, 26, 4, false},
+		{fpeFF1, 26, 5, true},
+		{fpeFF1, 62, 4, true},
+		{fpeFF1, 10, 200, true},
+		{fpeFF31, 10, 56, true},
+		{fpeFF31, 10, 57, false},
+		{fpeFF31, 62, 32, true},
+		{fpeFF31, 62, 33, false},
+	}
+	for _, tt := range tests {
+		c, err := newFPECipher(tt.algorithm, key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x := make([]int, tt.n)
+		for i := range x {
+			x[i] = i % tt.radix
+		}
+		y, err := c.encrypt(x, tt.radix)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s radix %d length %d: unexpected error %v", tt.algorithm, tt.radix, tt.n, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		z, err := c.decrypt(y, tt.radix)
+		if err != nil || numeralString(z, base62) != numeralString(x, base62) {
+			t.Errorf("%s radix %d length %d: did not decrypt %v", tt.algorithm, tt.radix, tt.n, err)
+		}
+	}
+}
+
+var base62 = fpeAlphabets["alphanumeric"]
+
+const fpeTestKey = "2b7e151628aed2a6abf7158809cf4f3c"
+
+func TestEncryptFilter(t *testing.T) {
+
+	for _, name := range []string{"ff1 encrypt", "ff3-1 encrypt"} {
+		columns := []string{"account", "phone", "code", "ref", "notes"}
+		alphabets := []string{"digits", "digits", "upper", "alphanumeric", "lower"}
+		filter, err := NewEncryptFilter(name, columns, alphabets, []byte(fpeTestKey), "", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := []string{"12345678", "020-7946-0018", "ABCDE-99", "Ab3-x9Z", nullValue}
+		r, err := filter.Filter(hashRow(columns, append([]string{}, values...)...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		patterns := []string{
+			`^[0-9]{8}$`,
+			`^[0-9]{3}-[0-9]{4}-[0-9]{4}$`,
+			`^[A-Z]{5}-99$`,
+			`^[0-9A-Za-z]{3}-[0-9A-Za-z]{3}$`,
+		}
+		for i, p := range patterns {
+			if !regexp.MustCompile(p).MatchString(r.Columns[i]) || r.Columns[i] == values[i] {
+				t.Errorf("%s: %q does not match %s", name, r.Columns[i], p)
+			}
+		}
+		if r.Columns[4] != nullValue {
+			t.Errorf("%s: NULL replaced with %q", name, r.Columns[4])
+		}
+
+		// the values are recovered by decryption
+		for i := range patterns {
+			got, err := fpeTransform(r.Columns[i], filter.alphabets[i], filter.cipher.decrypt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != values[i] {
+				t.Errorf("%s: decrypted %q, expected %q", name, got, values[i])
+			}
+		}
+	}
+}
+
+func TestEncryptFilterFail(t *testing.T) {
+
+	columns := []string{"account"}
+	tests := []struct {
+		name      string
+		alphabets []string
+		key       string
+	}{
+		{"ff1 encrypt", []string{"hex"}, fpeTestKey},
+		{"ff1 encrypt", []string{"digits", "digits"}, fpeTestKey},
+		{"ff1 encrypt", nil, "secret"},
+		{"ff1 encrypt", nil, fpeTestKey[:30]},
+		{"ff2 encrypt", nil, fpeTestKey},
+	}
+	for i, tt := range tests {
+		if _, err := NewEncryptFilter(tt.name, columns, tt.alphabets, []byte(tt.key), "", nil, nil); err == nil {
+			t.Errorf("test %d: expected an error", i)
+		}
+	}
+
+	if _, err := NewEncryptFilter("ff1 encrypt", columns, nil, []byte(fpeTestKey), "drop", nil, nil); err == nil {
+		t.Error("expected an unknown short value treatment error")
+	}
+
+	filter, err := NewEncryptFilter("ff1 encrypt", columns, nil, []byte(fpeTestKey), "fail", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := filter.Filter(hashRow(columns, "12-34")); err == nil || !strings.Contains(err.Error(), "column account") {
+		t.Errorf("expected a short value error, got %v", err)
+	}
+	r, err := filter.Filter(hashRow(columns, "none"))
+	if err != nil || r.Columns[0] != "none" {
+		t.Errorf("a value without digits was replaced with %q, %v", r.Columns[0], err)
+	}
+}
+
+func TestEncryptFilterShort(t *testing.T) {
+
+	columns := []string{"extension", "code"}
+	alphabets := []string{"digits", "upper"}
+	values := []string{"12-34", "AB-1"}
+
+	// short values are not written unchanged by default
+	filter, err := NewEncryptFilter("ff1 encrypt", columns, alphabets, []byte(fpeTestKey), "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := filter.Filter(hashRow(columns, append([]string{}, values...)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Columns[0] == values[0] || r.Columns[1] == values[1] {
+		t.Errorf("short values written unchanged as %q", r.Columns)
+	}
+
+	// but are kept if asked for
+	filter, err = NewEncryptFilter("ff1 encrypt", columns, alphabets, []byte(fpeTestKey), "keep", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err = filter.Filter(hashRow(columns, append([]string{}, values...)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Columns[0] != values[0] || r.Columns[1] != values[1] {
+		t.Errorf("short values replaced with %q", r.Columns)
+	}
+
+	// and replaced with a keyed hash of the same form
+	hashed := func(key string) []string {
+		filter, err := NewEncryptFilter("ff3-1 encrypt", columns, alphabets, []byte(key), "hash", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := filter.Filter(hashRow(columns, append([]string{}, values...)...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.Columns
+	}
+	got := hashed(fpeTestKey)
+	if !regexp.MustCompile(`^[0-9]{2}-[0-9]{2}$`).MatchString(got[0]) || !regexp.MustCompile(`^[A-Z]{2}-1$`).MatchString(got[1]) {
+		t.Errorf("short values hashed to %q", got)
+	}
+	if got[0] == values[0] && got[1] == values[1] {
+		t.Errorf("short values not replaced")
+	}
+	if again := hashed(fpeTestKey); strings.Join(again, ",") != strings.Join(got, ",") {
+		t.Errorf("short values hashed to %q and %q with the same key", got, again)
+	}
+	if other := hashed(strings.Repeat("0", 32)); strings.Join(other, ",") == strings.Join(got, ",") {
+		t.Errorf("short values hashed to %q with different keys", got)
+	}
+}
+
+func TestLoadEncryptFilter(t *testing.T) {
+
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte(fpeTestKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := LoadToml(`
+[["public.accounts"]]
+filter = "ff3-1 encrypt"
+columns = ["number", "sortcode"]
+optargs = {"key" = ["file", "` + keyFile + `"], "short" = ["hash", ""]}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf, err := loadFilters(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters := tf.getTableFilters("public.accounts")
+	if len(filters) != 1 || filters[0].FilterName() != "ff3-1 encrypt" || filters[0].(*EncryptFilter).short != fpeShortHash {
+		t.Errorf("unexpected filters %v", filters)
+	}
+
+	delete(settings["public.accounts"][0].OptArgs, "key")
+	if _, err := loadFilters(settings); err == nil {
+		t.Error("expected a missing key error")
+	}
+}
@@ -6,6 +6,17 @@ require (
 	github.com/BurntSushi/toml v1.1.0
 	github.com/google/uuid v1.3.0
 	github.com/jessevdk/go-flags v1.5.0
+	github.com/klauspost/compress v1.15.15
+	github.com/lib/pq v1.10.9
+	github.com/pierrec/lz4/v4 v4.1.21
+	github.com/xitongsys/parquet-go v1.6.2
+	golang.org/x/text v0.3.8
 )
 
-require golang.org/x/sys v0.0.0-20220704084225-05e143d24a9e // indirect
+require (
+	github.com/apache/arrow/go/arrow v0.0.0-20200730104253-651201b0f516 // indirect
+	github.com/apache/thrift v0.14.2 // indirect
+	github.com/golang/snappy v0.0.3 // indirect
+	golang.org/x/sys v0.0.0-20220722155257-8c9f86f7a55f // indirect
+	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543 // indirect
+)
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// The hash filter replaces the values of columns with values derived
+// from an HMAC-SHA256 of the original value using a secret key, so that
+// equal values are replaced with equal values in every table and on
+// every run with the same key, keeping joins between tables and
+// systems, while the original values cannot be recovered without the
+// key. The key is read from an environment variable or a file given by
+// the "key" option, such as optargs = {"key" = ["env", "ANON_KEY"]}.
+// The replacements of the filter give the format of each column:
+//
+//     hex          the 64 hexadecimal digits of the HMAC
+//     base32       the HMAC in unpadded base32
+//     uuid         a version 8 uuid
+//     integer      an integer in the range of the "range" option,
+//                  1 to 2147483647 by default
+//     alphanumeric the original value with each digit, upper and lower
+//                  case letter replaced by one of the same kind
+//
+// NULLs are not replaced.
+
+// hash formats
+const (
+	hashHex          = "hex"
+	hashBase32       = "base32"
+	hashUUID         = "uuid"
+	hashInteger      = "integer"
+	hashAlphanumeric = "alphanumeric"
+)
+
+// the default range of the integer format, that of positive postgresql
+// integers
+const (
+	hashIntegerMin = 1
+	hashIntegerMax = math.MaxInt32
+)
+
+// hashAlphabets are the alphabets of the alphanumeric format
+var hashAlphabets = struct{ digits, upper, lower string }{
+	"0123456789",
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"abcdefghijklmnopqrstuvwxyz",
+}
+
+// HashFilter replaces columns with values derived from a keyed hash of
+// their original values
+type HashFilter struct {
+	filterName
+	Columns    []string
+	formats    []string // the format of each column
+	key        []byte
+	min, max   int64 // the range of the integer format
+	whereTrue  map[string]string
+	whereFalse map[string]string
+}
+
+// NewHashFilter makes a new HashFilter with a format for each column,
+// or hex for every column if no formats are given
+func NewHashFilter(columns, formats []string, key []byte, min, max int64, whereTrue, whereFalse map[string]string) (*HashFilter, error) {
+
+	f := HashFilter{
+		filterName: "hash",
+		Columns:    columns,
+		formats:    formats,
+		key:        key,
+		min:        min,
+		max:        max,
+		whereTrue:  whereTrue,
+		whereFalse: whereFalse,
+	}
+
+	if len(columns) == 0 {
+		return &f, errors.New("hash: at least one column must be specified")
+	}
+	if len(key) == 0 {
+		return &f, errors.New("hash: a key must be provided")
+	}
+	if len(formats) == 0 {
+		f.formats = make([]string, len(columns))
+		for i := range f.formats {
+			f.formats[i] = hashHex
+		}
+	}
+	if len(f.formats) != len(columns) {
+		return &f, errors.New("hash: column length != format length")
+	}
+	for _, format := range f.formats {
+		switch format {
+		case hashHex, hashBase32, hashUUID, hashInteger, hashAlphanumeric:
+		default:
+			return &f, fmt.Errorf("hash: unknown format %q", format)
+		}
+	}
+	if min > max {
+		return &f, fmt.Errorf("hash: invalid integer range %d to %d", min, max)
+	}
+	return &f, nil
+}
+
+// Filter replaces the columns of a row with their hashes
+func (f *HashFilter) Filter(r Row) (Row, error) {
+
+	// if there is no line number the previous filter may have stopped
+	// processing
+	if r.lineNo == 0 {
+		return r, nil
+	}
+
+	// if no match for whereTrue conditions, return
+	if len(f.whereTrue) > 0 && r.match(f.FilterName(), f.whereTrue) != true {
+		return r, nil
+	}
+	// if match for whereFalse conditions, return
+	if len(f.whereFalse) > 0 && r.match(f.FilterName(), f.whereFalse) == true {
+		return r, nil
+	}
+
+	changed := 0
+	for i, rc := range r.ColumnNames() {
+		for j, cn := range f.Columns {
+			if rc == cn {
+				changed++
+				if r.Columns[i] != nullValue {
+					r.Columns[i] = f.hash(r.Columns[i], f.formats[j])
+				}
+				break
+			}
+		}
+	}
+
+	if changed != len(f.Columns) {
+		return r, errors.New("hash: could not find all columns in HashFilter")
+	}
+
+	return r, nil
+}
+
+// usedColumns returns the replaced and condition columns
+func (f *HashFilter) usedColumns() []string {
+	return whereColumns(f.Columns, f.whereTrue, f.whereFalse)
+}
+
+// mac returns the HMAC of a value, or for a counter greater than zero
+// that of the value followed by the counter, giving further blocks of
+// output for long values
+func (f *HashFilter) mac(v string, counter uint32) []byte {
+	h := hmac.New(sha256.New, f.key)
+	h.Write([]byte(v))
+	if counter > 0 {
+		var c [4]byte
+		binary.BigEndian.PutUint32(c[:], counter)
+		h.Write(c[:])
+	}
+	return h.Sum(nil)
+}
+
+// hash returns the hash of a value in a format
+func (f *HashFilter) hash(v, format string) string {
+
+	mac := f.mac(v, 0)
+	switch format {
+	case hashBase32:
+		return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(mac)
+	case hashUUID:
+		var u uuid.UUID
+		copy(u[:], mac)
+		u[6] = u[6]&0x0f | 0x80 // version 8
+		u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+		return u.String()
+	case hashInteger:
+		n := binary.BigEndian.Uint64(mac)
+		if span := uint64(f.max-f.min) + 1; span != 0 {
+			n %= span
+		}
+		return strconv.FormatInt(f.min+int64(n), 10)
+	case hashAlphanumeric:
+		return f.alphanumeric(v)
+	}
+	return hex.EncodeToString(mac)
+}
+
+// alphanumeric replaces each digit, upper and lower case letter of a
+// value with one of the same kind chosen by the value's HMAC stream
+func (f *HashFilter) alphanumeric(v string) string {
+	return f.alphanumericFrom(v, v)
+}
+
+// alphanumericFrom replaces each digit, upper and lower case letter of
+// a value with one of the same kind chosen by the HMAC stream of an
+// input
+func (f *HashFilter) alphanumericFrom(v, input string) string {
+
+	var counter uint32
+	stream := f.mac(input, counter)
+	next := func(n int) byte {
+		// reject the bytes which would bias the choice
+		limit := byte(256 - 256%n)
+		for {
+			if len(stream) == 0 {
+				counter++
+				stream = f.mac(input, counter)
+			}
+			b := stream[0]
+			stream = stream[1:]
+			if b < limit {
+				return b % byte(n)
+			}
+		}
+	}
+
+	var b strings.Builder
+	for _, r := range v {
+		alphabet := ""
+		switch {
+		case unicode.IsDigit(r):
+			alphabet = hashAlphabets.digits
+		case unicode.IsUpper(r):
+			alphabet = hashAlphabets.upper
+		case unicode.IsLower(r):
+			alphabet = hashAlphabets.lower
+		}
+		if alphabet == "" {
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteByte(alphabet[next(len(alphabet))])
+	}
+	return b.String()
+}
+
+// filterKey reads the secret key of a filter from the environment
+// variable or file of its "key" option, such as ["env", "ANON_KEY"] or
+// ["file", "/run/secrets/anon_key"]
+func filterKey(f Filter) ([]byte, error) {
+	opt, ok := f.OptArgs["key"]
+	if !ok {
+		return nil, errors.New(`a key must be provided with optargs key = ["env", name] or ["file", path]`)
+	}
+	return readKey(opt[0], opt[1])
+}
+
+// readKey reads a secret key from an environment variable or a file,
+// ignoring the trailing newline of a key file
+func readKey(source, name string) ([]byte, error) {
+	var key string
+	switch source {
+	case "env":
+		key = os.Getenv(name)
+		if key == "" {
+			return nil, fmt.Errorf("the key environment variable %s is not set", name)
+		}
+	case "file":
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("could not read key file: %w", err)
+		}
+		key = strings.TrimRight(string(b), "\r\n")
+		if key == "" {
+			return nil, fmt.Errorf("the key file %s is empty", name)
+		}
+	default:
+		return nil, fmt.Errorf(`unknown key source %q, expected "env" or "file"`, source)
+	}
+	return []byte(key), nil
+}
+
+// hashRange returns the integer range of the "range" option of a hash
+// filter, if given, or the default range
+func hashRange(f Filter) (int64, int64, error) {
+	opt, ok := f.OptArgs["range"]
+	if !ok {
+		return hashIntegerMin, hashIntegerMax, nil
+	}
+	min, err := strconv.ParseInt(opt[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range minimum %q", opt[0])
+	}
+	max, err := strconv.ParseInt(opt[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range maximum %q", opt[1])
+	}
+	return min, max, nil
+}
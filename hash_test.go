@@ -0,0 +1,165 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// hashRow returns a row of a table of the given columns
+func hashRow(columns []string, values ...string) Row {
+	dt := &DumpTable{TableName: "public.customers", columnNames: columns, initialised: true}
+	return Row{DumpTabler: dt, Columns: values, lineNo: 1}
+}
+
+func TestHashFilter(t *testing.T) {
+
+	columns := []string{"a", "b", "c", "d", "e", "f"}
+	formats := []string{"hex", "base32", "uuid", "integer", "alphanumeric", "hex"}
+	filter, err := NewHashFilter(columns, formats, []byte("secret"), 100, 199, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := []string{"ann@example.com", "ann@example.com", "ann@example.com", "ann@example.com", "AB-12cd é", nullValue}
+	r, err := filter.Filter(hashRow(columns, append([]string{}, values...)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patterns := []string{
+		`^[0-9a-f]{64}$`,
+		`^[A-Z2-7]{52}$`,
+		`^[0-9a-f]{8}-[0-9a-f]{4}-8[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`,
+		`^1[0-9]{2}$`,
+		`^[A-Z]{2}-[0-9]{2}[a-z]{2} [a-z]$`,
+	}
+	for i, p := range patterns {
+		if !regexp.MustCompile(p).MatchString(r.Columns[i]) {
+			t.Errorf("%s: %q does not match %s", formats[i], r.Columns[i], p)
+		}
+		if r.Columns[i] == values[i] {
+			t.Errorf("%s: value was not replaced", formats[i])
+		}
+	}
+	if r.Columns[5] != nullValue {
+		t.Errorf("expected NULL to be kept, got %q", r.Columns[5])
+	}
+	// the HMAC-SHA256 of the value, as given by
+	// printf ann@example.com | openssl dgst -sha256 -hmac secret
+	if want := "beac5748fe881f214ea8b8f7b35dd2452f728e8939045499f8fbe659da95f40f"; r.Columns[0] != want {
+		t.Errorf("expected hex hash %s got %s", want, r.Columns[0])
+	}
+
+	// equal values give equal hashes in another filter with the same key,
+	// and different hashes with another key
+	other, _ := NewHashFilter([]string{"x"}, []string{"hex"}, []byte("secret"), 1, 2, nil, nil)
+	o, err := other.Filter(hashRow([]string{"x"}, "ann@example.com"))
+	if err != nil || o.Columns[0] != r.Columns[0] {
+		t.Errorf("expected the same hash %s, got %s %v", r.Columns[0], o.Columns[0], err)
+	}
+	other, _ = NewHashFilter([]string{"x"}, nil, []byte("other"), 1, 2, nil, nil)
+	o, _ = other.Filter(hashRow([]string{"x"}, "ann@example.com"))
+	if o.Columns[0] == r.Columns[0] {
+		t.Error("expected a different hash with a different key")
+	}
+}
+
+func TestHashFilterAlphanumeric(t *testing.T) {
+
+	filter, err := NewHashFilter([]string{"a"}, []string{"alphanumeric"}, []byte("secret"), 1, 2, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a value longer than a block of the HMAC
+	long := ""
+	for i := 0; i < 100; i++ {
+		long += strconv.Itoa(i % 10)
+	}
+	r, err := filter.Filter(hashRow([]string{"a"}, long))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[0-9]{100}$`).MatchString(r.Columns[0]) || r.Columns[0] == long {
+		t.Errorf("unexpected alphanumeric hash %s", r.Columns[0])
+	}
+	if r, _ := filter.Filter(hashRow([]string{"a"}, "")); r.Columns[0] != "" {
+		t.Errorf("expected an empty string to be kept, got %q", r.Columns[0])
+	}
+}
+
+func TestHashFilterFail(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		columns  []string
+		formats  []string
+		key      string
+		min, max int64
+	}{
+		{"no columns", nil, nil, "k", 1, 2},
+		{"no key", []string{"a"}, nil, "", 1, 2},
+		{"format length", []string{"a", "b"}, []string{"hex"}, "k", 1, 2},
+		{"unknown format", []string{"a"}, []string{"md5"}, "k", 1, 2},
+		{"range", []string{"a"}, []string{"integer"}, "k", 2, 1},
+	}
+	for _, tc := range tests {
+		if _, err := NewHashFilter(tc.columns, tc.formats, []byte(tc.key), tc.min, tc.max, nil, nil); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+
+	filter, _ := NewHashFilter([]string{"z"}, nil, []byte("k"), 1, 2, nil, nil)
+	if _, err := filter.Filter(hashRow([]string{"a"}, "1")); err == nil {
+		t.Error("expected an error for a missing column")
+	}
+}
+
+func TestLoadHashFilter(t *testing.T) {
+
+	t.Setenv("GOPG_ANONYMISE_TEST_KEY", "secret")
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		optArgs map[string][2]string
+		ok      bool
+	}{
+		{"environment key", map[string][2]string{"key": {"env", "GOPG_ANONYMISE_TEST_KEY"}, "range": {"1", "10"}}, true},
+		{"file key", map[string][2]string{"key": {"file", keyFile}}, true},
+		{"no key", nil, false},
+		{"unset key", map[string][2]string{"key": {"env", "GOPG_ANONYMISE_UNSET_KEY"}}, false},
+		{"missing key file", map[string][2]string{"key": {"file", keyFile + ".missing"}}, false},
+		{"key source", map[string][2]string{"key": {"value", "secret"}}, false},
+		{"range", map[string][2]string{"key": {"file", keyFile}, "range": {"1", "x"}}, false},
+	}
+	var hashes []string
+	for _, tc := range tests {
+		settings := Settings{"public.customers": []Filter{{
+			Filter:       "hash",
+			Columns:      []string{"id"},
+			Replacements: []string{"integer"},
+			OptArgs:      tc.optArgs,
+		}}}
+		tf, err := loadFilters(settings)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		r, err := tf.tableFilters["public.customers"][0].Filter(hashRow([]string{"id"}, "42"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, r.Columns[0])
+	}
+	if n, _ := strconv.Atoi(hashes[0]); n < 1 || n > 10 {
+		t.Errorf("expected a hash in the range 1 to 10, got %s", hashes[0])
+	}
+}
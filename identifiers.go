@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Identifiers such as schema, table and column names are held in two
+// forms. Column names are held unquoted, as the name itself. Table
+// names, which may be qualified by a schema name, are held in the form
+// written by pg_dump, in which each part is quoted only if it is not a
+// simple lower case identifier, such as
+//
+//     public."UserAccounts"
+//
+// Identifiers read from settings are converted to the same forms. The
+// tables of the databases of pg_dumpall dumps are further qualified by
+// the database name.
+//
+// Quoted identifiers follow the postgresql rules, in which a double
+// quote is escaped by doubling it. Unlike in postgresql, unquoted
+// identifiers in settings are not folded to lower case, so that
+// public.UserAccounts and public."UserAccounts" are the same table.
+
+// simpleIdentifier matches identifiers which pg_dump does not quote
+var simpleIdentifier = regexp.MustCompile(`^[a-z_][a-z0-9_$]*$`)
+
+// quoteIdentifier quotes an identifier unless it is a simple identifier
+func quoteIdentifier(name string) string {
+	if simpleIdentifier.MatchString(name) {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// qualifiedName returns the table name form of a schema and table
+func qualifiedName(schema, table string) string {
+	if schema == "" {
+		return quoteIdentifier(table)
+	}
+	return quoteIdentifier(schema) + "." + quoteIdentifier(table)
+}
+
+// tableName returns the table name form of the unquoted parts of a
+// table name, which may be qualified by a schema name
+func tableName(parts []string) string {
+	if len(parts) == 1 {
+		return qualifiedName("", parts[0])
+	}
+	return qualifiedName(parts[0], parts[1])
+}
+
+// scanIdentifier reads an identifier starting at position p of s,
+// returning the unquoted identifier and the position after it. Unquoted
+// identifiers end at a space, dot, comma or parenthesis
+func scanIdentifier(s string, p int) (string, int, error) {
+
+	if p >= len(s) {
+		return "", p, errors.New("identifier expected")
+	}
+
+	if s[p] != '"' {
+		i := p
+		for i < len(s) && !strings.ContainsRune(" .,()\t", rune(s[i])) {
+			i++
+		}
+		if i == p {
+			return "", p, fmt.Errorf("identifier expected at %q", s[p:])
+		}
+		return s[p:i], i, nil
+	}
+
+	var b strings.Builder
+	for i := p + 1; i < len(s); i++ {
+		if s[i] != '"' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '"' {
+			b.WriteByte('"')
+			i++
+			continue
+		}
+		if b.Len() == 0 {
+			return "", p, errors.New("zero-length quoted identifier")
+		}
+		return b.String(), i + 1, nil
+	}
+	return "", p, fmt.Errorf("unterminated quoted identifier %s", s[p:])
+}
+
+// scanQualifiedName reads a dot separated name, such as schema.table,
+// starting at position p of s, returning its unquoted parts and the
+// position after it
+func scanQualifiedName(s string, p int) ([]string, int, error) {
+	parts := []string{}
+	for {
+		part, next, err := scanIdentifier(s, p)
+		if err != nil {
+			return nil, p, err
+		}
+		parts = append(parts, part)
+		p = next
+		if p >= len(s) || s[p] != '.' {
+			return parts, p, nil
+		}
+		p++
+	}
+}
+
+// scanIdentifierList reads a parenthesised, comma separated list of
+// identifiers starting at position p of s, returning the unquoted
+// identifiers and the position after the closing parenthesis
+func scanIdentifierList(s string, p int) ([]string, int, error) {
+	if p >= len(s) || s[p] != '(' {
+		return nil, p, errors.New("( expected")
+	}
+	p++
+	names := []string{}
+	for {
+		p = skipSpace(s, p)
+		name, next, err := scanIdentifier(s, p)
+		if err != nil {
+			return nil, p, err
+		}
+		names = append(names, name)
+		p = skipSpace(s, next)
+		if p >= len(s) {
+			return nil, p, errors.New("unterminated identifier list")
+		}
+		switch s[p] {
+		case ',':
+			p++
+		case ')':
+			return names, p + 1, nil
+		default:
+			return nil, p, fmt.Errorf("unexpected %q in identifier list", s[p])
+		}
+	}
+}
+
+// parseIdentifier parses a single, possibly quoted, identifier, such as
+// a column name in settings, returning it unquoted
+func parseIdentifier(s string) (string, error) {
+	name, p, err := scanIdentifier(s, 0)
+	if err != nil {
+		return "", err
+	}
+	if p != len(s) {
+		return "", fmt.Errorf("invalid identifier %s", s)
+	}
+	return name, nil
+}
+
+// parseQualifiedName parses a dot separated name with between min and
+// max parts, returning its unquoted parts
+func parseQualifiedName(s string, min, max int) ([]string, error) {
+	parts, p, err := scanQualifiedName(s, 0)
+	if err != nil {
+		return nil, err
+	}
+	if p != len(s) {
+		return nil, fmt.Errorf("invalid name %s", s)
+	}
+	if len(parts) < min || len(parts) > max {
+		return nil, fmt.Errorf("name %s has %d parts, expected %d to %d", s, len(parts), min, max)
+	}
+	return parts, nil
+}
+
+// normaliseTableName returns the table name form of a table name, such
+// as public.UserAccounts or public."UserAccounts", which may be
+// qualified by a database name, such as mydb.public.users
+func normaliseTableName(s string) (string, error) {
+	parts, err := parseQualifiedName(s, 1, 3)
+	if err != nil {
+		return "", fmt.Errorf("table name error: %w", err)
+	}
+	if len(parts) == 3 {
+		return databaseTableName(parts[0], tableName(parts[1:])), nil
+	}
+	return tableName(parts), nil
+}
+
+// databaseTableName returns the name of a table in a database of a
+// pg_dumpall dump, such as mydb.public.users, from the unquoted
+// database name and the table name
+func databaseTableName(database, table string) string {
+	if database == "" {
+		return table
+	}
+	return quoteIdentifier(database) + "." + table
+}
+
+// splitDatabaseTableName splits a table name qualified by a database
+// name into the unquoted database name and the table name. Names which
+// are not qualified by a database name are returned with an empty
+// database name
+func splitDatabaseTableName(name string) (string, string) {
+	parts, err := parseQualifiedName(name, 3, 3)
+	if err != nil {
+		return "", name
+	}
+	return parts[0], tableName(parts[1:])
+}
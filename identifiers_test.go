@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseCopyHeader(t *testing.T) {
+
+	tests := []struct {
+		line    string
+		table   string
+		columns []string
+	}{
+		{
+			`COPY example_schema.events (id, flags, data) FROM stdin;`,
+			"example_schema.events",
+			[]string{"id", "flags", "data"},
+		},
+		{
+			`COPY public."UserAccounts" ("firstName", "e-mail, primary") FROM stdin;`,
+			`public."UserAccounts"`,
+			[]string{"firstName", "e-mail, primary"},
+		},
+		{
+			`COPY "my.schema"."say ""hi""" ("a)b", "") FROM stdin;`,
+			"", nil,
+		},
+		{
+			`COPY "my.schema"."say ""hi""" ("a)b", c) FROM stdin;`,
+			`"my.schema"."say ""hi"""`,
+			[]string{"a)b", "c"},
+		},
+		{
+			`COPY users (id) FROM stdin;`,
+			"users",
+			[]string{"id"},
+		},
+		{`COPY a.b.c (id) FROM stdin;`, "", nil},
+		{`COPY public."unterminated (id) FROM stdin;`, "", nil},
+		{`COPY public.users (id) TO stdout;`, "", nil},
+	}
+
+	for _, tc := range tests {
+		table, columns, err := parseCopyHeader(tc.line)
+		if tc.table == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.line, err)
+			continue
+		}
+		if table != tc.table || !equalColumns(columns, tc.columns) {
+			t.Errorf("%s: got %s %q", tc.line, table, columns)
+		}
+	}
+}
+
+func TestNormaliseTableName(t *testing.T) {
+
+	tests := map[string]string{
+		"public.users":            "public.users",
+		`public."users"`:          "public.users",
+		"public.UserAccounts":     `public."UserAccounts"`,
+		`public."UserAccounts"`:   `public."UserAccounts"`,
+		`"my.schema"."a ""b"""`:   `"my.schema"."a ""b"""`,
+		"users":                   "users",
+		"mydb.public.users":       "mydb.public.users",
+		`"My DB".public.Users`:    `"My DB".public."Users"`,
+		"mydb.public.users.id":    "",
+		`public."unterminated`:    "",
+		"public.":                 "",
+		"public.e-mail, primary)": "",
+	}
+	for name, want := range tests {
+		got, err := normaliseTableName(name)
+		if want == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", name, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("%s: expected %s got %s (%v)", name, want, got, err)
+		}
+	}
+}
+
+func TestAnonymiseQuotedIdentifiers(t *testing.T) {
+
+	dump := strings.Join([]string{
+		`CREATE TABLE public."UserAccounts" (`,
+		`    id integer NOT NULL,`,
+		`    "firstName" text,`,
+		`    "e-mail, primary" text`,
+		`);`,
+		``,
+		`COPY public."UserAccounts" (id, "firstName", "e-mail, primary") FROM stdin;`,
+		"1\tariadne\tariadne@example.com",
+		"2\tjames\tjames@example.com",
+		`\.`,
+		``,
+		`COPY "Audit"."Log" ("userId", "who") FROM stdin;`,
+		"1\tariadne",
+		"2\tjames",
+		`\.`,
+		``,
+		`INSERT INTO "Staff"."Members" ("Name") VALUES ('lucius');`,
+		``,
+	}, "\n")
+
+	settings := `
+[['public.UserAccounts']]
+filter = "string replace"
+columns = ['"firstName"', 'e-mail, primary']
+replacements = ["zachary", "z@example.com"]
+`
+	dumpFile := filepath.Join(t.TempDir(), "dump.sql")
+	if err := os.WriteFile(dumpFile, []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// unquoted identifiers with commas are rejected
+	args := anonArgs{dumpFilePath: dumpFile, settingsToml: settings, output: bytes.NewBuffer(nil)}
+	if err := Anonymise(args); err == nil {
+		t.Error("an unquoted column name with a comma should fail")
+	}
+
+	settings = strings.Replace(settings, `'e-mail, primary'`, `'"e-mail, primary"'`, 1) + `
+[['"Audit"."Log"']]
+filter = "reference replace"
+columns = ["who"]
+replacements = ['"firstName"']
+optargs = {"fklookup" = ['"userId"', 'public."UserAccounts".id']}
+
+[['Staff.Members']]
+filter = "string replace"
+columns = ["Name"]
+replacements = ["zachary"]
+`
+	buffer := bytes.NewBuffer(nil)
+	args = anonArgs{dumpFilePath: dumpFile, settingsToml: settings, output: buffer}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+	output := buffer.String()
+	for _, want := range []string{
+		"1\tzachary\tz@example.com\n",
+		"2\tzachary\tz@example.com\n",
+		"1\tzachary\n",
+		"2\tzachary\n",
+		`INSERT INTO "Staff"."Members" ("Name") VALUES ('zachary');`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output does not contain %q", want)
+		}
+	}
+}
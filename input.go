@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// stdinPath is the dump file path denoting standard input
+const stdinPath = "-"
+
+// isStdin reports if a dump file path denotes standard input
+func isStdin(path string) bool {
+	return path == "" || path == stdinPath
+}
+
+// spool is a reader which records what it reads from an underlying
+// reader to a temporary file, so that a stream such as standard input
+// can be read a second time.
+//
+// Since the reference table scan returns as soon as all the reference
+// tables have been read, only the start of the stream up to the end of
+// the last reference table is recorded; the replay reads the recording
+// and then the remainder of the stream.
+type spool struct {
+	r    io.Reader
+	file *os.File
+}
+
+// newSpool makes a new spool of r
+func newSpool(r io.Reader) (*spool, error) {
+	f, err := os.CreateTemp("", "gopg-anonymise-spool-")
+	if err != nil {
+		return nil, fmt.Errorf("could not make spool file: %w", err)
+	}
+	return &spool{r: r, file: f}, nil
+}
+
+// Read reads from the underlying reader, recording what is read
+func (s *spool) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		if _, werr := s.file.Write(p[:n]); werr != nil {
+			return n, fmt.Errorf("spool write error: %w", werr)
+		}
+	}
+	return n, err
+}
+
+// replay returns a reader of the recorded stream followed by the
+// remainder of the underlying reader
+func (s *spool) replay() (io.Reader, error) {
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("spool seek error: %w", err)
+	}
+	return io.MultiReader(s.file, s.r), nil
+}
+
+// Close closes and removes the spool file
+func (s *spool) Close() error {
+	err := s.file.Close()
+	if rerr := os.Remove(s.file.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// dumpInput provides the input for each scan of a dump file, which is
+// opened afresh for each scan, or of standard input, which is spooled
+// during the reference table scan for the standard scan
+type dumpInput struct {
+	path  string
+	stdin io.Reader
+	spool *spool
+	scans int // the number of scans of stdin
+}
+
+// newDumpInput makes a new dumpInput for a dump file path, reading
+// stdin if the path denotes standard input. If twoPass is true stdin
+// is spooled for a second scan
+func newDumpInput(path string, stdin io.Reader, twoPass bool) (*dumpInput, error) {
+	d := &dumpInput{path: path}
+	if !isStdin(path) {
+		return d, nil
+	}
+	d.stdin = stdin
+	if d.stdin == nil {
+		d.stdin = os.Stdin
+	}
+	if twoPass {
+		s, err := newSpool(d.stdin)
+		if err != nil {
+			return nil, err
+		}
+		d.spool = s
+	}
+	return d, nil
+}
+
+// isDir reports if the input is a directory format dump
+func (d *dumpInput) isDir() bool {
+	if d.stdin != nil {
+		return false
+	}
+	info, err := os.Stat(d.path)
+	return err == nil && info.IsDir()
+}
+
+// open returns the input for the next scan. For standard input the
+// first scan reads, and if spooling records, stdin, and the second scan
+// replays the recording followed by the remainder of stdin
+func (d *dumpInput) open() (io.ReadCloser, error) {
+
+	if d.stdin == nil {
+		f, err := os.Open(d.path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("could not open dumpfile at %s for reading: %w", d.path, err)
+		}
+		return f, err
+	}
+
+	d.scans++
+	switch {
+	case d.scans == 1 && d.spool != nil:
+		return io.NopCloser(d.spool), nil
+	case d.scans == 1:
+		return io.NopCloser(d.stdin), nil
+	case d.scans == 2 && d.spool != nil:
+		r, err := d.spool.replay()
+		return io.NopCloser(r), err
+	}
+	return nil, errors.New("standard input cannot be read again")
+}
+
+// Close removes any spool file
+func (d *dumpInput) Close() error {
+	if d.spool == nil {
+		return nil
+	}
+	return d.spool.Close()
+}
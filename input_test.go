@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+
+	s, err := newSpool(strings.NewReader("abcdefghij"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(s, b); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.replay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(all) != "abcdefghij" {
+		t.Errorf("replay should return the whole stream, got %q", all)
+	}
+	info, err := os.Stat(s.file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 4 {
+		t.Errorf("only the bytes read should be spooled, got %d", info.Size())
+	}
+
+	name := s.file.Name()
+	s.Close()
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("spool file %s not removed", name)
+	}
+}
+
+func TestDumpInputStdinOnce(t *testing.T) {
+
+	d, err := newDumpInput(stdinPath, strings.NewReader("x"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if d.isDir() {
+		t.Error("stdin should not be a directory")
+	}
+	if _, err := d.open(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.open(); err == nil {
+		t.Error("stdin without spooling should not be opened twice")
+	}
+}
+
+func TestAnonymiseStdin(t *testing.T) {
+
+	tomlString, err := os.ReadFile("testdata/settings.toml")
+	if err != nil {
+		t.Fatalf("could not read settings file: %s", err)
+	}
+	dump, err := os.ReadFile("testdata/pg_dump.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same output is expected for a file and for stdin, including
+	// the reference replacement of fkexample
+	for _, path := range []string{"", stdinPath} {
+		buffer := bytes.NewBuffer(nil)
+		args := anonArgs{
+			dumpFilePath: path,
+			input:        bytes.NewReader(dump),
+			settingsToml: string(tomlString),
+			output:       buffer,
+			changedOnly:  true,
+		}
+		if err := Anonymise(args); err != nil {
+			t.Fatalf("Anonymise should not fail: %s", err)
+		}
+		if !strings.Contains(buffer.String(), "3\t5\tvanessa\n") {
+			t.Errorf("fkexample not anonymised by reference, got %q", buffer.String())
+		}
+		if count := strings.Count(buffer.String(), "zachary"); count != 3 {
+			t.Errorf("count of zachary string not 3, got %d", count)
+		}
+	}
+
+	// compressed stdin
+	buffer := bytes.NewBuffer(nil)
+	args := anonArgs{
+		dumpFilePath: stdinPath,
+		input:        bytes.NewReader(compressBytes(t, streamZstd, dump)),
+		settingsToml: string(tomlString),
+		output:       buffer,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise of compressed stdin should not fail: %s", err)
+	}
+	if !strings.Contains(buffer.String(), "3\t5\tvanessa\n") {
+		t.Error("compressed stdin fkexample not anonymised by reference")
+	}
+	if !strings.HasSuffix(buffer.String(), "-- PostgreSQL database dump complete\n--\n\n") {
+		t.Errorf("output truncated: %q", buffer.String()[buffer.Len()-100:])
+	}
+}
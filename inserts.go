@@ -0,0 +1,450 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// stdStringsRegex matches the setting of standard_conforming_strings,
+// which determines if backslashes are escapes in quoted literals
+var stdStringsRegex = regexp.MustCompile(`^SET standard_conforming_strings = '?(on|off)'?;`)
+
+// bareValueRegex matches the unquoted numeric and boolean literals of
+// INSERT statements
+var bareValueRegex = regexp.MustCompile(`^(?:-?[0-9]+(?:\.[0-9]+)?(?:[eE][-+]?[0-9]+)?|true|false)$`)
+
+// isInsert reports if a line starts an INSERT statement
+func isInsert(line string) bool {
+	return strings.HasPrefix(line, "INSERT INTO ")
+}
+
+// parseInsertHeader parses the start of an INSERT statement as written
+// by pg_dump --inserts or --column-inserts, up to and including VALUES,
+// returning the table name, the unquoted column names if listed and
+// the position after VALUES
+func parseInsertHeader(line string) (string, []string, int, error) {
+
+	if !isInsert(line) {
+		return "", nil, 0, errors.New("INSERT INTO expected")
+	}
+	parts, p, err := scanQualifiedName(line, len("INSERT INTO "))
+	if err != nil || len(parts) > 2 {
+		return "", nil, 0, fmt.Errorf("could not parse insert statement table name %s", line)
+	}
+	name := tableName(parts)
+
+	var columns []string
+	if strings.HasPrefix(line[p:], " (") {
+		columns, p, err = scanIdentifierList(line, p+1)
+		if err != nil {
+			return "", nil, 0, fmt.Errorf("could not parse insert statement columns %s: %w", line, err)
+		}
+	}
+	if strings.HasPrefix(line[p:], " OVERRIDING SYSTEM VALUE") {
+		p += len(" OVERRIDING SYSTEM VALUE")
+	}
+	if !strings.HasPrefix(line[p:], " VALUES") {
+		return "", nil, 0, fmt.Errorf("could not parse insert statement %s", line)
+	}
+	return name, columns, p + len(" VALUES"), nil
+}
+
+// newInsertDumpTable initialises a dump table for the INSERT statements
+// of a table. The column names are those listed in the statement, as
+// written by pg_dump --column-inserts, or otherwise those of the table
+// definition, if any
+func newInsertDumpTable(name string, columns []string, td *tableDefinition, refContext bool, tf tableFilters) (*DumpTable, error) {
+
+	d := &DumpTable{TableName: name, columnNames: columns, insert: true}
+	if len(d.columnNames) == 0 && td != nil {
+		d.columnNames = td.columnNames
+	}
+	if err := d.init(refContext, tf); err != nil {
+		return d, err
+	}
+	if len(d.columnNames) == 0 {
+		d.initialised = false
+		return d, fmt.Errorf("no columns found for table %s: a CREATE TABLE statement or --column-inserts is required", name)
+	}
+	return d, nil
+}
+
+// insertBuffer accumulates the lines of an INSERT statement, which may
+// span lines if it has several rows or a quoted literal includes a
+// newline, until its terminating semicolon, which is outside of quoted
+// literals and identifiers
+type insertBuffer struct {
+	lines       []string
+	stdStrings  bool
+	quote       byte // the quote character of a quoted literal or identifier
+	escapes     bool // backslash escapes apply in the current literal
+	terminated  bool
+	prevLetterE bool
+}
+
+// newInsertBuffer makes a new insertBuffer
+func newInsertBuffer(stdStrings bool) *insertBuffer {
+	return &insertBuffer{stdStrings: stdStrings}
+}
+
+// add adds a line to the statement
+func (b *insertBuffer) add(t string) {
+	b.lines = append(b.lines, t)
+	for i := 0; i < len(t) && !b.terminated; i++ {
+		c := t[i]
+		switch {
+		case b.quote != 0 && b.escapes && c == '\\':
+			i++
+		case b.quote != 0 && c == b.quote:
+			b.quote = 0
+		case b.quote != 0:
+		case c == '\'' || c == '"':
+			b.quote = c
+			b.escapes = c == '\'' && (!b.stdStrings || b.prevLetterE)
+		case c == ';':
+			b.terminated = true
+		}
+		b.prevLetterE = b.quote == 0 && (c == 'E' || c == 'e')
+	}
+}
+
+// complete reports if the statement is complete
+func (b *insertBuffer) complete() bool {
+	return b.terminated
+}
+
+// String returns the statement
+func (b *insertBuffer) String() string {
+	return strings.Join(b.lines, "\n")
+}
+
+// insertValue is a literal value of a row of an INSERT statement
+type insertValue struct {
+	raw    string // the literal as written in the statement
+	prefix string // the prefix of a quoted literal, such as E or B
+	quoted bool
+	column string // the decoded value
+}
+
+// insertRow is a row of the VALUES list of an INSERT statement
+type insertRow struct {
+	lead   string // the text before the row, such as " " or "\n\t"
+	raw    string // the row as written in the statement
+	values []insertValue
+}
+
+// insertStatement is a parsed INSERT statement
+type insertStatement struct {
+	raw        string // the statement as written
+	prefix     string // the statement up to and including VALUES
+	rows       []insertRow
+	suffix     string // the text after the last row, normally ";"
+	stdStrings bool
+}
+
+// parseInsert parses an INSERT statement with one or more rows. The
+// values of each row are decoded as for the columns of COPY data rows,
+// so that NULL is nullValue. If stdStrings is false, backslashes are
+// escapes in all quoted literals, not only E-prefixed literals
+func parseInsert(stmt string, stdStrings bool) (*insertStatement, error) {
+
+	_, _, p, err := parseInsertHeader(stmt)
+	if err != nil {
+		return nil, err
+	}
+	s := &insertStatement{raw: stmt, prefix: stmt[:p], stdStrings: stdStrings}
+
+	for {
+		start := p
+		p = skipSpace(stmt, p)
+		if p >= len(stmt) || stmt[p] != '(' {
+			return nil, fmt.Errorf("expected ( at position %d", p)
+		}
+		row := insertRow{lead: stmt[start:p]}
+		rowStart := p
+		p++
+		for {
+			p = skipSpace(stmt, p)
+			v, next, err := parseInsertValue(stmt, p, stdStrings)
+			if err != nil {
+				return nil, err
+			}
+			row.values = append(row.values, v)
+			p = skipSpace(stmt, next)
+			if p >= len(stmt) {
+				return nil, errors.New("unterminated row")
+			}
+			if stmt[p] == ',' {
+				p++
+				continue
+			}
+			if stmt[p] == ')' {
+				p++
+				break
+			}
+			return nil, fmt.Errorf("unexpected %q at position %d", stmt[p], p)
+		}
+		row.raw = stmt[rowStart:p]
+		s.rows = append(s.rows, row)
+
+		if p < len(stmt) && stmt[p] == ',' {
+			p++
+			continue
+		}
+		s.suffix = stmt[p:]
+		if !strings.HasPrefix(strings.TrimSpace(s.suffix), ";") {
+			return nil, fmt.Errorf("unexpected %q after values", s.suffix)
+		}
+		return s, nil
+	}
+}
+
+// skipSpace returns the position of the first non-space character of s
+// from p
+func skipSpace(s string, p int) int {
+	for p < len(s) && (s[p] == ' ' || s[p] == '\t' || s[p] == '\n' || s[p] == '\r') {
+		p++
+	}
+	return p
+}
+
+// parseInsertValue parses the literal value at position p of s,
+// returning the value and the position after it
+func parseInsertValue(s string, p int, stdStrings bool) (insertValue, int, error) {
+
+	var v insertValue
+
+	// quoted literals, optionally prefixed as E'', B'' or X''
+	q := p
+	if q+1 < len(s) && strings.ContainsRune("EeBbXx", rune(s[q])) && s[q+1] == '\'' {
+		v.prefix = s[q : q+1]
+		q++
+	}
+	if q < len(s) && s[q] == '\'' {
+		v.quoted = true
+		escapes := !stdStrings || strings.EqualFold(v.prefix, "E")
+		i := q + 1
+		for ; i < len(s); i++ {
+			if escapes && s[i] == '\\' {
+				i++
+				continue
+			}
+			if s[i] == '\'' {
+				if i+1 < len(s) && s[i+1] == '\'' {
+					i++
+					continue
+				}
+				break
+			}
+		}
+		if i >= len(s) {
+			return v, p, errors.New("unterminated quoted literal")
+		}
+		v.raw = s[p : i+1]
+		contents := s[q+1 : i]
+		switch {
+		case strings.EqualFold(v.prefix, "B") || strings.EqualFold(v.prefix, "X"):
+			v.column = contents
+		default:
+			v.column = unquoteLiteral(contents, escapes)
+		}
+		return v, i + 1, nil
+	}
+
+	// unquoted literals such as numbers, booleans, NULL and DEFAULT
+	i := p
+	for i < len(s) && !strings.ContainsRune(",) \t\n\r", rune(s[i])) {
+		i++
+	}
+	if i == p {
+		return v, p, fmt.Errorf("expected a value at position %d", p)
+	}
+	v.raw = s[p:i]
+	v.column = v.raw
+	if strings.EqualFold(v.raw, "NULL") {
+		v.column = nullValue
+	}
+	return v, i, nil
+}
+
+// unquoteLiteral decodes the contents of a quoted literal, in which
+// quotes are doubled and, if escapes is true, backslash escapes are
+// used as in E-prefixed literals
+func unquoteLiteral(s string, escapes bool) string {
+
+	if !escapes {
+		return strings.ReplaceAll(s, "''", "'")
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\'' && i+1 < len(s) && s[i+1] == '\'' {
+			b.WriteByte('\'')
+			i++
+			continue
+		}
+		if c != '\\' || i == len(s)-1 {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = s[i]; c {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			j := i + 1
+			for j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7' {
+				j++
+			}
+			n, _ := strconv.ParseUint(s[i:j], 8, 8)
+			b.WriteByte(byte(n))
+			i = j - 1
+		case 'x':
+			j := i + 1
+			for j < len(s) && j < i+3 && isHexDigit(s[j]) {
+				j++
+			}
+			if j == i+1 {
+				b.WriteByte(c)
+				continue
+			}
+			n, _ := strconv.ParseUint(s[i+1:j], 16, 8)
+			b.WriteByte(byte(n))
+			i = j - 1
+		case 'u', 'U':
+			size := 4
+			if c == 'U' {
+				size = 8
+			}
+			if i+size >= len(s) {
+				b.WriteByte(c)
+				continue
+			}
+			n, err := strconv.ParseUint(s[i+1:i+1+size], 16, 32)
+			if err != nil {
+				b.WriteByte(c)
+				continue
+			}
+			b.WriteRune(rune(n))
+			i += size
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// columns returns the values of a row in COPY text format
+func (r insertRow) columns() []string {
+	columns := make([]string, len(r.values))
+	for i, v := range r.values {
+		columns[i] = v.column
+	}
+	return columns
+}
+
+// render re-serialises the statement with the filtered columns of each
+// row, in which a nil row has been deleted. Unchanged values, rows and
+// statements are written as they were read, and changed values are
+// written in the style of the original value. False is returned if all
+// rows have been deleted
+func (s *insertStatement) render(rows [][]string) (string, bool) {
+
+	changed := false
+	kept := 0
+	for i, r := range rows {
+		if r == nil {
+			changed = true
+			continue
+		}
+		kept++
+		if !changed && !equalColumns(r, s.rows[i].columns()) {
+			changed = true
+		}
+	}
+	if kept == 0 {
+		return "", false
+	}
+	if !changed {
+		return s.raw, true
+	}
+
+	var b strings.Builder
+	b.WriteString(s.prefix)
+	first := true
+	for i, r := range rows {
+		if r == nil {
+			continue
+		}
+		if !first {
+			b.WriteByte(',')
+		}
+		first = false
+		row := s.rows[i]
+		b.WriteString(row.lead)
+		if equalColumns(r, row.columns()) {
+			b.WriteString(row.raw)
+			continue
+		}
+		b.WriteByte('(')
+		for j, col := range r {
+			if j > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(formatInsertValue(col, row.values[j], s.stdStrings))
+		}
+		b.WriteByte(')')
+	}
+	b.WriteString(s.suffix)
+	return b.String(), true
+}
+
+// equalColumns reports if two rows of columns are equal
+func equalColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// formatInsertValue formats a decoded column value as a literal in the
+// style of the original literal
+func formatInsertValue(column string, orig insertValue, stdStrings bool) string {
+
+	if column == orig.column {
+		return orig.raw
+	}
+	if column == nullValue {
+		return "NULL"
+	}
+	if !orig.quoted && bareValueRegex.MatchString(column) {
+		return column
+	}
+	if strings.EqualFold(orig.prefix, "B") || strings.EqualFold(orig.prefix, "X") {
+		return orig.prefix + "'" + strings.ReplaceAll(column, "'", "''") + "'"
+	}
+
+	value := column
+	if strings.EqualFold(orig.prefix, "E") || (!stdStrings && strings.Contains(value, `\`)) {
+		value = strings.ReplaceAll(value, `\`, `\\`)
+		return "E'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
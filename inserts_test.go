@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseInsert(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		stmt       string
+		stdStrings bool
+		rows       [][]string
+	}{
+		{
+			name:       "single row",
+			stmt:       `INSERT INTO public.users VALUES (1, 'ariadne', NULL, true, -1.5e3);`,
+			stdStrings: true,
+			rows:       [][]string{{"1", "ariadne", nullValue, "true", "-1.5e3"}},
+		},
+		{
+			name:       "column list and multiple rows",
+			stmt:       "INSERT INTO public.users (id, name) VALUES\n\t(1, 'it''s'),\n\t(2, 'a, b)');",
+			stdStrings: true,
+			rows:       [][]string{{"1", "it's"}, {"2", "a, b)"}},
+		},
+		{
+			name:       "special characters",
+			stmt:       "INSERT INTO public.users VALUES (1, 'tab\there\nnewline', 'back\\slash');",
+			stdStrings: true,
+			rows:       [][]string{{"1", "tab\there\nnewline", `back\slash`}},
+		},
+		{
+			name:       "escape strings",
+			stmt:       `INSERT INTO public.users VALUES (1, E'tab\there \'q\' \\ \101 \x42 C');`,
+			stdStrings: true,
+			rows:       [][]string{{"1", "tab\there 'q' \\ A B C"}},
+		},
+		{
+			name:       "non-standard strings",
+			stmt:       `INSERT INTO public.users VALUES (1, 'a\'b\\c');`,
+			stdStrings: false,
+			rows:       [][]string{{"1", `a'b\c`}},
+		},
+		{
+			name:       "bit strings, default and overriding",
+			stmt:       `INSERT INTO public.users (id, bits, total) OVERRIDING SYSTEM VALUE VALUES (1, B'0101', DEFAULT);`,
+			stdStrings: true,
+			rows:       [][]string{{"1", "0101", "DEFAULT"}},
+		},
+	}
+
+	for _, tc := range tests {
+		s, err := parseInsert(tc.stmt, tc.stdStrings)
+		if err != nil {
+			t.Fatalf("%s: parse error %s", tc.name, err)
+		}
+		if len(s.rows) != len(tc.rows) {
+			t.Fatalf("%s: expected %d rows got %d", tc.name, len(tc.rows), len(s.rows))
+		}
+		for i, r := range s.rows {
+			if got := r.columns(); !equalColumns(got, tc.rows[i]) {
+				t.Errorf("%s: row %d expected %q got %q", tc.name, i, tc.rows[i], got)
+			}
+		}
+		// unchanged statements are written verbatim
+		rows := [][]string{}
+		for _, r := range s.rows {
+			rows = append(rows, r.columns())
+		}
+		if got, ok := s.render(rows); !ok || got != tc.stmt {
+			t.Errorf("%s: unchanged statement rendered as %q", tc.name, got)
+		}
+	}
+}
+
+func TestParseInsertFail(t *testing.T) {
+	for _, stmt := range []string{
+		`INSERT INTO public.users VALUES (1, 'unterminated);`,
+		`INSERT INTO public.users VALUES (1, 2`,
+		`INSERT INTO public.users VALUES 1, 2;`,
+		`INSERT INTO public.users VALUES (1, 2) RETURNING id;`,
+		`INSERT INTO public.users SELECT 1;`,
+	} {
+		if _, err := parseInsert(stmt, true); err == nil {
+			t.Errorf("%s: expected a parse error", stmt)
+		}
+	}
+}
+
+func TestInsertRender(t *testing.T) {
+
+	stmt := "INSERT INTO public.users VALUES\n\t(1, 'a', 10, E'x'),\n\t(2, 'b', 20, E'y'),\n\t(3, 'c', 30, E'z');"
+	s, err := parseInsert(stmt, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := [][]string{
+		nil,
+		{"2", "it's\ta\\b", "21", `new\`},
+		{"3", nullValue, "thirty", "z"},
+	}
+	want := "INSERT INTO public.users VALUES\n\t(2, 'it''s\ta\\b', 21, E'new\\\\'),\n\t(3, NULL, 'thirty', E'z');"
+	got, ok := s.render(rows)
+	if !ok || got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+	if _, ok := s.render([][]string{nil, nil, nil}); ok {
+		t.Error("a statement with all rows deleted should not be output")
+	}
+}
+
+func TestInsertBuffer(t *testing.T) {
+
+	lines := []string{
+		"INSERT INTO public.users VALUES",
+		"\t(1, 'a;",
+		"b'),",
+		"\t(2, E'c\\';');",
+	}
+	b := newInsertBuffer(true)
+	for i, l := range lines {
+		if b.complete() {
+			t.Fatalf("statement complete before line %d", i)
+		}
+		b.add(l)
+	}
+	if !b.complete() {
+		t.Fatal("statement not complete")
+	}
+	s, err := parseInsert(b.String(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.rows[0].columns()[1]; got != "a;\nb" {
+		t.Errorf("unexpected multi-line value %q", got)
+	}
+	if got := s.rows[1].columns()[1]; got != `c';` {
+		t.Errorf("unexpected escaped value %q", got)
+	}
+
+	// quotes and semicolons in quoted identifiers
+	b = newInsertBuffer(true)
+	b.add(`INSERT INTO public."it's" ("a;b", "c""'d") VALUES`)
+	if b.complete() {
+		t.Fatal("statement with quoted identifiers complete before its values")
+	}
+	b.add(`	(1, 'e;f');`)
+	if !b.complete() {
+		t.Fatal("statement with quoted identifiers not complete")
+	}
+	if s, err = parseInsert(b.String(), true); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.rows[0].columns()[1]; got != "e;f" {
+		t.Errorf("unexpected value %q after quoted identifiers", got)
+	}
+}
+
+func TestAnonymiseInsertsQuotedIdentifiers(t *testing.T) {
+
+	dumpFile := filepath.Join(t.TempDir(), "dump.sql")
+	dump := strings.Join([]string{
+		`INSERT INTO public."it's" (id, "a;b") VALUES (1, 'ann');`,
+		`INSERT INTO public."it's" (id, "a;b") VALUES (2, 'bea');`,
+		`INSERT INTO public.other VALUES (1);`,
+	}, "\n") + "\n"
+	if err := os.WriteFile(dumpFile, []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	}
+	buffer := bytes.NewBuffer(nil)
+	args := anonArgs{
+		dumpFilePath: dumpFile,
+		settingsToml: `
+[["public.\"it's\""]]
+filter = "string replace"
+columns = ["a;b"]
+replacements = ["zachary"]
+`,
+		output: buffer,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+	want := strings.Join([]string{
+		`INSERT INTO public."it's" (id, "a;b") VALUES (1, 'zachary');`,
+		`INSERT INTO public."it's" (id, "a;b") VALUES (2, 'zachary');`,
+		`INSERT INTO public.other VALUES (1);`,
+	}, "\n") + "\n"
+	if got := buffer.String(); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+// testInsertOutput checks the anonymised output of the insert test dumps
+func testInsertOutput(t *testing.T, name, output string) {
+	t.Helper()
+
+	if strings.Contains(output, "INSERT INTO example_schema.events") {
+		t.Errorf("%s: events should be deleted", name)
+	}
+	if !strings.Contains(output, "(3, 5, 'vanessa')") {
+		t.Errorf("%s: fkexample not anonymised by reference", name)
+	}
+	if c := strings.Count(output, "'zachary'"); c != 3 {
+		t.Errorf("%s: expected 3 zacharies, got %d", name, c)
+	}
+	if strings.Contains(output, "ariadne") || strings.Contains(output, "wormtail") {
+		t.Errorf("%s: users not anonymised", name)
+	}
+	if !strings.Contains(output, "'this is a second note\twith a tab'") {
+		t.Errorf("%s: tab escape in replacement not written as a literal", name)
+	}
+	if c := strings.Count(output, ", NULL)"); c != 3 {
+		t.Errorf("%s: expected 3 NULL notes, got %d", name, c)
+	}
+}
+
+func TestAnonymiseInserts(t *testing.T) {
+
+	tomlString, err := os.ReadFile("testdata/settings.toml")
+	if err != nil {
+		t.Fatalf("could not read settings file: %s", err)
+	}
+
+	for _, dumpFile := range []string{"testdata/pg_dump_inserts.sql", "testdata/pg_dump_column_inserts.sql"} {
+
+		buffer := bytes.NewBuffer(nil)
+		args := anonArgs{
+			dumpFilePath: dumpFile,
+			settingsToml: string(tomlString),
+			output:       buffer,
+		}
+		if err := Anonymise(args); err != nil {
+			t.Fatalf("%s: Anonymise should not fail: %s", dumpFile, err)
+		}
+		output := buffer.String()
+		testInsertOutput(t, dumpFile, output)
+
+		// the rest of the dump is unchanged
+		if !strings.Contains(output, "CREATE TABLE public.users (\n") ||
+			!strings.HasSuffix(output, "-- PostgreSQL database dump complete\n--\n\n") {
+			t.Errorf("%s: dump not copied", dumpFile)
+		}
+	}
+}
+
+func TestAnonymiseInsertsNoColumns(t *testing.T) {
+
+	tomlString, err := os.ReadFile("testdata/settings.toml")
+	if err != nil {
+		t.Fatalf("could not read settings file: %s", err)
+	}
+
+	// an insert without a column list for a table without a definition
+	dumpFile := filepath.Join(t.TempDir(), "dump.sql")
+	dump := "INSERT INTO public.users VALUES (1, 'ariadne');\n"
+	if err := os.WriteFile(dumpFile, []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := anonArgs{
+		dumpFilePath: dumpFile,
+		settingsToml: string(tomlString),
+		output:       bytes.NewBuffer(nil),
+	}
+	if err := Anonymise(args); err == nil {
+		t.Error("an insert statement without columns should fail")
+	} else {
+		t.Log(err)
+	}
+}
+
+func TestAnonymiseInsertsCustom(t *testing.T) {
+
+	tomlString, err := os.ReadFile("testdata/settings.toml")
+	if err != nil {
+		t.Fatalf("could not read settings file: %s", err)
+	}
+
+	// an archive made with --inserts has no copy statements, with the
+	// column names of the tables in their table definitions
+	toc, _ := testTOC(t)
+	dump, err := os.ReadFile("testdata/pg_dump_inserts.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var defns strings.Builder
+	data := make([]string, len(toc)-2)
+	inCreate := false
+	for _, line := range strings.Split(string(dump), "\n") {
+		switch {
+		case strings.HasPrefix(line, "CREATE TABLE "):
+			inCreate = true
+		case isInsert(line):
+			name, _, _, _ := parseInsertHeader(line)
+			for i, te := range toc[1 : len(toc)-1] {
+				if te.namespace.String+"."+te.tag.String == name {
+					data[i] += line + "\n"
+				}
+			}
+		}
+		if inCreate {
+			defns.WriteString(line + "\n")
+			inCreate = line != ");"
+		}
+	}
+	toc[0].defn = sql.NullString{String: defns.String(), Valid: true}
+	for _, te := range toc {
+		te.copyStmt = sql.NullString{String: "", Valid: true}
+	}
+
+	dumpFile := filepath.Join(t.TempDir(), "dump.custom")
+	archive := makeCustomArchiveFrom(t, testArchiveHeader(14, compressionGzip), toc, data)
+	if err := os.WriteFile(dumpFile, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	args := anonArgs{
+		dumpFilePath: dumpFile,
+		settingsToml: string(tomlString),
+		output:       buffer,
+		changedOnly:  true,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+	testInsertOutput(t, "custom", buffer.String())
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Large objects are dumped apart from the tables which refer to them by
+// oid. A plain dump creates each large object with
+//
+//     SELECT pg_catalog.lo_create('16400');
+//
+// followed by statements such as ALTER LARGE OBJECT 16400 OWNER TO, and
+// after the table data writes the contents of each large object between
+// lo_open and lo_close lines:
+//
+//     SELECT pg_catalog.lo_open('16400', 131072);
+//     SELECT pg_catalog.lowrite(0, '\x255044462d...');
+//     SELECT pg_catalog.lo_close(0);
+//
+// Archives record large objects in BLOB or BLOB METADATA toc entries,
+// and their contents in the blob blocks of custom format archives or in
+// the blob_<oid>.dat files listed in the blobs toc file of directory and
+// tar format dumps.
+//
+// Large object filters replace the contents of large objects with a
+// placeholder, truncate them or drop them. They are given either for
+// pg_catalog.pg_largeobject, optionally with conditions on the oid
+// column loid, or for a table with the columns referring to large
+// objects, in which case the oids are collected from the table data in
+// the reference scan, as the large objects are created before the table
+// data is read. The referring columns of dropped large objects are set
+// to NULL.
+
+// largeObjectTable is the settings table name of large object filters
+// which select large objects by oid
+const largeObjectTable = "pg_catalog.pg_largeobject"
+
+// largeObjectColumn is the oid column of largeObjectTable, on which the
+// conditions of its filters are given
+const largeObjectColumn = "loid"
+
+// large object treatments, in increasing order of precedence, so that
+// a large object chosen by several filters is given the most severe
+// treatment
+const (
+	loReplace = iota + 1
+	loTruncate
+	loDrop
+)
+
+// largeObjectFilters are the large object filter names and treatments
+var largeObjectFilters = map[string]int{
+	"large object replace":  loReplace,
+	"large object truncate": loTruncate,
+	"large object drop":     loDrop,
+}
+
+// lobBufSize is the size of the chunks of large object data written in
+// each lowrite line, as for pg_dump
+const lobBufSize = 16384
+
+var (
+	loCreateRegex    = regexp.MustCompile(`^SELECT pg_catalog\.lo_create\('(\d+)'\);$`)
+	loOpenRegex      = regexp.MustCompile(`^SELECT pg_catalog\.lo_open\('(\d+)', \d+\);$`)
+	loStatementRegex = regexp.MustCompile(`^(?:ALTER|COMMENT ON|GRANT|REVOKE|SECURITY LABEL) .*\bLARGE OBJECT (\d+)\b`)
+	blobFileRegex    = regexp.MustCompile(`^blob_(\d+)\.dat$`)
+)
+
+const (
+	loWritePrefix = "SELECT pg_catalog.lowrite(0, "
+	loCloseLine   = "SELECT pg_catalog.lo_close(0);"
+)
+
+// largeObjectFilter sets the treatment of the large objects referred
+// to by the oids in Columns, or of those selected by its conditions on
+// loid if it is a filter of pg_largeobject
+type largeObjectFilter struct {
+	filterName
+	treatment   int
+	Columns     []string
+	placeholder []byte // the contents of replaced large objects
+	whereTrue   map[string]string
+	whereFalse  map[string]string
+	objects     *largeObjects
+}
+
+// NewLargeObjectFilter makes a new large object filter of the named
+// kind, registering the treatments of large objects in objects
+func NewLargeObjectFilter(name string, columns []string, placeholder []byte, whereTrue, whereFalse map[string]string, objects *largeObjects) (*largeObjectFilter, error) {
+
+	f := &largeObjectFilter{
+		filterName:  filterName(name),
+		treatment:   largeObjectFilters[name],
+		Columns:     columns,
+		placeholder: placeholder,
+		whereTrue:   whereTrue,
+		whereFalse:  whereFalse,
+		objects:     objects,
+	}
+	if f.treatment == 0 {
+		return f, fmt.Errorf("%s is not a large object filter", name)
+	}
+	if f.treatment == loReplace && placeholder == nil {
+		return f, errors.New("large object replace: a placeholder source file must be provided")
+	}
+	return f, nil
+}
+
+// applies reports if the conditions of the filter match a row
+func (f *largeObjectFilter) applies(r Row) bool {
+	if len(f.whereTrue) > 0 && r.match(f.FilterName(), f.whereTrue) != true {
+		return false
+	}
+	if len(f.whereFalse) > 0 && r.match(f.FilterName(), f.whereFalse) == true {
+		return false
+	}
+	return true
+}
+
+// Filter registers the treatment of the large objects referred to by a
+// row, setting the columns referring to dropped large objects to NULL
+func (f *largeObjectFilter) Filter(r Row) (Row, error) {
+
+	// if there is no line number the previous filter may have stopped
+	// processing
+	if r.lineNo == 0 || !f.applies(r) {
+		return r, nil
+	}
+
+	database := ""
+	if dt, ok := r.DumpTabler.(*DumpTable); ok {
+		database, _ = splitDatabaseTableName(dt.TableName)
+	}
+	for _, c := range f.Columns {
+		colNo, err := r.colNo(c)
+		if err != nil {
+			return r, fmt.Errorf("column %s %s: %w", c, f.FilterName(), err)
+		}
+		oid := r.Columns[colNo]
+		if oid == nullValue {
+			continue
+		}
+		f.objects.register(database, oid, f)
+		if t := f.objects.treatment(database, oid); t != nil && t.treatment == loDrop {
+			r.Columns[colNo] = nullValue
+		}
+	}
+	return r, nil
+}
+
+// usedColumns returns the oid and condition columns
+func (f *largeObjectFilter) usedColumns() []string {
+	return whereColumns(f.Columns, f.whereTrue, f.whereFalse)
+}
+
+// largeObjectKey identifies a large object in a database of a
+// pg_dumpall dump
+type largeObjectKey struct {
+	database, oid string
+}
+
+// largeObjects is the register of the treatments of large objects. The
+// treatments of large objects referred to by tables are registered as
+// the tables are filtered, which may be concurrently
+type largeObjects struct {
+	mu         sync.Mutex
+	treatments map[largeObjectKey]*largeObjectFilter
+	// oidFilters are the filters of pg_largeobject by settings table
+	// name, which may be qualified by a database name
+	oidFilters map[string][]*largeObjectFilter
+	// referenced records if large objects are chosen by the columns of
+	// tables referring to them
+	referenced bool
+}
+
+// newLargeObjects makes a new large object register
+func newLargeObjects() *largeObjects {
+	return &largeObjects{
+		treatments: map[largeObjectKey]*largeObjectFilter{},
+		oidFilters: map[string][]*largeObjectFilter{},
+	}
+}
+
+// active reports if any large object filters are set
+func (l *largeObjects) active() bool {
+	return l != nil && (l.referenced || len(l.oidFilters) > 0)
+}
+
+// byReference reports if large objects are chosen by the tables
+// referring to them, which requires a reference scan
+func (l *largeObjects) byReference() bool {
+	return l != nil && l.referenced
+}
+
+// register registers the treatment of a large object by a filter,
+// unless it already has a more severe treatment
+func (l *largeObjects) register(database, oid string, f *largeObjectFilter) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := largeObjectKey{database, oid}
+	if t, ok := l.treatments[key]; !ok || f.treatment > t.treatment {
+		l.treatments[key] = f
+	}
+}
+
+// treatment returns the filter giving the treatment of a large object
+// in a database, or nil if it is not treated. The filters of
+// pg_largeobject given for the database are used if any, otherwise
+// those given for any database
+func (l *largeObjects) treatment(database, oid string) *largeObjectFilter {
+	if !l.active() {
+		return nil
+	}
+	l.mu.Lock()
+	t := l.treatments[largeObjectKey{database, oid}]
+	l.mu.Unlock()
+
+	filters, ok := l.oidFilters[databaseTableName(database, largeObjectTable)]
+	if !ok {
+		filters = l.oidFilters[largeObjectTable]
+	}
+	dt := &DumpTable{TableName: largeObjectTable, columnNames: []string{largeObjectColumn}, initialised: true}
+	row := NewRow(dt, []string{oid}, 1)
+	for _, f := range filters {
+		if f.applies(row) && (t == nil || f.treatment > t.treatment) {
+			t = f
+		}
+	}
+	return t
+}
+
+// dropped reports if a large object in a database is dropped
+func (l *largeObjects) dropped(database, oid string) bool {
+	t := l.treatment(database, oid)
+	return t != nil && t.treatment == loDrop
+}
+
+// referencesLargeObjects reports if a table has filters choosing large
+// objects by the columns referring to them
+func (t *tableFilters) referencesLargeObjects(table string) bool {
+	for _, f := range t.getTableFilters(table) {
+		if lf, ok := f.(*largeObjectFilter); ok && len(lf.Columns) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLargeObjectFilters checks the filters of a table which are large
+// object filters. The filters of pg_largeobject, which are registered
+// for finding the treatments of large objects by oid, may only be
+// large object filters with conditions on loid, while those of other
+// tables must give the columns referring to large objects
+func (t *tableFilters) checkLargeObjectFilters(table string, filters []RowFilterer) error {
+
+	_, name := splitDatabaseTableName(table)
+	isOIDTable := name == largeObjectTable || table == largeObjectTable
+	for _, f := range filters {
+		lf, ok := f.(*largeObjectFilter)
+		switch {
+		case !ok && isOIDTable:
+			return fmt.Errorf("only large object filters may be given for %s", table)
+		case !ok:
+			continue
+		case isOIDTable:
+			if len(lf.Columns) > 0 {
+				return fmt.Errorf("%s filter for %s: columns cannot be given, use conditions on %s", lf.FilterName(), table, largeObjectColumn)
+			}
+			for _, where := range []map[string]string{lf.whereTrue, lf.whereFalse} {
+				for c := range where {
+					if c != largeObjectColumn {
+						return fmt.Errorf("%s filter for %s: conditions may only be given on %s", lf.FilterName(), table, largeObjectColumn)
+					}
+				}
+			}
+			t.largeObjects.oidFilters[table] = append(t.largeObjects.oidFilters[table], lf)
+		default:
+			if len(lf.Columns) == 0 {
+				return fmt.Errorf("%s filter for %s: at least one oid column must be provided", lf.FilterName(), table)
+			}
+			t.largeObjects.referenced = true
+		}
+	}
+	return nil
+}
+
+// droppedLargeObjectStatement reports if a line is a statement creating
+// or altering a large object which is dropped
+func (d *dumpFilter) droppedLargeObjectStatement(t string) bool {
+	m := loCreateRegex.FindStringSubmatch(t)
+	if m == nil {
+		m = loStatementRegex.FindStringSubmatch(t)
+	}
+	return m != nil && d.tableFilters.largeObjects.dropped(d.database, m[1])
+}
+
+// filterLargeObjectLine filters a line of a plain dump outside of table
+// data for large objects, returning the line to output and true, or
+// false if the line should not be output, and true if the line has
+// changed. The statements of dropped large objects are removed, and the
+// lowrite lines of the contents of replaced or truncated large objects
+// are removed, with the placeholder of replaced large objects written
+// before the lo_close line
+func (d *dumpFilter) filterLargeObjectLine(t string) (string, bool, bool) {
+
+	objects := d.tableFilters.largeObjects
+	if !objects.active() {
+		return t, true, false
+	}
+
+	if m := loOpenRegex.FindStringSubmatch(t); m != nil {
+		d.inLargeObject = true
+		d.largeObject = objects.treatment(d.database, m[1])
+		if d.largeObject != nil && d.largeObject.treatment == loDrop {
+			return "", false, true
+		}
+		return t, true, false
+	}
+	if !d.inLargeObject {
+		if d.droppedLargeObjectStatement(t) {
+			return "", false, true
+		}
+		return t, true, false
+	}
+
+	f := d.largeObject
+	switch {
+	case f == nil:
+		if t == loCloseLine {
+			d.inLargeObject = false
+		}
+		return t, true, false
+	case strings.HasPrefix(t, loWritePrefix):
+		return "", false, true
+	case t != loCloseLine:
+		return t, true, false
+	}
+
+	d.inLargeObject, d.largeObject = false, nil
+	switch f.treatment {
+	case loDrop:
+		return "", false, true
+	case loReplace:
+		return loWriteLines(f.placeholder, d.standardStrings) + t, true, true
+	}
+	return t, true, false
+}
+
+// loWriteLines returns the lowrite lines writing data to an open large
+// object, as bytea hex literals, each terminated by a newline
+func loWriteLines(data []byte, standardStrings bool) string {
+	var b strings.Builder
+	for len(data) > 0 {
+		n := len(data)
+		if n > lobBufSize {
+			n = lobBufSize
+		}
+		b.WriteString(loWritePrefix)
+		if standardStrings {
+			b.WriteString(`'\x`)
+		} else {
+			b.WriteString(`E'\\x`)
+		}
+		b.WriteString(fmt.Sprintf("%x", data[:n]))
+		b.WriteString("');\n")
+		data = data[n:]
+	}
+	return b.String()
+}
+
+// filterLargeObjectStatements removes the statements of dropped large
+// objects from the definition of a toc entry grouping several large
+// objects, returning false if no statements remain
+func (d *dumpFilter) filterLargeObjectStatements(s string) (string, bool) {
+	kept := []string{}
+	remain := false
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if d.droppedLargeObjectStatement(strings.TrimSpace(line)) {
+			continue
+		}
+		kept = append(kept, line)
+		remain = remain || strings.TrimSpace(line) != ""
+	}
+	return strings.Join(kept, ""), remain
+}
+
+// filterLargeObjectTOC removes the toc entries of dropped large objects
+// from an archive table of contents, together with their statements in
+// the entries grouping several large objects made by later versions of
+// pg_dump, and the dependencies on removed entries
+func (d *dumpFilter) filterLargeObjectTOC(toc []*tocEntry) []*tocEntry {
+
+	objects := d.tableFilters.largeObjects
+	if !objects.active() {
+		return toc
+	}
+
+	removed := map[string]bool{}
+	kept := []*tocEntry{}
+	for _, te := range toc {
+		tag := te.tag.String
+		switch {
+		case te.desc.String == "BLOB" && objects.dropped(d.database, tag):
+			removed[strconv.Itoa(te.dumpID)] = true
+			continue
+		case strings.HasPrefix(tag, "LARGE OBJECT ") && objects.dropped(d.database, strings.TrimPrefix(tag, "LARGE OBJECT ")):
+			removed[strconv.Itoa(te.dumpID)] = true
+			continue
+		case te.desc.String == "BLOB METADATA" || strings.HasPrefix(tag, "LARGE OBJECTS "):
+			defn, remain := d.filterLargeObjectStatements(te.defn.String)
+			if te.defn.String != "" && !remain {
+				removed[strconv.Itoa(te.dumpID)] = true
+				continue
+			}
+			te.defn.String = defn
+			te.dropStmt.String, _ = d.filterLargeObjectStatements(te.dropStmt.String)
+		}
+		kept = append(kept, te)
+	}
+
+	for _, te := range kept {
+		deps := te.deps[:0]
+		for _, dep := range te.deps {
+			if !removed[dep.String] {
+				deps = append(deps, dep)
+			}
+		}
+		te.deps = deps
+	}
+	return kept
+}
+
+// writeBlobData writes the contents of a large object to a custom
+// format blob block as compressed data chunks
+func writeBlobData(h archiveHeader, aw *archiveWriter, data []byte) error {
+	chunks := bufio.NewWriterSize(&chunkWriter{aw}, chunkSize)
+	cw, err := newCompressor(h, chunks)
+	if err != nil {
+		return err
+	}
+	if _, err := cw.Write(data); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	if err := chunks.Flush(); err != nil {
+		return err
+	}
+	aw.writeInt(0)
+	return aw.err
+}
+
+// filterBlobs filters a custom format large object block, in which each
+// large object is recorded as an oid followed by data chunks, and which
+// is terminated by a zero oid. Dropped large objects are removed, and
+// the contents of replaced and truncated large objects rewritten. Other
+// large objects are copied verbatim
+func filterBlobs(df *dumpFilter, h archiveHeader, ar *archiveReader, aw *archiveWriter) error {
+
+	objects := df.tableFilters.largeObjects
+	if !objects.active() {
+		return copyBlobs(ar, aw)
+	}
+	for {
+		n := ar.readInt()
+		if ar.err != nil {
+			return ar.err
+		}
+		if n == 0 {
+			aw.writeInt(0)
+			return aw.err
+		}
+		// oids are unsigned
+		oid := strconv.FormatUint(uint64(uint32(n)), 10)
+		f := objects.treatment(df.database, oid)
+		if f == nil {
+			aw.writeInt(n)
+			if err := copyChunks(ar, aw); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := copyChunks(ar, newArchiveWriter(io.Discard)); err != nil {
+			return err
+		}
+		if f.treatment == loDrop {
+			continue
+		}
+		aw.writeInt(n)
+		if err := writeBlobData(h, aw, f.placeholder); err != nil {
+			return err
+		}
+	}
+}
+
+// filterBlobsTOC filters the blobs toc file of a directory or tar format
+// dump, each line of which gives the oid and file name of a large
+// object, removing the lines of dropped large objects. It returns the
+// filtered file and the treatments of the files of treated large
+// objects
+func (d *dumpFilter) filterBlobsTOC(b []byte) ([]byte, map[string]*largeObjectFilter, error) {
+
+	out := bytes.NewBuffer(nil)
+	treated := map[string]*largeObjectFilter{}
+	for _, line := range strings.SplitAfter(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			out.WriteString(line)
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("invalid blobs toc line %q", strings.TrimSpace(line))
+		}
+		if f := d.tableFilters.largeObjects.treatment(d.database, fields[0]); f != nil {
+			treated[fields[1]] = f
+			if f.treatment == loDrop {
+				continue
+			}
+		}
+		out.WriteString(line)
+	}
+	return out.Bytes(), treated, nil
+}
+
+// blobFileTreatment returns the treatment of the large object of a tar
+// member or directory dump file named blob_<oid>.dat, or nil if it is
+// not treated
+func (d *dumpFilter) blobFileTreatment(name string) *largeObjectFilter {
+	m := blobFileRegex.FindStringSubmatch(name)
+	if m == nil {
+		return nil
+	}
+	return d.tableFilters.largeObjects.treatment(d.database, m[1])
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// largeObjectSettings returns settings with a placeholder file for large
+// object replace filters in place of %s
+func largeObjectSettings(t *testing.T, settings string) string {
+	t.Helper()
+	placeholder := filepath.Join(t.TempDir(), "placeholder.pdf")
+	if err := os.WriteFile(placeholder, []byte("redacted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return strings.ReplaceAll(settings, "%s", placeholder)
+}
+
+func TestLargeObjectFilterSettings(t *testing.T) {
+
+	tests := []struct {
+		name, settings string
+	}{
+		{"replace without a placeholder", `
+[["public.documents"]]
+filter = "large object replace"
+columns = ["scan"]
+`},
+		{"table filter without columns", `
+[["public.documents"]]
+filter = "large object drop"
+`},
+		{"pg_largeobject filter with columns", `
+[["pg_catalog.pg_largeobject"]]
+filter = "large object drop"
+columns = ["loid"]
+`},
+		{"pg_largeobject condition on another column", `
+[["pg_catalog.pg_largeobject"]]
+filter = "large object truncate"
+if = {"pageno" = "0"}
+`},
+		{"other filter for pg_largeobject", `
+[["pg_catalog.pg_largeobject"]]
+filter = "uuid"
+columns = ["loid"]
+`},
+	}
+	for _, tc := range tests {
+		settings, err := LoadToml(tc.settings)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		if _, err := loadFilters(settings); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+
+	settings, err := LoadToml(largeObjectSettings(t, `
+[["public.documents"]]
+filter = "large object replace"
+columns = ["scan"]
+source = "%s"
+
+[["sales.pg_catalog.pg_largeobject"]]
+filter = "large object drop"
+if = {"loid" = "16401"}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf, err := loadFilters(settings)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if !tf.largeObjects.byReference() || !tf.referencesLargeObjects("sales.public.documents") {
+		t.Error("expected large objects chosen by reference")
+	}
+	if !tf.largeObjects.dropped("sales", "16401") || tf.largeObjects.dropped("", "16401") {
+		t.Error("expected large object 16401 to be dropped only in database sales")
+	}
+}
+
+func TestLoWriteLines(t *testing.T) {
+	if got, want := loWriteLines([]byte("ab"), true), "SELECT pg_catalog.lowrite(0, '\\x6162');\n"; got != want {
+		t.Errorf("expected %q got %q", want, got)
+	}
+	if got, want := loWriteLines([]byte("ab"), false), "SELECT pg_catalog.lowrite(0, E'\\\\x6162');\n"; got != want {
+		t.Errorf("expected %q got %q", want, got)
+	}
+	if got := loWriteLines(bytes.Repeat([]byte("x"), lobBufSize+1), true); strings.Count(got, "\n") != 2 {
+		t.Errorf("expected two lowrite lines, got %d", strings.Count(got, "\n"))
+	}
+}
+
+const largeObjectPlainSettings = `
+[["public.documents"]]
+filter = "large object drop"
+columns = ["scan"]
+if = {"kind" = "passport"}
+
+[["public.documents"]]
+filter = "large object replace"
+columns = ["scan"]
+source = "%s"
+
+[["pg_catalog.pg_largeobject"]]
+filter = "large object truncate"
+if = {"loid" = "16402"}
+`
+
+func TestAnonymiseLargeObjects(t *testing.T) {
+
+	dump := strings.Join([]string{
+		`SET standard_conforming_strings = on;`,
+		`SELECT pg_catalog.lo_create('16400');`,
+		`ALTER LARGE OBJECT 16400 OWNER TO app;`,
+		`SELECT pg_catalog.lo_create('16401');`,
+		`ALTER LARGE OBJECT 16401 OWNER TO app;`,
+		`SELECT pg_catalog.lo_create('16402');`,
+		`COMMENT ON LARGE OBJECT 16402 IS 'scan';`,
+		`SELECT pg_catalog.lo_create('16403');`,
+		`GRANT SELECT ON LARGE OBJECT 16400 TO auditor;`,
+		``,
+		`COPY public.documents (id, kind, scan) FROM stdin;`,
+		"1\tpassport\t16400",
+		"2\tletter\t16401",
+		"3\tletter\t\\N",
+		`\.`,
+		``,
+		`BEGIN;`,
+		``,
+		`SELECT pg_catalog.lo_open('16400', 131072);`,
+		`SELECT pg_catalog.lowrite(0, '\x70617373706f7274');`,
+		`SELECT pg_catalog.lo_close(0);`,
+		``,
+		`SELECT pg_catalog.lo_open('16401', 131072);`,
+		`SELECT pg_catalog.lowrite(0, '\x6c6574');`,
+		`SELECT pg_catalog.lowrite(0, '\x746572');`,
+		`SELECT pg_catalog.lo_close(0);`,
+		``,
+		`SELECT pg_catalog.lo_open('16402', 131072);`,
+		`SELECT pg_catalog.lowrite(0, '\x7363616e');`,
+		`SELECT pg_catalog.lo_close(0);`,
+		``,
+		`SELECT pg_catalog.lo_open('16403', 131072);`,
+		`SELECT pg_catalog.lowrite(0, '\x6b657074');`,
+		`SELECT pg_catalog.lo_close(0);`,
+		``,
+		`COMMIT;`,
+		``,
+	}, "\n")
+
+	want := strings.Join([]string{
+		`SET standard_conforming_strings = on;`,
+		`SELECT pg_catalog.lo_create('16401');`,
+		`ALTER LARGE OBJECT 16401 OWNER TO app;`,
+		`SELECT pg_catalog.lo_create('16402');`,
+		`COMMENT ON LARGE OBJECT 16402 IS 'scan';`,
+		`SELECT pg_catalog.lo_create('16403');`,
+		``,
+		`COPY public.documents (id, kind, scan) FROM stdin;`,
+		"1\tpassport\t\\N",
+		"2\tletter\t16401",
+		"3\tletter\t\\N",
+		`\.`,
+		``,
+		`BEGIN;`,
+		``,
+		``,
+		`SELECT pg_catalog.lo_open('16401', 131072);`,
+		`SELECT pg_catalog.lowrite(0, '\x7265646163746564');`,
+		`SELECT pg_catalog.lo_close(0);`,
+		``,
+		`SELECT pg_catalog.lo_open('16402', 131072);`,
+		`SELECT pg_catalog.lo_close(0);`,
+		``,
+		`SELECT pg_catalog.lo_open('16403', 131072);`,
+		`SELECT pg_catalog.lowrite(0, '\x6b657074');`,
+		`SELECT pg_catalog.lo_close(0);`,
+		``,
+		`COMMIT;`,
+		``,
+	}, "\n")
+
+	dumpFile := filepath.Join(t.TempDir(), "dump.sql")
+	if err := os.WriteFile(dumpFile, []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	}
+	buffer := bytes.NewBuffer(nil)
+	args := anonArgs{
+		dumpFilePath: dumpFile,
+		settingsToml: largeObjectSettings(t, largeObjectPlainSettings),
+		output:       buffer,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+	if got := buffer.String(); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+// testLargeObjectTOC makes a table of contents with the large object
+// entries of pg_dump 16 and 17 for large objects 16400 and 16401
+func testLargeObjectTOC() []*tocEntry {
+	entry := func(id int, desc, tag, defn string, deps ...string) *tocEntry {
+		te := &tocEntry{
+			dumpID:    id,
+			tag:       sql.NullString{String: tag, Valid: true},
+			desc:      sql.NullString{String: desc, Valid: true},
+			section:   2,
+			defn:      sql.NullString{String: defn, Valid: true},
+			withOids:  sql.NullString{String: "false", Valid: true},
+			dataState: offsetNoData,
+		}
+		for _, d := range deps {
+			te.deps = append(te.deps, sql.NullString{String: d, Valid: true})
+		}
+		return te
+	}
+	blobs := entry(6, "BLOBS", "BLOBS", "", "1", "2", "5")
+	blobs.hadDumper, blobs.section, blobs.dataState = 1, 3, offsetPosNotSet
+	blobs.filename = sql.NullString{String: "blobs.toc", Valid: true}
+	return []*tocEntry{
+		entry(1, "BLOB", "16400", "SELECT pg_catalog.lo_create('16400');\n"),
+		entry(2, "BLOB", "16401", "SELECT pg_catalog.lo_create('16401');\n"),
+		entry(3, "ACL", "LARGE OBJECT 16400", "GRANT SELECT ON LARGE OBJECT 16400 TO auditor;\n", "1"),
+		entry(4, "BLOB METADATA", "16400..16401",
+			"SELECT pg_catalog.lo_create('16400');\nSELECT pg_catalog.lo_create('16401');\nALTER LARGE OBJECT 16400 OWNER TO app;\n"),
+		entry(5, "BLOB METADATA", "16400", "SELECT pg_catalog.lo_create('16400');\n"),
+		blobs,
+	}
+}
+
+func TestFilterLargeObjectTOC(t *testing.T) {
+
+	settings, err := LoadToml(`
+[["pg_catalog.pg_largeobject"]]
+filter = "large object drop"
+if = {"loid" = "16400"}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf, err := loadFilters(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	df := newDumpFilter(tf, RefTableRegister{}, false, false)
+
+	toc := df.filterLargeObjectTOC(testLargeObjectTOC())
+	ids := []int{}
+	for _, te := range toc {
+		ids = append(ids, te.dumpID)
+	}
+	if !reflect.DeepEqual(ids, []int{2, 4, 6}) {
+		t.Fatalf("unexpected entries %v", ids)
+	}
+	if got, want := toc[1].defn.String, "SELECT pg_catalog.lo_create('16401');\n"; got != want {
+		t.Errorf("expected definition %q got %q", want, got)
+	}
+	if len(toc[2].deps) != 1 || toc[2].deps[0].String != "2" {
+		t.Errorf("unexpected dependencies %v", toc[2].deps)
+	}
+}
+
+// largeObjectArchiveSettings drops large object 16400 and replaces
+// large object 16401
+const largeObjectArchiveSettings = `
+[["pg_catalog.pg_largeobject"]]
+filter = "large object drop"
+if = {"loid" = "16400"}
+
+[["pg_catalog.pg_largeobject"]]
+filter = "large object replace"
+source = "%s"
+`
+
+func TestAnonymiseLargeObjectsCustom(t *testing.T) {
+
+	for _, algo := range []byte{compressionNone, compressionGzip} {
+
+		h := testArchiveHeader(14, algo)
+		buf := bytes.NewBuffer(nil)
+		aw := newArchiveWriter(buf)
+		aw.writeHeader(h)
+		aw.writeTOC(h, testLargeObjectTOC())
+		aw.writeByte(blkBlobs)
+		aw.writeInt(6)
+		for _, blob := range []struct {
+			oid      int
+			contents string
+		}{{16400, "passport"}, {16401, "letter"}, {16402, "scan"}} {
+			aw.writeInt(blob.oid)
+			if err := writeBlobData(h, aw, []byte(blob.contents)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		aw.writeInt(0)
+		aw.flush()
+
+		dumpFile := filepath.Join(t.TempDir(), "dump.custom")
+		if err := os.WriteFile(dumpFile, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		output := bytes.NewBuffer(nil)
+		args := anonArgs{
+			dumpFilePath: dumpFile,
+			settingsToml: largeObjectSettings(t, largeObjectArchiveSettings),
+			output:       output,
+		}
+		if err := Anonymise(args); err != nil {
+			t.Fatalf("Anonymise should not fail: %s", err)
+		}
+
+		ar := newArchiveReader(bytes.NewReader(output.Bytes()))
+		oh, err := ar.readHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		toc, err := ar.readTOC(oh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(toc) != 3 {
+			t.Errorf("expected 3 toc entries, got %d", len(toc))
+		}
+		if ar.readByte() != blkBlobs || ar.readInt() != 6 {
+			t.Fatal("expected a blobs block")
+		}
+		blobs := map[int]string{}
+		for {
+			oid := ar.readInt()
+			if oid == 0 || ar.err != nil {
+				break
+			}
+			cr := &chunkReader{ar: ar}
+			dr, err := newDecompressor(oh.algorithm(), cr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(dr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			blobs[oid] = string(b)
+			io.Copy(io.Discard, cr)
+		}
+		want := map[int]string{16401: "redacted", 16402: "redacted"}
+		if !reflect.DeepEqual(blobs, want) {
+			t.Errorf("algorithm %d: expected large objects %v got %v", algo, want, blobs)
+		}
+	}
+}
+
+func TestAnonymiseLargeObjectsDirectory(t *testing.T) {
+
+	dumpDir := filepath.Join(t.TempDir(), "dump")
+	outDir := filepath.Join(t.TempDir(), "out")
+	if err := os.Mkdir(dumpDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	makeDirectoryDump(t, dumpDir, testArchiveHeader(16, compressionGzip))
+
+	args := anonArgs{
+		dumpFilePath: dumpDir,
+		settingsToml: largeObjectSettings(t, `
+[["pg_catalog.pg_largeobject"]]
+filter = "large object replace"
+source = "%s"
+`),
+		outputDir: outDir,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+	if got := readDirectoryData(t, outDir, "blob_16400.dat"); got != "redacted" {
+		t.Errorf("unexpected large object contents %q", got)
+	}
+	if b, _ := os.ReadFile(filepath.Join(outDir, "blobs.toc")); string(b) != "16400 blob_16400.dat\n" {
+		t.Errorf("unexpected blobs toc %q", b)
+	}
+
+	// dropped large objects are removed
+	outDir = filepath.Join(t.TempDir(), "out")
+	args.settingsToml = largeObjectSettings(t, largeObjectArchiveSettings)
+	args.outputDir = outDir
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(outDir, "blobs.toc")); len(b) != 0 {
+		t.Errorf("unexpected blobs toc %q", b)
+	}
+	if _, err := directoryDataFile(outDir, "blob_16400.dat"); err == nil {
+		t.Error("expected the dropped large object file to be removed")
+	}
+}
+
+func TestAnonymiseLargeObjectsTar(t *testing.T) {
+
+	for _, tc := range []struct {
+		settings string
+		blob     string
+		blobsTOC string
+	}{
+		{`
+[["pg_catalog.pg_largeobject"]]
+filter = "large object truncate"
+`, "", "16400 blob_16400.dat\n"},
+		{largeObjectArchiveSettings, "missing", ""},
+	} {
+		dumpFile := filepath.Join(t.TempDir(), "dump.tar")
+		if err := os.WriteFile(dumpFile, makeTarArchive(t), 0644); err != nil {
+			t.Fatal(err)
+		}
+		output := bytes.NewBuffer(nil)
+		args := anonArgs{
+			dumpFilePath: dumpFile,
+			settingsToml: largeObjectSettings(t, tc.settings),
+			output:       output,
+		}
+		if err := Anonymise(args); err != nil {
+			t.Fatalf("Anonymise should not fail: %s", err)
+		}
+		_, members := readTarMembers(t, output.Bytes())
+		blob, ok := members["blob_16400.dat"]
+		if !ok {
+			blob = "missing"
+		}
+		if blob != tc.blob || members["blobs.toc"] != tc.blobsTOC {
+			t.Errorf("unexpected large object %q and blobs toc %q", blob, members["blobs.toc"])
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// lineBufferSize is the size of the buffer of a lineReader. Lines which
+// fit in the buffer are filtered whole, while longer lines, such as
+// table rows with large jsonb documents or bytea values, are read in
+// parts
+const lineBufferSize = 1 << 20
+
+// lineReader reads the lines of a dump, which may be of any length.
+//
+// Long lines which are not part of a table of interest are copied to
+// the output part by part. Of the long rows of tables of interest only
+// the columns used by the table's filters are held in memory; the other
+// columns are written to a spool file and copied back to the output
+// once the row has been filtered.
+type lineReader struct {
+	r     *bufio.Reader
+	spool *os.File // the columns of a long row not used by the filters
+	// encoding, if set, is the client encoding from which lines are
+	// decoded to UTF-8
+	encoding *clientEncoding
+}
+
+// newLineReader makes a new lineReader reading r
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{r: bufio.NewReaderSize(r, lineBufferSize)}
+}
+
+// next returns the next line without its line ending and false, or the
+// first part of a line longer than the buffer and true. The returned
+// slice is only valid until the next read. At the end of the input
+// io.EOF is returned
+func (lr *lineReader) next() ([]byte, bool, error) {
+	return lr.readPart()
+}
+
+// readPart reads the next line, or part of a long line, decoding it to
+// UTF-8 if the lineReader has an encoding
+func (lr *lineReader) readPart() ([]byte, bool, error) {
+	part, more, err := lr.r.ReadLine()
+	if err != nil || lr.encoding == nil {
+		return part, more, err
+	}
+	return lr.encoding.decodeBytes(part), more, nil
+}
+
+// rest reads the remainder of a long line, the first part of which is
+// prefix, returning the whole line
+func (lr *lineReader) rest(prefix []byte) (string, error) {
+	line := append([]byte{}, prefix...)
+	for {
+		part, more, err := lr.readPart()
+		if err == io.EOF {
+			return string(line), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		line = append(line, part...)
+		if !more {
+			return string(line), nil
+		}
+	}
+}
+
+// copyRest writes a long line, the first part of which is prefix, and
+// a newline to w, part by part
+func (lr *lineReader) copyRest(prefix []byte, w io.Writer) error {
+	part, more := prefix, true
+	for {
+		if _, err := w.Write(part); err != nil {
+			return fmt.Errorf("write error: %w", err)
+		}
+		if !more {
+			break
+		}
+		var err error
+		part, more, err = lr.readPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return fmt.Errorf("write error: %w", err)
+	}
+	return nil
+}
+
+// longColumn is a column of a long row in COPY text format, held in
+// memory if it is used by a filter, otherwise in the spool file
+type longColumn struct {
+	value   string
+	spooled bool
+	offset  int64
+	size    int64
+}
+
+// readLongRow reads a long COPY data row, the first part of which is
+// prefix, into its columns. The columns whose numbers are in used are
+// held in memory, and the others are spooled
+func (lr *lineReader) readLongRow(prefix []byte, used map[int]bool) ([]longColumn, error) {
+
+	if lr.spool == nil {
+		f, err := os.CreateTemp("", "gopg-anonymise-row-")
+		if err != nil {
+			return nil, fmt.Errorf("could not make row spool file: %w", err)
+		}
+		lr.spool = f
+	} else {
+		if err := lr.spool.Truncate(0); err != nil {
+			return nil, fmt.Errorf("row spool error: %w", err)
+		}
+		if _, err := lr.spool.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("row spool error: %w", err)
+		}
+	}
+
+	columns := []longColumn{}
+	column := longColumn{spooled: !used[0]}
+	value := []byte{}
+	var offset int64
+
+	// add appends part of a column to the column's value or spool
+	add := func(part []byte) error {
+		if !column.spooled {
+			value = append(value, part...)
+			return nil
+		}
+		n, err := lr.spool.Write(part)
+		column.size += int64(n)
+		offset += int64(n)
+		if err != nil {
+			return fmt.Errorf("row spool error: %w", err)
+		}
+		return nil
+	}
+
+	part, more := prefix, true
+	for {
+		for {
+			i := bytes.IndexByte(part, '\t')
+			if i < 0 {
+				break
+			}
+			if err := add(part[:i]); err != nil {
+				return nil, err
+			}
+			column.value = string(value)
+			columns = append(columns, column)
+			column = longColumn{spooled: !used[len(columns)], offset: offset}
+			value = value[:0]
+			part = part[i+1:]
+		}
+		if err := add(part); err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+		var err error
+		part, more, err = lr.readPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	column.value = string(value)
+	return append(columns, column), nil
+}
+
+// writeLongRow writes a long row to w, with the filtered values of the
+// columns held in memory and the spooled columns copied verbatim
+func (lr *lineReader) writeLongRow(w io.Writer, columns []longColumn, values []string) error {
+
+	for i, c := range columns {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\t"); err != nil {
+				return fmt.Errorf("write error: %w", err)
+			}
+		}
+		if c.spooled {
+			if _, err := io.Copy(w, io.NewSectionReader(lr.spool, c.offset, c.size)); err != nil {
+				return fmt.Errorf("row spool copy error: %w", err)
+			}
+			continue
+		}
+		if _, err := io.WriteString(w, encodeCopyLine(values[i:i+1])); err != nil {
+			return fmt.Errorf("write error: %w", err)
+		}
+	}
+	if _, err := io.WriteString(w, "\n"); err != nil {
+		return fmt.Errorf("write error: %w", err)
+	}
+	return nil
+}
+
+// Close closes and removes any spool file
+func (lr *lineReader) Close() error {
+	if lr.spool == nil {
+		return nil
+	}
+	err := lr.spool.Close()
+	if rerr := os.Remove(lr.spool.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// filterNext filters the next line read by lr, writing any output to w.
+// At the end of the input io.EOF is returned
+func (d *dumpFilter) filterNext(lr *lineReader, w io.Writer) error {
+
+	lr.encoding = nil
+	if d.toUTF8 {
+		lr.encoding = d.encoding
+	}
+	part, more, err := lr.next()
+	if err != nil {
+		return err
+	}
+
+	var line string
+	switch {
+	case !more:
+		line = string(part)
+	case d.streamable(part):
+		if d.referenceMode || d.changedOnly {
+			return lr.copyRest(part, io.Discard)
+		}
+		return lr.copyRest(part, w)
+	// the whole of a long row is read if it is exported
+	case d.dt.Inited() && !d.dt.insert && !d.referenceMode && !d.refTableInDumpMode && d.exportFile == nil:
+		return d.filterLongRow(lr, part, w)
+	default:
+		if line, err = lr.rest(part); err != nil {
+			return err
+		}
+	}
+
+	t, ok, err := d.filterLine(line)
+	if err != nil || !ok {
+		return err
+	}
+	if _, err := io.WriteString(w, t+"\n"); err != nil {
+		return fmt.Errorf("write error: %w", err)
+	}
+	return nil
+}
+
+// longLinePrefixes are the prefixes of lines which the dumpFilter reads
+// outside of table data
+var longLinePrefixes = [][]byte{
+	[]byte("COPY "), []byte("INSERT "), []byte("CREATE "), []byte("SET "),
+	[]byte("ALTER ROLE "), []byte(connectPrefix),
+}
+
+// streamable reports if a long line, the first part of which is prefix,
+// is not needed by the dumpFilter, as it is not part of the data of a
+// table of interest, an INSERT statement or a table definition
+func (d *dumpFilter) streamable(prefix []byte) bool {
+	if d.dt.Inited() || d.insert != nil || d.largeObject != nil || (d.ddl != nil && d.ddl.current != nil) {
+		return false
+	}
+	for _, p := range longLinePrefixes {
+		if bytes.HasPrefix(prefix, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterLongRow filters a long COPY data row, the first part of which
+// is prefix, holding only the columns used by the table's filters in
+// memory
+func (d *dumpFilter) filterLongRow(lr *lineReader, prefix []byte, w io.Writer) error {
+
+	columns, err := lr.readLongRow(prefix, d.usedColumns())
+	if err != nil {
+		return err
+	}
+	values := make([]string, len(columns))
+	for i, c := range columns {
+		if !c.spooled {
+			values[i] = decodeCopyValue(c.value)
+		}
+	}
+	if err := d.checkRowFields(values); err != nil {
+		return err
+	}
+	d.dt.lines++
+
+	values, ok, err := d.filterRow(values)
+	if err != nil || !ok {
+		return err
+	}
+	return lr.writeLongRow(w, columns, values)
+}
+
+// usedColumns returns the numbers of the columns of the current table
+// which are used by its filters
+func (d *dumpFilter) usedColumns() map[int]bool {
+	names := map[string]bool{}
+	for _, f := range d.filters {
+		for _, c := range f.usedColumns() {
+			names[c] = true
+		}
+	}
+	used := map[int]bool{}
+	for i, c := range d.dt.ColumnNames() {
+		if names[c] {
+			used[i] = true
+		}
+	}
+	return used
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLineReader(t *testing.T) {
+
+	long := strings.Repeat("x", lineBufferSize*2+7)
+	input := "short\n" + long + "\n" + "a\tb\n" + long + "\tend"
+
+	lr := newLineReader(strings.NewReader(input))
+	defer lr.Close()
+
+	part, more, err := lr.next()
+	if err != nil || more || string(part) != "short" {
+		t.Fatalf("unexpected first line %q %t %v", part, more, err)
+	}
+	part, more, err = lr.next()
+	if err != nil || !more {
+		t.Fatalf("expected a long line, got %t %v", more, err)
+	}
+	line, err := lr.rest(part)
+	if err != nil || line != long {
+		t.Fatalf("long line not read whole (%d bytes): %v", len(line), err)
+	}
+
+	part, _, _ = lr.next()
+	if string(part) != "a\tb" {
+		t.Fatalf("unexpected line %q", part)
+	}
+
+	// a long row with a spooled first column and an end column held in
+	// memory
+	part, more, err = lr.next()
+	if err != nil || !more {
+		t.Fatalf("expected a long line, got %t %v", more, err)
+	}
+	columns, err := lr.readLongRow(part, map[int]bool{1: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(columns) != 2 || !columns[0].spooled || columns[0].size != int64(len(long)) ||
+		columns[1].spooled || columns[1].value != "end" {
+		t.Fatalf("unexpected long row columns %d", len(columns))
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := lr.writeLongRow(buf, columns, []string{"", "new\tend"}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != long+"\tnew\\tend\n" {
+		t.Errorf("unexpected long row output of %d bytes", buf.Len())
+	}
+	if _, _, err = lr.next(); err == nil {
+		t.Error("expected the end of the input")
+	}
+}
+
+func TestAnonymiseLongLines(t *testing.T) {
+
+	image := strings.Repeat(`\\x0123456789abcdef`, lineBufferSize/8)
+	doc := `{"a": "` + strings.Repeat("b", lineBufferSize*2) + `"}`
+
+	dump := strings.Join([]string{
+		"-- " + doc,
+		`CREATE TABLE public.users (id integer, name text, image bytea, doc jsonb);`,
+		``,
+		`CREATE TABLE public.notes (`,
+		`    id integer,`,
+		`    name text,`,
+		`    doc jsonb`,
+		`);`,
+		``,
+		`COPY public.users (id, name, image, doc) FROM stdin;`,
+		"1\tariadne\t" + image + "\t" + doc,
+		"2\tjames\t" + image + "\t\\N",
+		"3\tlucius\t\\N\t" + doc,
+		`\.`,
+		``,
+		`COPY public.images (id, user_id, image) FROM stdin;`,
+		"1\t1\t" + image,
+		`\.`,
+		``,
+		`COPY public.docs (id, user_id, name, doc) FROM stdin;`,
+		"1\t3\tlucius\t" + doc,
+		`\.`,
+		``,
+		`INSERT INTO public.notes VALUES (1, 'ariadne', '` + doc + `');`,
+		``,
+	}, "\n")
+
+	settings := `
+[["public.users"]]
+filter = "string replace"
+columns = ["name"]
+replacements = ["zachary"]
+notif = {"id" = "2"}
+
+[["public.users"]]
+filter = "file replace"
+columns = ["doc"]
+source = "testdata/newnotes.txt"
+if = {"name" = "zachary"}
+
+[["public.docs"]]
+filter = "reference replace"
+columns = ["name"]
+replacements = ["name"]
+optargs = {"fklookup" = ["user_id", "public.users.id"]}
+
+[["public.images"]]
+filter = "string replace"
+columns = ["user_id"]
+replacements = ["2"]
+
+[["public.notes"]]
+filter = "string replace"
+columns = ["name"]
+replacements = ["yael"]
+`
+	dumpFile := filepath.Join(t.TempDir(), "dump.sql")
+	if err := os.WriteFile(dumpFile, []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	}
+	buffer := bytes.NewBuffer(nil)
+	args := anonArgs{dumpFilePath: dumpFile, settingsToml: settings, output: buffer}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+
+	want := strings.Join([]string{
+		"-- " + doc,
+		`CREATE TABLE public.users (id integer, name text, image bytea, doc jsonb);`,
+		``,
+		`CREATE TABLE public.notes (`,
+		`    id integer,`,
+		`    name text,`,
+		`    doc jsonb`,
+		`);`,
+		``,
+		`COPY public.users (id, name, image, doc) FROM stdin;`,
+		"1\tzachary\t" + image + "\tthis is the first note",
+		"2\tjames\t" + image + "\t\\N",
+		"3\tzachary\t\\N\tthis is the first note",
+		`\.`,
+		``,
+		`COPY public.images (id, user_id, image) FROM stdin;`,
+		"1\t2\t" + image,
+		`\.`,
+		``,
+		`COPY public.docs (id, user_id, name, doc) FROM stdin;`,
+		"1\t3\tzachary\t" + doc,
+		`\.`,
+		``,
+		`INSERT INTO public.notes VALUES (1, 'yael', '` + doc + `');`,
+		``,
+	}, "\n")
+	if got := buffer.String(); got != want {
+		gotLines, wantLines := strings.Split(got, "\n"), strings.Split(want, "\n")
+		for i := range wantLines {
+			if i >= len(gotLines) || gotLines[i] != wantLines[i] {
+				t.Fatalf("output differs at line %d", i+1)
+			}
+		}
+		t.Fatalf("output has %d lines, expected %d", len(gotLines), len(wantLines))
+	}
+}
+
+func TestAnonymiseLongLinesFail(t *testing.T) {
+
+	// a long row with a missing field is refused as a short row is
+	doc := `{"a": "` + strings.Repeat("b", lineBufferSize*2) + `"}`
+	dump := strings.Join([]string{
+		`COPY public.users (id, name, image, doc) FROM stdin;`,
+		"1\tariadne\t" + doc,
+		`\.`,
+		``,
+	}, "\n")
+	settings := `
+[["public.users"]]
+filter = "string replace"
+columns = ["name"]
+replacements = ["zachary"]
+`
+	dumpFile := filepath.Join(t.TempDir(), "dump.sql")
+	if err := os.WriteFile(dumpFile, []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := anonArgs{dumpFilePath: dumpFile, settingsToml: settings, output: bytes.NewBuffer(nil)}
+	err := Anonymise(args)
+	if err == nil || !strings.Contains(err.Error(), "table public.users row 1 has 3 fields, expected 4") {
+		t.Errorf("expected a field count error, got %v", err)
+	}
+}
@@ -9,15 +9,26 @@ import (
 // tableFilters represent a map of fully qualified table names (in
 // schema.table format) to a list of filters represented by the common
 // interface used by filters, together with a slice of reference table
-// names, those tables referred to by other tables by reference filters
+// names, those tables referred to by other tables by reference filters,
+// and the register of the treatments of large objects
 type tableFilters struct {
 	refTableNames []string
 	tableFilters  map[string][]RowFilterer
+	largeObjects  *largeObjects
 }
 
-// getTableFilters gets the slice of RowFilterer filters for a table
+// getTableFilters gets the slice of RowFilterer filters for a table. A
+// table in a database of a pg_dumpall dump, such as mydb.public.users,
+// has the filters given for the table in that database if any,
+// otherwise those given for the table in any database
 func (t *tableFilters) getTableFilters(table string) []RowFilterer {
-	return t.tableFilters[table]
+	if filters, ok := t.tableFilters[table]; ok {
+		return filters
+	}
+	if database, name := splitDatabaseTableName(table); database != "" {
+		return t.tableFilters[name]
+	}
+	return nil
 }
 
 // getReferenceTables returns a map of external tables
@@ -33,6 +44,20 @@ func (t *tableFilters) getReferenceTables() map[string]int {
 	return et
 }
 
+// isReferenceTable reports if a table is a reference table, in the
+// same way as getTableFilters finds the filters of a table
+func (t *tableFilters) isReferenceTable(table string) bool {
+	et := t.getReferenceTables()
+	if _, ok := et[table]; ok {
+		return true
+	}
+	if database, name := splitDatabaseTableName(table); database != "" {
+		_, ok := et[name]
+		return ok
+	}
+	return false
+}
+
 // loadFilters loads a set of filters from a settings file and returns a
 // tableFilters struct
 func loadFilters(settings Settings) (tableFilters, error) {
@@ -40,20 +65,35 @@ func loadFilters(settings Settings) (tableFilters, error) {
 	tf := tableFilters{
 		refTableNames: []string{},
 		tableFilters:  map[string][]RowFilterer{},
+		largeObjects:  newLargeObjects(),
 	}
 
 	// retrieve filters for each table from settings
-	for tableName, filters := range settings {
+	for settingsName, filters := range settings {
 
 		rfs := []RowFilterer{}
 
 		if len(filters) == 0 {
-			return tf, fmt.Errorf("table '%s' could not be found in settings", tableName)
+			return tf, fmt.Errorf("table '%s' could not be found in settings", settingsName)
+		}
+
+		// identifiers in settings may be quoted
+		tableName, err := normaliseTableName(settingsName)
+		if err != nil {
+			return tf, fmt.Errorf("settings table '%s': %w", settingsName, err)
+		}
+		if _, ok := tf.tableFilters[tableName]; ok {
+			return tf, fmt.Errorf("table '%s' is given more than once in settings", settingsName)
 		}
 
 		// load filters
 		for _, f := range filters {
 
+			f, err := normaliseFilter(f)
+			if err != nil {
+				return tf, fmt.Errorf("%s filter for table '%s': %w", f.Filter, settingsName, err)
+			}
+
 			switch f.Filter {
 			case "delete":
 				filter, _ := NewDeleteFilter()
@@ -66,6 +106,72 @@ func loadFilters(settings Settings) (tableFilters, error) {
 				}
 				rfs = append(rfs, filter)
 
+			case "hash":
+				key, err := filterKey(f)
+				if err != nil {
+					return tf, fmt.Errorf("hash filter error: %w", err)
+				}
+				min, max, err := hashRange(f)
+				if err != nil {
+					return tf, fmt.Errorf("hash filter error: %w", err)
+				}
+				filter, err := NewHashFilter(f.Columns, f.Replacements, key, min, max, f.If, f.NotIf)
+				if err != nil {
+					return tf, fmt.Errorf("hash filter error: %w", err)
+				}
+				rfs = append(rfs, filter)
+
+			case "ff1 encrypt", "ff3-1 encrypt":
+				key, err := filterKey(f)
+				if err != nil {
+					return tf, fmt.Errorf("%s filter error: %w", f.Filter, err)
+				}
+				filter, err := NewEncryptFilter(f.Filter, f.Columns, f.Replacements, key, f.OptArgs["short"][0], f.If, f.NotIf)
+				if err != nil {
+					return tf, fmt.Errorf("%s filter error: %w", f.Filter, err)
+				}
+				rfs = append(rfs, filter)
+
+			case "fake":
+				locale, seed := fakeLocaleOption(f)
+				filter, err := NewFakeFilter(f.Columns, f.Replacements, locale, seed, f.If, f.NotIf)
+				if err != nil {
+					return tf, fmt.Errorf("fake filter error: %w", err)
+				}
+				rfs = append(rfs, filter)
+
+			case "fake person":
+				locale, seed := fakeLocaleOption(f)
+				var from [2]string
+				for i, c := range f.OptArgs["from"] {
+					if c == "" {
+						continue
+					}
+					if from[i], err = parseIdentifier(c); err != nil {
+						return tf, fmt.Errorf("fake person filter error: from column %s: %w", c, err)
+					}
+				}
+				filter, err := NewPersonFilter(f.Columns, f.Replacements, locale, seed, from[0], from[1], f.If, f.NotIf)
+				if err != nil {
+					return tf, fmt.Errorf("fake person filter error: %w", err)
+				}
+				rfs = append(rfs, filter)
+
+			case "email":
+				if len(f.If) > 0 || len(f.NotIf) > 0 {
+					return tf, errors.New("email filter error: if and notif conditions cannot be given, as the replacements must be unique in the table")
+				}
+				key, err := filterKey(f)
+				if err != nil {
+					return tf, fmt.Errorf("email filter error: %w", err)
+				}
+				domain := f.OptArgs["domain"]
+				filter, err := NewEmailFilter(f.Columns, domain[0], domain[1], key)
+				if err != nil {
+					return tf, fmt.Errorf("email filter error: %w", err)
+				}
+				rfs = append(rfs, filter)
+
 			case "string replace":
 				if len(f.Columns) < 1 {
 					return tf, errors.New("string replace filter: must provide at lease one column")
@@ -107,7 +213,7 @@ func loadFilters(settings Settings) (tableFilters, error) {
 
 				fk, ok := f.OptArgs["fklookup"]
 				if !ok {
-					fmt.Errorf("no optargs.fklookup provided for %s", tableName)
+					return tf, fmt.Errorf("no optargs.fklookup provided for %s", tableName)
 				}
 				fkKeyCol := fk[0]
 				fkValueCol := fk[1]
@@ -123,8 +229,34 @@ func loadFilters(settings Settings) (tableFilters, error) {
 				if err != nil {
 					return tf, fmt.Errorf("creation error for reference replace: %w", err)
 				}
+				// the reference table of a table in a database is in
+				// the same database
+				if database, _ := splitDatabaseTableName(tableName); database != "" {
+					filter.foreignTable = databaseTableName(database, filter.foreignTable)
+				}
 				rfs = append(rfs, &filter)
 
+			case "large object replace", "large object truncate", "large object drop":
+				var placeholder []byte
+				if f.Source != "" {
+					placeholder, err = os.ReadFile(f.Source)
+					if err != nil {
+						return tf, fmt.Errorf("%s filter error: %w", f.Filter, err)
+					}
+				}
+				filter, err := NewLargeObjectFilter(
+					f.Filter,
+					f.Columns,
+					placeholder,
+					f.If,
+					f.NotIf,
+					tf.largeObjects,
+				)
+				if err != nil {
+					return tf, fmt.Errorf("%s filter error for %s: %w", f.Filter, tableName, err)
+				}
+				rfs = append(rfs, filter)
+
 			default:
 				return tf, fmt.Errorf("filter type %s not known", f.Filter)
 			}
@@ -141,6 +273,59 @@ func loadFilters(settings Settings) (tableFilters, error) {
 	return tf, nil
 }
 
+// normaliseFilter returns a filter with the column names of its
+// settings, which may be quoted, in the unquoted form used for column
+// names, and with the values of its conditions and string replacements
+// decoded so that \N is NULL. The column names of the reference replace
+// filter's fklookup are parsed by the filter
+func normaliseFilter(f Filter) (Filter, error) {
+
+	var err error
+	normaliseColumns := func(columns []string) ([]string, error) {
+		normalised := make([]string, len(columns))
+		for i, c := range columns {
+			if normalised[i], err = parseIdentifier(c); err != nil {
+				return nil, fmt.Errorf("column %s: %w", c, err)
+			}
+		}
+		return normalised, nil
+	}
+	normaliseWhere := func(where map[string]string) (map[string]string, error) {
+		normalised := map[string]string{}
+		for c, v := range where {
+			n, err := parseIdentifier(c)
+			if err != nil {
+				return nil, fmt.Errorf("condition column %s: %w", c, err)
+			}
+			normalised[n] = settingsValue(v)
+		}
+		return normalised, nil
+	}
+
+	if f.Columns, err = normaliseColumns(f.Columns); err != nil {
+		return f, err
+	}
+	switch f.Filter {
+	case "reference replace":
+		if f.Replacements, err = normaliseColumns(f.Replacements); err != nil {
+			return f, err
+		}
+	case "string replace":
+		replacements := make([]string, len(f.Replacements))
+		for i, r := range f.Replacements {
+			replacements[i] = settingsValue(r)
+		}
+		f.Replacements = replacements
+	}
+	if f.If, err = normaliseWhere(f.If); err != nil {
+		return f, err
+	}
+	if f.NotIf, err = normaliseWhere(f.NotIf); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
 // check if the filters for each table are ok as a group, and calculate
 // the number of external references
 func (t *tableFilters) check() error {
@@ -153,6 +338,9 @@ func (t *tableFilters) check() error {
 	var sourceTables = make(map[string]int)
 
 	for table, filters := range t.tableFilters {
+		if err := t.checkLargeObjectFilters(table, filters); err != nil {
+			return err
+		}
 		l := len(filters)
 		for _, f := range filters {
 
@@ -167,7 +355,7 @@ func (t *tableFilters) check() error {
 
 			default:
 				if l == 0 {
-					fmt.Errorf("at least one filter expected for %s", table)
+					return fmt.Errorf("at least one filter expected for %s", table)
 				}
 			}
 		}
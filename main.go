@@ -1,12 +1,46 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
 
+	// run the pipeline command
+	if len(os.Args) > 1 && os.Args[1] == pipelineCommand {
+		args, err := parsePipelineFlags()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err = runPipeline(ctx, args)
+		stop()
+		if err != nil {
+			fmt.Printf("Pipeline error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// run the decrypt command
+	if len(os.Args) > 1 && os.Args[1] == decryptCommand {
+		args, err := parseDecryptFlags()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := runDecrypt(args, os.Stdin, os.Stdout); err != nil {
+			fmt.Printf("Decrypt error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// parse flags
 	args, err := parseFlags()
 	if err != nil {
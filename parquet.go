@@ -0,0 +1,826 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Parquet exports are typed by the columns of the CREATE TABLE
+// statement of each table in the dump. Integer, real and double
+// precision, boolean, date and timestamp columns are written as the
+// corresponding parquet types, timestamps in microseconds, and json
+// and jsonb columns as JSON strings. Arrays of one dimension are
+// written as lists of their element type; all other types, such as
+// numeric, uuid or enums, and columns without a definition are written
+// as strings. The infinite dates and timestamps of postgresql are
+// written as the smallest and largest values of their types, and the
+// dates of years before Christ or after 9999 as those of the proleptic
+// Gregorian calendar used by postgresql. Rows are
+// buffered in row groups of parquetRowGroupSize rows, or of the size
+// set by --row-group-size, and each column of a row group is written
+// as a single zstd compressed page.
+
+// parquetRowGroupSize is the default number of rows of a parquet row
+// group
+const parquetRowGroupSize = 50000
+
+// parquetMagic starts and ends a parquet file
+const parquetMagic = "PAR1"
+
+// parquet physical types
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetFloat     = 4
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// parquet converted types
+const (
+	convertedNone            = -1
+	convertedUTF8            = 0
+	convertedList            = 3
+	convertedDate            = 6
+	convertedTimestampMicros = 10
+	convertedInt16           = 16
+	convertedInt32           = 17
+	convertedInt64           = 18
+	convertedJSON            = 19
+)
+
+// parquet repetition types, encodings, codec and page type
+const (
+	repetitionOptional = 1
+	repetitionRepeated = 2
+	encodingPlain      = 0
+	encodingRLE        = 3
+	codecZstd          = 6
+	pageData           = 0
+)
+
+// parquetType is the parquet type of a postgresql column type, with a
+// function appending the plain encoding of a value
+type parquetType struct {
+	physical  int32
+	converted int32
+	logical   func(t *thriftWriter) // writes the logical type, if any
+	encode    func(c *parquetColumn, v string) error
+}
+
+// logical types, writing the field of the LogicalType union
+var (
+	logicalString = func(t *thriftWriter) { t.beginStruct(1); t.end() }
+	logicalList   = func(t *thriftWriter) { t.beginStruct(3); t.end() }
+	logicalDate   = func(t *thriftWriter) { t.beginStruct(6); t.end() }
+	logicalJSON   = func(t *thriftWriter) { t.beginStruct(12); t.end() }
+)
+
+// logicalTimestamp returns the logical type of a timestamp in
+// microseconds
+func logicalTimestamp(utc bool) func(t *thriftWriter) {
+	return func(t *thriftWriter) {
+		t.beginStruct(8)
+		t.boolean(1, utc)
+		t.beginStruct(2)
+		t.beginStruct(2) // MICROS
+		t.end()
+		t.end()
+		t.end()
+	}
+}
+
+// logicalInteger returns the logical type of a signed integer
+func logicalInteger(bitWidth int8) func(t *thriftWriter) {
+	return func(t *thriftWriter) {
+		t.beginStruct(10)
+		t.byteField(1, bitWidth)
+		t.boolean(2, true)
+		t.end()
+	}
+}
+
+var (
+	parquetStringType = &parquetType{physical: parquetByteArray, converted: convertedUTF8, logical: logicalString, encode: encodeByteArray}
+	parquetJSONType   = &parquetType{physical: parquetByteArray, converted: convertedJSON, logical: logicalJSON, encode: encodeByteArray}
+	parquetInt16Type  = &parquetType{physical: parquetInt32, converted: convertedInt16, logical: logicalInteger(16), encode: encodeInt(16)}
+	parquetInt32Type  = &parquetType{physical: parquetInt32, converted: convertedInt32, logical: logicalInteger(32), encode: encodeInt(32)}
+	parquetInt64Type  = &parquetType{physical: parquetInt64, converted: convertedInt64, logical: logicalInteger(64), encode: encodeInt(64)}
+	parquetFloatType  = &parquetType{physical: parquetFloat, converted: convertedNone, encode: encodeFloat(32)}
+	parquetDoubleType = &parquetType{physical: parquetDouble, converted: convertedNone, encode: encodeFloat(64)}
+	parquetBoolType   = &parquetType{physical: parquetBoolean, converted: convertedNone, encode: encodeBool}
+	parquetDateType   = &parquetType{physical: parquetInt32, converted: convertedDate, logical: logicalDate, encode: encodeDate}
+	// timestamps with a time zone are adjusted to UTC, those without are
+	// local, for which there is no converted type
+	parquetTimestampTZType = &parquetType{physical: parquetInt64, converted: convertedTimestampMicros, logical: logicalTimestamp(true), encode: encodeTimestamp(true)}
+	parquetTimestampType   = &parquetType{physical: parquetInt64, converted: convertedNone, logical: logicalTimestamp(false), encode: encodeTimestamp(false)}
+)
+
+// parquetTypes are the parquet types of postgresql column types, as
+// written by pg_dump without type modifiers, and their aliases
+var parquetTypes = map[string]*parquetType{
+	"smallint":                    parquetInt16Type,
+	"int2":                        parquetInt16Type,
+	"integer":                     parquetInt32Type,
+	"int":                         parquetInt32Type,
+	"int4":                        parquetInt32Type,
+	"bigint":                      parquetInt64Type,
+	"int8":                        parquetInt64Type,
+	"real":                        parquetFloatType,
+	"float4":                      parquetFloatType,
+	"double precision":            parquetDoubleType,
+	"float8":                      parquetDoubleType,
+	"boolean":                     parquetBoolType,
+	"bool":                        parquetBoolType,
+	"date":                        parquetDateType,
+	"timestamp with time zone":    parquetTimestampTZType,
+	"timestamptz":                 parquetTimestampTZType,
+	"timestamp without time zone": parquetTimestampType,
+	"timestamp":                   parquetTimestampType,
+	"json":                        parquetJSONType,
+	"jsonb":                       parquetJSONType,
+}
+
+// parquetColumnType returns the parquet type of a column type from a
+// table definition, and if it is an array, ignoring type modifiers
+// such as those of "timestamp(3) with time zone" or "integer[]"
+func parquetColumnType(columnType string) (*parquetType, bool) {
+
+	typ := strings.ToLower(strings.TrimSpace(columnType))
+	array := false
+	for strings.HasSuffix(typ, "]") {
+		i := strings.LastIndex(typ, "[")
+		if i < 0 {
+			break
+		}
+		typ, array = strings.TrimSpace(typ[:i]), true
+	}
+	if i := strings.Index(typ, "("); i >= 0 {
+		if j := strings.Index(typ[i:], ")"); j >= 0 {
+			typ = typ[:i] + typ[i+j+1:]
+		}
+	}
+	typ = strings.Join(strings.Fields(typ), " ")
+	if pt, ok := parquetTypes[typ]; ok {
+		return pt, array
+	}
+	return parquetStringType, array
+}
+
+// parquetColumn buffers the levels and plain encoded values of a column
+// of a row group
+type parquetColumn struct {
+	name      string
+	typ       *parquetType
+	list      bool    // the column is a list of one dimensional arrays
+	defs      []uint8 // definition levels
+	reps      []uint8 // repetition levels of lists
+	values    bytes.Buffer
+	bools     int // the number of bit packed boolean values
+	numValues int // the number of values, including nulls
+}
+
+// add adds a value of the column, or its elements if it is a list. The
+// definition level of a value is 0 for a NULL and 1 otherwise, and for
+// a list 1 if it is empty, 2 for a NULL element and 3 for an element
+func (c *parquetColumn) add(v string) error {
+	if !c.list {
+		c.numValues++
+		if v == nullValue {
+			c.defs = append(c.defs, 0)
+			return nil
+		}
+		c.defs = append(c.defs, 1)
+		return c.typ.encode(c, v)
+	}
+
+	if v == nullValue {
+		c.numValues++
+		c.defs, c.reps = append(c.defs, 0), append(c.reps, 0)
+		return nil
+	}
+	elements, err := parseArray(v)
+	if err != nil {
+		return err
+	}
+	if len(elements) == 0 {
+		c.numValues++
+		c.defs, c.reps = append(c.defs, 1), append(c.reps, 0)
+		return nil
+	}
+	for i, e := range elements {
+		c.numValues++
+		rep := uint8(1)
+		if i == 0 {
+			rep = 0
+		}
+		c.reps = append(c.reps, rep)
+		if e == nullValue {
+			c.defs = append(c.defs, 2)
+			continue
+		}
+		c.defs = append(c.defs, 3)
+		if err := c.typ.encode(c, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reset empties the column for the next row group
+func (c *parquetColumn) reset() {
+	c.defs, c.reps = c.defs[:0], c.reps[:0]
+	c.values.Reset()
+	c.bools, c.numValues = 0, 0
+}
+
+// path returns the schema path of the column's values
+func (c *parquetColumn) path() []string {
+	if c.list {
+		return []string{c.name, "list", "element"}
+	}
+	return []string{c.name}
+}
+
+// writeUint32 appends a little endian 32 bit value
+func (c *parquetColumn) writeUint32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	c.values.Write(b[:])
+}
+
+// writeUint64 appends a little endian 64 bit value
+func (c *parquetColumn) writeUint64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	c.values.Write(b[:])
+}
+
+// encodeByteArray appends a plain encoded string
+func encodeByteArray(c *parquetColumn, v string) error {
+	c.writeUint32(uint32(len(v)))
+	c.values.WriteString(v)
+	return nil
+}
+
+// encodeInt returns a function appending a plain encoded integer of a
+// bit size
+func encodeInt(bitSize int) func(c *parquetColumn, v string) error {
+	return func(c *parquetColumn, v string) error {
+		i, err := strconv.ParseInt(v, 10, bitSize)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", v)
+		}
+		if bitSize == 64 {
+			c.writeUint64(uint64(i))
+		} else {
+			c.writeUint32(uint32(i))
+		}
+		return nil
+	}
+}
+
+// encodeFloat returns a function appending a plain encoded floating
+// point number of a bit size, including NaN and Infinity
+func encodeFloat(bitSize int) func(c *parquetColumn, v string) error {
+	return func(c *parquetColumn, v string) error {
+		f, err := strconv.ParseFloat(v, bitSize)
+		if err != nil {
+			return fmt.Errorf("invalid floating point number %q", v)
+		}
+		if bitSize == 32 {
+			c.writeUint32(math.Float32bits(float32(f)))
+		} else {
+			c.writeUint64(math.Float64bits(f))
+		}
+		return nil
+	}
+}
+
+// encodeBool appends a bit packed boolean
+func encodeBool(c *parquetColumn, v string) error {
+	if v != "t" && v != "f" {
+		return fmt.Errorf("invalid boolean %q", v)
+	}
+	if c.bools%8 == 0 {
+		c.values.WriteByte(0)
+	}
+	if v == "t" {
+		b := c.values.Bytes()
+		b[len(b)-1] |= 1 << (c.bools % 8)
+	}
+	c.bools++
+	return nil
+}
+
+// encodeDate appends a date in days since the epoch
+func encodeDate(c *parquetColumn, v string) error {
+	var days int32
+	switch v {
+	case "infinity":
+		days = math.MaxInt32
+	case "-infinity":
+		days = math.MinInt32
+	default:
+		year, date, err := splitYear(v)
+		if err != nil {
+			return fmt.Errorf("invalid date %q", v)
+		}
+		t, err := time.Parse("2006-01-02", date)
+		if err != nil {
+			return fmt.Errorf("invalid date %q", v)
+		}
+		days = int32(math.Floor(float64(withYear(t, year).Unix()) / 86400))
+	}
+	c.writeUint32(uint32(days))
+	return nil
+}
+
+// timestampLayouts are the layouts of timestamps in the ISO DateStyle
+// of dumps, with time zone offsets of hours, minutes or seconds
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999-07",
+	"2006-01-02 15:04:05.999999-07:00",
+	"2006-01-02 15:04:05.999999-07:00:00",
+}
+
+// encodeTimestamp returns a function appending a timestamp in
+// microseconds since the epoch, with or without a time zone
+func encodeTimestamp(tz bool) func(c *parquetColumn, v string) error {
+	return func(c *parquetColumn, v string) error {
+		var micros int64
+		switch v {
+		case "infinity":
+			micros = math.MaxInt64
+		case "-infinity":
+			micros = math.MinInt64
+		default:
+			t, err := parseTimestamp(v, tz)
+			if err != nil {
+				return err
+			}
+			micros = t.UnixMicro()
+		}
+		c.writeUint64(uint64(micros))
+		return nil
+	}
+}
+
+// parseTimestamp parses a timestamp, with or without a time zone
+func parseTimestamp(v string, tz bool) (time.Time, error) {
+	year, timestamp, err := splitYear(v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", v)
+	}
+	if !tz {
+		t, err := time.Parse("2006-01-02 15:04:05.999999", timestamp)
+		if err != nil {
+			return t, fmt.Errorf("invalid timestamp %q", v)
+		}
+		return withYear(t, year), nil
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, timestamp); err == nil {
+			return withYear(t, year), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", v)
+}
+
+// splitYear returns the year of a date or timestamp in the ISO
+// DateStyle, which may have more than four digits or be followed by
+// BC, such as 0044-03-15 BC, and the value with the year replaced by
+// 2000, a leap year, so that it can be parsed by a layout. Years before
+// Christ are returned as astronomical years, with 1 BC as year 0.
+func splitYear(v string) (int, string, error) {
+	bc := strings.HasSuffix(v, " BC")
+	v = strings.TrimSuffix(v, " BC")
+	i := strings.IndexByte(v, '-')
+	if i < 4 {
+		return 0, "", fmt.Errorf("invalid year in %q", v)
+	}
+	year, err := strconv.Atoi(v[:i])
+	if err != nil || year < 1 {
+		return 0, "", fmt.Errorf("invalid year in %q", v)
+	}
+	if bc {
+		year = 1 - year
+	}
+	return year, "2000" + v[i:], nil
+}
+
+// withYear returns a time parsed by splitYear in its year
+func withYear(t time.Time, year int) time.Time {
+	return time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// errMultidimensionalArray is returned for an array value of more than
+// one dimension
+var errMultidimensionalArray = errors.New("multidimensional arrays cannot be exported to parquet")
+
+// parseArray parses the elements of a one dimensional postgresql array
+// value, such as {1,NULL,"a b"}, returning NULL elements as nullValue
+func parseArray(v string) ([]string, error) {
+
+	// arrays with lower bounds other than one are decorated with their
+	// dimensions, such as [0:1]={1,2}
+	if strings.HasPrefix(v, "[") {
+		if i := strings.Index(v, "="); i >= 0 {
+			v = v[i+1:]
+		}
+	}
+	if len(v) < 2 || v[0] != '{' || v[len(v)-1] != '}' {
+		return nil, fmt.Errorf("invalid array %q", v)
+	}
+	v = v[1 : len(v)-1]
+	if v == "" {
+		return []string{}, nil
+	}
+
+	var elements []string
+	var b strings.Builder
+	quoted, inQuotes, escaped := false, false, false
+	for i := 0; i < len(v); i++ {
+		ch := v[i]
+		switch {
+		case escaped:
+			b.WriteByte(ch)
+			escaped = false
+		case ch == '\\':
+			escaped = true
+		case ch == '"':
+			inQuotes, quoted = !inQuotes, true
+		case inQuotes:
+			b.WriteByte(ch)
+		case ch == '{':
+			return nil, errMultidimensionalArray
+		case ch == ',':
+			elements = append(elements, arrayElement(b.String(), quoted))
+			b.Reset()
+			quoted = false
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	if inQuotes || escaped {
+		return nil, fmt.Errorf("invalid array %q", v)
+	}
+	return append(elements, arrayElement(b.String(), quoted)), nil
+}
+
+// arrayElement returns an array element, or nullValue for an unquoted
+// NULL
+func arrayElement(e string, quoted bool) string {
+	if !quoted && strings.EqualFold(e, "NULL") {
+		return nullValue
+	}
+	return e
+}
+
+// parquetWriter writes the rows of a table to a parquet file in row
+// groups
+type parquetWriter struct {
+	w            io.Writer
+	table        string
+	columns      []*parquetColumn
+	rowGroupSize int
+	rows         int // rows in the current row group
+	totalRows    int64
+	offset       int64 // the offset of the next write
+	rowGroups    [][]byte
+	encoder      *zstd.Encoder
+}
+
+// newParquetWriter makes a parquet writer for the columns of a table,
+// typed by their types in the table definition, if known
+func newParquetWriter(w io.Writer, table string, columns []string, td *tableDefinition, rowGroupSize int) (*parquetWriter, error) {
+
+	types := map[string]string{}
+	if td != nil {
+		for i, name := range td.columnNames {
+			types[name] = td.columnTypes[i]
+		}
+	}
+	if rowGroupSize <= 0 {
+		rowGroupSize = parquetRowGroupSize
+	}
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	p := &parquetWriter{w: w, table: table, rowGroupSize: rowGroupSize, encoder: encoder}
+	for _, name := range columns {
+		typ, list := parquetColumnType(types[name])
+		p.columns = append(p.columns, &parquetColumn{name: name, typ: typ, list: list})
+	}
+	return p, p.write([]byte(parquetMagic))
+}
+
+// write writes to the file, recording the offset
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+// writeRow adds a row of UTF-8 values, writing a row group when it is
+// full
+func (p *parquetWriter) writeRow(values []string) error {
+	if len(values) != len(p.columns) {
+		return fmt.Errorf("%d values for %d columns", len(values), len(p.columns))
+	}
+	for i, v := range values {
+		if err := p.columns[i].add(v); err != nil {
+			return fmt.Errorf("table %s row %d column %s: %w", p.table, p.totalRows+int64(p.rows)+1, p.columns[i].name, err)
+		}
+	}
+	p.rows++
+	if p.rows >= p.rowGroupSize {
+		return p.writeRowGroup()
+	}
+	return nil
+}
+
+// writeRowGroup writes the buffered rows as a row group, with a page
+// for each column
+func (p *parquetWriter) writeRowGroup() error {
+
+	rg := &thriftWriter{}
+	rg.list(1, thriftStruct, len(p.columns))
+	var totalSize int64
+	for _, c := range p.columns {
+
+		var page bytes.Buffer
+		if c.list {
+			writeLevels(&page, c.reps)
+		}
+		writeLevels(&page, c.defs)
+		page.Write(c.values.Bytes())
+		compressed := p.encoder.EncodeAll(page.Bytes(), nil)
+
+		header := &thriftWriter{}
+		header.i32(1, pageData)
+		header.i32(2, int32(page.Len()))
+		header.i32(3, int32(len(compressed)))
+		header.beginStruct(5)
+		header.i32(1, int32(c.numValues))
+		header.i32(2, encodingPlain)
+		header.i32(3, encodingRLE)
+		header.i32(4, encodingRLE)
+		header.end()
+		header.end()
+
+		offset := p.offset
+		if err := p.write(header.b); err != nil {
+			return err
+		}
+		if err := p.write(compressed); err != nil {
+			return err
+		}
+		uncompressedSize := int64(len(header.b) + page.Len())
+		totalSize += uncompressedSize
+
+		// the column chunk
+		rg.push()
+		rg.i64(2, offset)
+		rg.beginStruct(3)
+		rg.i32(1, c.typ.physical)
+		rg.list(2, thriftI32, 2)
+		rg.listI32(encodingPlain)
+		rg.listI32(encodingRLE)
+		path := c.path()
+		rg.list(3, thriftBinary, len(path))
+		for _, s := range path {
+			rg.listBinary(s)
+		}
+		rg.i32(4, codecZstd)
+		rg.i64(5, int64(c.numValues))
+		rg.i64(6, uncompressedSize)
+		rg.i64(7, int64(len(header.b)+len(compressed)))
+		rg.i64(9, offset)
+		rg.end()
+		rg.end()
+		c.reset()
+	}
+	rg.i64(2, totalSize)
+	rg.i64(3, int64(p.rows))
+	p.rowGroups = append(p.rowGroups, rg.b)
+	p.totalRows += int64(p.rows)
+	p.rows = 0
+	return nil
+}
+
+// writeLevels writes levels with the RLE encoding, prefixed by their
+// length, as runs of repeated levels. Levels of up to three have a bit
+// width of at most two, so the level of each run is a single byte
+func writeLevels(w *bytes.Buffer, levels []uint8) {
+	var b []byte
+	for i := 0; i < len(levels); {
+		j := i + 1
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		b = appendUvarint(b, uint64(j-i)<<1)
+		b = append(b, levels[i])
+		i = j
+	}
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(b)))
+	w.Write(n[:])
+	w.Write(b)
+}
+
+// schemaElement writes a schema element
+func schemaElement(t *thriftWriter, name string, typ *parquetType, repetition int32, children int, converted int32, logical func(*thriftWriter)) {
+	t.push()
+	if typ != nil {
+		t.i32(1, typ.physical)
+	}
+	if repetition >= 0 {
+		t.i32(3, repetition)
+	}
+	t.binary(4, name)
+	if children > 0 {
+		t.i32(5, int32(children))
+	}
+	if converted != convertedNone {
+		t.i32(6, converted)
+	}
+	if logical != nil {
+		t.beginStruct(10)
+		logical(t)
+		t.end()
+	}
+	t.end()
+}
+
+// close writes any buffered rows and the file metadata
+func (p *parquetWriter) close() error {
+
+	defer p.encoder.Close()
+	if p.rows > 0 {
+		if err := p.writeRowGroup(); err != nil {
+			return err
+		}
+	}
+
+	elements := 1
+	for _, c := range p.columns {
+		elements++
+		if c.list {
+			elements += 2
+		}
+	}
+	t := &thriftWriter{}
+	t.i32(1, 1)
+	t.list(2, thriftStruct, elements)
+	schemaElement(t, "schema", nil, -1, len(p.columns), convertedNone, nil)
+	for _, c := range p.columns {
+		if !c.list {
+			schemaElement(t, c.name, c.typ, repetitionOptional, 0, c.typ.converted, c.typ.logical)
+			continue
+		}
+		schemaElement(t, c.name, nil, repetitionOptional, 1, convertedList, logicalList)
+		schemaElement(t, "list", nil, repetitionRepeated, 1, convertedNone, nil)
+		schemaElement(t, "element", c.typ, repetitionOptional, 0, c.typ.converted, c.typ.logical)
+	}
+	t.i64(3, p.totalRows)
+	t.list(4, thriftStruct, len(p.rowGroups))
+	for _, rg := range p.rowGroups {
+		t.push()
+		t.b = append(t.b, rg...)
+		t.end()
+	}
+	t.binary(6, "gopg-anonymise")
+	t.end()
+
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(t.b)))
+	for _, b := range [][]byte{t.b, n[:], []byte(parquetMagic)} {
+		if err := p.write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// thrift compact protocol types
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the structs of parquet file metadata with the
+// thrift compact protocol
+type thriftWriter struct {
+	b     []byte
+	id    int16   // the id of the last field of the current struct
+	stack []int16 // the last field ids of enclosing structs
+}
+
+// field writes a field header
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.id; delta > 0 && delta <= 15 {
+		t.b = append(t.b, byte(delta)<<4|typ)
+	} else {
+		t.b = append(t.b, typ)
+		t.b = appendVarint(t.b, int64(id))
+	}
+	t.id = id
+}
+
+// push starts a struct, as a field or a list element
+func (t *thriftWriter) push() {
+	t.stack = append(t.stack, t.id)
+	t.id = 0
+}
+
+// end ends a struct
+func (t *thriftWriter) end() {
+	t.b = append(t.b, 0)
+	if len(t.stack) > 0 {
+		t.id = t.stack[len(t.stack)-1]
+		t.stack = t.stack[:len(t.stack)-1]
+	}
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.push()
+}
+
+func (t *thriftWriter) boolean(id int16, v bool) {
+	if v {
+		t.field(id, thriftTrue)
+	} else {
+		t.field(id, thriftFalse)
+	}
+}
+
+func (t *thriftWriter) byteField(id int16, v int8) {
+	t.field(id, thriftByte)
+	t.b = append(t.b, byte(v))
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.b = appendVarint(t.b, int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.b = appendVarint(t.b, v)
+}
+
+func (t *thriftWriter) binary(id int16, v string) {
+	t.field(id, thriftBinary)
+	t.listBinary(v)
+}
+
+// list writes the header of a list of n elements of a type
+func (t *thriftWriter) list(id int16, typ byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.b = append(t.b, byte(n)<<4|typ)
+		return
+	}
+	t.b = append(t.b, 0xf0|typ)
+	t.b = appendUvarint(t.b, uint64(n))
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.b = appendVarint(t.b, int64(v))
+}
+
+func (t *thriftWriter) listBinary(v string) {
+	t.b = appendUvarint(t.b, uint64(len(v)))
+	t.b = append(t.b, v...)
+}
+
+// appendVarint appends a zigzag encoded varint
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+// appendUvarint appends a varint
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+func TestParquetColumnType(t *testing.T) {
+
+	tests := []struct {
+		columnType string
+		want       *parquetType
+		array      bool
+	}{
+		{"integer", parquetInt32Type, false},
+		{"bigint", parquetInt64Type, false},
+		{"smallint", parquetInt16Type, false},
+		{"double precision", parquetDoubleType, false},
+		{"boolean", parquetBoolType, false},
+		{"timestamp(3) with time zone", parquetTimestampTZType, false},
+		{"timestamp without time zone", parquetTimestampType, false},
+		{"jsonb", parquetJSONType, false},
+		{"text[]", parquetStringType, true},
+		{"integer[][]", parquetInt32Type, true},
+		{"character varying(20)[]", parquetStringType, true},
+		{"numeric(10,2)", parquetStringType, false},
+		{"public.mood", parquetStringType, false},
+		{"", parquetStringType, false},
+	}
+	for _, tc := range tests {
+		got, array := parquetColumnType(tc.columnType)
+		if got != tc.want || array != tc.array {
+			t.Errorf("%s: unexpected type %+v array %t", tc.columnType, got, array)
+		}
+	}
+}
+
+func TestParseArray(t *testing.T) {
+
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"{}", []string{}},
+		{"{1,2,3}", []string{"1", "2", "3"}},
+		{`{a,NULL,"NULL","b c","d,e","f\"g","h\\i"}`, []string{"a", nullValue, "NULL", "b c", "d,e", `f"g`, `h\i`}},
+		{`{""}`, []string{""}},
+		{"[0:1]={7,8}", []string{"7", "8"}},
+	}
+	for _, tc := range tests {
+		got, err := parseArray(tc.value)
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected %q got %q %v", tc.value, tc.want, got, err)
+		}
+	}
+	for _, v := range []string{"1,2", `{"a}`, "{{1,2},{3,4}}"} {
+		if _, err := parseArray(v); err == nil {
+			t.Errorf("%s: expected an error", v)
+		}
+	}
+}
+
+func TestParquetDates(t *testing.T) {
+
+	dates := []struct {
+		value string
+		days  int32
+	}{
+		{"1970-01-01", 0},
+		{"1969-12-31", -1},
+		{"0001-01-01", -719162},
+		{"0001-12-31 BC", -719163},
+		{"0001-02-29 BC", -719469},
+		{"0044-03-15 BC", -735160},
+		{"9999-12-31", 2932896},
+		{"10000-01-01", 2932897},
+	}
+	for _, tc := range dates {
+		c := parquetColumn{typ: parquetDateType}
+		if err := encodeDate(&c, tc.value); err != nil {
+			t.Errorf("%s: unexpected error %s", tc.value, err)
+			continue
+		}
+		if got := int32(binary.LittleEndian.Uint32(c.values.Bytes())); got != tc.days {
+			t.Errorf("%s: expected %d days got %d", tc.value, tc.days, got)
+		}
+	}
+
+	timestamps := []struct {
+		value  string
+		tz     bool
+		micros int64
+	}{
+		{"0044-03-15 12:00:00 BC", false, -735160*86400e6 + 12*3600e6},
+		{"0044-03-15 12:00:00.5+01 BC", true, -735160*86400e6 + 11*3600e6 + 5e5},
+		{"12345-06-07 01:02:03", false, 327416950923e6},
+	}
+	for _, tc := range timestamps {
+		c := parquetColumn{}
+		if err := encodeTimestamp(tc.tz)(&c, tc.value); err != nil {
+			t.Errorf("%s: unexpected error %s", tc.value, err)
+			continue
+		}
+		if got := int64(binary.LittleEndian.Uint64(c.values.Bytes())); got != tc.micros {
+			t.Errorf("%s: expected %d microseconds got %d", tc.value, tc.micros, got)
+		}
+	}
+
+	for _, v := range []string{"44-03-15", "0000-01-01", "0044-03-15 AD", "2024-02-30"} {
+		c := parquetColumn{}
+		if err := encodeDate(&c, v); err == nil {
+			t.Errorf("%s: expected an error", v)
+		}
+	}
+}
+
+// thriftReader decodes thrift compact protocol structs as maps of field
+// ids to values, for checking parquet metadata
+type thriftReader struct {
+	t *testing.T
+	r *bytes.Reader
+}
+
+func (tr *thriftReader) varint() int64 {
+	v, err := binary.ReadVarint(tr.r)
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	return v
+}
+
+func (tr *thriftReader) uvarint() uint64 {
+	v, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	return v
+}
+
+func (tr *thriftReader) readByte() byte {
+	b, err := tr.r.ReadByte()
+	if err != nil {
+		tr.t.Fatal(err)
+	}
+	return b
+}
+
+func (tr *thriftReader) readStruct() map[int16]interface{} {
+	s := map[int16]interface{}{}
+	var id int16
+	for {
+		b := tr.readByte()
+		if b == 0 {
+			return s
+		}
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(tr.varint())
+		}
+		s[id] = tr.readValue(b & 0x0f)
+	}
+}
+
+func (tr *thriftReader) readValue(typ byte) interface{} {
+	switch typ {
+	case thriftTrue:
+		return true
+	case thriftFalse:
+		return false
+	case thriftByte:
+		return int64(int8(tr.readByte()))
+	case thriftI32, thriftI64:
+		return tr.varint()
+	case thriftBinary:
+		b := make([]byte, tr.uvarint())
+		tr.r.Read(b)
+		return string(b)
+	case thriftList:
+		h := tr.readByte()
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(tr.uvarint())
+		}
+		l := []interface{}{}
+		for i := 0; i < n; i++ {
+			l = append(l, tr.readValue(h&0x0f))
+		}
+		return l
+	case thriftStruct:
+		return tr.readStruct()
+	}
+	tr.t.Fatalf("unexpected thrift type %d", typ)
+	return nil
+}
+
+// parquetFile is a decoded parquet export, with the rows of each column
+// as int64, float64, bool or string values, lists of values or nil
+type parquetFile struct {
+	metadata  map[int16]interface{}
+	rowGroups []int64 // the number of rows of each row group
+	columns   map[string][]interface{}
+}
+
+// readParquet decodes a parquet file written by parquetWriter
+func readParquet(t *testing.T, b []byte) parquetFile {
+	t.Helper()
+
+	if !bytes.HasPrefix(b, []byte(parquetMagic)) || !bytes.HasSuffix(b, []byte(parquetMagic)) {
+		t.Fatal("missing parquet magic")
+	}
+	n := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	tr := &thriftReader{t: t, r: bytes.NewReader(b[len(b)-8-n : len(b)-8])}
+	pf := parquetFile{metadata: tr.readStruct(), columns: map[string][]interface{}{}}
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+
+	for _, rg := range pf.metadata[4].([]interface{}) {
+		rowGroup := rg.(map[int16]interface{})
+		pf.rowGroups = append(pf.rowGroups, rowGroup[3].(int64))
+		for _, cc := range rowGroup[1].([]interface{}) {
+			meta := cc.(map[int16]interface{})[3].(map[int16]interface{})
+			path := meta[3].([]interface{})
+			name, list := path[0].(string), len(path) > 1
+
+			r := bytes.NewReader(b[meta[9].(int64):])
+			header := (&thriftReader{t: t, r: r}).readStruct()
+			compressed := make([]byte, header[3].(int64))
+			r.Read(compressed)
+			page, err := decoder.DecodeAll(compressed, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			numValues := int(header[5].(map[int16]interface{})[1].(int64))
+
+			var reps []uint8
+			if list {
+				reps, page = readLevels(t, page, numValues)
+			}
+			defs, page := readLevels(t, page, numValues)
+			values := &plainValues{page: page, typ: meta[1].(int64)}
+			var row []interface{}
+			for i, def := range defs {
+				var v interface{}
+				switch {
+				case list && def == 0:
+				case list && def == 1:
+					v = []interface{}{}
+				case list && def == 2:
+					v = []interface{}{nil}
+				case list:
+					v = []interface{}{values.next()}
+				case def == 1:
+					v = values.next()
+				}
+				if list && reps[i] == 1 {
+					row[len(row)-1] = append(row[len(row)-1].([]interface{}), v.([]interface{})...)
+					continue
+				}
+				row = append(row, v)
+			}
+			pf.columns[name] = append(pf.columns[name], row...)
+		}
+	}
+	return pf
+}
+
+// readLevels decodes RLE encoded levels, returning the remainder of the
+// page
+func readLevels(t *testing.T, page []byte, n int) ([]uint8, []byte) {
+	t.Helper()
+	size := binary.LittleEndian.Uint32(page)
+	r := bytes.NewReader(page[4 : 4+size])
+	var levels []uint8
+	for r.Len() > 0 {
+		h, _ := binary.ReadUvarint(r)
+		if h&1 == 1 {
+			t.Fatal("unexpected bit packed levels")
+		}
+		level, _ := r.ReadByte()
+		for i := uint64(0); i < h>>1; i++ {
+			levels = append(levels, level)
+		}
+	}
+	if len(levels) != n {
+		t.Fatalf("expected %d levels, got %d", n, len(levels))
+	}
+	return levels, page[4+size:]
+}
+
+// plainValues decodes plain encoded values
+type plainValues struct {
+	page  []byte
+	typ   int64
+	bools int
+}
+
+func (p *plainValues) next() interface{} {
+	switch p.typ {
+	case parquetBoolean:
+		v := p.page[p.bools/8]&(1<<(p.bools%8)) != 0
+		p.bools++
+		return v
+	case parquetInt32:
+		v := int64(int32(binary.LittleEndian.Uint32(p.page)))
+		p.page = p.page[4:]
+		return v
+	case parquetInt64:
+		v := int64(binary.LittleEndian.Uint64(p.page))
+		p.page = p.page[8:]
+		return v
+	case parquetFloat:
+		v := float64(math.Float32frombits(binary.LittleEndian.Uint32(p.page)))
+		p.page = p.page[4:]
+		return v
+	case parquetDouble:
+		v := math.Float64frombits(binary.LittleEndian.Uint64(p.page))
+		p.page = p.page[8:]
+		return v
+	}
+	n := binary.LittleEndian.Uint32(p.page)
+	v := string(p.page[4 : 4+n])
+	p.page = p.page[4+n:]
+	return v
+}
+
+var parquetDump = []string{
+	"CREATE TABLE public.events (",
+	"    id bigint NOT NULL,",
+	"    name character varying(20),",
+	"    score double precision,",
+	"    active boolean,",
+	"    day date,",
+	"    created timestamp(3) with time zone DEFAULT now(),",
+	"    data jsonb,",
+	"    tags text[],",
+	"    counts integer[]",
+	");",
+	"",
+	"COPY public.events (id, name, score, active, day, created, data, tags, counts) FROM stdin;",
+	"1\tann\t1.5\tt\t2024-02-29\t2024-03-01 12:00:00.25+01\t{\"a\": 1}\t{x,NULL,\"y z\"}\t{1,2}",
+	"2\t\\N\t\\N\t\\N\t\\N\t\\N\t\\N\t\\N\t\\N",
+	"3\tbea\tNaN\tf\t1969-12-31\tinfinity\t[]\t{}\t{NULL}",
+	`\.`,
+}
+
+const parquetSettings = `
+[["public.events"]]
+filter = "string replace"
+columns = ["name"]
+replacements = ["zoë"]
+notif = {"id" = "2"}
+`
+
+func TestAnonymiseParquetExport(t *testing.T) {
+
+	dir := t.TempDir()
+	args := anonArgs{
+		dumpFilePath:       versionDump(t, "16.2", parquetDump...),
+		settingsToml:       parquetSettings,
+		output:             bytes.NewBuffer(nil),
+		exportDir:          dir,
+		exportFormat:       exportParquet,
+		exportRowGroupSize: 2,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "public.events.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf := readParquet(t, b)
+
+	if pf.metadata[3].(int64) != 3 || !reflect.DeepEqual(pf.rowGroups, []int64{2, 1}) {
+		t.Errorf("expected 3 rows in row groups of 2 and 1, got %d %v", pf.metadata[3], pf.rowGroups)
+	}
+
+	// the name, physical type, repetition and converted type of each
+	// schema element, and if it has a logical type
+	var schema []string
+	for _, e := range pf.metadata[2].([]interface{}) {
+		se := e.(map[int16]interface{})
+		s := []string{se[4].(string)}
+		for _, id := range []int16{1, 3, 6} {
+			if v, ok := se[id]; ok {
+				s = append(s, fmt.Sprint(v))
+			} else {
+				s = append(s, "-")
+			}
+		}
+		if _, ok := se[10]; ok {
+			s = append(s, "logical")
+		}
+		schema = append(schema, strings.Join(s, " "))
+	}
+	wantSchema := []string{
+		"schema - - -",
+		"id 2 1 18 logical",
+		"name 6 1 0 logical",
+		"score 5 1 -",
+		"active 0 1 -",
+		"day 1 1 6 logical",
+		"created 2 1 10 logical",
+		"data 6 1 19 logical",
+		"tags - 1 3 logical",
+		"list - 2 -",
+		"element 6 1 0 logical",
+		"counts - 1 3 logical",
+		"list - 2 -",
+		"element 1 1 17 logical",
+	}
+	if !reflect.DeepEqual(schema, wantSchema) {
+		t.Errorf("expected schema\n%q\ngot\n%q", wantSchema, schema)
+	}
+
+	created := int64(1709290800250000) // 2024-03-01 11:00:00.25 UTC
+	wantColumns := map[string][]interface{}{
+		"id":      {int64(1), int64(2), int64(3)},
+		"name":    {"zoë", nil, "zoë"},
+		"score":   {1.5, nil, math.NaN()},
+		"active":  {true, nil, false},
+		"day":     {int64(19782), nil, int64(-1)},
+		"created": {created, nil, int64(math.MaxInt64)},
+		"data":    {`{"a": 1}`, nil, "[]"},
+		"tags":    {[]interface{}{"x", nil, "y z"}, nil, []interface{}{}},
+		"counts":  {[]interface{}{int64(1), int64(2)}, nil, []interface{}{nil}},
+	}
+	for name, want := range wantColumns {
+		got := pf.columns[name]
+		if name == "score" {
+			if len(got) != 3 || got[0] != 1.5 || got[1] != nil || !math.IsNaN(got[2].(float64)) {
+				t.Errorf("score: unexpected values %v", got)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %#v got %#v", name, want, got)
+		}
+	}
+}
+
+// parquetGolden is the export of parquetDump in row groups of 2 rows,
+// checked by an independent parquet reader. It is rewritten by copying
+// the file exported by TestParquetGolden if the writer changes.
+const parquetGolden = "testdata/parquet/public.events.parquet"
+
+// bytesFile is a read only parquet-go source of the bytes of a file
+type bytesFile struct {
+	*bytes.Reader
+	b []byte
+}
+
+func (f bytesFile) Write([]byte) (int, error) { return 0, os.ErrPermission }
+func (f bytesFile) Close() error              { return nil }
+func (f bytesFile) Open(string) (source.ParquetFile, error) {
+	return bytesFile{bytes.NewReader(f.b), f.b}, nil
+}
+func (f bytesFile) Create(string) (source.ParquetFile, error) { return nil, os.ErrPermission }
+
+// TestParquetGolden checks that the export of parquetDump is the
+// golden file, and that the golden file is read by parquet-go, a reader
+// independent of the writer and of readParquet
+func TestParquetGolden(t *testing.T) {
+
+	dir := t.TempDir()
+	args := anonArgs{
+		dumpFilePath:       versionDump(t, "16.2", parquetDump...),
+		settingsToml:       parquetSettings,
+		output:             bytes.NewBuffer(nil),
+		exportDir:          dir,
+		exportFormat:       exportParquet,
+		exportRowGroupSize: 2,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+	exported, err := os.ReadFile(filepath.Join(dir, "public.events.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := os.ReadFile(parquetGolden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(exported, golden) {
+		t.Errorf("the export differs from %s", parquetGolden)
+	}
+
+	pr, err := reader.NewParquetColumnReader(bytesFile{bytes.NewReader(golden), golden}, 1)
+	if err != nil {
+		t.Fatalf("parquet-go could not read %s: %s", parquetGolden, err)
+	}
+	if pr.GetNumRows() != 3 || len(pr.Footer.RowGroups) != 2 {
+		t.Errorf("expected 3 rows in 2 row groups, got %d in %d", pr.GetNumRows(), len(pr.Footer.RowGroups))
+	}
+
+	// the name, physical, repetition, converted and logical types of
+	// each schema element
+	var schema []string
+	for i, e := range pr.Footer.Schema {
+		// parquet-go renames the elements, keeping their names as read
+		s := []string{pr.SchemaHandler.Infos[i].ExName}
+		for _, v := range []interface{}{e.Type, e.RepetitionType, e.ConvertedType} {
+			if reflect.ValueOf(v).IsNil() {
+				s = append(s, "-")
+			} else {
+				s = append(s, fmt.Sprint(reflect.ValueOf(v).Elem()))
+			}
+		}
+		switch l := e.LogicalType; {
+		case l == nil:
+			s = append(s, "-")
+		case l.IsSetINTEGER():
+			s = append(s, fmt.Sprintf("INTEGER(%d,%t)", l.INTEGER.BitWidth, l.INTEGER.IsSigned))
+		case l.IsSetTIMESTAMP():
+			s = append(s, fmt.Sprintf("TIMESTAMP(%t,%t)", l.TIMESTAMP.IsAdjustedToUTC, l.TIMESTAMP.Unit.IsSetMICROS()))
+		case l.IsSetSTRING():
+			s = append(s, "STRING")
+		case l.IsSetDATE():
+			s = append(s, "DATE")
+		case l.IsSetJSON():
+			s = append(s, "JSON")
+		case l.IsSetLIST():
+			s = append(s, "LIST")
+		default:
+			s = append(s, "other")
+		}
+		schema = append(schema, strings.Join(s, " "))
+	}
+	wantSchema := []string{
+		"schema - - - -",
+		"id INT64 OPTIONAL INT_64 INTEGER(64,true)",
+		"name BYTE_ARRAY OPTIONAL UTF8 STRING",
+		"score DOUBLE OPTIONAL - -",
+		"active BOOLEAN OPTIONAL - -",
+		"day INT32 OPTIONAL DATE DATE",
+		"created INT64 OPTIONAL TIMESTAMP_MICROS TIMESTAMP(true,true)",
+		"data BYTE_ARRAY OPTIONAL JSON JSON",
+		"tags - OPTIONAL LIST LIST",
+		"list - REPEATED - -",
+		"element BYTE_ARRAY OPTIONAL UTF8 STRING",
+		"counts - OPTIONAL LIST LIST",
+		"list - REPEATED - -",
+		"element INT32 OPTIONAL INT_32 INTEGER(32,true)",
+	}
+	if !reflect.DeepEqual(schema, wantSchema) {
+		t.Errorf("expected schema\n%q\ngot\n%q", wantSchema, schema)
+	}
+
+	// the values, definition and repetition levels of each column
+	wantColumns := map[string][3]string{
+		"schema.id":                  {"[1 2 3]", "[1 1 1]", "[0 0 0]"},
+		"schema.name":                {"[zoë <nil> zoë]", "[1 0 1]", "[0 0 0]"},
+		"schema.score":               {"[1.5 <nil> NaN]", "[1 0 1]", "[0 0 0]"},
+		"schema.active":              {"[true <nil> false]", "[1 0 1]", "[0 0 0]"},
+		"schema.day":                 {"[19782 <nil> -1]", "[1 0 1]", "[0 0 0]"},
+		"schema.created":             {"[1709290800250000 <nil> 9223372036854775807]", "[1 0 1]", "[0 0 0]"},
+		"schema.data":                {`[{"a": 1} <nil> []]`, "[1 0 1]", "[0 0 0]"},
+		"schema.tags.list.element":   {"[x <nil> y z <nil> <nil>]", "[3 2 3 0 1]", "[0 1 1 0 0]"},
+		"schema.counts.list.element": {"[1 2 <nil> <nil>]", "[3 3 0 2]", "[0 1 0 0]"},
+	}
+	for path, want := range wantColumns {
+		values, rls, dls, err := pr.ReadColumnByPath(common.ReformPathStr(path), 10)
+		if err != nil {
+			t.Errorf("%s: parquet-go could not read the column: %s", path, err)
+			continue
+		}
+		if got := [3]string{fmt.Sprint(values), fmt.Sprint(dls), fmt.Sprint(rls)}; got != want {
+			t.Errorf("%s: expected values, definition and repetition levels %q got %q", path, want, got)
+		}
+	}
+}
+
+func TestAnonymiseParquetExportFail(t *testing.T) {
+
+	lines := append([]string{}, parquetDump[:13]...)
+	lines = append(lines, "x	ann	\\N	\\N	\\N	\\N	\\N	\\N	\\N", `\.`)
+	args := anonArgs{
+		dumpFilePath: versionDump(t, "16.2", lines...),
+		settingsToml: parquetSettings,
+		output:       bytes.NewBuffer(nil),
+		exportDir:    t.TempDir(),
+		exportFormat: exportParquet,
+	}
+	err := Anonymise(args)
+	if err == nil || !strings.Contains(err.Error(), `table public.events row 1 column id: invalid integer "x"`) {
+		t.Errorf("expected an invalid integer error, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -11,18 +12,46 @@ var usage = `: a simple postgresql dump file anonymiser.
 
 Anonymise a postgresql dump file using a toml settings file setting out
 the deletion, or columnar uuid, string, file or reference replacement
-filters to use.
+filters to use. The pipeline command, described by "gopg-anonymise
+pipeline -h", runs pg_dump and restores its anonymised output, and the
+decrypt command, described by "gopg-anonymise decrypt -h", decrypts
+values encrypted by the ff1 or ff3-1 encrypt filters.
 
-gopg-anonymise -s <settings.toml> [-o output or stdout] [-t test]`
+gopg-anonymise -s <settings.toml> [-o output or stdout] [-z compression]
+               [-r role passwords] [-u] [-t test]
+               [--csv] [--table schema.table] [--delimiter char] [--cdc]
+               [-d connection string [-1]]
+               [--export-dir directory [--export-format format]
+               [--row-group-size rows]] [--split directory]`
 
 // Options set the programme flag options
 type Options struct {
 	Settings string `short:"s" long:"settings" required:"true" description:"settings toml file"`
-	Output   string `short:"o" long:"output" description:"output file (otherwise stdout)"`
-	Test     bool   `short:"t" long:"testmode" description:"show only changed lines for testing"`
-	Args     struct {
-		Input string `default:"" description:"input postgresql dump file"`
-	} `positional-args:"yes" required:"true"`
+	Output   string `short:"o" long:"output" description:"output file or directory (otherwise stdout)"`
+	Compress string `short:"z" long:"compress" choice:"gzip" choice:"zstd" choice:"lz4" choice:"none" description:"output compression (default from output file extension)"`
+	// role passwords of pg_dumpall dumps
+	RolePasswords string `short:"r" long:"role-passwords" choice:"scrub" choice:"replace" description:"scrub or replace the role passwords of pg_dumpall dumps (default scrub)"`
+	RolePassword  string `long:"role-password" description:"replacement role password or password hash"`
+	UTF8          bool   `short:"u" long:"utf8" description:"rewrite plain dumps in other encodings as UTF-8"`
+	Test          bool   `short:"t" long:"testmode" description:"show only changed lines for testing"`
+	// standalone csv or tsv exports
+	CSV       bool   `long:"csv" description:"read a CSV or TSV export with a header row (default for .csv and .tsv files)"`
+	Table     string `long:"table" description:"settings table of a CSV or TSV export (default from the file name)"`
+	Delimiter string `long:"delimiter" description:"CSV field delimiter (default comma, or tab for .tsv files)"`
+	// change data capture streams
+	CDC bool `long:"cdc" description:"read newline-delimited wal2json or Debezium JSON change events"`
+	// database output
+	Database          string `short:"d" long:"database" description:"write the output to the postgresql database of a connection string or URL"`
+	SingleTransaction bool   `short:"1" long:"single-transaction" description:"write the output to the database in a single transaction"`
+	// per-table exports
+	ExportDir    string `long:"export-dir" description:"also export the anonymised data of each table with filters to a file in this directory"`
+	ExportFormat string `long:"export-format" choice:"csv" choice:"jsonl" choice:"parquet" default:"csv" description:"format of the table exports"`
+	RowGroupSize int    `long:"row-group-size" default:"50000" description:"rows in each row group of parquet exports"`
+	// split output
+	Split string `long:"split" description:"split the output into pre-data, per-table data and post-data files with a restore script in this directory"`
+	Args  struct {
+		Input string `default:"" description:"input postgresql dump file (otherwise stdin)"`
+	} `positional-args:"yes"`
 }
 
 // parseFlags parses the command line options, taken out of main to
@@ -38,13 +67,73 @@ func parseFlags() (args anonArgs, err error) {
 	}
 
 	args.changedOnly = options.Test
+	args.rolePasswords = options.RolePasswords
+	args.rolePassword = options.RolePassword
+	args.toUTF8 = options.UTF8
+	args.csv, err = newCSVOptions(options.Args.Input, options.Table, options.Delimiter, options.CSV)
+	if err != nil {
+		return args, err
+	}
+	args.cdc = options.CDC
+	if args.cdc && args.csv != nil {
+		return args, errors.New("change events cannot be read as a CSV or TSV export")
+	}
 
-	// set dumpfile
-	args.dumpFilePath = options.Args.Input
-	_, err = os.Stat(args.dumpFilePath)
-	if os.IsNotExist(err) {
+	args.exportDir = options.ExportDir
+	args.exportFormat = options.ExportFormat
+	args.exportRowGroupSize = options.RowGroupSize
+	if args.exportRowGroupSize < 1 {
+		return args, errors.New("the row group size must be at least 1")
+	}
+	if args.cdc && args.exportDir != "" {
+		return args, errExportCDC
+	}
+
+	// output split into files
+	args.splitDir = options.Split
+	if args.splitDir != "" {
+		switch {
+		case options.Output != "" || options.Compress != "":
+			return args, errors.New("split output cannot also be written to a file or compressed")
+		case args.changedOnly:
+			return args, errors.New("test mode output cannot be split")
+		}
+	}
+
+	// output to a database
+	args.database = options.Database
+	args.singleTransaction = options.SingleTransaction
+	if args.singleTransaction && args.database == "" {
+		return args, errors.New("a single transaction requires a database")
+	}
+	if args.database != "" {
+		switch {
+		case options.Output != "" || options.Compress != "":
+			return args, errors.New("output to a database cannot also be written to a file or compressed")
+		case args.changedOnly:
+			return args, errors.New("test mode output cannot be written to a database")
+		}
+	}
+	if err := args.checkOutput(); err != nil {
 		return args, err
 	}
+	if args.csv != nil || args.cdc {
+		if err := args.checkPlainOnly(); err != nil {
+			return args, err
+		}
+	}
+
+	// set dumpfile, reading stdin if no file or "-" is given
+	args.dumpFilePath = options.Args.Input
+	var info os.FileInfo
+	if isStdin(args.dumpFilePath) {
+		args.input = os.Stdin
+	} else {
+		info, err = os.Stat(args.dumpFilePath)
+		if os.IsNotExist(err) {
+			return args, err
+		}
+	}
 
 	// read settings file
 	settings, err := os.ReadFile(options.Settings)
@@ -53,6 +142,33 @@ func parseFlags() (args anonArgs, err error) {
 	}
 	args.settingsToml = string(settings)
 
+	// output written to a database or split into files
+	if args.database != "" || args.splitDir != "" {
+		if info != nil && info.IsDir() {
+			return args, args.checkPlainOnly()
+		}
+		return args, nil
+	}
+
+	// directory format dumps are written to an output directory, except
+	// in test mode
+	if info != nil && info.IsDir() && !args.changedOnly {
+		if options.Output == "" || options.Output == "-" {
+			return args, errors.New("an output directory is required for directory format dumps")
+		}
+		if options.Compress != "" {
+			return args, errors.New("output compression is not available for directory format dumps")
+		}
+		args.outputDir = options.Output
+		return args, nil
+	}
+
+	// set output compression, by default from the output file extension
+	args.outputCompression = options.Compress
+	if args.outputCompression == "" {
+		args.outputCompression = compressionFromExtension(options.Output)
+	}
+
 	// open stdout or file for writing
 	if options.Output == "" || options.Output == "-" {
 		args.output = os.Stdout
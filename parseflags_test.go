@@ -3,6 +3,7 @@ package main
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -50,3 +51,190 @@ func TestFlagParsingOutputFile(t *testing.T) {
 	}
 	t.Log(err)
 }
+
+func TestFlagParsingDirectoryNoOutput(t *testing.T) {
+
+	os.Args = []string{"prog", "-s", "testdata/settings.toml", "testdata"}
+	_, err := parseFlags()
+	if err == nil {
+		t.Error("a directory dump without an output directory should fail")
+	}
+	t.Log(err)
+}
+
+func TestFlagParsingCompression(t *testing.T) {
+
+	outFile := filepath.Join(t.TempDir(), "out.sql.zst")
+
+	os.Args = []string{"prog", "-s", "testdata/settings.toml", "-o", outFile, "/dev/random"}
+	args, err := parseFlags()
+	if err != nil {
+		t.Fatalf("compressed file output should not fail: %s", err)
+	}
+	if args.outputCompression != streamZstd {
+		t.Errorf("expected zstd compression from extension, got %s", args.outputCompression)
+	}
+
+	os.Args = []string{"prog", "-s", "testdata/settings.toml", "-z", "none", "-o", outFile, "/dev/random"}
+	args, err = parseFlags()
+	if err != nil {
+		t.Fatalf("compressed file output should not fail: %s", err)
+	}
+	if args.outputCompression != streamNone {
+		t.Errorf("expected no compression, got %s", args.outputCompression)
+	}
+
+	os.Args = []string{"prog", "-s", "testdata/settings.toml", "-z", "gzip", "-o", t.TempDir(), "testdata"}
+	if _, err = parseFlags(); err == nil {
+		t.Error("compression of a directory format dump should fail")
+	}
+}
+
+func TestFlagParsingStdin(t *testing.T) {
+
+	for _, a := range [][]string{{"prog", "-s", "testdata/settings.toml"}, {"prog", "-s", "testdata/settings.toml", "-"}} {
+		os.Args = a
+		args, err := parseFlags()
+		if err != nil {
+			t.Fatalf("stdin input should not fail: %s", err)
+		}
+		if args.input != os.Stdin || !isStdin(args.dumpFilePath) {
+			t.Errorf("expected stdin input for %v", a)
+		}
+	}
+}
+
+func TestFlagParsingRolePasswords(t *testing.T) {
+
+	os.Args = []string{"prog", "-s", "testdata/settings.toml", "-r", "replace", "--role-password", "secret", "/dev/random"}
+	args, err := parseFlags()
+	if err != nil {
+		t.Fatalf("failed flag parsing %s", err)
+	}
+	if args.rolePasswords != replaceRolePasswords || args.rolePassword != "secret" {
+		t.Errorf("unexpected role password options %s %s", args.rolePasswords, args.rolePassword)
+	}
+}
+
+func TestFlagParsingUTF8(t *testing.T) {
+
+	os.Args = []string{"prog", "-s", "testdata/settings.toml", "-u", "/dev/random"}
+	args, err := parseFlags()
+	if err != nil {
+		t.Fatalf("failed flag parsing %s", err)
+	}
+	if !args.toUTF8 {
+		t.Error("expected the utf8 option to be set")
+	}
+}
+
+func TestFlagParsingCSV(t *testing.T) {
+
+	os.Args = []string{"prog", "-s", "testdata/settings.toml", "--csv", "--table", "public.users", "--delimiter", "|", "-"}
+	args, err := parseFlags()
+	if err != nil {
+		t.Fatalf("failed flag parsing %s", err)
+	}
+	if args.csv == nil || args.csv.table != "public.users" || args.csv.delimiter != '|' {
+		t.Errorf("unexpected csv options %v", args.csv)
+	}
+}
+
+func TestFlagParsingCDC(t *testing.T) {
+
+	os.Args = []string{"prog", "-s", "testdata/settings.toml", "--cdc", "-"}
+	args, err := parseFlags()
+	if err != nil {
+		t.Fatalf("failed flag parsing %s", err)
+	}
+	if !args.cdc || args.csv != nil {
+		t.Errorf("unexpected cdc options %t %v", args.cdc, args.csv)
+	}
+
+	os.Args = []string{"prog", "-s", "testdata/settings.toml", "--cdc", "--csv", "--table", "public.users", "-"}
+	if _, err := parseFlags(); err == nil {
+		t.Error("expected an error for cdc and csv options together")
+	}
+}
+
+func TestFlagParsingDatabase(t *testing.T) {
+
+	os.Args = []string{"prog", "-s", "testdata/settings.toml", "-d", "host=localhost dbname=test", "-1", "/dev/random"}
+	args, err := parseFlags()
+	if err != nil {
+		t.Fatalf("failed flag parsing %s", err)
+	}
+	if args.database != "host=localhost dbname=test" || !args.singleTransaction || args.output != nil {
+		t.Errorf("unexpected database options %s %t %v", args.database, args.singleTransaction, args.output)
+	}
+
+	for _, flags := range [][]string{
+		{"-1"},
+		{"-d", "host=localhost", "-o", "out.sql"},
+		{"-d", "host=localhost", "-t"},
+		{"-d", "host=localhost", "--cdc"},
+	} {
+		os.Args = append(append([]string{"prog", "-s", "testdata/settings.toml"}, flags...), "/dev/random")
+		if _, err := parseFlags(); err == nil {
+			t.Errorf("%v: expected an error", flags)
+		}
+	}
+}
+
+func TestFlagParsingExport(t *testing.T) {
+
+	dir := t.TempDir()
+	os.Args = []string{"prog", "-s", "testdata/settings.toml", "--export-dir", dir, "--export-format", "jsonl", "/dev/random"}
+	args, err := parseFlags()
+	if err != nil {
+		t.Fatalf("failed flag parsing %s", err)
+	}
+	if args.exportDir != dir || args.exportFormat != exportJSONL {
+		t.Errorf("unexpected export options %s %s", args.exportDir, args.exportFormat)
+	}
+
+	os.Args = []string{"prog", "-s", "testdata/settings.toml", "--export-dir", dir, "--export-format", "parquet", "--row-group-size", "1000", "/dev/random"}
+	args, err = parseFlags()
+	if err != nil {
+		t.Fatalf("failed flag parsing %s", err)
+	}
+	if args.exportFormat != exportParquet || args.exportRowGroupSize != 1000 {
+		t.Errorf("unexpected parquet export options %s %d", args.exportFormat, args.exportRowGroupSize)
+	}
+
+	os.Args = []string{"prog", "-s", "testdata/settings.toml", "--export-dir", dir, "--row-group-size", "0", "/dev/random"}
+	if _, err := parseFlags(); err == nil {
+		t.Error("expected an error for an empty row group size")
+	}
+
+	os.Args = []string{"prog", "-s", "testdata/settings.toml", "--export-dir", dir, "--cdc", "-"}
+	if _, err := parseFlags(); err == nil {
+		t.Error("expected an error exporting change events")
+	}
+}
+
+func TestFlagParsingSplit(t *testing.T) {
+
+	dir := t.TempDir()
+	os.Args = []string{"prog", "-s", "testdata/settings.toml", "--split", dir, "/dev/random"}
+	args, err := parseFlags()
+	if err != nil {
+		t.Fatalf("failed flag parsing %s", err)
+	}
+	if args.splitDir != dir || args.output != nil {
+		t.Errorf("unexpected split options %s %v", args.splitDir, args.output)
+	}
+
+	for _, flags := range [][]string{
+		{"--split", dir, "-o", "out.sql"},
+		{"--split", dir, "-z", "gzip"},
+		{"--split", dir, "-t"},
+		{"--split", dir, "-d", "host=localhost"},
+		{"--split", dir, "--cdc"},
+	} {
+		os.Args = append(append([]string{"prog", "-s", "testdata/settings.toml"}, flags...), "/dev/random")
+		if _, err := parseFlags(); err == nil {
+			t.Errorf("%v: expected an error", flags)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The fake person filter replaces several columns of a row with the
+// parts of a single fake person, so that the title, names, gender,
+// email address and username of a row agree, with the email address
+// and username made from the person's name. The replacements of the
+// filter give the part of the person for each column. The locale and
+// seed are given by the "locale" option as for the fake filter, and
+// the "from" option names columns holding the gender and country of
+// the original person, such as optargs = {"from" = ["sex", "country"]},
+// either of which may be empty. A person is then made with the gender
+// of the gender column, and in the locale of the country column, where
+// they are recognised, so that a row with a gender of "F" is given a
+// female name and title. A column replaced with the gender part is
+// written in the style of its original value, as a letter or word of
+// the same case, so that "F" is replaced with "F" or "M", while NULLs
+// and values not recognised, such as "X", are kept. Values written in
+// several styles, such as "F" of "F" and "M" and of "F" and "H", are
+// written in the style of the other values seen in the column, or else
+// of the language of the locale of the row. With a seed, the
+// person is generated from the seed and the original values of the
+// columns, so that rows with equal values are given the same person.
+
+// the parts of a fake person
+const (
+	personTitle         = "title"
+	personFirstName     = "first_name"
+	personLastName      = "last_name"
+	personFullName      = "full_name"
+	personGender        = "gender"
+	personEmail         = "email"
+	personUsername      = "username"
+	personPhone         = "phone"
+	personStreetAddress = "street_address"
+	personPostcode      = "postcode"
+	personCity          = "city"
+)
+
+// genders of a fake person
+const (
+	genderFemale = "female"
+	genderMale   = "male"
+)
+
+// genderStyle is a way of writing genders, as the female and male
+// values, in lower case, and the language of the locales using it
+type genderStyle struct {
+	female   string
+	male     string
+	language string
+}
+
+// personGenderStyles are the recognised styles of a gender column. As
+// a value such as "F" or "M" is written in several styles, the style of
+// a column is chosen from the values seen in the column, or else from
+// the language of the locale of the row.
+var personGenderStyles = []genderStyle{
+	{"f", "m", "en"}, {"w", "m", "de"}, {"f", "h", "fr"},
+	{"female", "male", "en"}, {"woman", "man", "en"}, {"weiblich", "männlich", "de"}, {"femme", "homme", "fr"},
+	{"mrs", "mr", "en"}, {"ms", "mr", "en"}, {"miss", "mr", "en"}, {"mrs.", "mr.", "en"}, {"ms.", "mr.", "en"},
+	{"frau", "herr", "de"}, {"madame", "monsieur", "fr"}, {"mme", "m.", "fr"}, {"mlle", "m.", "fr"},
+}
+
+// genderKey returns a value of a gender column as it is matched with
+// the values of the styles
+func genderKey(v string) string {
+	return strings.ToLower(strings.TrimSpace(v))
+}
+
+// matchGenderStyles returns the gender of a value of a gender column
+// and the styles in which it is written
+func matchGenderStyles(key string) (string, []genderStyle) {
+	var gender string
+	var styles []genderStyle
+	for _, style := range personGenderStyles {
+		switch key {
+		case style.female:
+			gender, styles = genderFemale, append(styles, style)
+		case style.male:
+			gender, styles = genderMale, append(styles, style)
+		}
+	}
+	return gender, styles
+}
+
+// personGenderStyle returns the gender of a value of a gender column
+// and the styles in which it is written, if it is recognised. Values
+// not recognised with a trailing full stop, such as "F.", are matched
+// without it, in styles keeping it.
+func personGenderStyle(v string) (string, []genderStyle, bool) {
+	key := genderKey(v)
+	gender, styles := matchGenderStyles(key)
+	if len(styles) == 0 && strings.HasSuffix(key, ".") {
+		gender, styles = matchGenderStyles(strings.TrimSuffix(key, "."))
+		for i := range styles {
+			styles[i].female += "."
+			styles[i].male += "."
+		}
+	}
+	return gender, styles, len(styles) > 0
+}
+
+// styleGender returns a gender written in a style, in the case of the
+// original value of a gender column, so that "F" is replaced with "F"
+// or "M" and "Female" with "Female" or "Male"
+func styleGender(gender, original string, style genderStyle) string {
+	v := style.female
+	if gender == genderMale {
+		v = style.male
+	}
+	o := strings.TrimSpace(original)
+	letters := 0
+	for _, r := range o {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	switch first, _ := utf8.DecodeRuneInString(o); {
+	case letters > 1 && strings.ToUpper(o) == o:
+		v = strings.ToUpper(v)
+	case unicode.IsUpper(first):
+		r, n := utf8.DecodeRuneInString(v)
+		v = string(unicode.ToUpper(r)) + v[n:]
+	}
+	return v
+}
+
+// personCountries are the recognised values of a country column, in
+// lower case, and their locales
+var personCountries = map[string]string{
+	"gb": "en_GB", "gbr": "en_GB", "uk": "en_GB", "united kingdom": "en_GB", "great britain": "en_GB",
+	"england": "en_GB", "scotland": "en_GB", "wales": "en_GB", "northern ireland": "en_GB",
+	"us": "en_US", "usa": "en_US", "united states": "en_US", "united states of america": "en_US",
+	"de": "de_DE", "deu": "de_DE", "germany": "de_DE", "deutschland": "de_DE",
+	"fr": "fr_FR", "fra": "fr_FR", "france": "fr_FR",
+}
+
+// personTitles are the titles of each gender in each locale
+var personTitles = map[string]map[string][]string{
+	"en_GB": {genderFemale: {"Ms", "Mrs", "Miss"}, genderMale: {"Mr"}},
+	"en_US": {genderFemale: {"Ms.", "Mrs.", "Miss"}, genderMale: {"Mr."}},
+	"de_DE": {genderFemale: {"Frau"}, genderMale: {"Herr"}},
+	"fr_FR": {genderFemale: {"Mme", "Mlle"}, genderMale: {"M."}},
+}
+
+// fakePerson is a fake person
+type fakePerson struct {
+	gender, title, first, last string
+	l                          *fakeLocale
+	r                          *fakeRand
+}
+
+// newFakePerson makes a fake person of a gender, or of either gender if
+// the gender is empty, in a locale
+func newFakePerson(l *fakeLocale, gender string, r *fakeRand) *fakePerson {
+	p := &fakePerson{gender: gender, l: l, r: r}
+	if p.gender == "" {
+		p.gender = genderFemale
+		if r.intn(2) == 0 {
+			p.gender = genderMale
+		}
+	}
+	if p.gender == genderFemale {
+		p.first = r.pick(l.femaleNames)
+	} else {
+		p.first = r.pick(l.maleNames)
+	}
+	p.last = r.pick(l.lastNames)
+	p.title = r.pick(personTitles[l.name][p.gender])
+	return p
+}
+
+// part returns a part of a person
+func (p *fakePerson) part(name string) string {
+	switch name {
+	case personTitle:
+		return p.title
+	case personFirstName:
+		return p.first
+	case personLastName:
+		return p.last
+	case personFullName:
+		return p.first + " " + p.last
+	case personGender:
+		return p.gender
+	case personEmail:
+		return p.l.email(p.first, p.last, p.r)
+	case personUsername:
+		return p.l.username(p.first, p.last, p.r)
+	case personPhone:
+		return p.l.phone(p.r)
+	case personStreetAddress:
+		return p.l.address(p.r.pick(p.l.streets), p.r)
+	case personPostcode:
+		return p.l.postcode(p.r)
+	case personCity:
+		return p.r.pick(p.l.cities)
+	}
+	return ""
+}
+
+// PersonFilter replaces columns with the parts of a fake person
+type PersonFilter struct {
+	filterName
+	Columns       []string
+	parts         []string // the part of the person of each column
+	locale        *fakeLocale
+	locales       map[string]*fakeLocale // the locales of countries
+	genderColumn  string
+	countryColumn string
+	seed          string
+	rand          *fakeRand                  // the generator of random values without a seed
+	encoding      *clientEncoding            // the encoding of the values, nil for UTF-8
+	genderSeen    map[string]map[string]bool // the values seen in each gender part column
+	whereTrue     map[string]string
+	whereFalse    map[string]string
+}
+
+// NewPersonFilter makes a new PersonFilter with a part of a person for
+// each column in a locale, with the gender and locale of each person
+// from the gender and country columns, if given
+func NewPersonFilter(columns, parts []string, locale, seed, genderColumn, countryColumn string, whereTrue, whereFalse map[string]string) (*PersonFilter, error) {
+
+	f := PersonFilter{
+		filterName:    "fake person",
+		Columns:       columns,
+		parts:         parts,
+		genderColumn:  genderColumn,
+		countryColumn: countryColumn,
+		seed:          seed,
+		genderSeen:    map[string]map[string]bool{},
+		whereTrue:     whereTrue,
+		whereFalse:    whereFalse,
+	}
+
+	if len(columns) == 0 {
+		return &f, errors.New("fake person: at least one column must be specified")
+	}
+	if len(parts) != len(columns) {
+		return &f, errors.New("fake person: column length != part length")
+	}
+	for i, p := range parts {
+		switch p {
+		case personGender:
+			f.genderSeen[columns[i]] = map[string]bool{}
+		case personTitle, personFirstName, personLastName, personFullName,
+			personEmail, personUsername, personPhone, personStreetAddress, personPostcode, personCity:
+		default:
+			return &f, fmt.Errorf("fake person: unknown part %q", p)
+		}
+	}
+
+	var err error
+	if f.locale, err = loadFakeLocale(locale); err != nil {
+		return &f, fmt.Errorf("fake person: %w", err)
+	}
+	if countryColumn != "" {
+		f.locales = map[string]*fakeLocale{}
+		for name := range fakeLocales {
+			if f.locales[name], err = loadFakeLocale(name); err != nil {
+				return &f, fmt.Errorf("fake person: %w", err)
+			}
+		}
+	}
+	if seed == "" {
+		f.rand = newFakeRand()
+	}
+	return &f, nil
+}
+
+// Filter replaces the columns of a row with the parts of a fake person
+func (f *PersonFilter) Filter(r Row) (Row, error) {
+
+	// if there is no line number the previous filter may have stopped
+	// processing
+	if r.lineNo == 0 {
+		return r, nil
+	}
+
+	// if no match for whereTrue conditions, return
+	if len(f.whereTrue) > 0 && r.match(f.FilterName(), f.whereTrue) != true {
+		return r, nil
+	}
+	// if match for whereFalse conditions, return
+	if len(f.whereFalse) > 0 && r.match(f.FilterName(), f.whereFalse) == true {
+		return r, nil
+	}
+
+	// the original values of the columns and the condition columns
+	names := r.ColumnNames()
+	values := map[string]string{}
+	for i, rc := range names {
+		values[rc] = r.Columns[i]
+	}
+	for _, c := range f.usedColumns() {
+		if _, ok := values[c]; !ok {
+			return r, fmt.Errorf("fake person: could not find column %s in PersonFilter", c)
+		}
+	}
+
+	locale, gender := f.locale, ""
+	if f.countryColumn != "" {
+		if l, ok := f.locales[personCountries[strings.ToLower(strings.TrimSpace(values[f.countryColumn]))]]; ok {
+			locale = l
+		}
+	}
+	if f.genderColumn != "" {
+		gender, _, _ = personGenderStyle(values[f.genderColumn])
+	}
+
+	random := f.rand
+	if f.seed != "" {
+		parts := []string{locale.name, gender}
+		for _, c := range f.Columns {
+			parts = append(parts, values[c])
+		}
+		random = seedFakeRand(f.seed, parts...)
+	}
+	person := newFakePerson(locale, gender, random)
+
+	for i, rc := range names {
+		for j, cn := range f.Columns {
+			if rc == cn {
+				switch {
+				case r.Columns[i] == nullValue:
+				case f.parts[j] == personGender:
+					if style, ok := f.columnGenderStyle(cn, r.Columns[i], locale.name); ok {
+						r.Columns[i] = fakeTransliterate(styleGender(person.gender, r.Columns[i], style), f.encoding)
+					}
+				default:
+					r.Columns[i] = fakeTransliterate(person.part(f.parts[j]), f.encoding)
+				}
+				break
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// columnGenderStyle returns the style of a value of a gender part
+// column, if it is recognised, preferring a style of which both values
+// have been seen in the column, and then a style of the language of the
+// locale of the row, so that "F" is written in the style of "F" and "H"
+// in a column holding "H" or in a row of the fr_FR locale
+func (f *PersonFilter) columnGenderStyle(column, value, locale string) (genderStyle, bool) {
+	_, styles, ok := personGenderStyle(value)
+	if !ok {
+		return genderStyle{}, false
+	}
+	seen := f.genderSeen[column]
+	seen[genderKey(value)] = true
+	for _, s := range styles {
+		if seen[s.female] && seen[s.male] {
+			return s, true
+		}
+	}
+	for _, s := range styles {
+		if strings.HasPrefix(locale, s.language+"_") {
+			return s, true
+		}
+	}
+	return styles[0], true
+}
+
+// setEncoding sets the encoding of the values, nil for UTF-8
+func (f *PersonFilter) setEncoding(enc *clientEncoding) {
+	f.encoding = enc
+}
+
+// usedColumns returns the replaced, gender, country and condition
+// columns
+func (f *PersonFilter) usedColumns() []string {
+	used := whereColumns(f.Columns, f.whereTrue, f.whereFalse)
+	for _, c := range []string{f.genderColumn, f.countryColumn} {
+		if c != "" {
+			used = append(used, c)
+		}
+	}
+	return used
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// contains reports if a slice contains a string
+func contains(s []string, v string) bool {
+	for _, i := range s {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPersonFilter(t *testing.T) {
+
+	columns := []string{"title", "firstname", "lastname", "name", "sex", "email", "login"}
+	parts := []string{"title", "first_name", "last_name", "full_name", "gender", "email", "username"}
+	filter, err := NewPersonFilter(columns, parts, "en_GB", "", "", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := filter.locale
+	for n := 0; n < 100; n++ {
+		r, err := filter.Filter(hashRow(columns, "Mr", "Ann", "Smith", "Ann Smith", "F", "ann@example.com", "asmith"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		title, first, last, name, sex, email, login := r.Columns[0], r.Columns[1], r.Columns[2], r.Columns[3], r.Columns[4], r.Columns[5], r.Columns[6]
+		gender, _, _ := personGenderStyle(sex)
+		if sex != "F" && sex != "M" {
+			t.Errorf("gender F replaced with %q", sex)
+		}
+		names := l.maleNames
+		if gender == genderFemale {
+			names = l.femaleNames
+		}
+		if !contains(names, first) || !contains(l.lastNames, last) || name != first+" "+last {
+			t.Errorf("%s person with names %q %q %q", gender, first, last, name)
+		}
+		if !contains(personTitles["en_GB"][gender], title) {
+			t.Errorf("%s person with title %q", gender, title)
+		}
+		if !strings.Contains(email, fakeASCII(last)) || !strings.Contains(email, fakeASCII(first)) {
+			t.Errorf("email %q not made from %s %s", email, first, last)
+		}
+		f, l := fakeASCII(first), fakeASCII(last)
+		number, err := strconv.Atoi(strings.TrimPrefix(login, l+f[:1]))
+		if login != f[:1]+l && login != f+"."+l && (!strings.HasPrefix(login, l+f[:1]) || err != nil || number < 1 || number > 99) {
+			t.Errorf("username %q not made from %s %s", login, first, last)
+		}
+	}
+}
+
+func TestPersonFilterFrom(t *testing.T) {
+
+	columns := []string{"title", "firstname", "email"}
+	parts := []string{"title", "first_name", "email"}
+	tableColumns := append([]string{"sex", "country"}, columns...)
+	filter, err := NewPersonFilter(columns, parts, "en_US", "", "sex", "country", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sex, country string
+		locale       string
+		gender       string
+	}{
+		{"F", "Germany", "de_DE", genderFemale},
+		{"m", "FR", "fr_FR", genderMale},
+		{"Mrs.", "uk", "en_GB", genderFemale},
+		{"Herr", "Atlantis", "en_US", genderMale},
+		{"female", nullValue, "en_US", genderFemale},
+	}
+	for _, tt := range tests {
+		for n := 0; n < 20; n++ {
+			r, err := filter.Filter(hashRow(tableColumns, tt.sex, tt.country, "Dr", "Sam", "sam@example.com"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Columns[0] != tt.sex || r.Columns[1] != tt.country {
+				t.Errorf("from columns replaced with %q", r.Columns[:2])
+			}
+			l := filter.locale
+			if tt.locale != l.name {
+				l = filter.locales[tt.locale]
+			}
+			names := l.maleNames
+			if tt.gender == genderFemale {
+				names = l.femaleNames
+			}
+			if !contains(personTitles[tt.locale][tt.gender], r.Columns[2]) || !contains(names, r.Columns[3]) {
+				t.Errorf("%s %s: unexpected %s person %q %q", tt.sex, tt.country, tt.locale, r.Columns[2], r.Columns[3])
+			}
+		}
+	}
+}
+
+func TestPersonFilterGender(t *testing.T) {
+
+	columns := []string{"sex", "firstname"}
+	parts := []string{"gender", "first_name"}
+	tableColumns := append([]string{"original"}, columns...)
+	filter, err := NewPersonFilter(columns, parts, "en_GB", "", "original", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sex    string
+		female string
+		male   string
+	}{
+		{"F", "F", "M"},
+		{"M", "F", "M"},
+		{"f", "f", "m"},
+		{"female", "female", "male"},
+		{"Male", "Female", "Male"},
+		{"FEMALE", "FEMALE", "MALE"},
+		{"Mrs.", "Mrs.", "Mr."},
+		{"W", "W", "M"},
+		{"H", "F", "H"},
+		{"X", "X", "X"},
+		{"non-binary", "non-binary", "non-binary"},
+		{nullValue, nullValue, nullValue},
+	}
+	for _, tt := range tests {
+		// the person is of the gender of the original column
+		for _, original := range []string{"F", "M"} {
+			want := tt.female
+			if original == "M" {
+				want = tt.male
+			}
+			r, err := filter.Filter(hashRow(tableColumns, original, tt.sex, "Sam"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Columns[1] != want {
+				t.Errorf("%s person: gender %q replaced with %q, expected %q", original, tt.sex, r.Columns[1], want)
+			}
+		}
+	}
+}
+
+func TestPersonFilterGenderColumn(t *testing.T) {
+
+	columns := []string{"sex", "firstname"}
+	parts := []string{"gender", "first_name"}
+	tableColumns := append([]string{"original"}, columns...)
+
+	// the rows of a column, with the original gender, the value of the
+	// gender column and its expected replacement
+	type row struct{ original, sex, want string }
+	tests := []struct {
+		name   string
+		locale string
+		rows   []row
+	}{
+		{"F/H column", "en_GB", []row{{"M", "H", "H"}, {"F", "H", "F"}, {"M", "F", "H"}}},
+		{"F/H locale", "fr_FR", []row{{"M", "F", "H"}, {"F", "F", "F"}}},
+		{"W/M column", "en_GB", []row{{"F", "W", "W"}, {"F", "M", "W"}, {"M", "M", "M"}}},
+		{"W/M locale", "de_DE", []row{{"F", "M", "W"}}},
+		{"F/M", "en_GB", []row{{"F", "M", "F"}, {"M", "F", "M"}}},
+		{"Mme/M.", "en_GB", []row{{"F", "M.", "Mme"}, {"M", "Mme", "M."}, {"F", "Mlle", "Mlle"}}},
+	}
+	for _, tt := range tests {
+		filter, err := NewPersonFilter(columns, parts, tt.locale, "", "original", "", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rr := range tt.rows {
+			r, err := filter.Filter(hashRow(tableColumns, rr.original, rr.sex, "Sam"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Columns[1] != rr.want {
+				t.Errorf("%s: %s person: gender %q replaced with %q, expected %q", tt.name, rr.original, rr.sex, r.Columns[1], rr.want)
+			}
+		}
+	}
+}
+
+func TestPersonFilterSeed(t *testing.T) {
+
+	columns := []string{"firstname", "lastname", "email"}
+	parts := []string{"first_name", "last_name", "email"}
+	person := func(seed string, values ...string) []string {
+		filter, err := NewPersonFilter(columns, parts, "de_DE", seed, "", "", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := filter.Filter(hashRow(columns, values...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.Columns
+	}
+
+	a := person("42", "Ann", "Smith", "ann@example.com")
+	if b := person("42", "Ann", "Smith", "ann@example.com"); strings.Join(a, ",") != strings.Join(b, ",") {
+		t.Errorf("seeded people differ: %q and %q", a, b)
+	}
+	if b := person("42", "Ann", "Smith", nullValue); b[2] != nullValue {
+		t.Errorf("NULL replaced with %q", b[2])
+	}
+}
+
+func TestPersonFilterFail(t *testing.T) {
+
+	tests := []struct {
+		columns []string
+		parts   []string
+		locale  string
+	}{
+		{nil, nil, "en_GB"},
+		{[]string{"a", "b"}, []string{"email"}, "en_GB"},
+		{[]string{"a"}, []string{"company"}, "en_GB"},
+		{[]string{"a"}, []string{"email"}, "xx_XX"},
+	}
+	for i, tt := range tests {
+		if _, err := NewPersonFilter(tt.columns, tt.parts, tt.locale, "", "", "", nil, nil); err == nil {
+			t.Errorf("test %d: expected an error", i)
+		}
+	}
+
+	filter, err := NewPersonFilter([]string{"name"}, []string{"full_name"}, "en_GB", "", "sex", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := filter.Filter(hashRow([]string{"name"}, "Ann Smith")); err == nil {
+		t.Error("expected a missing gender column error")
+	}
+}
+
+func TestLoadPersonFilter(t *testing.T) {
+
+	settings, err := LoadToml(`
+[["public.customers"]]
+filter = "fake person"
+columns = ["title", "firstname", "email"]
+replacements = ["title", "first_name", "email"]
+optargs = {"locale" = ["fr_FR", ""], "from" = ["\"Gender\"", ""]}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf, err := loadFilters(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := tf.getTableFilters("public.customers")[0].(*PersonFilter)
+	if filter.locale.name != "fr_FR" || filter.genderColumn != "Gender" || filter.countryColumn != "" {
+		t.Errorf("unexpected filter %+v", filter)
+	}
+	if used := filter.usedColumns(); !contains(used, "Gender") {
+		t.Errorf("gender column not used in %q", used)
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+// The pipeline command runs pg_dump on a source database, anonymises
+// its output as it is read and restores the result into a target
+// database with psql, for plain format dumps, or pg_restore, for custom
+// format archives, replacing shell pipelines such as
+//
+//     pg_dump -d source | gopg-anonymise -s settings.toml | psql -d target
+//
+// The standard error of pg_dump and the restore programme is passed
+// through, and the last line of each is reported if it fails. If pg_dump
+// or the anonymisation fails, or the pipeline is cancelled, the restore
+// programme is killed before it reads the end of its input, so that an
+// incomplete dump restored in a single transaction is not committed.
+//
+// Passwords are removed from the source and target connection strings
+// and given to the processes in the PGPASSWORD environment variable, as
+// the command lines of processes can be seen by other users.
+
+// pipelineCommand is the name of the pipeline command
+const pipelineCommand = "pipeline"
+
+// pipeline dump formats
+const (
+	pipelinePlain  = "plain"
+	pipelineCustom = "custom"
+)
+
+// stderrTailSize is the amount of the standard error of a process kept
+// for reporting its failure
+const stderrTailSize = 4096
+
+var pipelineUsage = `pipeline: anonymise a database into another database.
+
+Run pg_dump on the source database, anonymise the dump using a toml
+settings file and restore it into the target database with psql, or
+pg_restore for custom format dumps. The source and target are
+postgresql connection strings or URLs.
+
+gopg-anonymise pipeline -s <settings.toml> --source <connection string>
+               --target <connection string> [-F format] [-1]
+               [--dump-option option] [--restore-option option]`
+
+// PipelineOptions set the pipeline command flag options
+type PipelineOptions struct {
+	Settings          string   `short:"s" long:"settings" required:"true" description:"settings toml file"`
+	Source            string   `long:"source" required:"true" description:"source database connection string or URL for pg_dump"`
+	Target            string   `long:"target" required:"true" description:"target database connection string or URL"`
+	Format            string   `short:"F" long:"format" choice:"plain" choice:"custom" default:"plain" description:"dump format, restored with psql (plain) or pg_restore (custom)"`
+	SingleTransaction bool     `short:"1" long:"single-transaction" description:"restore the dump in a single transaction"`
+	DumpOptions       []string `long:"dump-option" description:"additional pg_dump option, such as --schema=public (may be repeated)"`
+	RestoreOptions    []string `long:"restore-option" description:"additional psql or pg_restore option (may be repeated)"`
+}
+
+// pipelineArgs are the arguments of runPipeline
+type pipelineArgs struct {
+	settingsToml      string
+	source            string
+	target            string
+	format            string
+	singleTransaction bool
+	dumpOptions       []string
+	restoreOptions    []string
+	stderr            io.Writer // standard error of the processes, os.Stderr if nil
+}
+
+// parsePipelineFlags parses the options of the pipeline command, which
+// follow the command name
+func parsePipelineFlags() (args pipelineArgs, err error) {
+
+	var options PipelineOptions
+	var parser = flags.NewParser(&options, flags.Default)
+	parser.Usage = pipelineUsage
+
+	if _, err := parser.ParseArgs(os.Args[2:]); err != nil {
+		os.Exit(1)
+	}
+
+	settings, err := os.ReadFile(options.Settings)
+	if err != nil {
+		return args, fmt.Errorf("Could not read settings file: %s", err)
+	}
+	args.settingsToml = string(settings)
+	args.source = options.Source
+	args.target = options.Target
+	args.format = options.Format
+	args.singleTransaction = options.SingleTransaction
+	args.dumpOptions = options.DumpOptions
+	args.restoreOptions = options.RestoreOptions
+	return args, nil
+}
+
+// dumpCommand returns the pg_dump command line of a pipeline
+func (a pipelineArgs) dumpCommand() []string {
+	cmd := []string{"pg_dump", "--format=" + a.format, "--no-password", "--dbname=" + a.source}
+	return append(cmd, a.dumpOptions...)
+}
+
+// restoreCommand returns the psql or pg_restore command line of a
+// pipeline, which reads the dump from standard input and stops at the
+// first error
+func (a pipelineArgs) restoreCommand() []string {
+	var cmd []string
+	if a.format == pipelineCustom {
+		cmd = []string{"pg_restore", "--exit-on-error", "--no-password", "--dbname=" + a.target}
+	} else {
+		cmd = []string{"psql", "--no-psqlrc", "--quiet", "--set=ON_ERROR_STOP=1", "--no-password", "--dbname=" + a.target}
+	}
+	if a.singleTransaction {
+		cmd = append(cmd, "--single-transaction")
+	}
+	return append(cmd, a.restoreOptions...)
+}
+
+// conninfoPassword returns a connection string or URL without any
+// password, and the password
+func conninfoPassword(conninfo string) (string, string, error) {
+
+	if strings.HasPrefix(conninfo, "postgres://") || strings.HasPrefix(conninfo, "postgresql://") {
+		u, err := url.Parse(conninfo)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid database connection URL: %w", err)
+		}
+		var password string
+		var found bool
+		if u.User != nil {
+			if password, found = u.User.Password(); found {
+				u.User = url.User(u.User.Username())
+			}
+		}
+		if query := u.Query(); query.Has("password") {
+			password, found = query.Get("password"), true
+			query.Del("password")
+			u.RawQuery = query.Encode()
+		}
+		if !found {
+			return conninfo, "", nil
+		}
+		return u.String(), password, nil
+	}
+
+	// a string without an equals sign is a database name
+	if !strings.Contains(conninfo, "=") {
+		return conninfo, "", nil
+	}
+	pairs, err := parseConninfo(conninfo)
+	if err != nil {
+		return "", "", err
+	}
+	var password string
+	var found bool
+	var kept []string
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	for _, p := range pairs {
+		if p[0] == "password" {
+			password, found = p[1], true
+			continue
+		}
+		kept = append(kept, p[0]+"='"+quote.Replace(p[1])+"'")
+	}
+	if !found {
+		return conninfo, "", nil
+	}
+	return strings.Join(kept, " "), password, nil
+}
+
+// parseConninfo parses a connection string of keyword = value pairs,
+// the values of which may be single quoted, with backslash escapes
+func parseConninfo(conninfo string) ([][2]string, error) {
+
+	var pairs [][2]string
+	isSpace := func(c byte) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v' }
+	s := conninfo
+	skip := func() {
+		for len(s) > 0 && isSpace(s[0]) {
+			s = s[1:]
+		}
+	}
+	for skip(); len(s) > 0; skip() {
+		i := 0
+		for i < len(s) && s[i] != '=' && !isSpace(s[i]) {
+			i++
+		}
+		key := s[:i]
+		s = s[i:]
+		skip()
+		if key == "" || len(s) == 0 || s[0] != '=' {
+			return nil, fmt.Errorf("invalid database connection string: missing \"=\" after %q", key)
+		}
+		s = s[1:]
+		skip()
+		var value strings.Builder
+		quoted := len(s) > 0 && s[0] == '\''
+		if quoted {
+			s = s[1:]
+		}
+		for {
+			if len(s) == 0 {
+				if quoted {
+					return nil, fmt.Errorf("invalid database connection string: unterminated quoted value of %q", key)
+				}
+				break
+			}
+			c := s[0]
+			if (quoted && c == '\'') || (!quoted && isSpace(c)) {
+				if quoted {
+					s = s[1:]
+				}
+				break
+			}
+			if c == '\\' && len(s) > 1 {
+				s = s[1:]
+				c = s[0]
+			}
+			value.WriteByte(c)
+			s = s[1:]
+		}
+		pairs = append(pairs, [2]string{key, value.String()})
+	}
+	return pairs, nil
+}
+
+// passwordEnv returns the environment of a process given a password,
+// replacing any PGPASSWORD inherited
+func passwordEnv(password string) []string {
+	var env []string
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "PGPASSWORD=") {
+			env = append(env, e)
+		}
+	}
+	return append(env, "PGPASSWORD="+password)
+}
+
+// stderrTail passes through the standard error of a process, keeping
+// its end for reporting
+type stderrTail struct {
+	mu   sync.Mutex
+	w    io.Writer
+	tail []byte
+}
+
+// Write writes standard error output
+func (s *stderrTail) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tail = append(s.tail, p...)
+	if len(s.tail) > stderrTailSize {
+		s.tail = s.tail[len(s.tail)-stderrTailSize:]
+	}
+	return s.w.Write(p)
+}
+
+// lastLine returns the last non-empty line of standard error
+func (s *stderrTail) lastLine() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := strings.Split(strings.TrimSpace(string(s.tail)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// pipelineProcess is a subprocess of a pipeline
+type pipelineProcess struct {
+	name   string
+	cmd    *exec.Cmd
+	stderr *stderrTail
+}
+
+// newPipelineProcess makes a process of a pipeline, which is killed if
+// the context is cancelled, given any password of its connection string
+func newPipelineProcess(ctx context.Context, command []string, password string, stderr io.Writer) *pipelineProcess {
+	p := &pipelineProcess{
+		name:   command[0],
+		cmd:    exec.CommandContext(ctx, command[0], command[1:]...),
+		stderr: &stderrTail{w: stderr},
+	}
+	p.cmd.Stderr = p.stderr
+	if password != "" {
+		p.cmd.Env = passwordEnv(password)
+	}
+	return p
+}
+
+// killed reports if an error is that of a process killed by a signal or
+// the cancellation of its context
+func killed(err error) bool {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode() == -1
+	}
+	return errors.Is(err, context.Canceled)
+}
+
+// wait waits for a process to exit, returning an error describing its
+// failure
+func (p *pipelineProcess) wait() error {
+	err := p.cmd.Wait()
+	if err == nil {
+		return nil
+	}
+	if msg := p.stderr.lastLine(); msg != "" {
+		return fmt.Errorf("%s failed: %w: %s", p.name, err, msg)
+	}
+	return fmt.Errorf("%s failed: %w", p.name, err)
+}
+
+// runPipeline runs pg_dump, anonymises its output and restores it with
+// psql or pg_restore, until the pipeline completes, one of its parts
+// fails or the context is cancelled. The first failure is reported,
+// rather than those of processes killed as a result
+func runPipeline(ctx context.Context, args pipelineArgs) error {
+
+	if args.stderr == nil {
+		args.stderr = os.Stderr
+	}
+	var sourcePassword, targetPassword string
+	var err error
+	if args.source, sourcePassword, err = conninfoPassword(args.source); err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+	if args.target, targetPassword, err = conninfoPassword(args.target); err != nil {
+		return fmt.Errorf("target: %w", err)
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	dump := newPipelineProcess(ctx, args.dumpCommand(), sourcePassword, args.stderr)
+	restore := newPipelineProcess(ctx, args.restoreCommand(), targetPassword, args.stderr)
+	dumpOutput, err := dump.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	restoreInput, err := restore.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := restore.cmd.Start(); err != nil {
+		return fmt.Errorf("could not start %s: %w", restore.name, err)
+	}
+	if err := dump.cmd.Start(); err != nil {
+		cancel()
+		restore.cmd.Wait()
+		return fmt.Errorf("could not start %s: %w", dump.name, err)
+	}
+
+	anonErr := Anonymise(anonArgs{
+		dumpFilePath: stdinPath,
+		input:        dumpOutput,
+		settingsToml: args.settingsToml,
+		output:       restoreInput,
+	})
+
+	// read the remainder of the dump, such as the end of an archive, so
+	// that pg_dump can exit, then kill the restore before its input is
+	// closed if either failed or the pipeline has been cancelled
+	if anonErr != nil {
+		cancel()
+	}
+	io.Copy(io.Discard, dumpOutput)
+	dumpErr := dump.wait()
+	if anonErr != nil || dumpErr != nil || ctx.Err() != nil {
+		cancel()
+		restore.cmd.Process.Kill()
+	}
+	restoreInput.Close()
+	restoreErr := restore.wait()
+
+	switch {
+	case parent.Err() != nil:
+		return fmt.Errorf("pipeline cancelled: %w", parent.Err())
+	case dumpErr != nil && !killed(dumpErr):
+		return dumpErr
+	case restoreErr != nil && !killed(restoreErr):
+		return restoreErr
+	case anonErr != nil:
+		return fmt.Errorf("anonymisation failed: %w", anonErr)
+	case dumpErr != nil:
+		return dumpErr
+	}
+	return restoreErr
+}
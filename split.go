@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// The output of a plain format dump may be split into files in a
+// directory so that the data of tables can be restored in parallel, or
+// reloaded separately: pre-data.sql holds the entries of the dump
+// before its data, such as the CREATE TABLE statements, the data
+// directory a file for the data of each table, as written by the COPY
+// block or INSERT statements of its "Data for Name" entry, and
+// post-data.sql the entries after the data, such as sequence values,
+// indexes, constraints and privileges. The entries are recognised by
+// the comment headers written by pg_dump. Each data file and the
+// post-data file starts with the \restrict line and SET statements of
+// the dump's preamble, such as that of the client encoding, as each is
+// restored in a session of its own. The restore.sh script restores the
+// files in order with psql, running JOBS data files in parallel.
+
+// errSplitPlainOnly is returned when splitting the output of an archive
+var errSplitPlainOnly = errors.New("only plain format dumps can be split")
+
+// names of the files of a split dump
+const (
+	splitPreData       = "pre-data.sql"
+	splitPostData      = "post-data.sql"
+	splitDataDir       = "data"
+	splitRestoreScript = "restore.sh"
+)
+
+// entryHeaderRegex matches the comment header of a pg_dump entry,
+// capturing whether it is a data entry, its name, type and schema
+var entryHeaderRegex = regexp.MustCompile(`^-- (Data for )?Name: (.+?); Type: (.+?); Schema: ([^;]+)`)
+
+// splitRestoreScriptHeader starts the restore script, which passes its
+// arguments to psql
+const splitRestoreScriptHeader = `#!/bin/sh
+# Restore a dump split by gopg-anonymise with psql, passing the
+# arguments of the script to psql, for example:
+#
+#     JOBS=4 ./restore.sh --dbname=scratch
+#
+# The data files are restored by JOBS parallel psql processes (default
+# 1), after the pre-data file and before the post-data file.
+set -e
+cd "$(dirname "$0")"
+psql="psql --no-psqlrc --quiet --set=ON_ERROR_STOP=1"
+`
+
+// splitFile is a file of a split dump
+type splitFile struct {
+	name string // the name of the file in the split directory
+	file *os.File
+	w    *bufio.Writer
+}
+
+// close flushes and closes a file, if it is open
+func (f *splitFile) close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.w.Flush()
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	f.file = nil
+	return err
+}
+
+// splitWriter is an io.Writer splitting the lines of the output of a
+// plain format dump into the files of a split directory
+type splitWriter struct {
+	dir      string
+	err      error  // the error of an earlier write
+	partial  []byte // a line without its newline
+	lineNo   int    // the output line number
+	preamble []string
+	entries  bool     // an entry header has been read
+	copying  bool     // the lines are those of a COPY block
+	comments []string // comment lines which may start an entry header
+	current  *splitFile
+	preData  *splitFile
+	data     []*splitFile
+	postData *splitFile
+}
+
+// newSplitWriter makes a writer of a split dump in a directory, which is
+// created if necessary
+func newSplitWriter(dir string) (*splitWriter, error) {
+	if err := os.MkdirAll(filepath.Join(dir, splitDataDir), 0755); err != nil {
+		return nil, fmt.Errorf("could not make split directory: %w", err)
+	}
+	w := &splitWriter{dir: dir}
+	f, err := w.open(splitPreData, false)
+	if err != nil {
+		return nil, err
+	}
+	w.preData, w.current = f, f
+	return w, nil
+}
+
+// open makes a file of the split dump, starting with the preamble if
+// required
+func (w *splitWriter) open(name string, preamble bool) (*splitFile, error) {
+	file, err := os.Create(filepath.Join(w.dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("split output error: %w", err)
+	}
+	f := &splitFile{name: name, file: file, w: bufio.NewWriter(file)}
+	if preamble {
+		for _, t := range w.preamble {
+			f.w.WriteString(t + "\n")
+		}
+		f.w.WriteString("\n")
+	}
+	return f, nil
+}
+
+// Write splits output line by line
+func (w *splitWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.partial = append(w.partial, p...)
+			break
+		}
+		line := string(p[:i])
+		if len(w.partial) > 0 {
+			line = string(w.partial) + line
+			w.partial = w.partial[:0]
+		}
+		p = p[i+1:]
+		if w.err = w.writeLine(line); w.err != nil {
+			return 0, w.err
+		}
+	}
+	return n, nil
+}
+
+// writeLine writes a line of output to the current file, or starts the
+// file of an entry
+func (w *splitWriter) writeLine(t string) error {
+
+	w.lineNo++
+	if w.copying {
+		w.copying = t != `\.`
+		return w.write(t)
+	}
+
+	// hold the comment lines which may precede an entry header
+	if t == "--" {
+		w.comments = append(w.comments, t)
+		return nil
+	}
+	if m := entryHeaderRegex.FindStringSubmatch(t); m != nil {
+		if err := w.startEntry(m[1] != "", m[2], m[4]); err != nil {
+			return err
+		}
+		return w.write(t)
+	}
+
+	switch {
+	case strings.HasPrefix(t, connectPrefix):
+		return fmt.Errorf("output line %d: the output of pg_dumpall dumps cannot be split", w.lineNo)
+	case isCopyData(t):
+		w.copying = true
+	case !w.entries && (strings.HasPrefix(t, "SET ") || strings.HasPrefix(t, "SELECT pg_catalog.set_config(") || strings.HasPrefix(t, `\restrict `)):
+		w.preamble = append(w.preamble, t)
+	}
+	return w.write(t)
+}
+
+// startEntry starts the file of an entry: a file for the data of a
+// table, the post-data file for other entries after the first data
+// entry, or otherwise the pre-data file. The file of the data of the
+// previous entry is closed
+func (w *splitWriter) startEntry(data bool, name, schema string) error {
+	w.entries = true
+	if w.current != w.preData && w.current != w.postData {
+		if err := w.current.close(); err != nil {
+			return fmt.Errorf("split output error: %w", err)
+		}
+	}
+	switch {
+	case data:
+		if schema != "-" {
+			name = schema + "." + name
+		}
+		f, err := w.open(splitDataFileName(len(w.data)+1, name), true)
+		if err != nil {
+			return err
+		}
+		w.data = append(w.data, f)
+		w.current = f
+	case len(w.data) > 0 && w.postData == nil:
+		f, err := w.open(splitPostData, true)
+		if err != nil {
+			return err
+		}
+		w.postData, w.current = f, f
+	case w.postData != nil:
+		w.current = w.postData
+	}
+	return nil
+}
+
+// splitDataFileName returns the name of a data file, numbered in the
+// order of the dump
+func splitDataFileName(n int, name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == '\'' || r == '"' || r <= ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	return fmt.Sprintf("%s/%04d-%s.sql", splitDataDir, n, name)
+}
+
+// write writes a line, after any held comment lines, to the current
+// file
+func (w *splitWriter) write(t string) error {
+	w.writeComments()
+	if _, err := w.current.w.WriteString(t + "\n"); err != nil {
+		return fmt.Errorf("split output error: %w", err)
+	}
+	return nil
+}
+
+// writeComments writes any held comment lines to the current file
+func (w *splitWriter) writeComments() {
+	for _, c := range w.comments {
+		w.current.w.WriteString(c + "\n")
+	}
+	w.comments = w.comments[:0]
+}
+
+// files returns the files of the split dump, in order
+func (w *splitWriter) files() []*splitFile {
+	files := append([]*splitFile{w.preData}, w.data...)
+	if w.postData != nil {
+		files = append(files, w.postData)
+	}
+	return files
+}
+
+// Close writes any held lines, closes the files and writes the restore
+// script
+func (w *splitWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.partial) > 0 {
+		if err := w.writeLine(string(w.partial)); err != nil {
+			return err
+		}
+	}
+	w.writeComments()
+	if err := w.abort(); err != nil {
+		return fmt.Errorf("split output error: %w", err)
+	}
+
+	var script strings.Builder
+	script.WriteString(splitRestoreScriptHeader)
+	script.WriteString("$psql \"$@\" --file=" + splitPreData + "\n")
+	if len(w.data) > 0 {
+		script.WriteString("xargs -P \"${JOBS:-1}\" -I {} $psql \"$@\" --file={} <<'EOF'\n")
+		for _, f := range w.data {
+			script.WriteString(f.name + "\n")
+		}
+		script.WriteString("EOF\n")
+	}
+	if w.postData != nil {
+		script.WriteString("$psql \"$@\" --file=" + splitPostData + "\n")
+	}
+	if err := os.WriteFile(filepath.Join(w.dir, splitRestoreScript), []byte(script.String()), 0755); err != nil {
+		return fmt.Errorf("split output error: %w", err)
+	}
+	return nil
+}
+
+// abort closes the files of the split dump, returning the first error
+func (w *splitWriter) abort() error {
+	var err error
+	for _, f := range w.files() {
+		if cerr := f.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// withoutPreamble removes the preamble, ending with a blank line, from
+// the start of a data or post-data file
+func withoutPreamble(t *testing.T, s string) string {
+	t.Helper()
+	i := strings.Index(s, "\n\n")
+	if i < 0 || !strings.HasPrefix(s, "SET ") {
+		t.Fatalf("expected a preamble, got %q", s)
+	}
+	return s[i+2:]
+}
+
+func TestAnonymiseSplit(t *testing.T) {
+
+	dir := filepath.Join(t.TempDir(), "split")
+	args := anonArgs{
+		dumpFilePath: "testdata/pg_dump.sql",
+		settingsToml: exportSettings,
+		splitDir:     dir,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+
+	dataFiles, err := filepath.Glob(filepath.Join(dir, "data", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range dataFiles {
+		dataFiles[i] = filepath.Base(f)
+	}
+	want := []string{"0001-example_schema.events.sql", "0002-public.fkexample.sql", "0003-public.users.sql"}
+	if !reflect.DeepEqual(dataFiles, want) {
+		t.Fatalf("expected data files %q got %q", want, dataFiles)
+	}
+
+	// the files, without their preambles, make up the unsplit output
+	joined := readStub(t, dir, splitPreData)
+	for _, name := range dataFiles {
+		data := withoutPreamble(t, readStub(t, dir, filepath.Join("data", name)))
+		if !strings.HasPrefix(data, "--\n-- Data for Name: ") {
+			t.Errorf("%s: unexpected start %q", name, data[:30])
+		}
+		joined += data
+	}
+	postData := readStub(t, dir, splitPostData)
+	post := withoutPreamble(t, postData)
+	joined += post
+	if want := anonymised(t, "testdata/pg_dump.sql", exportSettings); joined != want {
+		t.Errorf("expected the split files to join to the output\n%s\ngot\n%s", want, joined)
+	}
+	if !strings.HasPrefix(post, "--\n-- Name: events_id_seq; Type: SEQUENCE SET;") || !strings.Contains(post, "FK CONSTRAINT") {
+		t.Errorf("unexpected post-data\n%s", post)
+	}
+	if !strings.Contains(postData, "SET client_encoding = 'UTF8';\n") {
+		t.Error("expected the post-data file to set the client encoding")
+	}
+
+	want = []string{
+		"$psql \"$@\" --file=pre-data.sql",
+		"xargs -P \"${JOBS:-1}\" -I {} $psql \"$@\" --file={} <<'EOF'",
+		"data/0001-example_schema.events.sql",
+		"data/0002-public.fkexample.sql",
+		"data/0003-public.users.sql",
+		"EOF",
+		"$psql \"$@\" --file=post-data.sql",
+	}
+	script := strings.TrimPrefix(readStub(t, dir, splitRestoreScript), splitRestoreScriptHeader)
+	if got := strings.Split(strings.TrimSpace(script), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected restore script\n%q\ngot\n%q", want, got)
+	}
+}
+
+func TestSplitRestoreScript(t *testing.T) {
+
+	if _, err := exec.LookPath("xargs"); err != nil {
+		t.Skip("xargs is not available")
+	}
+	dir := t.TempDir()
+	args := anonArgs{dumpFilePath: "testdata/pg_dump.sql", settingsToml: exportSettings, splitDir: dir}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+
+	// a stub psql records its arguments
+	stubs := t.TempDir()
+	stub := "#!/bin/sh\necho \"$@\" >> \"$STUB_DIR/psql.log\"\n"
+	if err := os.WriteFile(filepath.Join(stubs, "psql"), []byte(stub), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", stubs+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("STUB_DIR", stubs)
+	t.Setenv("JOBS", "2")
+
+	stderr := bytes.NewBuffer(nil)
+	cmd := exec.Command(filepath.Join(dir, splitRestoreScript), "--dbname=scratch")
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("restore script failed: %s %s", err, stderr)
+	}
+	calls := strings.Split(strings.TrimSpace(readStub(t, stubs, "psql.log")), "\n")
+	prefix := "--no-psqlrc --quiet --set=ON_ERROR_STOP=1 --dbname=scratch --file="
+	for i, c := range calls {
+		calls[i] = strings.TrimPrefix(c, prefix)
+	}
+	if len(calls) != 5 || calls[0] != splitPreData || calls[4] != splitPostData {
+		t.Fatalf("unexpected psql calls %q", calls)
+	}
+	data := calls[1:4]
+	sort.Strings(data)
+	if data[0] != "data/0001-example_schema.events.sql" || data[2] != "data/0003-public.users.sql" {
+		t.Errorf("unexpected data file calls %q", data)
+	}
+}
+
+func TestAnonymiseSplitFail(t *testing.T) {
+
+	args := anonArgs{
+		dumpFilePath: "testdata/pg_dumpall.sql",
+		settingsToml: exportSettings,
+		splitDir:     t.TempDir(),
+	}
+	if err := Anonymise(args); err == nil || !strings.Contains(err.Error(), "pg_dumpall dumps cannot be split") {
+		t.Errorf("expected a pg_dumpall error, got %v", err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "dump.custom")
+	if err := os.WriteFile(archive, makeCustomArchive(t, testArchiveHeader(14, compressionNone)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args.dumpFilePath = archive
+	if err := Anonymise(args); err != errSplitPlainOnly {
+		t.Errorf("expected a plain format error, got %v", err)
+	}
+}
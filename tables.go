@@ -3,8 +3,8 @@ package main
 import (
 	"errors"
 	"fmt"
-	"regexp"
 	"strings"
+	"sync"
 )
 
 // DumpTable  describes metadata about a table from pg_dump file
@@ -13,6 +13,7 @@ type DumpTable struct {
 	columnNames []string
 	lines       int
 	initialised bool
+	insert      bool // the table data is in INSERT statements
 }
 
 // RefTableRegister is a register of reference tables
@@ -43,12 +44,53 @@ type DumpTabler interface {
 	ColumnNames() []string
 }
 
-// copy_regex is a regular expression to grab a COPY header from a
-// pg_dump table COPY block
-var copyRegex = regexp.MustCompile(`^COPY ([^ ]+) \(([^)]+)\) FROM stdin;`)
+// parseCopyHeader parses the COPY header of a pg_dump table COPY block,
+// returning the table name and the unquoted column names, which for a
+// table dumped WITH OIDS start with the oid column
+func parseCopyHeader(copyLine string) (string, []string, error) {
+	name, columns, _, err := parseCopyStatement(copyLine)
+	return name, columns, err
+}
+
+// parseCopyStatement parses the COPY header of a table COPY block as
+// for parseCopyHeader, also reporting if the table is dumped WITH OIDS.
+// The column list of a table without columns is empty, as in
+//
+//     COPY public.empty  FROM stdin;
+func parseCopyStatement(copyLine string) (string, []string, bool, error) {
+
+	if !strings.HasPrefix(copyLine, "COPY ") {
+		return "", nil, false, errors.New("COPY expected")
+	}
+	parts, p, err := scanQualifiedName(copyLine, len("COPY "))
+	if err != nil {
+		return "", nil, false, err
+	}
+	if len(parts) > 2 {
+		return "", nil, false, fmt.Errorf("table name has %d parts", len(parts))
+	}
+	if !strings.HasPrefix(copyLine[p:], " ") {
+		return "", nil, false, errors.New("column list expected")
+	}
+	columns := []string{}
+	if strings.HasPrefix(copyLine[p+1:], " ") {
+		p++
+	} else if columns, p, err = scanIdentifierList(copyLine, p+1); err != nil {
+		return "", nil, false, err
+	}
+	withOids := strings.HasPrefix(copyLine[p:], " WITH OIDS")
+	if withOids {
+		p += len(" WITH OIDS")
+		columns = append([]string{oidColumn}, columns...)
+	}
+	if copyLine[p:] != " FROM stdin;" {
+		return "", nil, false, errors.New("FROM stdin expected")
+	}
+	return tableName(parts), columns, withOids, nil
+}
 
 // NewDumpTable is used to initialise a dump table when given a "COPY"
-// line from a pg_dump file, such as
+// line from a pg_dump file, which may include quoted identifiers, such as
 //
 //     COPY example_schema.events (id, flags, data) FROM stdin;
 //
@@ -56,42 +98,56 @@ var copyRegex = regexp.MustCompile(`^COPY ([^ ]+) \(([^)]+)\) FROM stdin;`)
 // is in the tableFilters argument, and whether or not it is called in
 // a refContext mode (i.e. reference context)
 func NewDumpTable(copyLine string, refContext bool, tf tableFilters) (*DumpTable, error) {
+	return newDumpTable(copyLine, "", refContext, tf)
+}
+
+// newDumpTable initialises a dump table from a "COPY" line as for
+// NewDumpTable, with the table name qualified by the name of the
+// database, if any, of a pg_dumpall dump
+func newDumpTable(copyLine, database string, refContext bool, tf tableFilters) (*DumpTable, error) {
 
 	d := new(DumpTable)
 
 	if !(strings.Contains(copyLine, "COPY ") && strings.Contains(copyLine, " FROM stdin;")) {
 		return d, ErrNoDumpTable
 	}
-	matches := copyRegex.FindStringSubmatch(copyLine)
-	if len(matches) != 3 {
-		return d, fmt.Errorf("could not parse copy line %s with parts %d", copyLine, len(matches))
+	name, columns, err := parseCopyHeader(copyLine)
+	if err != nil {
+		return d, fmt.Errorf("could not parse copy line %s: %w", copyLine, err)
 	}
 
-	d.TableName = matches[1]
-	d.columnNames = strings.Split(matches[2], ", ")
+	d.TableName = databaseTableName(database, name)
+	d.columnNames = columns
+
+	return d, d.init(refContext, tf)
+}
+
+// init marks the dump table as initialised if the table is of interest
+// to tf, which in refContext mode means that it is a reference table or
+// refers to large objects, otherwise returning ErrNotInterestingTable
+func (d *DumpTable) init(refContext bool, tf tableFilters) error {
 
 	// If in refContext mode, return ErrIsNormalDumpTable unless the
 	// table is in tf.refTableNames.
 	if refContext == true {
-		ex := tf.getReferenceTables()
-		if _, ok := ex[d.TableName]; !ok {
-			return d, ErrNotInterestingTable
+		if !tf.isReferenceTable(d.TableName) && !tf.referencesLargeObjects(d.TableName) {
+			return ErrNotInterestingTable
 		}
 	} else {
 		filters := tf.getTableFilters(d.TableName)
 		if len(filters) == 0 {
-			return d, ErrNotInterestingTable
+			return ErrNotInterestingTable
 		}
 	}
 
 	// mark the struct as initialised
 	d.initialised = true
 
-	return d, nil
+	return nil
 }
 
-// LineSplitter returns the fields of the requisite table and true if
-// the table is still being read, false otherwise
+// LineSplitter returns the decoded fields of the requisite table and
+// true if the table is still being read, false otherwise
 func (dt *DumpTable) LineSplitter(line string) ([]string, bool) {
 
 	s := []string{}
@@ -101,7 +157,7 @@ func (dt *DumpTable) LineSplitter(line string) ([]string, bool) {
 		return s, false
 	}
 	dt.lines++
-	s = strings.Split(line, "\t")
+	s = decodeCopyLine(line)
 	return s, true
 }
 
@@ -123,9 +179,11 @@ func (dt *DumpTable) ColumnNames() []string {
 // in that row has been anonymised, any original or new valies in what
 // was row 22 can be referenced. The index map is a map of column names
 // to values in that column and the row number (in both originalRows and
-// latestRows).
+// latestRows). The index is built lazily and guarded by a mutex, as
+// tables referencing the reference table may be filtered concurrently.
 type ReferenceDumpTable struct {
 	*DumpTable
+	mu           sync.Mutex
 	rowIndex     map[string]map[string]int // column name to value to row number
 	originalRows []Row
 	latestRows   []Row
@@ -135,17 +193,36 @@ type ReferenceDumpTable struct {
 // additional fields for reference
 func NewReferenceDumpTable(copyLine string, tf tableFilters) (*ReferenceDumpTable, error) {
 
-	var rdt ReferenceDumpTable
-	var err error
-	rdt.DumpTable, err = NewDumpTable(copyLine, true, tf)
-	if err != nil {
-		return &rdt, err // err comes in several flavours, eg ErrNotInterestingTable
+	dt, err := NewDumpTable(copyLine, true, tf)
+	// err comes in several flavours, eg ErrNotInterestingTable
+	return newReferenceDumpTable(dt), err
+}
+
+// newReferenceDumpTable wraps a DumpTable as a ReferenceDumpTable
+func newReferenceDumpTable(dt *DumpTable) *ReferenceDumpTable {
+	return &ReferenceDumpTable{
+		DumpTable:    dt,
+		originalRows: []Row{},
+		latestRows:   []Row{},
+		rowIndex:     map[string]map[string]int{},
 	}
-	rdt.originalRows = []Row{}
-	rdt.latestRows = []Row{}
-	rdt.rowIndex = map[string]map[string]int{}
+}
 
-	return &rdt, nil
+// inDatabase returns the register with the tables of a database of a
+// pg_dumpall dump also registered by their names without the database,
+// so that reference filters find the reference tables of the database
+func (r RefTableRegister) inDatabase(database string) RefTableRegister {
+	if database == "" {
+		return r
+	}
+	rt := RefTableRegister{}
+	for name, rdt := range r {
+		rt[name] = rdt
+		if db, table := splitDatabaseTableName(name); db == database {
+			rt[table] = rdt
+		}
+	}
+	return rt
 }
 
 // addRow adds rows to either the original or latest row slices
@@ -199,6 +276,9 @@ func (rdt *ReferenceDumpTable) buildIndex(keyCol string) error {
 // keyCol and returns the new value for targetCol at that row
 func (rdt *ReferenceDumpTable) getUpdatedFieldValue(keyCol, originalValue, targetCol string) (string, error) {
 
+	rdt.mu.Lock()
+	defer rdt.mu.Unlock()
+
 	// if the index doesn't exist, build it
 	if len(rdt.rowIndex[keyCol]) == 0 {
 		rdt.buildIndex(keyCol)
@@ -30,6 +30,11 @@ func (f mockFilter) Filter(r Row) (Row, error) {
 	return r, nil
 }
 
+// usedColumns is an empty implementation
+func (f mockFilter) usedColumns() []string {
+	return nil
+}
+
 func TestTable(t *testing.T) {
 
 	f, err := os.Open("testdata/pg_dump.sql")
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// tarMagicOffset is the offset of the "ustar" magic in a tar header
+const tarMagicOffset = 257
+
+// isTarArchive reports if the buffered reader starts with a tar header
+func isTarArchive(r *bufio.Reader) bool {
+	b, _ := r.Peek(tarMagicOffset + 5)
+	return len(b) == tarMagicOffset+5 && string(b[tarMagicOffset:]) == "ustar"
+}
+
+// scanTar filters the table data members of a pg_dump tar format
+// archive read from r, writing a new tar format archive to w. The
+// archive is streamed member by member: the toc.dat member is read to
+// map data members to tables, the data members of tables with filters
+// and the members of large objects with filters are filtered, and all
+// other members are copied verbatim.
+//
+// As tar headers record the size of their member, each filtered member
+// is held in memory until it has been filtered.
+func scanTar(df *dumpFilter, r io.Reader, w io.Writer) error {
+
+	tr := tar.NewReader(r)
+
+	// an archive is not written in reference or test mode
+	var tw *tar.Writer
+	if !df.referenceMode && !df.changedOnly {
+		tw = tar.NewWriter(w)
+	}
+
+	// toc entries by data member name
+	var entries map[string]*tocEntry
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("tar archive read error: %w", err)
+		}
+
+		if hdr.Name == directoryTOC {
+			entries, err = readTarTOC(df, tr, hdr, tw)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if entries == nil {
+			return fmt.Errorf("tar archive member %s found before %s", hdr.Name, directoryTOC)
+		}
+
+		te, ok := entries[hdr.Name]
+		if df.tableFilters.largeObjects.active() {
+			filtered, err := filterTarBlobs(df, te, tr, hdr, tw)
+			if err != nil {
+				return fmt.Errorf("tar archive %s: %w", hdr.Name, err)
+			}
+			if filtered {
+				continue
+			}
+		}
+		if ok && te.isTableData() {
+			filtered, err := filterTarData(df, te, tr, hdr, tw, w)
+			if err != nil {
+				return fmt.Errorf("tar archive %s %s: %w", te.desc.String, te.tag.String, err)
+			}
+			if df.refTablesComplete() {
+				return nil
+			}
+			if filtered {
+				continue
+			}
+		}
+
+		if tw == nil {
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("tar archive write error: %w", err)
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return fmt.Errorf("tar archive copy error: %w", err)
+		}
+	}
+
+	if tw == nil {
+		return nil
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("tar archive write error: %w", err)
+	}
+	return nil
+}
+
+// readTarTOC reads the header and table of contents from the toc.dat
+// member of a tar archive, copying the member to tw if it is not nil,
+// without the entries of dropped large objects, and returns the toc
+// entries keyed by data member name. The table definitions of the
+// entries are read by the dumpFilter
+func readTarTOC(df *dumpFilter, tr *tar.Reader, hdr *tar.Header, tw *tar.Writer) (map[string]*tocEntry, error) {
+
+	b, err := io.ReadAll(tr)
+	if err != nil {
+		return nil, fmt.Errorf("tar archive toc read error: %w", err)
+	}
+	ar := newArchiveReader(bytes.NewReader(b))
+	h, err := ar.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if h.format != archTar {
+		return nil, fmt.Errorf("archive format %d is not a tar format archive", h.format)
+	}
+	toc, err := ar.readTOC(h)
+	if err != nil {
+		return nil, err
+	}
+	if h.dumpVersion.String != "" {
+		if err := df.setDumpVersion(h.dumpVersion.String); err != nil {
+			return nil, err
+		}
+	}
+	if err := df.readDefinitions(toc); err != nil {
+		return nil, err
+	}
+	if df.tableFilters.largeObjects.active() {
+		toc = df.filterLargeObjectTOC(toc)
+		buf := bytes.NewBuffer(nil)
+		aw := newArchiveWriter(buf)
+		if err := aw.writeHeader(h); err != nil {
+			return nil, err
+		}
+		if err := aw.writeTOC(h, toc); err != nil {
+			return nil, err
+		}
+		if err := aw.flush(); err != nil {
+			return nil, err
+		}
+		b = buf.Bytes()
+		hdr.Size = int64(len(b))
+	}
+
+	entries := map[string]*tocEntry{}
+	for _, te := range toc {
+		if te.filename.String != "" {
+			entries[te.filename.String] = te
+		}
+	}
+
+	if tw != nil {
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, fmt.Errorf("tar archive write error: %w", err)
+		}
+		if _, err := tw.Write(b); err != nil {
+			return nil, fmt.Errorf("tar archive write error: %w", err)
+		}
+	}
+	return entries, nil
+}
+
+// filterTarData filters a tar data member if the table described by
+// the copy statement of its toc entry is of interest to the dumpFilter,
+// returning false if it is not. The filtered member is written to tw,
+// if not nil, or in test mode in plain text to text
+func filterTarData(df *dumpFilter, te *tocEntry, tr *tar.Reader, hdr *tar.Header, tw *tar.Writer, text io.Writer) (bool, error) {
+
+	// initialise the dump table from the toc entry
+	line, ok, err := df.startEntry(te)
+	if err != nil {
+		return false, err
+	}
+	if !df.dt.Inited() {
+		return false, nil
+	}
+
+	if df.changedOnly {
+		if ok {
+			if _, err := io.WriteString(text, line+"\n"); err != nil {
+				return true, fmt.Errorf("write error: %w", err)
+			}
+		}
+		return true, filterData(df, tr, text)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := filterData(df, tr, buf); err != nil {
+		return true, err
+	}
+	if tw == nil {
+		return true, nil
+	}
+
+	hdr.Size = int64(buf.Len())
+	if err := tw.WriteHeader(hdr); err != nil {
+		return true, fmt.Errorf("tar archive write error: %w", err)
+	}
+	if _, err := buf.WriteTo(tw); err != nil {
+		return true, fmt.Errorf("tar archive write error: %w", err)
+	}
+	return true, nil
+}
+
+// filterTarBlobs filters the blobs toc member of a tar archive, whose
+// toc entry is te, or the member of a large object, returning false if
+// the member is neither or the large object has no filters. Dropped
+// large objects are removed and the contents of replaced and truncated
+// large objects rewritten. Filtered members are written to tw, if not
+// nil
+func filterTarBlobs(df *dumpFilter, te *tocEntry, tr *tar.Reader, hdr *tar.Header, tw *tar.Writer) (bool, error) {
+
+	var b []byte
+	switch f := df.blobFileTreatment(hdr.Name); {
+	case te != nil && te.desc.String == "BLOBS":
+		toc, err := io.ReadAll(tr)
+		if err != nil {
+			return true, fmt.Errorf("tar archive read error: %w", err)
+		}
+		if b, _, err = df.filterBlobsTOC(toc); err != nil {
+			return true, err
+		}
+	case f == nil:
+		return false, nil
+	case f.treatment == loDrop:
+		return true, nil
+	default:
+		b = f.placeholder
+	}
+	if tw == nil {
+		return true, nil
+	}
+
+	hdr.Size = int64(len(b))
+	if err := tw.WriteHeader(hdr); err != nil {
+		return true, fmt.Errorf("tar archive write error: %w", err)
+	}
+	if _, err := tw.Write(b); err != nil {
+		return true, fmt.Errorf("tar archive write error: %w", err)
+	}
+	return true, nil
+}
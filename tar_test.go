@@ -0,0 +1,169 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// makeTarArchive makes a tar format archive from the testTOC entries
+// and data, with the members in the order written by pg_dump
+func makeTarArchive(t *testing.T) []byte {
+	t.Helper()
+
+	h := testArchiveHeader(14, compressionNone)
+	h.format = archTar
+	toc, data := testTOC(t)
+
+	tocBuf := bytes.NewBuffer(nil)
+	aw := newArchiveWriter(tocBuf)
+	aw.writeHeader(h)
+	aw.writeTOC(h, toc)
+	aw.flush()
+
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	addMember := func(name string, contents []byte) {
+		hdr := &tar.Header{
+			Name:   name,
+			Mode:   0600,
+			Size:   int64(len(contents)),
+			Format: tar.FormatUSTAR,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(contents)
+	}
+	addMember(directoryTOC, tocBuf.Bytes())
+	for i, d := range data {
+		addMember(toc[i+1].filename.String, []byte(d))
+	}
+	addMember("blobs.toc", []byte("16400 blob_16400.dat\n"))
+	addMember("blob_16400.dat", []byte("a scanned document"))
+	addMember("restore.sql", []byte("COPY public.users FROM '$$PATH$$/4.dat';\n"))
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readTarMembers reads the names and contents of the members of a tar
+// archive
+func readTarMembers(t *testing.T, archive []byte) ([]string, map[string]string) {
+	t.Helper()
+
+	names := []string{}
+	members := map[string]string{}
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar read error %s", err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("tar member read error %s", err)
+		}
+		names = append(names, hdr.Name)
+		members[hdr.Name] = string(b)
+	}
+	return names, members
+}
+
+func TestIsTarArchive(t *testing.T) {
+	if !isTarArchive(bufio.NewReader(bytes.NewReader(makeTarArchive(t)))) {
+		t.Error("tar archive not detected")
+	}
+	f, err := os.Open("testdata/pg_dump.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if isTarArchive(bufio.NewReader(f)) {
+		t.Error("plain dump detected as a tar archive")
+	}
+}
+
+func TestAnonymiseTar(t *testing.T) {
+
+	tomlString, err := os.ReadFile("testdata/settings.toml")
+	if err != nil {
+		t.Fatalf("could not read settings file: %s", err)
+	}
+
+	archive := makeTarArchive(t)
+	dumpFile := filepath.Join(t.TempDir(), "dump.tar")
+	if err := os.WriteFile(dumpFile, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	args := anonArgs{
+		dumpFilePath: dumpFile,
+		settingsToml: string(tomlString),
+		output:       buffer,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+
+	inNames, inMembers := readTarMembers(t, archive)
+	outNames, outMembers := readTarMembers(t, buffer.Bytes())
+
+	if strings.Join(inNames, " ") != strings.Join(outNames, " ") {
+		t.Errorf("member order changed from %v to %v", inNames, outNames)
+	}
+	for _, name := range []string{directoryTOC, "blobs.toc", "blob_16400.dat", "restore.sql"} {
+		if inMembers[name] != outMembers[name] {
+			t.Errorf("member %s not copied verbatim", name)
+		}
+	}
+	if got := outMembers["2.dat"]; got != "\\.\n\n\n" {
+		t.Errorf("events table should be empty, got %q", got)
+	}
+	if got := outMembers["3.dat"]; !strings.Contains(got, "3\t5\tvanessa\n") {
+		t.Errorf("fkexample not anonymised, got %q", got)
+	}
+	if c := strings.Count(outMembers["4.dat"], "zachary"); c != 2 {
+		t.Errorf("expected 2 zacharies in users, got %d", c)
+	}
+}
+
+func TestAnonymiseTarTestMode(t *testing.T) {
+
+	tomlString, err := os.ReadFile("testdata/settings.toml")
+	if err != nil {
+		t.Fatalf("could not read settings file: %s", err)
+	}
+
+	dumpFile := filepath.Join(t.TempDir(), "dump.tar")
+	if err := os.WriteFile(dumpFile, makeTarArchive(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	args := anonArgs{
+		dumpFilePath: dumpFile,
+		settingsToml: string(tomlString),
+		output:       buffer,
+		changedOnly:  true,
+	}
+	if err := Anonymise(args); err != nil {
+		t.Fatalf("Anonymise should not fail: %s", err)
+	}
+	if count := strings.Count(buffer.String(), "COPY "); count != 3 {
+		t.Errorf("count of COPY lines should be 3, got %d", count)
+	}
+	if count := strings.Count(buffer.String(), "zachary"); count != 3 {
+		t.Errorf("count of zachary string not 3, got %d", count)
+	}
+}
@@ -0,0 +1,209 @@
+--
+-- PostgreSQL database cluster dump
+--
+
+SET default_transaction_read_only = off;
+
+SET client_encoding = 'UTF8';
+SET standard_conforming_strings = on;
+
+--
+-- Roles
+--
+
+CREATE ROLE dbuser;
+ALTER ROLE dbuser WITH NOSUPERUSER INHERIT NOCREATEROLE NOCREATEDB LOGIN NOREPLICATION NOBYPASSRLS PASSWORD 'SCRAM-SHA-256$4096:c2FsdHNhbHQ=$c3RvcmVka2V5:c2VydmVya2V5';
+CREATE ROLE postgres;
+ALTER ROLE postgres WITH SUPERUSER INHERIT CREATEROLE CREATEDB LOGIN REPLICATION BYPASSRLS PASSWORD 'md5a3556571e93b0d20722ba62be61e8c2d' VALID UNTIL 'infinity';
+CREATE ROLE reader;
+ALTER ROLE reader WITH NOSUPERUSER INHERIT NOCREATEROLE NOCREATEDB NOLOGIN NOREPLICATION NOBYPASSRLS;
+
+--
+-- Databases
+--
+
+--
+-- Database "template1" dump
+--
+
+\connect template1
+
+--
+-- PostgreSQL database dump
+--
+
+-- Dumped from database version 16.2
+-- Dumped by pg_dump version 16.2
+
+SET statement_timeout = 0;
+SET client_encoding = 'UTF8';
+SET standard_conforming_strings = on;
+
+--
+-- PostgreSQL database dump complete
+--
+
+--
+-- Database "sales" dump
+--
+
+--
+-- PostgreSQL database dump
+--
+
+-- Dumped from database version 16.2
+-- Dumped by pg_dump version 16.2
+
+SET statement_timeout = 0;
+SET client_encoding = 'UTF8';
+SET standard_conforming_strings = on;
+
+--
+-- Name: sales; Type: DATABASE; Schema: -; Owner: dbuser
+--
+
+CREATE DATABASE sales WITH TEMPLATE = template0 ENCODING = 'UTF8' LOCALE_PROVIDER = libc LOCALE = 'en_GB.UTF-8';
+
+
+ALTER DATABASE sales OWNER TO dbuser;
+
+\connect sales
+
+SET statement_timeout = 0;
+SET client_encoding = 'UTF8';
+SET standard_conforming_strings = on;
+
+--
+-- Name: users; Type: TABLE; Schema: public; Owner: dbuser
+--
+
+CREATE TABLE public.users (
+    id integer NOT NULL,
+    name text,
+    email text
+);
+
+
+ALTER TABLE public.users OWNER TO dbuser;
+
+--
+-- Name: orders; Type: TABLE; Schema: public; Owner: dbuser
+--
+
+CREATE TABLE public.orders (
+    id integer NOT NULL,
+    user_id integer,
+    customer text
+);
+
+
+ALTER TABLE public.orders OWNER TO dbuser;
+
+--
+-- Data for Name: users; Type: TABLE DATA; Schema: public; Owner: dbuser
+--
+
+COPY public.users (id, name, email) FROM stdin;
+1	ariadne	ariadne@example.com
+2	james	james@example.com
+\.
+
+
+--
+-- Data for Name: orders; Type: TABLE DATA; Schema: public; Owner: dbuser
+--
+
+COPY public.orders (id, user_id, customer) FROM stdin;
+1	2	james
+2	1	ariadne
+\.
+
+
+--
+-- PostgreSQL database dump complete
+--
+
+--
+-- Database "Support Desk" dump
+--
+
+--
+-- PostgreSQL database dump
+--
+
+-- Dumped from database version 16.2
+-- Dumped by pg_dump version 16.2
+
+SET statement_timeout = 0;
+SET client_encoding = 'UTF8';
+SET standard_conforming_strings = on;
+
+--
+-- Name: Support Desk; Type: DATABASE; Schema: -; Owner: dbuser
+--
+
+CREATE DATABASE "Support Desk" WITH TEMPLATE = template0 ENCODING = 'UTF8' LOCALE_PROVIDER = libc LOCALE = 'en_GB.UTF-8';
+
+
+ALTER DATABASE "Support Desk" OWNER TO dbuser;
+
+\connect -reuse-previous=on "dbname='Support Desk'"
+
+SET statement_timeout = 0;
+SET client_encoding = 'UTF8';
+SET standard_conforming_strings = on;
+
+--
+-- Name: users; Type: TABLE; Schema: public; Owner: dbuser
+--
+
+CREATE TABLE public.users (
+    id integer NOT NULL,
+    name text,
+    email text
+);
+
+
+ALTER TABLE public.users OWNER TO dbuser;
+
+--
+-- Name: tickets; Type: TABLE; Schema: public; Owner: dbuser
+--
+
+CREATE TABLE public.tickets (
+    id integer NOT NULL,
+    user_id integer,
+    reporter text
+);
+
+
+ALTER TABLE public.tickets OWNER TO dbuser;
+
+--
+-- Data for Name: tickets; Type: TABLE DATA; Schema: public; Owner: dbuser
+--
+
+COPY public.tickets (id, user_id, reporter) FROM stdin;
+1	1	lucius
+2	2	biggles
+\.
+
+
+--
+-- Data for Name: users; Type: TABLE DATA; Schema: public; Owner: dbuser
+--
+
+COPY public.users (id, name, email) FROM stdin;
+1	lucius	lucius@example.com
+2	biggles	biggles@example.com
+\.
+
+
+--
+-- PostgreSQL database dump complete
+--
+
+--
+-- PostgreSQL database cluster dump complete
+--
+
@@ -72,7 +72,7 @@ notif = {"notes" = "\\N"}`
 
 	_, err := toml.Decode(inlineToml, &filter)
 	if err != nil {
-		t.Errorf("could not decode toml filter: %w", err)
+		t.Errorf("could not decode toml filter: %s", err)
 	}
 	if notes, ok := filter.If["notes"]; !ok || notes != `\N` {
 		t.Errorf("null decoding if map failed: ok %t notes %s", ok, notes)
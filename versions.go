@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Plain dumps record the version of pg_dump which made them in a header
+// line, such as
+//
+//     -- Dumped by pg_dump version 12.10 (Debian 12.10-1.pgdg100+1)
+//
+// and archives in their header. Dumps made by pg_dump 9.6 to 17 are
+// known, and their differences are handled by version:
+//
+//   - before version 12 the data of tables with oids may be dumped with
+//     COPY ... WITH OIDS, in which the oid of each row is its first
+//     field. The oid is presented to filters as the column oid
+//   - the point releases of August 2025 surround plain dumps, and each
+//     database of a pg_dumpall dump, with \restrict and \unrestrict
+//     psql meta-commands, which must have the same key
+//
+// The COPY statements of a dump of a known version must be of the form
+// written by pg_dump, so that no table data is passed through without
+// being filtered. Dumps made by later versions of pg_dump, or without a
+// version, are read as those of an unknown version, in which the COPY
+// statements of tables with filters must still be of that form. Dumps
+// made before pg_dump 9.6 are not supported.
+
+// known pg_dump versions, in the form of server_version_num
+const (
+	minDumpVersion = 90600
+	maxDumpVersion = 179999
+	// pgVersion12 removed tables with oids
+	pgVersion12 = 120000
+)
+
+// oidColumn is the name of the column of the oids of the rows of a
+// table dumped WITH OIDS
+const oidColumn = "oid"
+
+var (
+	dumpedByRegex = regexp.MustCompile(`^-- Dumped by pg_dump(?:all)? version (\S+)`)
+	restrictRegex = regexp.MustCompile(`^\\(restrict|unrestrict) (\S+)$`)
+	versionRegex  = regexp.MustCompile(`^(\d+)(?:\.(\d+))?(?:\.(\d+))?`)
+)
+
+// parseDumpVersion parses a postgresql version, such as 9.6.24, 12.10,
+// 17.2 or 17beta1, returning it in the form of server_version_num, such
+// as 90624, 120010, 170002 and 170000
+func parseDumpVersion(s string) (int, error) {
+	m := versionRegex.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid pg_dump version %q", s)
+	}
+	n := make([]int, 3)
+	for i, part := range m[1:] {
+		if part != "" {
+			n[i], _ = strconv.Atoi(part)
+		}
+	}
+	// versions before 10 have two part major versions
+	if n[0] < 10 {
+		return n[0]*10000 + n[1]*100 + n[2], nil
+	}
+	return n[0]*10000 + n[1], nil
+}
+
+// setDumpVersion records the pg_dump version of a dump, returning an
+// error if the version is before the first supported version
+func (d *dumpFilter) setDumpVersion(s string) error {
+	v, err := parseDumpVersion(s)
+	if err != nil {
+		return err
+	}
+	if v < minDumpVersion {
+		return fmt.Errorf("pg_dump version %s is not supported; dumps made by pg_dump 9.6 or later are supported", s)
+	}
+	d.version = v
+	return nil
+}
+
+// knownVersion reports if the dump was made by a known version of
+// pg_dump
+func (d *dumpFilter) knownVersion() bool {
+	return d.version >= minDumpVersion && d.version <= maxDumpVersion
+}
+
+// readHeaderLine reads a line outside of table data for the pg_dump
+// version of a plain dump and its \restrict and \unrestrict keys
+func (d *dumpFilter) readHeaderLine(t string) error {
+
+	if m := dumpedByRegex.FindStringSubmatch(t); m != nil {
+		return d.setDumpVersion(m[1])
+	}
+	if !strings.HasPrefix(t, `\`) {
+		return nil
+	}
+	m := restrictRegex.FindStringSubmatch(t)
+	if m == nil {
+		return nil
+	}
+	if m[1] == "restrict" {
+		d.restrictKey = m[2]
+		return nil
+	}
+	if d.restrictKey != "" && m[2] != d.restrictKey {
+		return fmt.Errorf("\\unrestrict key %s does not match \\restrict key %s", m[2], d.restrictKey)
+	}
+	d.restrictKey = ""
+	return nil
+}
+
+// isCopyData reports if a line is a COPY statement for table data read
+// from stdin, as for the data of a dump
+func isCopyData(t string) bool {
+	return strings.HasPrefix(t, "COPY ") && strings.Contains(t, " FROM stdin")
+}
+
+// checkCopyStatement checks a COPY statement of table data, which in a
+// dump of a known version, or for a table with filters, must be of the
+// form written by pg_dump. Tables dumped WITH OIDS must be in a dump
+// made before pg_dump 12
+func (d *dumpFilter) checkCopyStatement(t string) error {
+
+	if !isCopyData(t) {
+		return nil
+	}
+	name, _, withOids, err := parseCopyStatement(t)
+	if err == nil {
+		if withOids && d.version >= pgVersion12 {
+			return fmt.Errorf("table %s: COPY WITH OIDS is not written by pg_dump 12 or later", name)
+		}
+		return nil
+	}
+	if d.knownVersion() {
+		return fmt.Errorf("unrecognised COPY statement for pg_dump version %d: %s: %w", d.version/10000, t, err)
+	}
+	parts, _, perr := scanQualifiedName(t, len("COPY "))
+	if perr != nil {
+		return nil
+	}
+	name = databaseTableName(d.database, tableName(parts))
+	if len(d.tableFilters.getTableFilters(name)) > 0 {
+		return fmt.Errorf("unrecognised COPY statement for table %s with filters: %s: %w", name, t, err)
+	}
+	return nil
+}
+
+// checkRowFields checks that a row of table data has a field for each
+// column of the table. The rows of a table without columns are empty
+// lines, read as a single empty field
+func (d *dumpFilter) checkRowFields(columns []string) error {
+	n := len(d.dt.ColumnNames())
+	if len(columns) == n || (n == 0 && len(columns) == 1 && columns[0] == "") {
+		return nil
+	}
+	return fmt.Errorf("table %s row %d has %d fields, expected %d", d.dt.TableName, d.lineNo+1, len(columns), n)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDumpVersion(t *testing.T) {
+
+	tests := map[string]int{
+		"9.6.24":                      90624,
+		"9.6":                         90600,
+		"10.23":                       100023,
+		"12.10 (Debian 12.10-1.pgdg)": 120010,
+		"17.6":                        170006,
+		"17beta1":                     170000,
+		"18devel":                     180000,
+	}
+	for s, want := range tests {
+		if got, err := parseDumpVersion(s); err != nil || got != want {
+			t.Errorf("%s: expected %d got %d %v", s, want, got, err)
+		}
+	}
+	if _, err := parseDumpVersion("unknown"); err == nil {
+		t.Error("expected an error for an invalid version")
+	}
+}
+
+func TestParseCopyStatement(t *testing.T) {
+
+	tests := []struct {
+		line     string
+		table    string
+		columns  []string
+		withOids bool
+	}{
+		{`COPY public.users (id, name) FROM stdin;`, "public.users", []string{"id", "name"}, false},
+		{`COPY public.users (id, name) WITH OIDS FROM stdin;`, "public.users", []string{"oid", "id", "name"}, true},
+		{`COPY public.empty  FROM stdin;`, "public.empty", []string{}, false},
+		{`COPY public.empty  WITH OIDS FROM stdin;`, "public.empty", []string{"oid"}, true},
+		{`COPY public.users (id) FROM stdin WITH (FORMAT csv);`, "", nil, false},
+		{`COPY public.users (id) WITH OIDS;`, "", nil, false},
+	}
+	for _, tc := range tests {
+		table, columns, withOids, err := parseCopyStatement(tc.line)
+		if tc.table == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.line)
+			}
+			continue
+		}
+		if err != nil || table != tc.table || !reflect.DeepEqual(columns, tc.columns) || withOids != tc.withOids {
+			t.Errorf("%s: got %s %q %t %v", tc.line, table, columns, withOids, err)
+		}
+	}
+}
+
+// versionDump writes a plain dump made by a pg_dump version with the
+// given lines after its header, returning its path
+func versionDump(t *testing.T, version string, lines ...string) string {
+	t.Helper()
+	header := []string{"--", "-- PostgreSQL database dump", "--", ""}
+	if version != "" {
+		header = append(header, "-- Dumped from database version "+version, "-- Dumped by pg_dump version "+version, "")
+	}
+	dumpFile := filepath.Join(t.TempDir(), "dump.sql")
+	dump := strings.Join(append(header, lines...), "\n") + "\n"
+	if err := os.WriteFile(dumpFile, []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dumpFile
+}
+
+const versionSettings = `
+[["public.users"]]
+filter = "string replace"
+columns = ["name"]
+replacements = ["zachary"]
+notif = {"oid" = "16390"}
+`
+
+func TestAnonymiseVersions(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		version string
+		lines   []string
+		want    []string
+	}{
+		{
+			name:    "9.6 with oids",
+			version: "9.6.24",
+			lines: []string{
+				`COPY public.users (id, name) WITH OIDS FROM stdin;`,
+				"16389\t1\tann",
+				"16390\t2\tbea",
+				`\.`,
+			},
+			want: []string{
+				`COPY public.users (id, name) WITH OIDS FROM stdin;`,
+				"16389\t1\tzachary",
+				"16390\t2\tbea",
+				`\.`,
+			},
+		},
+		{
+			name:    "17.6 restrict keys",
+			version: "17.6",
+			lines: []string{
+				`\restrict abcDEF123`,
+				`COPY public.users (id, name) FROM stdin;`,
+				"1\tann",
+				`\.`,
+				`COPY public.empty  FROM stdin;`,
+				``,
+				`\.`,
+				`\unrestrict abcDEF123`,
+			},
+			want: []string{
+				`\restrict abcDEF123`,
+				`COPY public.users (id, name) FROM stdin;`,
+				"1\tzachary",
+				`\.`,
+				`COPY public.empty  FROM stdin;`,
+				``,
+				`\.`,
+				`\unrestrict abcDEF123`,
+			},
+		},
+		{
+			name:    "later version",
+			version: "18.1",
+			lines: []string{
+				`COPY public.users (id, name) FROM stdin;`,
+				"1\tann",
+				`\.`,
+				`COPY public.other (id) FROM stdin WITH (FORMAT text);`,
+				"1",
+				`\.`,
+			},
+			want: []string{
+				`COPY public.users (id, name) FROM stdin;`,
+				"1\tzachary",
+				`\.`,
+				`COPY public.other (id) FROM stdin WITH (FORMAT text);`,
+				"1",
+				`\.`,
+			},
+		},
+		{
+			name: "unknown version with an unrecognised copy of a table without filters",
+			lines: []string{
+				`COPY public.other (id) FROM stdin WITH (FORMAT text);`,
+				"1",
+				`\.`,
+			},
+			want: []string{
+				`COPY public.other (id) FROM stdin WITH (FORMAT text);`,
+				"1",
+				`\.`,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		buffer := bytes.NewBuffer(nil)
+		args := anonArgs{
+			dumpFilePath: versionDump(t, tc.version, tc.lines...),
+			settingsToml: versionSettings,
+			output:       buffer,
+		}
+		if err := Anonymise(args); err != nil {
+			t.Fatalf("%s: Anonymise should not fail: %s", tc.name, err)
+		}
+		if want := strings.Join(tc.want, "\n") + "\n"; !strings.HasSuffix(buffer.String(), want) {
+			t.Errorf("%s: expected output ending\n%s\ngot\n%s", tc.name, want, buffer)
+		}
+	}
+}
+
+func TestAnonymiseVersionsFail(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		version string
+		lines   []string
+		err     string
+	}{
+		{"too old", "9.5.25", nil, "not supported"},
+		{
+			"unrecognised copy of a table with filters in a later version", "18.1",
+			[]string{`COPY public.users (id, name) FROM stdin WITH (FORMAT csv);`, "1,ann", `\.`},
+			"unrecognised COPY statement for table public.users",
+		},
+		{
+			"oids in 12", "12.10",
+			[]string{`COPY public.users (id, name) WITH OIDS FROM stdin;`, "1\t1\tann", `\.`},
+			"WITH OIDS",
+		},
+		{
+			"unrecognised copy in a known version", "16.2",
+			[]string{`COPY public.other (id) FROM stdin WITH (FORMAT binary);`, `\.`},
+			"unrecognised COPY statement",
+		},
+		{
+			"unrecognised copy of a table with filters", "",
+			[]string{`COPY public.users (id, name) FROM stdin WITH (FORMAT csv);`, "1,ann", `\.`},
+			"unrecognised COPY statement for table public.users",
+		},
+		{
+			"mismatched restrict keys", "17.6",
+			[]string{`\restrict abc`, `\unrestrict def`},
+			"does not match",
+		},
+		{
+			"unterminated table data", "15.4",
+			[]string{`COPY public.users (id, name) FROM stdin;`, "1\tann"},
+			"ends within the data",
+		},
+		{
+			"short row", "",
+			[]string{`COPY public.users (id, name) FROM stdin;`, "1", `\.`},
+			"has 1 fields, expected 2",
+		},
+	}
+
+	for _, tc := range tests {
+		args := anonArgs{
+			dumpFilePath: versionDump(t, tc.version, tc.lines...),
+			settingsToml: versionSettings,
+			output:       bytes.NewBuffer(nil),
+		}
+		err := Anonymise(args)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestAnonymiseArchiveVersion(t *testing.T) {
+
+	h := testArchiveHeader(14, compressionNone)
+	h.dumpVersion.String = "9.5.25"
+	dumpFile := filepath.Join(t.TempDir(), "dump.custom")
+	if err := os.WriteFile(dumpFile, makeCustomArchive(t, h), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := anonArgs{
+		dumpFilePath: dumpFile,
+		settingsToml: versionSettings,
+		output:       bytes.NewBuffer(nil),
+	}
+	if err := Anonymise(args); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("expected a version error, got %v", err)
+	}
+
+	// archives made by later versions are read
+	h.dumpVersion.String = "18.1"
+	if err := os.WriteFile(dumpFile, makeCustomArchive(t, h), 0644); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := os.ReadFile("testdata/settings.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	args.settingsToml = string(settings)
+	args.output = bytes.NewBuffer(nil)
+	if err := Anonymise(args); err != nil {
+		t.Errorf("an archive made by pg_dump 18.1 should be read: %s", err)
+	}
+}